@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/encryption"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/userimport"
+)
+
+func importUsers(ctx context.Context, cfg *config.Config, logger *logger.Logger, args []string) error {
+	flags := flag.NewFlagSet("import-users", flag.ExitOnError)
+	file := flags.String("file", "-", "input file, or - for stdin")
+	formatName := flags.String("format", "", "csv or ndjson (default: from file extension)")
+	dryRun := flags.Bool("dry-run", false, "validate and report without writing")
+	reportPath := flags.String("report", "-", "CSV report output file, or - for stdout")
+	batchSize := flags.Int("batch-size", userimport.DefaultBatchSize, "rows per transaction")
+	org := flags.String("org", cfg.Tenancy.DefaultOrganization, "slug or ID of the organization to import into")
+	flags.Parse(args)
+
+	if *formatName == "" {
+		*formatName = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+	format, err := userimport.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("failed to open input: %w", err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	db, err := database.NewPostgresConnection(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, err = organizationContext(ctx, db, *org)
+	if err != nil {
+		return err
+	}
+
+	cipher, err := encryption.New(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+
+	importer := userimport.New(dao.NewUserDAO(db, cfg.Users, cipher))
+	importer.BatchSize = *batchSize
+
+	report, err := importer.Run(ctx, format, input, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	// The server caches users, so drop what changed when Redis is reachable
+	if changed := report.ChangedUserIDs(); len(changed) > 0 {
+		if redis, err := database.NewRedisConnection(cfg.Redis); err != nil {
+			logger.Warn("Skipping cache invalidation", "error", err)
+		} else {
+			defer redis.Close()
+			cacheDAO := dao.NewCacheDAO(redis)
+			keys := make([]string, len(changed))
+			for i, id := range changed {
+				keys[i] = id.String()
+			}
+			if err := cacheDAO.DeleteUser(ctx, keys...); err != nil {
+				logger.Warn("Failed to invalidate user cache", "error", err)
+			}
+			if err := cacheDAO.InvalidateUsersList(ctx); err != nil {
+				logger.Warn("Failed to invalidate users list cache", "error", err)
+			}
+		}
+	}
+
+	var output io.Writer = os.Stdout
+	if *reportPath != "-" {
+		f, err := os.Create(*reportPath)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+		output = f
+	}
+	if err := report.WriteCSV(output); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	logger.Info("Users imported", "dry_run", report.DryRun, "totals", report.String())
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/encryption"
+	"github.com/spurge/p4rsec/server/internal/ldapsync"
+	"github.com/spurge/p4rsec/server/internal/logger"
+)
+
+func ldapSync(ctx context.Context, cfg *config.Config, logger *logger.Logger, args []string) error {
+	flags := flag.NewFlagSet("ldap-sync", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would change without changing it")
+	flags.Parse(args)
+
+	if cfg.LDAP.URL == "" || cfg.LDAP.Organization == "" {
+		return errors.New("ldap.url and ldap.organization must be configured")
+	}
+
+	db, err := database.NewPostgresConnection(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	redis, err := database.NewRedisConnection(cfg.Redis)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	defer redis.Close()
+
+	cipher, err := encryption.New(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+
+	syncer := ldapsync.NewSyncer(cfg.LDAP, ldapsync.Dialer(cfg.LDAP),
+		dao.NewUserDAO(db, cfg.Users, cipher), dao.NewGroupDAO(db), dao.NewLDAPDAO(db),
+		dao.NewOrganizationDAO(db), dao.NewCacheDAO(redis), logger)
+
+	report, err := syncer.Sync(ctx, *dryRun, "admin:ldap-sync")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,80 @@
+// Command admin runs maintenance tasks against the server's database using
+// the same configuration as the server.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/logger"
+)
+
+type command struct {
+	summary string
+	run     func(ctx context.Context, cfg *config.Config, logger *logger.Logger, args []string) error
+}
+
+var commands = map[string]command{
+	"import-users":     {"Create or update users from a CSV or NDJSON file", importUsers},
+	"issue-token":      {"Print a signed bearer token, e.g. for an operator", issueToken},
+	"ldap-sync":        {"Sync users and groups from the configured LDAP directory", ldapSync},
+	"normalize-users":  {"Recompute normalized emails and usernames", normalizeUsers},
+	"rotate-keys":      {"Re-encrypt users under the primary encryption key", rotateKeys},
+	"verify-audit-log": {"Check the audit log's hash chain for tampering", verifyAuditLog},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: admin <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-22s %s\n", name, commands[name].summary)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Initialize logger
+	logger := logger.New(cfg.Logger.Level, cfg.Environment)
+	defer logger.Sync()
+
+	// Stop work cleanly on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Changes made by a command are audited under its name
+	ctx = audit.WithMetadata(ctx, audit.Metadata{Actor: "admin:" + os.Args[1]})
+
+	if err := cmd.run(ctx, cfg, logger, os.Args[2:]); err != nil {
+		logger.Error("Command failed", "command", os.Args[1], "error", err)
+		stop()
+		logger.Sync()
+		os.Exit(1)
+	}
+}
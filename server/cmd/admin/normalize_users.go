@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/encryption"
+	"github.com/spurge/p4rsec/server/internal/logger"
+)
+
+// normalizeUsers recomputes normalized emails and usernames in every
+// organization, e.g. after enabling users.fold_plus_addressing or after the
+// migration's SQL backfill.
+func normalizeUsers(ctx context.Context, cfg *config.Config, logger *logger.Logger, args []string) error {
+	db, err := database.NewPostgresConnection(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	cipher, err := encryption.New(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+
+	userDAO := dao.NewUserDAO(db, cfg.Users, cipher)
+
+	updated := 0
+	var conflicts []dao.IdentityConflict
+	err = forEachOrganization(ctx, db, func(ctx context.Context, orgID uuid.UUID) error {
+		orgUpdated, orgConflicts, err := userDAO.RenormalizeIdentities(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, conflict := range orgConflicts {
+			logger.Warn("User identity conflicts with another user", "user_id", conflict.UserID, "field", conflict.Field,
+				"organization_id", orgID)
+		}
+		updated += orgUpdated
+		conflicts = append(conflicts, orgConflicts...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("User identities normalized", "updated", updated, "conflicts", len(conflicts))
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%d users could not be normalized without a conflict", len(conflicts))
+	}
+	return nil
+}
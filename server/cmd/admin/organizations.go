@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// organizationContext returns ctx acting for the organization named by ID or
+// slug.
+func organizationContext(ctx context.Context, db *database.PostgresDB, name string) (context.Context, error) {
+	orgDAO := dao.NewOrganizationDAO(db)
+
+	var id uuid.UUID
+	if parsed, err := uuid.Parse(name); err == nil {
+		org, err := orgDAO.GetByID(ctx, parsed)
+		if err != nil {
+			return nil, fmt.Errorf("organization %q: %w", name, err)
+		}
+		id = org.ID
+	} else {
+		org, err := orgDAO.GetBySlug(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("organization %q: %w", name, err)
+		}
+		id = org.ID
+	}
+
+	return tenant.WithID(ctx, id), nil
+}
+
+// forEachOrganization calls fn with a context acting for each organization in
+// turn, stopping at the first error.
+func forEachOrganization(ctx context.Context, db *database.PostgresDB, fn func(ctx context.Context, id uuid.UUID) error) error {
+	ids, err := dao.NewOrganizationDAO(db).GetAllIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := fn(tenant.WithID(ctx, id), id); err != nil {
+			return fmt.Errorf("organization %s: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/encryption"
+	"github.com/spurge/p4rsec/server/internal/logger"
+)
+
+// rotateKeys re-encrypts every user, in every organization, whose data is not
+// under the keyring's primary key, including users stored before encryption
+// was enabled, and then the users' history entries the same way. Each batch
+// commits on its own, so an interrupted run can simply be repeated.
+func rotateKeys(ctx context.Context, cfg *config.Config, logger *logger.Logger, args []string) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	batchSize := flags.Int("batch-size", 500, "number of users or history entries to re-encrypt per transaction")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *batchSize < 1 {
+		return fmt.Errorf("batch size must be at least 1")
+	}
+
+	cipher, err := encryption.New(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.NewPostgresConnection(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	userDAO := dao.NewUserDAO(db, cfg.Users, cipher)
+	logger.Info("Rotating user encryption keys", "primary_key", cipher.PrimaryKeyID())
+
+	total, totalHistory := 0, 0
+	counts := make(map[string]int64)
+	err = forEachOrganization(ctx, db, func(ctx context.Context, orgID uuid.UUID) error {
+		after := uuid.Nil
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			last, rotated, err := userDAO.RotateKeys(ctx, after, *batchSize)
+			if err != nil {
+				return err
+			}
+			total += rotated
+			after = last
+			if rotated > 0 {
+				logger.Info("Users re-encrypted", "count", total, "organization_id", orgID)
+			}
+			if rotated < *batchSize {
+				break
+			}
+		}
+
+		afterEntry := int64(0)
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			last, rotated, err := userDAO.RotateHistoryKeys(ctx, afterEntry, *batchSize)
+			if err != nil {
+				return err
+			}
+			totalHistory += rotated
+			afterEntry = last
+			if rotated > 0 {
+				logger.Info("User history entries re-encrypted", "count", totalHistory, "organization_id", orgID)
+			}
+			if rotated < *batchSize {
+				break
+			}
+		}
+
+		orgCounts, err := userDAO.CountUsersByKey(ctx)
+		if err != nil {
+			return err
+		}
+		for keyID, count := range orgCounts {
+			counts[keyID] += count
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for keyID, count := range counts {
+		if keyID == "" {
+			keyID = "(none)"
+		}
+		logger.Info("Users per key", "key_id", keyID, "users", count)
+	}
+	logger.Info("Key rotation finished", "rotated", total, "history_rotated", totalHistory)
+
+	return nil
+}
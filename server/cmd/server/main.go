@@ -10,8 +10,11 @@ import (
 
 	"github.com/spurge/p4rsec/server/internal/config"
 	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/encryption"
 	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/mailer"
 	"github.com/spurge/p4rsec/server/internal/server"
+	"github.com/spurge/p4rsec/server/internal/storage"
 )
 
 func main() {
@@ -36,8 +39,23 @@ func main() {
 		logger.Fatal("Failed to connect to Redis", "error", err)
 	}
 
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		logger.Fatal("Failed to initialize storage", "error", err)
+	}
+
+	cipher, err := encryption.New(cfg.Encryption)
+	if err != nil {
+		logger.Fatal("Failed to initialize encryption", "error", err)
+	}
+
+	mail, err := mailer.New(cfg.Mailer, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize mailer", "error", err)
+	}
+
 	// Initialize and start server
-	srv := server.New(cfg, logger, db, redis)
+	srv := server.New(cfg, logger, db, redis, store, cipher, mail)
 	
 	// Start server in a goroutine
 	go func() {
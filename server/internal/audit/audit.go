@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionAttributeSchemaUpdate = "attribute_schema.update"
+	ActionGroupCreate           = "group.create"
+	ActionGroupUpdate           = "group.update"
+	ActionGroupDelete           = "group.delete"
+	ActionGroupMemberSet        = "group.member_set"
+	ActionGroupMemberRemove     = "group.member_remove"
+	ActionGroupSubgroupAdd      = "group.subgroup_add"
+	ActionGroupSubgroupRemove   = "group.subgroup_remove"
+	ActionInvitationAccept      = "invitation.accept"
+	ActionInvitationCreate      = "invitation.create"
+	ActionInvitationDecline     = "invitation.decline"
+	ActionInvitationResend      = "invitation.resend"
+	ActionInvitationRevoke      = "invitation.revoke"
+	ActionOrganizationCreate    = "organization.create"
+	ActionOrganizationUpdate    = "organization.update"
+	ActionSCIMTokenCreate       = "scim_token.create"
+	ActionSCIMTokenRevoke       = "scim_token.revoke"
+	ActionUserBlock             = "user.block"
+	ActionUserDataExport        = "user.data_export"
+	ActionUserFollow            = "user.follow"
+	ActionUserPreferences       = "user.preferences"
+	ActionUserPurge             = "user.purge"
+	ActionUserRenormalize       = "user.renormalize"
+	ActionUserUnblock           = "user.unblock"
+	ActionUserUnfollow          = "user.unfollow"
+)
+
+// UserAction returns the audit action for a user history operation, e.g.
+// "user.update".
+func UserAction(operation string) string {
+	return "user." + operation
+}
+
+// GenesisHash is the previous hash of the first event in the chain.
+var GenesisHash = strings.Repeat("0", 64)
+
+// Metadata describes where a change came from. Handlers attach it to the
+// request context; the DAOs copy it into every audit event they write.
+// Actor is only used when the write itself names no actor, as with
+// background jobs and admin commands. ClaimedActor is who the client says
+// is acting, which nothing verifies, and is kept apart from the actor.
+type Metadata struct {
+	Actor        string
+	ClaimedActor string
+	IP           string
+	RequestID    string
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying m.
+func WithMetadata(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+// MetadataFrom returns the metadata attached to ctx, if any.
+func MetadataFrom(ctx context.Context) Metadata {
+	m, _ := ctx.Value(metadataKey{}).(Metadata)
+	return m
+}
+
+// hashedEvent fixes the fields, and their order, that an event's hash
+// covers. Events with a changes digest are hashed over the digest in place
+// of the changes; older events have none and hash their changes directly.
+// Likewise only events recorded for an organization hash its ID, and only
+// those with a claimed actor hash it.
+type hashedEvent struct {
+	ID            int64           `json:"id"`
+	TenantID      string          `json:"tenant_id,omitempty"`
+	OccurredAt    string          `json:"occurred_at"`
+	Action        string          `json:"action"`
+	Actor         *string         `json:"actor"`
+	ClaimedActor  string          `json:"claimed_actor,omitempty"`
+	TargetType    string          `json:"target_type"`
+	TargetID      string          `json:"target_id"`
+	IP            *string         `json:"ip"`
+	RequestID     *string         `json:"request_id"`
+	Changes       json.RawMessage `json:"changes"`
+	ChangesDigest string          `json:"changes_digest,omitempty"`
+	PrevHash      string          `json:"prev_hash"`
+}
+
+// Digest returns the SHA-256 of an event's encoded changes.
+func Digest(changes json.RawMessage) string {
+	sum := sha256.Sum256(changes)
+	return hex.EncodeToString(sum[:])
+}
+
+// Hash returns the SHA-256 over e's content and the hash of the event before
+// it, so changing, removing or reordering any event breaks every hash after
+// it. OccurredAt is hashed at microsecond precision, as it is stored.
+func Hash(e *models.AuditEvent) string {
+	event := hashedEvent{
+		ID:         e.ID,
+		OccurredAt: e.OccurredAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		Action:     e.Action,
+		Actor:      e.Actor,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		IP:         e.IP,
+		RequestID:  e.RequestID,
+		Changes:    e.Changes,
+		PrevHash:   e.PrevHash,
+	}
+	if e.TenantID != nil {
+		event.TenantID = e.TenantID.String()
+	}
+	if e.ClaimedActor != nil {
+		event.ClaimedActor = *e.ClaimedActor
+	}
+	if e.ChangesDigest != nil {
+		event.Changes = nil
+		event.ChangesDigest = *e.ChangesDigest
+	}
+	if len(event.Changes) == 0 {
+		event.Changes = json.RawMessage("null")
+	}
+
+	payload, _ := json.Marshal(event)
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verifier checks events, fed to it in ID order, against the hash chain.
+type Verifier struct {
+	lastID   int64
+	lastHash string
+	count    int64
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{lastHash: GenesisHash}
+}
+
+// Check verifies the next event of the chain. IDs must follow each other
+// without gaps, each event must point at the hash of the one before it, and
+// its own hash must match its content. Redacted changes are only missing,
+// so they are not checked against their digest.
+func (v *Verifier) Check(e *models.AuditEvent) error {
+	if e.ID != v.lastID+1 {
+		return fmt.Errorf("event %d follows event %d: events are missing", e.ID, v.lastID)
+	}
+	if e.PrevHash != v.lastHash {
+		return fmt.Errorf("event %d does not link to the hash of event %d", e.ID, v.lastID)
+	}
+	if Hash(e) != e.Hash {
+		return fmt.Errorf("event %d does not match its hash: its content was changed", e.ID)
+	}
+	if e.ChangesDigest != nil && len(e.Changes) > 0 && Digest(e.Changes) != *e.ChangesDigest {
+		return fmt.Errorf("event %d does not match its changes digest: its changes were altered", e.ID)
+	}
+
+	v.lastID = e.ID
+	v.lastHash = e.Hash
+	v.count++
+	return nil
+}
+
+// Head returns the ID and hash of the last verified event and how many
+// events were verified.
+func (v *Verifier) Head() (int64, string, int64) {
+	return v.lastID, v.lastHash, v.count
+}
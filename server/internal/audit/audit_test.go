@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// testEvent returns an event with every field set and a changes digest.
+func testEvent() *models.AuditEvent {
+	tenantID := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	changes := json.RawMessage(`{"email":{"old":"ann@example.com","new":"ann@example.org"}}`)
+	digest := Digest(changes)
+	return &models.AuditEvent{
+		ID:            1,
+		TenantID:      &tenantID,
+		OccurredAt:    time.Date(2024, 5, 14, 9, 0, 0, 123456789, time.UTC),
+		Action:        "user.update",
+		Actor:         strPtr("user-1"),
+		TargetType:    "user",
+		TargetID:      "3f6c2c4e-9a1d-4b8e-8f3a-1c2d3e4f5a6b",
+		IP:            strPtr("192.0.2.1"),
+		RequestID:     strPtr("req-1"),
+		Changes:       changes,
+		ChangesDigest: &digest,
+		PrevHash:      GenesisHash,
+	}
+}
+
+// chain returns n linked events, numbered from 1.
+func chain(n int) []*models.AuditEvent {
+	events := make([]*models.AuditEvent, n)
+	prev := GenesisHash
+	for i := range events {
+		e := testEvent()
+		e.ID = int64(i + 1)
+		e.OccurredAt = e.OccurredAt.Add(time.Duration(i) * time.Second)
+		e.PrevHash = prev
+		e.Hash = Hash(e)
+		prev = e.Hash
+		events[i] = e
+	}
+	return events
+}
+
+func verify(events []*models.AuditEvent) error {
+	v := NewVerifier()
+	for _, e := range events {
+		if err := v.Check(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestDigest(t *testing.T) {
+	// SHA-256 of the empty string and of "{}"
+	if got := Digest(json.RawMessage("")); got != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("Digest(\"\") = %s", got)
+	}
+	if got := Digest(json.RawMessage("{}")); got != "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a" {
+		t.Errorf("Digest({}) = %s", got)
+	}
+}
+
+func TestHashIsStable(t *testing.T) {
+	// Stored events must keep hashing to what they were written with, so the
+	// encoding of an event must not change, and fields added since must be
+	// left out when they are unset
+	const want = "b234cbcc999cd611f8bda88eca6820631137c9a5b2b77ba53cb1ef8fe4fccd34"
+	if got := Hash(testEvent()); got != want {
+		t.Errorf("Hash = %s, want %s", got, want)
+	}
+
+	legacy := testEvent()
+	legacy.TenantID = nil
+	legacy.ChangesDigest = nil
+	const wantLegacy = "c637c081fb32e4a5ba452f4b1aeb1651fce572cb8262cc48595b2dcb8b1d102b"
+	if got := Hash(legacy); got != wantLegacy {
+		t.Errorf("Hash of an event without tenant and digest = %s, want %s", got, wantLegacy)
+	}
+}
+
+func TestHashCoversEveryField(t *testing.T) {
+	base := Hash(testEvent())
+	changes := map[string]func(e *models.AuditEvent){
+		"id":            func(e *models.AuditEvent) { e.ID = 2 },
+		"tenant":        func(e *models.AuditEvent) { e.TenantID = nil },
+		"occurred_at":   func(e *models.AuditEvent) { e.OccurredAt = e.OccurredAt.Add(time.Microsecond) },
+		"action":        func(e *models.AuditEvent) { e.Action = "user.delete" },
+		"actor":         func(e *models.AuditEvent) { e.Actor = strPtr("user-2") },
+		"no actor":      func(e *models.AuditEvent) { e.Actor = nil },
+		"claimed actor": func(e *models.AuditEvent) { e.ClaimedActor = strPtr("ann") },
+		"target type":   func(e *models.AuditEvent) { e.TargetType = "group" },
+		"target id":     func(e *models.AuditEvent) { e.TargetID = "other" },
+		"ip":            func(e *models.AuditEvent) { e.IP = strPtr("192.0.2.2") },
+		"request id":    func(e *models.AuditEvent) { e.RequestID = nil },
+		"digest":        func(e *models.AuditEvent) { e.ChangesDigest = strPtr(Digest(json.RawMessage("{}"))) },
+		"prev hash":     func(e *models.AuditEvent) { e.PrevHash = strings.Repeat("1", 64) },
+	}
+	for name, change := range changes {
+		e := testEvent()
+		change(e)
+		if Hash(e) == base {
+			t.Errorf("changing the %s does not change the hash", name)
+		}
+	}
+}
+
+func TestHashIgnoresSubMicrosecondsAndZone(t *testing.T) {
+	e := testEvent()
+	want := Hash(e)
+
+	e.OccurredAt = e.OccurredAt.Truncate(time.Microsecond).In(time.FixedZone("CEST", 2*60*60))
+	if got := Hash(e); got != want {
+		t.Error("the hash depends on nanoseconds or the time zone, which are not stored")
+	}
+}
+
+func TestHashWithDigestSurvivesRedaction(t *testing.T) {
+	e := testEvent()
+	want := Hash(e)
+
+	e.Changes = nil
+	if got := Hash(e); got != want {
+		t.Error("redacting the changes of an event with a digest changes its hash")
+	}
+
+	// Without a digest the changes themselves are hashed
+	legacy := testEvent()
+	legacy.ChangesDigest = nil
+	legacyHash := Hash(legacy)
+	legacy.Changes = nil
+	if Hash(legacy) == legacyHash {
+		t.Error("removing the changes of an event without a digest keeps its hash")
+	}
+}
+
+func TestVerifierAcceptsChain(t *testing.T) {
+	events := chain(3)
+	v := NewVerifier()
+	for _, e := range events {
+		if err := v.Check(e); err != nil {
+			t.Fatalf("Check(%d): %v", e.ID, err)
+		}
+	}
+
+	id, hash, count := v.Head()
+	if id != 3 || hash != events[2].Hash || count != 3 {
+		t.Errorf("Head = %d %s %d, want 3 %s 3", id, hash, count, events[2].Hash)
+	}
+}
+
+func TestVerifierAcceptsRedactedChanges(t *testing.T) {
+	events := chain(3)
+	events[1].Changes = nil
+	if err := verify(events); err != nil {
+		t.Errorf("redacted chain: %v", err)
+	}
+}
+
+func TestVerifierRejectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(events []*models.AuditEvent) []*models.AuditEvent
+		want   string
+	}{
+		{"missing event", func(events []*models.AuditEvent) []*models.AuditEvent {
+			return append(events[:1], events[2:]...)
+		}, "missing"},
+		{"missing first event", func(events []*models.AuditEvent) []*models.AuditEvent {
+			return events[1:]
+		}, "missing"},
+		{"reordered events", func(events []*models.AuditEvent) []*models.AuditEvent {
+			events[1], events[2] = events[2], events[1]
+			return events
+		}, "missing"},
+		{"renumbered event", func(events []*models.AuditEvent) []*models.AuditEvent {
+			events = append(events[:1], events[2:]...)
+			events[1].ID = 2
+			return events
+		}, "does not link"},
+		{"edited field", func(events []*models.AuditEvent) []*models.AuditEvent {
+			events[1].Actor = strPtr("someone-else")
+			return events
+		}, "does not match its hash"},
+		{"rehashed edit", func(events []*models.AuditEvent) []*models.AuditEvent {
+			// Rehashing the edited event breaks the link from the next one
+			events[1].Action = "user.create"
+			events[1].Hash = Hash(events[1])
+			return events
+		}, "does not link"},
+		{"edited changes", func(events []*models.AuditEvent) []*models.AuditEvent {
+			events[1].Changes = json.RawMessage(`{"email":{"old":"ann@example.com","new":"eve@example.org"}}`)
+			return events
+		}, "changes digest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(tt.tamper(chain(3)))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	if got := MetadataFrom(context.Background()); got != (Metadata{}) {
+		t.Errorf("MetadataFrom(empty) = %+v", got)
+	}
+
+	m := Metadata{Actor: "job:test", ClaimedActor: "ann", IP: "192.0.2.1", RequestID: "req-1"}
+	if got := MetadataFrom(WithMetadata(context.Background(), m)); got != m {
+		t.Errorf("MetadataFrom = %+v, want %+v", got, m)
+	}
+}
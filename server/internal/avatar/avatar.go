@@ -0,0 +1,154 @@
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+// Sizes are the edge lengths, in pixels, of the square thumbnails made from
+// every upload. DefaultSize is the one linked from a user's avatar_url.
+var Sizes = []int{64, 128, 256, 512}
+
+const DefaultSize = 256
+
+// ContentType is the type of every thumbnail.
+const ContentType = "image/jpeg"
+
+const jpegQuality = 85
+
+// ObjectKey returns the storage key of the thumbnail of size under the
+// avatar's key.
+func ObjectKey(key string, size int) string {
+	return fmt.Sprintf("%s/%d.jpg", key, size)
+}
+
+var (
+	// ErrUnsupportedFormat is returned for uploads that are not JPEG, PNG or
+	// GIF images, judged by their content rather than their declared type.
+	ErrUnsupportedFormat = errors.New("avatar must be a JPEG, PNG or GIF image")
+	// ErrInvalidImage is returned for uploads that cannot be decoded or whose
+	// dimensions are out of bounds.
+	ErrInvalidImage = errors.New("invalid avatar image")
+)
+
+// Avatar holds the thumbnails made from one upload. Hash identifies the
+// uploaded content, so storage keys that include it can be cached forever.
+type Avatar struct {
+	Hash       string
+	Thumbnails map[int][]byte
+}
+
+// Process decodes an uploaded image, crops it to a centered square and scales
+// it to every size in Sizes. Images with more than maxPixels pixels are
+// refused before they are decoded. Transparent areas become white.
+func Process(data []byte, maxPixels int) (*Avatar, error) {
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("%w: image is empty", ErrInvalidImage)
+	}
+	if maxPixels > 0 && cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("%w: image is larger than %d pixels", ErrInvalidImage, maxPixels)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	square := cropSquare(src)
+
+	avatar := &Avatar{Thumbnails: make(map[int][]byte, len(Sizes))}
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resize(square, size), &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, fmt.Errorf("failed to encode avatar: %w", err)
+		}
+		avatar.Thumbnails[size] = buf.Bytes()
+	}
+
+	sum := sha256.Sum256(data)
+	avatar.Hash = hex.EncodeToString(sum[:8])
+
+	return avatar, nil
+}
+
+// cropSquare returns the largest centered square of src, drawn over a white
+// background.
+func cropSquare(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, origin, draw.Over)
+	return dst
+}
+
+// resize scales the opaque square src to size×size. Each output pixel is the
+// average of the source pixels it covers, which keeps downscaled images free
+// of aliasing; images smaller than size are scaled up by repeating pixels.
+func resize(src *image.RGBA, size int) *image.RGBA {
+	n := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, size, n)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, size, n)
+
+			var r, g, b uint32
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					i += 4
+				}
+			}
+
+			count := uint32((x1 - x0) * (y1 - y0))
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / count)
+			dst.Pix[j+1] = uint8(g / count)
+			dst.Pix[j+2] = uint8(b / count)
+			dst.Pix[j+3] = 0xff
+		}
+	}
+
+	return dst
+}
+
+// span returns the range of source pixels, out of n, covered by output pixel
+// i of size. The range is never empty.
+func span(i, size, n int) (int, int) {
+	lo := i * n / size
+	hi := (i + 1) * n / size
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
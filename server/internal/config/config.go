@@ -10,12 +10,23 @@ import (
 )
 
 type Config struct {
-	Environment string   `mapstructure:"environment"`
-	Server      Server   `mapstructure:"server"`
-	Database    Database `mapstructure:"database"`
-	Redis       Redis    `mapstructure:"redis"`
-	Logger      Logger   `mapstructure:"logger"`
-	JWT         JWT      `mapstructure:"jwt"`
+	Environment string      `mapstructure:"environment"`
+	Server      Server      `mapstructure:"server"`
+	Database    Database    `mapstructure:"database"`
+	Redis       Redis       `mapstructure:"redis"`
+	Logger      Logger      `mapstructure:"logger"`
+	JWT         JWT         `mapstructure:"jwt"`
+	Retention   Retention   `mapstructure:"retention"`
+	Export      Export      `mapstructure:"export"`
+	Idempotency Idempotency `mapstructure:"idempotency"`
+	Users       Users       `mapstructure:"users"`
+	Storage     Storage     `mapstructure:"storage"`
+	Avatars     Avatars     `mapstructure:"avatars"`
+	Encryption  Encryption  `mapstructure:"encryption"`
+	Tenancy     Tenancy     `mapstructure:"tenancy"`
+	Mailer      Mailer      `mapstructure:"mailer"`
+	Invitations Invitations `mapstructure:"invitations"`
+	LDAP        LDAP        `mapstructure:"ldap"`
 }
 
 type Server struct {
@@ -49,9 +60,176 @@ type Logger struct {
 	Level string `mapstructure:"level"`
 }
 
+// JWT verifies the HS256 bearer tokens requests authenticate with. Tokens
+// whose RoleClaim, a string or a list of strings, holds AdminRole belong to
+// operators, who may use the admin API.
 type JWT struct {
 	Secret         string        `mapstructure:"secret"`
 	ExpirationTime time.Duration `mapstructure:"expiration_time"`
+	RoleClaim      string        `mapstructure:"role_claim"`
+	AdminRole      string        `mapstructure:"admin_role"`
+}
+
+// Retention controls how long soft-deleted data is kept. A zero period
+// disables purging.
+type Retention struct {
+	DeletedUsers  time.Duration `mapstructure:"deleted_users"`
+	PurgeInterval time.Duration `mapstructure:"purge_interval"`
+}
+
+// Export configures where asynchronous user exports are written and how
+// long they are kept.
+type Export struct {
+	Dir       string        `mapstructure:"dir"`
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+// Idempotency controls how long responses to requests carrying an
+// Idempotency-Key are kept for replay, and how long a request may hold its
+// key while it is being processed.
+type Idempotency struct {
+	TTL         time.Duration `mapstructure:"ttl"`
+	LockTimeout time.Duration `mapstructure:"lock_timeout"`
+}
+
+// Users controls how user identities are compared and changed. With
+// FoldPlusAddressing, "bob+news@example.com" and "bob@example.com" count as
+// the same email. A user may change username once per
+// UsernameChangeCooldown, and a released username stays reserved for its
+// previous owner for UsernameReservation.
+type Users struct {
+	FoldPlusAddressing     bool          `mapstructure:"fold_plus_addressing"`
+	UsernameChangeCooldown time.Duration `mapstructure:"username_change_cooldown"`
+	UsernameReservation    time.Duration `mapstructure:"username_reservation"`
+}
+
+// Storage selects where uploaded files such as avatars are kept: "local"
+// writes them under Dir and "s3" puts them in an S3-compatible bucket. When
+// PublicURL is set, files are linked from there (e.g. a CDN or public bucket)
+// instead of being served by the API.
+type Storage struct {
+	Driver    string `mapstructure:"driver"`
+	Dir       string `mapstructure:"dir"`
+	PublicURL string `mapstructure:"public_url"`
+	S3        S3     `mapstructure:"s3"`
+}
+
+// S3 addresses a bucket on AWS S3 or a compatible service such as MinIO.
+// PathStyle puts the bucket in the URL path rather than the host name, which
+// most self-hosted services need.
+type S3 struct {
+	Endpoint        string `mapstructure:"endpoint"`
+	Region          string `mapstructure:"region"`
+	Bucket          string `mapstructure:"bucket"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	PathStyle       bool   `mapstructure:"path_style"`
+}
+
+// Avatars limits avatar uploads by file size and by image dimensions, which
+// bound the memory needed to decode them.
+type Avatars struct {
+	MaxBytes  int64 `mapstructure:"max_bytes"`
+	MaxPixels int   `mapstructure:"max_pixels"`
+}
+
+// Encryption names the keyring that personal data is encrypted with.
+type Encryption struct {
+	KeyringFile string `mapstructure:"keyring_file"`
+}
+
+// Tenancy controls how a request's organization is resolved: from the
+// TokenClaim of its bearer token, from its subdomain under BaseDomain, or
+// from its Header, in that order. Requests that name no organization act for
+// DefaultOrganization, unless it is empty.
+type Tenancy struct {
+	BaseDomain          string `mapstructure:"base_domain"`
+	Header              string `mapstructure:"header"`
+	TokenClaim          string `mapstructure:"token_claim"`
+	DefaultOrganization string `mapstructure:"default_organization"`
+}
+
+// Mailer selects how emails are delivered: "log" only writes them to the
+// log, for development, and "smtp" sends them through an SMTP server. From
+// is the sender address, e.g. "p4rsec <no-reply@example.com>".
+type Mailer struct {
+	Driver string `mapstructure:"driver"`
+	From   string `mapstructure:"from"`
+	SMTP   SMTP   `mapstructure:"smtp"`
+}
+
+// SMTP addresses a mail server. Username and Password are only sent if
+// Username is set.
+type SMTP struct {
+	Host     string `mapstructure:"host"`
+	Port     string `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// Invitations expire TTL after they are sent. AcceptURL is the page the
+// invitation email links to; the token is appended as its token query
+// parameter.
+type Invitations struct {
+	TTL       time.Duration `mapstructure:"ttl"`
+	AcceptURL string        `mapstructure:"accept_url"`
+}
+
+// LDAP syncs the users and groups of Organization, given by slug, from a
+// directory every Interval; a zero Interval only syncs on request. URL is
+// "ldap://" or "ldaps://"; StartTLS upgrades a plain connection. Searches
+// read PageSize entries at a time. With DryRun scheduled syncs only report
+// what they would change, and Deactivate suspends users whose entries are
+// gone or disabled.
+type LDAP struct {
+	Organization string        `mapstructure:"organization"`
+	URL          string        `mapstructure:"url"`
+	StartTLS     bool          `mapstructure:"start_tls"`
+	BindDN       string        `mapstructure:"bind_dn"`
+	BindPassword string        `mapstructure:"bind_password"`
+	Interval     time.Duration `mapstructure:"interval"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	PageSize     int           `mapstructure:"page_size"`
+	DryRun       bool          `mapstructure:"dry_run"`
+	Deactivate   bool          `mapstructure:"deactivate"`
+	Users        LDAPUsers     `mapstructure:"users"`
+	Groups       LDAPGroups    `mapstructure:"groups"`
+}
+
+// LDAPUsers selects the entries under BaseDN that Filter matches as users.
+// Those DisabledFilter also matches, e.g.
+// "(userAccountControl:1.2.840.113556.1.4.803:=2)" on Active Directory, are
+// disabled. Attributes maps user fields onto entry attributes; ID names an
+// attribute that never changes, such as entryUUID or objectGUID.
+type LDAPUsers struct {
+	BaseDN         string             `mapstructure:"base_dn"`
+	Filter         string             `mapstructure:"filter"`
+	DisabledFilter string             `mapstructure:"disabled_filter"`
+	Attributes     LDAPUserAttributes `mapstructure:"attributes"`
+}
+
+type LDAPUserAttributes struct {
+	ID        string `mapstructure:"id"`
+	Username  string `mapstructure:"username"`
+	Email     string `mapstructure:"email"`
+	FirstName string `mapstructure:"first_name"`
+	LastName  string `mapstructure:"last_name"`
+}
+
+// LDAPGroups selects the entries under BaseDN that Filter matches as
+// groups. An empty Filter leaves groups alone. Member holds the DNs of a
+// group's members, users and groups alike.
+type LDAPGroups struct {
+	BaseDN     string              `mapstructure:"base_dn"`
+	Filter     string              `mapstructure:"filter"`
+	Attributes LDAPGroupAttributes `mapstructure:"attributes"`
+}
+
+type LDAPGroupAttributes struct {
+	ID          string `mapstructure:"id"`
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+	Member      string `mapstructure:"member"`
 }
 
 func Load() (*Config, error) {
@@ -132,4 +310,85 @@ func setDefaults() {
 	// JWT
 	viper.SetDefault("jwt.secret", "your-secret-key")
 	viper.SetDefault("jwt.expiration_time", "24h")
+	viper.SetDefault("jwt.role_claim", "role")
+	viper.SetDefault("jwt.admin_role", "admin")
+
+	// Retention
+	viper.SetDefault("retention.deleted_users", "720h")
+	viper.SetDefault("retention.purge_interval", "1h")
+
+	// Export
+	viper.SetDefault("export.dir", "./data/exports")
+	viper.SetDefault("export.retention", "24h")
+
+	// Idempotency
+	viper.SetDefault("idempotency.ttl", "24h")
+	viper.SetDefault("idempotency.lock_timeout", "30s")
+
+	// Users
+	viper.SetDefault("users.fold_plus_addressing", false)
+	viper.SetDefault("users.username_change_cooldown", "168h")
+	viper.SetDefault("users.username_reservation", "2160h")
+
+	// Storage
+	viper.SetDefault("storage.driver", "local")
+	viper.SetDefault("storage.dir", "./data/storage")
+	viper.SetDefault("storage.public_url", "")
+	viper.SetDefault("storage.s3.endpoint", "https://s3.amazonaws.com")
+	viper.SetDefault("storage.s3.region", "us-east-1")
+	viper.SetDefault("storage.s3.bucket", "")
+	viper.SetDefault("storage.s3.access_key_id", "")
+	viper.SetDefault("storage.s3.secret_access_key", "")
+	viper.SetDefault("storage.s3.path_style", false)
+
+	// Avatars
+	viper.SetDefault("avatars.max_bytes", 2097152)
+	viper.SetDefault("avatars.max_pixels", 25000000)
+
+	// Encryption
+	viper.SetDefault("encryption.keyring_file", "./configs/keyring.development.json")
+
+	// Tenancy
+	viper.SetDefault("tenancy.base_domain", "")
+	viper.SetDefault("tenancy.header", "X-Tenant-ID")
+	viper.SetDefault("tenancy.token_claim", "org")
+	viper.SetDefault("tenancy.default_organization", "default")
+
+	// Mailer
+	viper.SetDefault("mailer.driver", "log")
+	viper.SetDefault("mailer.from", "p4rsec <no-reply@localhost>")
+	viper.SetDefault("mailer.smtp.host", "")
+	viper.SetDefault("mailer.smtp.port", "587")
+	viper.SetDefault("mailer.smtp.username", "")
+	viper.SetDefault("mailer.smtp.password", "")
+
+	// Invitations
+	viper.SetDefault("invitations.ttl", "168h")
+	viper.SetDefault("invitations.accept_url", "http://localhost:3000/invitations/accept")
+
+	// LDAP
+	viper.SetDefault("ldap.organization", "")
+	viper.SetDefault("ldap.url", "")
+	viper.SetDefault("ldap.start_tls", false)
+	viper.SetDefault("ldap.bind_dn", "")
+	viper.SetDefault("ldap.bind_password", "")
+	viper.SetDefault("ldap.interval", "0s")
+	viper.SetDefault("ldap.timeout", "5m")
+	viper.SetDefault("ldap.page_size", 500)
+	viper.SetDefault("ldap.dry_run", false)
+	viper.SetDefault("ldap.deactivate", true)
+	viper.SetDefault("ldap.users.base_dn", "")
+	viper.SetDefault("ldap.users.filter", "(objectClass=inetOrgPerson)")
+	viper.SetDefault("ldap.users.disabled_filter", "")
+	viper.SetDefault("ldap.users.attributes.id", "entryUUID")
+	viper.SetDefault("ldap.users.attributes.username", "uid")
+	viper.SetDefault("ldap.users.attributes.email", "mail")
+	viper.SetDefault("ldap.users.attributes.first_name", "givenName")
+	viper.SetDefault("ldap.users.attributes.last_name", "sn")
+	viper.SetDefault("ldap.groups.base_dn", "")
+	viper.SetDefault("ldap.groups.filter", "(objectClass=groupOfNames)")
+	viper.SetDefault("ldap.groups.attributes.id", "entryUUID")
+	viper.SetDefault("ldap.groups.attributes.name", "cn")
+	viper.SetDefault("ldap.groups.attributes.description", "description")
+	viper.SetDefault("ldap.groups.attributes.member", "member")
 }
@@ -0,0 +1,306 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// auditChainLock is the advisory lock appends to the audit log take turns
+// on, so that each event links to the one committed before it.
+const auditChainLock = 4141
+
+// auditEventColumns is the column list every audit query selects, in the
+// order scanAuditEvent expects them.
+const auditEventColumns = `id, tenant_id, occurred_at, action, actor, claimed_actor, target_type, target_id, ip, request_id, changes, changes_digest, prev_hash, hash`
+
+// newAuditEvent describes a change for the audit log. The IP and request ID
+// come from the metadata on ctx, as does the actor if none is given, and the
+// event belongs to the organization ctx acts for.
+func newAuditEvent(ctx context.Context, action, actor, targetType, targetID string, changes interface{}) (*models.AuditEvent, error) {
+	meta := audit.MetadataFrom(ctx)
+	if actor == "" {
+		actor = meta.Actor
+	}
+
+	event := &models.AuditEvent{
+		OccurredAt:   time.Now().UTC().Truncate(time.Microsecond),
+		Action:       action,
+		Actor:        nullIfEmpty(actor),
+		ClaimedActor: nullIfEmpty(meta.ClaimedActor),
+		TargetType:   targetType,
+		TargetID:     targetID,
+		IP:           nullIfEmpty(meta.IP),
+		RequestID:    nullIfEmpty(meta.RequestID),
+	}
+	if tenantID, ok := tenant.FromContext(ctx); ok {
+		event.TenantID = &tenantID
+	}
+
+	if changes != nil {
+		encoded, err := json.Marshal(changes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode audit changes: %w", err)
+		}
+		digest := audit.Digest(encoded)
+		event.Changes = encoded
+		event.ChangesDigest = &digest
+	}
+
+	return event, nil
+}
+
+// appendAuditEvents adds events to the end of the audit chain, numbering and
+// hashing them. It runs in the caller's transaction when q is one, so events
+// are only kept if the changes they describe are committed.
+func appendAuditEvents(ctx context.Context, q querier, events ...*models.AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	return inTx(ctx, q, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+			return fmt.Errorf("failed to lock audit log: %w", err)
+		}
+
+		lastID, lastHash := int64(0), audit.GenesisHash
+		err := tx.QueryRow(ctx, `SELECT id, hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&lastID, &lastHash)
+		if err != nil && err != pgx.ErrNoRows {
+			return fmt.Errorf("failed to get audit log head: %w", err)
+		}
+
+		rows := make([][]interface{}, 0, len(events))
+		for _, e := range events {
+			e.ID = lastID + 1
+			e.PrevHash = lastHash
+			e.Hash = audit.Hash(e)
+			lastID, lastHash = e.ID, e.Hash
+
+			rows = append(rows, []interface{}{e.ID, e.TenantID, e.OccurredAt, e.Action, e.Actor, e.ClaimedActor,
+				e.TargetType, e.TargetID, e.IP, e.RequestID, e.Changes, e.ChangesDigest, e.PrevHash, e.Hash})
+		}
+
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"audit_events"}, strings.Split(auditEventColumns, ", "), pgx.CopyFromRows(rows))
+		if err != nil {
+			return fmt.Errorf("failed to append audit events: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// auditTenantCondition matches the events of the organization passed as
+// argument n. Events from before organizations existed have no tenant and
+// belong to the default organization.
+func auditTenantCondition(tenantID uuid.UUID, n int) string {
+	if tenantID == models.DefaultOrganizationID {
+		return fmt.Sprintf("(tenant_id = $%d OR tenant_id IS NULL)", n)
+	}
+	return fmt.Sprintf("tenant_id = $%d", n)
+}
+
+// redactAuditChanges clears the recorded changes of every event about the
+// target, keeping the events themselves and their place in the chain. It
+// returns how many events were redacted and how many could not be because
+// they predate changes digests.
+func redactAuditChanges(ctx context.Context, q querier, targetType, targetID string) (int64, int64, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	result, err := q.Exec(ctx, `
+		UPDATE audit_events
+		SET changes = NULL
+		WHERE target_type = $1 AND target_id = $2 AND `+auditTenantCondition(tenantID, 3)+`
+			AND changes IS NOT NULL AND changes_digest IS NOT NULL
+	`, targetType, targetID, tenantID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to redact audit events: %w", err)
+	}
+
+	var kept int64
+	err = q.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM audit_events
+		WHERE target_type = $1 AND target_id = $2 AND `+auditTenantCondition(tenantID, 3)+`
+			AND changes IS NOT NULL AND changes_digest IS NULL
+	`, targetType, targetID, tenantID).Scan(&kept)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count unredacted audit events: %w", err)
+	}
+
+	return result.RowsAffected(), kept, nil
+}
+
+// AuditDAO reads the audit log. Events are written by the DAOs that make the
+// changes they describe.
+type AuditDAO struct {
+	db *database.PostgresDB
+}
+
+func NewAuditDAO(db *database.PostgresDB) *AuditDAO {
+	return &AuditDAO{db: db}
+}
+
+func scanAuditEvent(row pgx.Row) (*models.AuditEvent, error) {
+	var e models.AuditEvent
+	err := row.Scan(&e.ID, &e.TenantID, &e.OccurredAt, &e.Action, &e.Actor, &e.ClaimedActor, &e.TargetType,
+		&e.TargetID, &e.IP, &e.RequestID, &e.Changes, &e.ChangesDigest, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// GetEvents returns the organization's events matching filter, newest
+// first.
+func (d *AuditDAO) GetEvents(ctx context.Context, filter models.AuditFilter, limit, offset int) ([]*models.AuditEvent, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{tenantID}
+	conditions := []string{auditTenantCondition(tenantID, 1)}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if filter.TargetType != "" {
+		add("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		add("target_id = $%d", filter.TargetID)
+	}
+	if filter.RequestID != "" {
+		add("request_id = $%d", filter.RequestID)
+	}
+	if filter.From != nil {
+		add("occurred_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("occurred_at < $%d", *filter.To)
+	}
+
+	args = append(args, limit, offset)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM audit_events
+		WHERE %s
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d
+	`, auditEventColumns, strings.Join(conditions, " AND "), len(args)-1, len(args))
+
+	rows, err := d.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate audit events: %w", rows.Err())
+	}
+
+	return events, nil
+}
+
+// Record appends an event for something that changes nothing the DAOs
+// record themselves, such as a read of personal data.
+func (d *AuditDAO) Record(ctx context.Context, action, actor, targetType, targetID string, details interface{}) error {
+	event, err := newAuditEvent(ctx, action, actor, targetType, targetID, details)
+	if err != nil {
+		return err
+	}
+	return appendAuditEvents(ctx, d.db.Pool, event)
+}
+
+// ForEachUserEvent calls fn with every event of the organization about the
+// user or made by it, in ID order.
+func (d *AuditDAO) ForEachUserEvent(ctx context.Context, userID string, fn func(*models.AuditEvent) error) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		SELECT ` + auditEventColumns + `
+		FROM audit_events
+		WHERE ((target_type = 'user' AND target_id = $1) OR actor = $1) AND ` + auditTenantCondition(tenantID, 2) + `
+		ORDER BY id
+	`
+
+	rows, err := d.db.Pool.Query(ctx, query, userID, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to get audit events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	if rows.Err() != nil {
+		return fmt.Errorf("failed to iterate audit events: %w", rows.Err())
+	}
+
+	return nil
+}
+
+// ForEachEvent calls fn with every event of every organization in ID order,
+// stopping at the first error fn returns. Rows are streamed, so the whole log is never held in
+// memory.
+func (d *AuditDAO) ForEachEvent(ctx context.Context, fn func(*models.AuditEvent) error) error {
+	rows, err := d.db.Pool.Query(ctx, `SELECT `+auditEventColumns+` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to get audit events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	if rows.Err() != nil {
+		return fmt.Errorf("failed to iterate audit events: %w", rows.Err())
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package dao
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+func TestNewAuditEvent(t *testing.T) {
+	tenantID := uuid.New()
+	ctx := tenant.WithID(context.Background(), tenantID)
+	ctx = audit.WithMetadata(ctx, audit.Metadata{Actor: "job:test", ClaimedActor: "ann", IP: "192.0.2.1", RequestID: "req-1"})
+
+	changes := map[string]models.FieldChange{"username": {Old: "ann", New: "anna"}}
+	event, err := newAuditEvent(ctx, audit.UserAction("update"), "user-1", "user", "target", changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Actor == nil || *event.Actor != "user-1" {
+		t.Errorf("actor = %v, want the one the write names", event.Actor)
+	}
+	if event.ClaimedActor == nil || *event.ClaimedActor != "ann" {
+		t.Errorf("claimed actor = %v, want ann", event.ClaimedActor)
+	}
+	if event.TenantID == nil || *event.TenantID != tenantID {
+		t.Errorf("tenant = %v, want %s", event.TenantID, tenantID)
+	}
+	if event.ChangesDigest == nil || *event.ChangesDigest != audit.Digest(event.Changes) {
+		t.Errorf("changes digest = %v, want the digest of %s", event.ChangesDigest, event.Changes)
+	}
+
+	// Jobs and commands name no actor and fall back to the metadata's
+	event, err = newAuditEvent(ctx, audit.ActionUserPurge, "", "user", "target", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Actor == nil || *event.Actor != "job:test" {
+		t.Errorf("actor = %v, want job:test", event.Actor)
+	}
+	if event.Changes != nil || event.ChangesDigest != nil {
+		t.Errorf("changes = %s with digest %v, want neither", event.Changes, event.ChangesDigest)
+	}
+
+	// The claimed actor is never promoted to the actor
+	event, err = newAuditEvent(audit.WithMetadata(ctx, audit.Metadata{ClaimedActor: "ann"}),
+		audit.ActionUserPurge, "", "user", "target", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Actor != nil {
+		t.Errorf("actor = %q, want none", *event.Actor)
+	}
+}
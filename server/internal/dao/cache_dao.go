@@ -2,12 +2,16 @@ package dao
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/spurge/p4rsec/server/internal/database"
 	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
 )
 
 type CacheDAO struct {
@@ -19,14 +23,34 @@ func NewCacheDAO(redis *database.RedisDB) *CacheDAO {
 }
 
 const (
-	UserCachePrefix    = "user:"
-	UsersCacheKey      = "users:list"
-	DefaultCacheExpiry = 1 * time.Hour
+	UserCachePrefix        = "user:"
+	UsersCacheKey          = "users:list"
+	PreferencesCacheSuffix = ":preferences"
+	DefaultCacheExpiry     = 1 * time.Hour
 )
 
+// TenantCachePrefix namespaces the keys of everything that belongs to an
+// organization: users, their preferences and sessions, user lists, jobs and
+// idempotency records. The generic and rate limiting methods below are not
+// namespaced.
+const TenantCachePrefix = "tenant:"
+
+// tenantKey prefixes key with the organization ctx acts for, failing without
+// one so that no entry is ever read or written outside a tenant.
+func tenantKey(ctx context.Context, key string) (string, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return "", err
+	}
+	return TenantCachePrefix + tenantID.String() + ":" + key, nil
+}
+
 // User caching methods
 func (d *CacheDAO) SetUser(ctx context.Context, user *models.User) error {
-	key := fmt.Sprintf("%s%s", UserCachePrefix, user.ID.String())
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s", UserCachePrefix, user.ID.String()))
+	if err != nil {
+		return err
+	}
 
 	data, err := json.Marshal(user)
 	if err != nil {
@@ -37,7 +61,10 @@ func (d *CacheDAO) SetUser(ctx context.Context, user *models.User) error {
 }
 
 func (d *CacheDAO) GetUser(ctx context.Context, userID string) (*models.User, error) {
-	key := fmt.Sprintf("%s%s", UserCachePrefix, userID)
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s", UserCachePrefix, userID))
+	if err != nil {
+		return nil, err
+	}
 
 	data, err := d.redis.Get(ctx, key)
 	if err != nil {
@@ -52,13 +79,168 @@ func (d *CacheDAO) GetUser(ctx context.Context, userID string) (*models.User, er
 	return &user, nil
 }
 
-func (d *CacheDAO) DeleteUser(ctx context.Context, userID string) error {
-	key := fmt.Sprintf("%s%s", UserCachePrefix, userID)
+// DeleteUser drops the cached users together with their preferences and
+// follow counts.
+func (d *CacheDAO) DeleteUser(ctx context.Context, userIDs ...string) error {
+	keys := make([]string, 0, 3*len(userIDs))
+	for _, userID := range userIDs {
+		for _, key := range []string{
+			fmt.Sprintf("%s%s", UserCachePrefix, userID),
+			fmt.Sprintf("%s%s%s", UserCachePrefix, userID, PreferencesCacheSuffix),
+			fmt.Sprintf("%s%s%s", UserCachePrefix, userID, FollowCountsCacheSuffix),
+		} {
+			key, err := tenantKey(ctx, key)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+		}
+	}
+	return d.redis.Delete(ctx, keys...)
+}
+
+// Preferences are cached next to the user they belong to
+func (d *CacheDAO) SetPreferences(ctx context.Context, userID string, prefs *models.UserPreferences) error {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s%s", UserCachePrefix, userID, PreferencesCacheSuffix))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal preferences: %w", err)
+	}
+
+	return d.redis.Set(ctx, key, data, DefaultCacheExpiry)
+}
+
+func (d *CacheDAO) GetPreferences(ctx context.Context, userID string) (*models.UserPreferences, error) {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s%s", UserCachePrefix, userID, PreferencesCacheSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := d.redis.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("preferences not found in cache: %w", err)
+	}
+
+	var prefs models.UserPreferences
+	if err := json.Unmarshal([]byte(data), &prefs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal preferences: %w", err)
+	}
+
+	return &prefs, nil
+}
+
+func (d *CacheDAO) DeletePreferences(ctx context.Context, userID string) error {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s%s", UserCachePrefix, userID, PreferencesCacheSuffix))
+	if err != nil {
+		return err
+	}
 	return d.redis.Delete(ctx, key)
 }
 
+// Follow counts are dropped whenever a follow or block changes them. The
+// expiry bounds how long they can lag behind users being deleted or
+// restored, which does not touch the counts of the users they follow.
+//
+// Counts read from the database before a change but cached after it would
+// hide the change, so each user's counts have a version that every change
+// bumps, and counts are only cached if the version they were read at is
+// still current. Versions outlive any read by far.
+const (
+	FollowCountsCacheSuffix   = ":follow_counts"
+	FollowCountsVersionSuffix = ":follow_counts_version"
+	FollowCountsCacheExpiry   = 10 * time.Minute
+)
+
+func followCountsKeys(ctx context.Context, userID string) (string, string, error) {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s%s", UserCachePrefix, userID, FollowCountsCacheSuffix))
+	if err != nil {
+		return "", "", err
+	}
+	version, err := tenantKey(ctx, fmt.Sprintf("%s%s%s", UserCachePrefix, userID, FollowCountsVersionSuffix))
+	if err != nil {
+		return "", "", err
+	}
+	return key, version, nil
+}
+
+// FollowCountsVersion returns the current version of the user's follow
+// counts, to be read before the counts and passed to SetFollowCounts.
+func (d *CacheDAO) FollowCountsVersion(ctx context.Context, userID string) (string, error) {
+	_, versionKey, err := followCountsKeys(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	version, err := d.redis.Get(ctx, versionKey)
+	if err == redis.Nil {
+		return "", nil
+	}
+	return version, err
+}
+
+// SetFollowCounts caches counts read at version, unless a change has bumped
+// the version since, and reports whether it did.
+func (d *CacheDAO) SetFollowCounts(ctx context.Context, userID, version string, counts *models.FollowCounts) (bool, error) {
+	key, versionKey, err := followCountsKeys(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := json.Marshal(counts)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal follow counts: %w", err)
+	}
+
+	return d.redis.SetIfEqual(ctx, versionKey, version, key, data, FollowCountsCacheExpiry)
+}
+
+func (d *CacheDAO) GetFollowCounts(ctx context.Context, userID string) (*models.FollowCounts, error) {
+	key, _, err := followCountsKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := d.redis.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts models.FollowCounts
+	if err := json.Unmarshal([]byte(data), &counts); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal follow counts: %w", err)
+	}
+
+	return &counts, nil
+}
+
+// DeleteFollowCounts bumps the version of the users' follow counts and drops
+// the cached counts, once a change to them has been committed.
+func (d *CacheDAO) DeleteFollowCounts(ctx context.Context, userIDs ...string) error {
+	keys := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		key, versionKey, err := followCountsKeys(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// Bumping first means a read racing the change cannot cache what
+		// it read after the counts are dropped
+		if _, err := d.redis.IncrWithExpiry(ctx, versionKey, 2*FollowCountsCacheExpiry); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	return d.redis.Delete(ctx, keys...)
+}
+
 func (d *CacheDAO) SetUsers(ctx context.Context, users []*models.User, page, limit int) error {
-	key := fmt.Sprintf("%s:%d:%d", UsersCacheKey, page, limit)
+	key, err := tenantKey(ctx, fmt.Sprintf("%s:%d:%d", UsersCacheKey, page, limit))
+	if err != nil {
+		return err
+	}
 
 	data, err := json.Marshal(users)
 	if err != nil {
@@ -69,7 +251,10 @@ func (d *CacheDAO) SetUsers(ctx context.Context, users []*models.User, page, lim
 }
 
 func (d *CacheDAO) GetUsers(ctx context.Context, page, limit int) ([]*models.User, error) {
-	key := fmt.Sprintf("%s:%d:%d", UsersCacheKey, page, limit)
+	key, err := tenantKey(ctx, fmt.Sprintf("%s:%d:%d", UsersCacheKey, page, limit))
+	if err != nil {
+		return nil, err
+	}
 
 	data, err := d.redis.Get(ctx, key)
 	if err != nil {
@@ -86,7 +271,10 @@ func (d *CacheDAO) GetUsers(ctx context.Context, page, limit int) ([]*models.Use
 
 func (d *CacheDAO) InvalidateUsersList(ctx context.Context) error {
 	// Use pattern matching to delete all users list cache entries
-	pattern := fmt.Sprintf("%s:*", UsersCacheKey)
+	pattern, err := tenantKey(ctx, fmt.Sprintf("%s:*", UsersCacheKey))
+	if err != nil {
+		return err
+	}
 
 	// Note: In production, you might want to use SCAN instead of KEYS for better performance
 	keys, err := d.redis.Client.Keys(ctx, pattern).Result()
@@ -101,6 +289,231 @@ func (d *CacheDAO) InvalidateUsersList(ctx context.Context) error {
 	return nil
 }
 
+// Import reports are kept long enough for the caller to download them
+const (
+	ImportReportPrefix = "import:report:"
+	ImportReportExpiry = 24 * time.Hour
+)
+
+func (d *CacheDAO) SetImportReport(ctx context.Context, reportID string, report interface{}) error {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s", ImportReportPrefix, reportID))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal import report: %w", err)
+	}
+
+	return d.redis.Set(ctx, key, data, ImportReportExpiry)
+}
+
+func (d *CacheDAO) GetImportReport(ctx context.Context, reportID string, report interface{}) error {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s", ImportReportPrefix, reportID))
+	if err != nil {
+		return err
+	}
+
+	data, err := d.redis.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("import report not found: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(data), report); err != nil {
+		return fmt.Errorf("failed to unmarshal import report: %w", err)
+	}
+
+	return nil
+}
+
+// Export job state expires together with the exported file
+const ExportJobPrefix = "export:job:"
+
+func (d *CacheDAO) SetExportJob(ctx context.Context, jobID string, job interface{}, expiration time.Duration) error {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s", ExportJobPrefix, jobID))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal export job: %w", err)
+	}
+
+	return d.redis.Set(ctx, key, data, expiration)
+}
+
+func (d *CacheDAO) GetExportJob(ctx context.Context, jobID string, job interface{}) error {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s", ExportJobPrefix, jobID))
+	if err != nil {
+		return err
+	}
+
+	data, err := d.redis.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("export job not found: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(data), job); err != nil {
+		return fmt.Errorf("failed to unmarshal export job: %w", err)
+	}
+
+	return nil
+}
+
+// Erasure jobs are kept long enough to answer for the erasure later on
+const (
+	ErasureJobPrefix = "erasure:job:"
+	ErasureJobExpiry = 30 * 24 * time.Hour
+)
+
+func (d *CacheDAO) SetErasureJob(ctx context.Context, jobID string, job interface{}) error {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s", ErasureJobPrefix, jobID))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal erasure job: %w", err)
+	}
+
+	return d.redis.Set(ctx, key, data, ErasureJobExpiry)
+}
+
+func (d *CacheDAO) GetErasureJob(ctx context.Context, jobID string, job interface{}) error {
+	key, err := tenantKey(ctx, fmt.Sprintf("%s%s", ErasureJobPrefix, jobID))
+	if err != nil {
+		return err
+	}
+
+	data, err := d.redis.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("erasure job not found: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(data), job); err != nil {
+		return fmt.Errorf("failed to unmarshal erasure job: %w", err)
+	}
+
+	return nil
+}
+
+// The last LDAP sync report is kept until a later sync replaces it
+const (
+	LDAPSyncReportKey    = "ldap_sync:report"
+	LDAPSyncReportExpiry = 30 * 24 * time.Hour
+)
+
+func (d *CacheDAO) SetLDAPSyncReport(ctx context.Context, report interface{}) error {
+	key, err := tenantKey(ctx, LDAPSyncReportKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ldap sync report: %w", err)
+	}
+
+	return d.redis.Set(ctx, key, data, LDAPSyncReportExpiry)
+}
+
+func (d *CacheDAO) GetLDAPSyncReport(ctx context.Context, report interface{}) error {
+	key, err := tenantKey(ctx, LDAPSyncReportKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := d.redis.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("ldap sync report not found: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(data), report); err != nil {
+		return fmt.Errorf("failed to unmarshal ldap sync report: %w", err)
+	}
+
+	return nil
+}
+
+// Idempotency records replay the response to a request retried with the
+// same Idempotency-Key; the lock marks a key whose request is in flight
+const (
+	IdempotencyRecordPrefix = "idempotency:record:"
+	IdempotencyLockPrefix   = "idempotency:lock:"
+)
+
+func (d *CacheDAO) SetIdempotencyRecord(ctx context.Context, key string, record interface{}, expiration time.Duration) error {
+	key, err := tenantKey(ctx, IdempotencyRecordPrefix+key)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	return d.redis.Set(ctx, key, data, expiration)
+}
+
+// GetIdempotencyRecord reports whether a record exists for key and, if so,
+// decodes it into record.
+func (d *CacheDAO) GetIdempotencyRecord(ctx context.Context, key string, record interface{}) (bool, error) {
+	key, err := tenantKey(ctx, IdempotencyRecordPrefix+key)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := d.redis.Client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get idempotency record: %w", err)
+	}
+
+	if err := json.Unmarshal(data, record); err != nil {
+		return false, fmt.Errorf("failed to unmarshal idempotency record: %w", err)
+	}
+
+	return true, nil
+}
+
+// LockIdempotencyKey claims key for one request, returning the token that
+// releases it. It returns false if another request holds it.
+func (d *CacheDAO) LockIdempotencyKey(ctx context.Context, key string, expiration time.Duration) (string, bool, error) {
+	key, err := tenantKey(ctx, IdempotencyLockPrefix+key)
+	if err != nil {
+		return "", false, err
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", false, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+
+	locked, err := d.redis.SetNX(ctx, key, token, expiration)
+	if err != nil || !locked {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// UnlockIdempotencyKey releases the lock on key if token still holds it.
+// A lock that expired and was claimed by another request is left alone.
+func (d *CacheDAO) UnlockIdempotencyKey(ctx context.Context, key, token string) error {
+	key, err := tenantKey(ctx, IdempotencyLockPrefix+key)
+	if err != nil {
+		return err
+	}
+	_, err = d.redis.DeleteIfEqual(ctx, key, token)
+	return err
+}
+
 // Generic cache methods
 func (d *CacheDAO) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	return d.redis.Set(ctx, key, value, expiration)
@@ -135,18 +548,153 @@ func (d *CacheDAO) IncrementRateLimit(ctx context.Context, key string, window ti
 	return count, nil
 }
 
-// Session management
+// Session management. Each user's session IDs are also kept in a set, so
+// that their sessions can be listed and revoked together.
+const UserSessionsPrefix = "user:sessions:"
+
+// sessionKey and userSessionsKey return the organization's keys for a
+// session and for a user's set of sessions.
+func sessionKey(ctx context.Context, sessionID string) (string, error) {
+	return tenantKey(ctx, fmt.Sprintf("session:%s", sessionID))
+}
+
+func userSessionsKey(ctx context.Context, userID string) (string, error) {
+	return tenantKey(ctx, UserSessionsPrefix+userID)
+}
+
+// UserSession is a live session. Its ID is a credential and is never
+// serialized.
+type UserSession struct {
+	ID        string     `json:"-"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
 func (d *CacheDAO) SetSession(ctx context.Context, sessionID string, userID string, expiration time.Duration) error {
-	key := fmt.Sprintf("session:%s", sessionID)
-	return d.redis.Set(ctx, key, userID, expiration)
+	key, err := sessionKey(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if err := d.redis.Set(ctx, key, userID, expiration); err != nil {
+		return err
+	}
+
+	setKey, err := userSessionsKey(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := d.redis.Client.SAdd(ctx, setKey, sessionID).Err(); err != nil {
+		return fmt.Errorf("failed to index session: %w", err)
+	}
+
+	// The set must outlive its longest session
+	ttl, err := d.redis.Client.TTL(ctx, setKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to index session: %w", err)
+	}
+	if expiration <= 0 {
+		return d.redis.Client.Persist(ctx, setKey).Err()
+	}
+	if ttl >= 0 && ttl < expiration {
+		return d.redis.Expire(ctx, setKey, expiration)
+	}
+	return nil
 }
 
 func (d *CacheDAO) GetSession(ctx context.Context, sessionID string) (string, error) {
-	key := fmt.Sprintf("session:%s", sessionID)
+	key, err := sessionKey(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
 	return d.redis.Get(ctx, key)
 }
 
 func (d *CacheDAO) DeleteSession(ctx context.Context, sessionID string) error {
-	key := fmt.Sprintf("session:%s", sessionID)
+	key, err := sessionKey(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if userID, err := d.redis.Get(ctx, key); err == nil {
+		setKey, err := userSessionsKey(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := d.redis.Client.SRem(ctx, setKey, sessionID).Err(); err != nil {
+			return fmt.Errorf("failed to unindex session: %w", err)
+		}
+	}
 	return d.redis.Delete(ctx, key)
 }
+
+// GetUserSessions returns the user's live sessions, dropping expired ones
+// from the index as it finds them.
+func (d *CacheDAO) GetUserSessions(ctx context.Context, userID string) ([]UserSession, error) {
+	setKey, err := userSessionsKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := d.redis.Client.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	sessions := make([]UserSession, 0, len(ids))
+	for _, id := range ids {
+		key, err := sessionKey(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := d.redis.Client.TTL(ctx, key).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get session: %w", err)
+		}
+
+		switch {
+		case ttl == -2:
+			// Expired; -2 is how Redis reports a missing key
+			if err := d.redis.Client.SRem(ctx, setKey, id).Err(); err != nil {
+				return nil, fmt.Errorf("failed to unindex session: %w", err)
+			}
+		case ttl < 0:
+			sessions = append(sessions, UserSession{ID: id})
+		default:
+			expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+			sessions = append(sessions, UserSession{ID: id, ExpiresAt: &expiresAt})
+		}
+	}
+
+	return sessions, nil
+}
+
+// DeleteUserSessions revokes every session of the user and returns how many
+// there were.
+func (d *CacheDAO) DeleteUserSessions(ctx context.Context, userID string) (int, error) {
+	setKey, err := userSessionsKey(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := d.redis.Client.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	keys := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		key, err := sessionKey(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	keys = append(keys, setKey)
+
+	deleted, err := d.redis.Client.Del(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	// The set itself was one of the deleted keys, if it existed
+	if len(ids) > 0 {
+		deleted--
+	}
+	return int(deleted), nil
+}
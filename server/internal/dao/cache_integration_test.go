@@ -0,0 +1,61 @@
+//go:build integration
+
+package dao
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+func TestFollowCountsVersion(t *testing.T) {
+	cache := NewCacheDAO(testRedis(t))
+	ctx := tenantContext(uuid.New())
+	id := uuid.NewString()
+
+	// Counts read before any change are cached
+	version, err := cache.FollowCountsVersion(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set, err := cache.SetFollowCounts(ctx, id, version, &models.FollowCounts{Followers: 1}); err != nil || !set {
+		t.Fatalf("counts read at the current version were not cached: %v", err)
+	}
+	if counts, err := cache.GetFollowCounts(ctx, id); err != nil || counts.Followers != 1 {
+		t.Fatalf("cached counts = %+v, %v", counts, err)
+	}
+
+	// A follow lands while the counts are being read again
+	version, err = cache.FollowCountsVersion(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.DeleteFollowCounts(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.GetFollowCounts(ctx, id); err == nil {
+		t.Error("the counts outlived a change to them")
+	}
+	if set, err := cache.SetFollowCounts(ctx, id, version, &models.FollowCounts{Followers: 1}); err != nil || set {
+		t.Fatalf("counts read before a change were cached after it: %v", err)
+	}
+	if _, err := cache.GetFollowCounts(ctx, id); err == nil {
+		t.Error("stale counts are cached")
+	}
+
+	// Counts read after the change are cached
+	version, err = cache.FollowCountsVersion(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version == "" {
+		t.Fatal("the change did not bump the version")
+	}
+	if set, err := cache.SetFollowCounts(ctx, id, version, &models.FollowCounts{Followers: 2}); err != nil || !set {
+		t.Fatalf("counts read after the change were not cached: %v", err)
+	}
+	if counts, err := cache.GetFollowCounts(ctx, id); err != nil || counts.Followers != 2 {
+		t.Errorf("cached counts = %+v, %v", counts, err)
+	}
+}
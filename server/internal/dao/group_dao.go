@@ -0,0 +1,582 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+const groupColumns = `id, tenant_id, name, description, created_at, updated_at`
+
+const groupMemberColumns = `group_id, user_id, role, created_at, updated_at`
+
+// GroupDAO manages the groups of the organization ctx acts for, their
+// members and their nesting.
+type GroupDAO struct {
+	db *database.PostgresDB
+}
+
+func NewGroupDAO(db *database.PostgresDB) *GroupDAO {
+	return &GroupDAO{db: db}
+}
+
+func scanGroup(row pgx.Row) (*models.Group, error) {
+	var group models.Group
+	if err := row.Scan(&group.ID, &group.TenantID, &group.Name, &group.Description, &group.CreatedAt, &group.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func scanGroupMember(row pgx.Row) (*models.GroupMember, error) {
+	var member models.GroupMember
+	if err := row.Scan(&member.GroupID, &member.UserID, &member.Role, &member.CreatedAt, &member.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// asGroupNameTaken maps a unique violation on the group name to the error
+// the handlers expect.
+func asGroupNameTaken(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return fmt.Errorf("group name already exists")
+	}
+	return nil
+}
+
+// Create inserts group into the organization, filling in its ID, tenant and
+// timestamps. It fails with "group name already exists" if the name is
+// taken.
+func (d *GroupDAO) Create(ctx context.Context, group *models.Group, actor string) error {
+	query := `
+		INSERT INTO groups (id, tenant_id, name, description, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	group.ID = uuid.New()
+	group.TenantID = tenantID
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+
+	return inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query, group.ID, group.TenantID, group.Name, group.Description, group.CreatedAt, group.UpdatedAt)
+		if err != nil {
+			if taken := asGroupNameTaken(err); taken != nil {
+				return taken
+			}
+			return fmt.Errorf("failed to create group: %w", err)
+		}
+
+		return recordGroupChange(ctx, tx, audit.ActionGroupCreate, actor, group.ID, map[string]models.FieldChange{
+			"name":        {New: group.Name},
+			"description": {New: group.Description},
+		})
+	})
+}
+
+func (d *GroupDAO) GetByID(ctx context.Context, id uuid.UUID) (*models.Group, error) {
+	return d.getGroup(ctx, d.db.Pool, id, false)
+}
+
+// getGroup reads a group of the organization, optionally locking it until
+// the surrounding transaction ends.
+func (d *GroupDAO) getGroup(ctx context.Context, q querier, id uuid.UUID, lock bool) (*models.Group, error) {
+	query := `SELECT ` + groupColumns + ` FROM groups WHERE id = $1 AND tenant_id = $2`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := scanGroup(q.QueryRow(ctx, query, id, tenantID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("group not found")
+		}
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+
+	return group, nil
+}
+
+func (d *GroupDAO) GetAll(ctx context.Context, limit, offset int) ([]*models.Group, error) {
+	query := `
+		SELECT ` + groupColumns + `
+		FROM groups
+		WHERE tenant_id = $1
+		ORDER BY name, id
+		LIMIT $2 OFFSET $3
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Pool.Query(ctx, query, tenantID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+
+	return collectGroups(rows)
+}
+
+func collectGroups(rows pgx.Rows) ([]*models.Group, error) {
+	defer rows.Close()
+
+	var groups []*models.Group
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		groups = append(groups, group)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate groups: %w", rows.Err())
+	}
+
+	return groups, nil
+}
+
+// Update replaces the group's name and description.
+func (d *GroupDAO) Update(ctx context.Context, id uuid.UUID, name, description, actor string) (*models.Group, error) {
+	query := `
+		UPDATE groups
+		SET name = $1, description = $2, updated_at = $3
+		WHERE id = $4 AND tenant_id = $5
+		RETURNING ` + groupColumns
+
+	var group *models.Group
+	err := inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		before, err := d.getGroup(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		group, err = scanGroup(tx.QueryRow(ctx, query, name, description, time.Now(), id, before.TenantID))
+		if err != nil {
+			if taken := asGroupNameTaken(err); taken != nil {
+				return taken
+			}
+			return fmt.Errorf("failed to update group: %w", err)
+		}
+
+		changes := make(map[string]models.FieldChange)
+		if before.Name != group.Name {
+			changes["name"] = models.FieldChange{Old: before.Name, New: group.Name}
+		}
+		if before.Description != group.Description {
+			changes["description"] = models.FieldChange{Old: before.Description, New: group.Description}
+		}
+		return recordGroupChange(ctx, tx, audit.ActionGroupUpdate, actor, id, changes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// Delete removes the group together with its memberships and nesting. Its
+// subgroups are kept.
+func (d *GroupDAO) Delete(ctx context.Context, id uuid.UUID, actor string) error {
+	return inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		before, err := d.getGroup(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM groups WHERE id = $1 AND tenant_id = $2`, id, before.TenantID); err != nil {
+			return fmt.Errorf("failed to delete group: %w", err)
+		}
+		if err := deleteExternalIDs(ctx, tx, "Group", id); err != nil {
+			return err
+		}
+
+		return recordGroupChange(ctx, tx, audit.ActionGroupDelete, actor, id, map[string]models.FieldChange{
+			"name": {Old: before.Name},
+		})
+	})
+}
+
+// SetMember makes the user a direct member of the group with role, or
+// changes the role of an existing member. It reports whether the user was
+// added. The user must belong to the organization and not be deleted.
+func (d *GroupDAO) SetMember(ctx context.Context, groupID, userID uuid.UUID, role models.GroupRole, actor string) (*models.GroupMember, bool, error) {
+	query := `
+		INSERT INTO group_members (group_id, user_id, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (group_id, user_id) DO UPDATE
+		SET role = EXCLUDED.role, updated_at = EXCLUDED.updated_at
+		RETURNING ` + groupMemberColumns
+
+	var member *models.GroupMember
+	var previous *models.GroupRole
+	err := inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		group, err := d.getGroup(ctx, tx, groupID, true)
+		if err != nil {
+			return err
+		}
+
+		var exists bool
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2 AND status <> 'deleted')
+		`, userID, group.TenantID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("user not found")
+		}
+
+		var current models.GroupRole
+		err = tx.QueryRow(ctx, `SELECT role FROM group_members WHERE group_id = $1 AND user_id = $2`, groupID, userID).Scan(&current)
+		if err == nil {
+			previous = &current
+		} else if err != pgx.ErrNoRows {
+			return fmt.Errorf("failed to get group member: %w", err)
+		}
+
+		member, err = scanGroupMember(tx.QueryRow(ctx, query, groupID, userID, role, time.Now()))
+		if err != nil {
+			return fmt.Errorf("failed to set group member: %w", err)
+		}
+
+		if previous != nil && *previous == role {
+			return nil
+		}
+		change := models.FieldChange{New: role}
+		if previous != nil {
+			change.Old = *previous
+		}
+		return recordGroupChange(ctx, tx, audit.ActionGroupMemberSet, actor, groupID, map[string]models.FieldChange{
+			"member." + userID.String(): change,
+		})
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return member, previous == nil, nil
+}
+
+// RemoveMember ends the user's direct membership of the group. It fails
+// with "group member not found" if there is none.
+func (d *GroupDAO) RemoveMember(ctx context.Context, groupID, userID uuid.UUID, actor string) error {
+	return inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		if _, err := d.getGroup(ctx, tx, groupID, true); err != nil {
+			return err
+		}
+
+		var role models.GroupRole
+		err := tx.QueryRow(ctx, `
+			DELETE FROM group_members WHERE group_id = $1 AND user_id = $2 RETURNING role
+		`, groupID, userID).Scan(&role)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return fmt.Errorf("group member not found")
+			}
+			return fmt.Errorf("failed to remove group member: %w", err)
+		}
+
+		return recordGroupChange(ctx, tx, audit.ActionGroupMemberRemove, actor, groupID, map[string]models.FieldChange{
+			"member." + userID.String(): {Old: role},
+		})
+	})
+}
+
+// GetMembers lists the users in the group who have not been deleted, by
+// user ID. Direct members come with their role. With transitive, members of
+// its subgroups, at any depth, are included too; each user is listed once,
+// as a direct member where they are one and otherwise with the subgroup
+// they belong to as GroupID.
+func (d *GroupDAO) GetMembers(ctx context.Context, groupID uuid.UUID, transitive bool, limit, offset int) ([]*models.GroupMember, error) {
+	query := `
+		SELECT m.group_id, m.user_id, m.role, m.created_at, m.updated_at
+		FROM group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1 AND u.tenant_id = $2 AND u.status <> 'deleted'
+		ORDER BY m.user_id
+		LIMIT $3 OFFSET $4
+	`
+	if transitive {
+		query = `
+			WITH RECURSIVE descendants(group_id) AS (
+				SELECT $1::uuid
+				UNION
+				SELECT s.child_id FROM group_subgroups s JOIN descendants d ON s.parent_id = d.group_id
+			)
+			SELECT ` + groupMemberColumns + ` FROM (
+				SELECT DISTINCT ON (m.user_id) m.group_id, m.user_id, m.role, m.created_at, m.updated_at
+				FROM group_members m
+				JOIN descendants d ON d.group_id = m.group_id
+				JOIN users u ON u.id = m.user_id
+				WHERE u.tenant_id = $2 AND u.status <> 'deleted'
+				ORDER BY m.user_id, m.group_id = $1 DESC, m.created_at
+			) members
+			ORDER BY user_id
+			LIMIT $3 OFFSET $4
+		`
+	}
+
+	group, err := d.GetByID(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Pool.Query(ctx, query, groupID, group.TenantID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []*models.GroupMember
+	for rows.Next() {
+		member, err := scanGroupMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate group members: %w", rows.Err())
+	}
+
+	return members, nil
+}
+
+// GetUserGroups lists the groups the user belongs to, by name. Direct
+// memberships come with the user's role. With transitive, the groups above
+// those, at any depth, are included too, with the subgroup the user belongs
+// through as Via. It fails with "user not found" unless the user belongs to
+// the organization.
+func (d *GroupDAO) GetUserGroups(ctx context.Context, userID uuid.UUID, transitive bool) ([]*models.GroupMembership, error) {
+	query := `
+		WITH RECURSIVE member_of(group_id, role, via) AS (
+			SELECT m.group_id, m.role, NULL::uuid
+			FROM group_members m
+			WHERE m.user_id = $1
+			UNION
+			SELECT s.parent_id, NULL, s.child_id
+			FROM group_subgroups s
+			JOIN member_of mo ON s.child_id = mo.group_id
+			WHERE $3
+		)
+		SELECT ` + groupColumns + `, role, via FROM (
+			SELECT DISTINCT ON (g.id) g.id, g.tenant_id, g.name, g.description, g.created_at, g.updated_at, mo.role, mo.via
+			FROM member_of mo
+			JOIN groups g ON g.id = mo.group_id
+			WHERE g.tenant_id = $2
+			ORDER BY g.id, mo.role IS NULL, mo.via
+		) groups
+		ORDER BY name, id
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err = d.db.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2)`, userID, tenantID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	rows, err := d.db.Pool.Query(ctx, query, userID, tenantID, transitive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user groups: %w", err)
+	}
+	defer rows.Close()
+
+	var memberships []*models.GroupMembership
+	for rows.Next() {
+		var group models.Group
+		var membership models.GroupMembership
+		err := rows.Scan(&group.ID, &group.TenantID, &group.Name, &group.Description, &group.CreatedAt, &group.UpdatedAt,
+			&membership.Role, &membership.Via)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user group: %w", err)
+		}
+		membership.Group = &group
+		memberships = append(memberships, &membership)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate user groups: %w", rows.Err())
+	}
+
+	return memberships, nil
+}
+
+// IsMember reports whether the user, if not deleted, belongs to the group
+// directly or through any of its subgroups. It walks up from the user's own
+// groups, so its cost depends on how many groups the user is in rather than
+// on the size of the group.
+func (d *GroupDAO) IsMember(ctx context.Context, groupID, userID uuid.UUID) (bool, error) {
+	query := `
+		WITH RECURSIVE member_of(group_id) AS (
+			SELECT m.group_id
+			FROM group_members m
+			JOIN users u ON u.id = m.user_id
+			WHERE m.user_id = $1 AND u.tenant_id = $3 AND u.status <> 'deleted'
+			UNION
+			SELECT s.parent_id
+			FROM group_subgroups s
+			JOIN member_of mo ON s.child_id = mo.group_id
+		)
+		SELECT EXISTS (SELECT 1 FROM member_of WHERE group_id = $2)
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var member bool
+	if err := d.db.Pool.QueryRow(ctx, query, userID, groupID, tenantID).Scan(&member); err != nil {
+		return false, fmt.Errorf("failed to check group membership: %w", err)
+	}
+	return member, nil
+}
+
+// GetSubgroups lists the groups nested directly in the group, by name.
+func (d *GroupDAO) GetSubgroups(ctx context.Context, groupID uuid.UUID) ([]*models.Group, error) {
+	query := `
+		SELECT g.id, g.tenant_id, g.name, g.description, g.created_at, g.updated_at
+		FROM group_subgroups s
+		JOIN groups g ON g.id = s.child_id
+		WHERE s.parent_id = $1 AND g.tenant_id = $2
+		ORDER BY g.name, g.id
+	`
+
+	group, err := d.GetByID(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Pool.Query(ctx, query, groupID, group.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subgroups: %w", err)
+	}
+
+	return collectGroups(rows)
+}
+
+// AddSubgroup nests child in parent. It fails with "subgroup would create a
+// cycle" if parent is child or already nested in it. Nesting changes within
+// an organization are serialized, so concurrent additions cannot close a
+// cycle between them. The organization's lock is taken before the groups
+// are, and the groups in ID order, so that additions in opposite directions
+// wait for each other instead of deadlocking.
+func (d *GroupDAO) AddSubgroup(ctx context.Context, parentID, childID uuid.UUID, actor string) error {
+	cycle := `
+		WITH RECURSIVE descendants(group_id) AS (
+			SELECT $1::uuid
+			UNION
+			SELECT s.child_id FROM group_subgroups s JOIN descendants d ON s.parent_id = d.group_id
+		)
+		SELECT EXISTS (SELECT 1 FROM descendants WHERE group_id = $2)
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`,
+			"group_subgroups:"+tenantID.String()); err != nil {
+			return fmt.Errorf("failed to lock groups: %w", err)
+		}
+
+		first, second := parentID, childID
+		if second.String() < first.String() {
+			first, second = second, first
+		}
+		for _, id := range []uuid.UUID{first, second} {
+			if _, err := d.getGroup(ctx, tx, id, true); err != nil {
+				return err
+			}
+		}
+
+		var cycles bool
+		if err := tx.QueryRow(ctx, cycle, childID, parentID).Scan(&cycles); err != nil {
+			return fmt.Errorf("failed to check subgroups: %w", err)
+		}
+		if cycles {
+			return fmt.Errorf("subgroup would create a cycle")
+		}
+
+		result, err := tx.Exec(ctx, `
+			INSERT INTO group_subgroups (parent_id, child_id, created_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, parentID, childID, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to add subgroup: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return nil
+		}
+
+		return recordGroupChange(ctx, tx, audit.ActionGroupSubgroupAdd, actor, parentID, map[string]models.FieldChange{
+			"subgroup." + childID.String(): {New: true},
+		})
+	})
+}
+
+// RemoveSubgroup un-nests child from parent. It fails with "subgroup not
+// found" if child is not directly nested in parent.
+func (d *GroupDAO) RemoveSubgroup(ctx context.Context, parentID, childID uuid.UUID, actor string) error {
+	return inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		if _, err := d.getGroup(ctx, tx, parentID, true); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(ctx, `DELETE FROM group_subgroups WHERE parent_id = $1 AND child_id = $2`, parentID, childID)
+		if err != nil {
+			return fmt.Errorf("failed to remove subgroup: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("subgroup not found")
+		}
+
+		return recordGroupChange(ctx, tx, audit.ActionGroupSubgroupRemove, actor, parentID, map[string]models.FieldChange{
+			"subgroup." + childID.String(): {Old: true},
+		})
+	})
+}
+
+func recordGroupChange(ctx context.Context, q querier, action, actor string, groupID uuid.UUID, changes map[string]models.FieldChange) error {
+	event, err := newAuditEvent(ctx, action, actor, "group", groupID.String(), changes)
+	if err != nil {
+		return err
+	}
+	return appendAuditEvents(ctx, q, event)
+}
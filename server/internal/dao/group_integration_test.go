@@ -0,0 +1,54 @@
+//go:build integration
+
+package dao
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+func TestAddSubgroupConcurrentOppositeDirections(t *testing.T) {
+	db := testDB(t)
+	_, ctx := testOrganization(t, db)
+	groups := NewGroupDAO(db)
+
+	for i := 0; i < 20; i++ {
+		a, b := &models.Group{Name: fmt.Sprintf("a%d", i)}, &models.Group{Name: fmt.Sprintf("b%d", i)}
+		if err := groups.Create(ctx, a, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if err := groups.Create(ctx, b, "test"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Nesting each in the other at once must neither deadlock nor
+		// close a cycle: one wins and the other sees the cycle
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for j, pair := range [][2]*models.Group{{a, b}, {b, a}} {
+			wg.Add(1)
+			go func(j int, parent, child *models.Group) {
+				defer wg.Done()
+				errs[j] = groups.AddSubgroup(ctx, parent.ID, child.ID, "test")
+			}(j, pair[0], pair[1])
+		}
+		wg.Wait()
+
+		failed := 0
+		for _, err := range errs {
+			if err == nil {
+				continue
+			}
+			if err.Error() != "subgroup would create a cycle" {
+				t.Fatalf("round %d: %v", i, err)
+			}
+			failed++
+		}
+		if failed != 1 {
+			t.Fatalf("round %d: %d of the two additions failed, want 1", i, failed)
+		}
+	}
+}
@@ -0,0 +1,561 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+const invitationColumns = `id, tenant_id, email, groups, status, invited_by, user_id, expires_at, sent_at, send_count, responded_at, created_at, updated_at, data_key, data_key_id`
+
+// ProfileError is returned when accepting an invitation needs to create a
+// user and the profile given for it is invalid.
+type ProfileError struct {
+	Err error
+}
+
+func (e *ProfileError) Error() string {
+	return e.Err.Error()
+}
+
+// InvitationDAO manages the invitations of the organization ctx acts for.
+// Accepting one creates or updates users, so it works through the UserDAO.
+type InvitationDAO struct {
+	users *UserDAO
+}
+
+func NewInvitationDAO(users *UserDAO) *InvitationDAO {
+	return &InvitationDAO{users: users}
+}
+
+// ParseInvitationToken returns the organization an invitation token belongs
+// to. It fails with "invitation not found" if the token is malformed; whether
+// it names an invitation is only known once it is looked up in that
+// organization.
+func ParseInvitationToken(token string) (uuid.UUID, error) {
+	tenantID, ok := tokenTenant(token)
+	if !ok {
+		return uuid.Nil, fmt.Errorf("invitation not found")
+	}
+	return tenantID, nil
+}
+
+// scanInvitation reads a row of invitationColumns and decrypts the email.
+// Pending invitations past their expiry are reported as expired.
+func (d *InvitationDAO) scanInvitation(ctx context.Context, row pgx.Row) (*models.Invitation, error) {
+	var invitation models.Invitation
+	var groups []byte
+	var dataKey []byte
+	var dataKeyID string
+	err := row.Scan(&invitation.ID, &invitation.TenantID, &invitation.Email, &groups, &invitation.Status,
+		&invitation.InvitedBy, &invitation.UserID, &invitation.ExpiresAt, &invitation.SentAt, &invitation.SendCount,
+		&invitation.RespondedAt, &invitation.CreatedAt, &invitation.UpdatedAt, &dataKey, &dataKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(groups, &invitation.Groups); err != nil {
+		return nil, fmt.Errorf("failed to decode invitation groups: %w", err)
+	}
+
+	envelope, err := d.users.cipher.OpenEnvelope(ctx, dataKeyID, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt invitation %s: %w", invitation.ID, err)
+	}
+	if invitation.Email, err = envelope.Open("email", invitation.Email); err != nil {
+		return nil, fmt.Errorf("failed to decrypt invitation %s: %w", invitation.ID, err)
+	}
+
+	if invitation.Status == models.InvitationStatusPending && !time.Now().Before(invitation.ExpiresAt) {
+		invitation.Status = models.InvitationStatusExpired
+	}
+	return &invitation, nil
+}
+
+// Create stores invitation, filling in its ID, tenant, status, expiry and
+// timestamps, and returns its token. It fails with "group not found" if a
+// granted group is not in the organization and with "invitation already
+// pending" if the email has an open invitation.
+func (d *InvitationDAO) Create(ctx context.Context, invitation *models.Invitation, ttl time.Duration, actor string) (string, error) {
+	query := `
+		INSERT INTO invitations (id, tenant_id, email, email_index, data_key, data_key_id, token_hash, groups, status,
+			invited_by, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12)
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if invitation.Groups == nil {
+		invitation.Groups = []models.InvitationGroup{}
+	}
+	groups, err := json.Marshal(invitation.Groups)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode invitation groups: %w", err)
+	}
+
+	token, tokenHash, err := newTenantToken(tenantID)
+	if err != nil {
+		return "", err
+	}
+
+	envelope, err := d.users.cipher.NewEnvelope(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt invitation: %w", err)
+	}
+	email, err := envelope.Seal("email", invitation.Email)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt invitation: %w", err)
+	}
+	emailIndex := d.users.emailIndex(invitation.Email)
+
+	invitation.ID = uuid.New()
+	invitation.TenantID = tenantID
+	invitation.Status = models.InvitationStatusPending
+	invitation.InvitedBy = nullIfEmpty(actor)
+	invitation.CreatedAt = time.Now()
+	invitation.UpdatedAt = invitation.CreatedAt
+	invitation.ExpiresAt = invitation.CreatedAt.Add(ttl)
+
+	err = inTx(ctx, d.users.q, func(tx pgx.Tx) error {
+		if err := checkInvitationGroups(ctx, tx, tenantID, invitation.Groups); err != nil {
+			return err
+		}
+
+		// An expired invitation no longer blocks a new one
+		_, err := tx.Exec(ctx, `
+			UPDATE invitations SET status = 'expired', updated_at = $3
+			WHERE tenant_id = $1 AND email_index = $2 AND status = 'pending' AND expires_at <= $3
+		`, tenantID, emailIndex, invitation.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to expire invitations: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, query, invitation.ID, tenantID, email, emailIndex, envelope.WrappedKey, envelope.KeyID,
+			tokenHash, groups, invitation.Status, invitation.InvitedBy, invitation.ExpiresAt, invitation.CreatedAt)
+		if err != nil {
+			if pending := asInvitationPending(err); pending != nil {
+				return pending
+			}
+			return fmt.Errorf("failed to create invitation: %w", err)
+		}
+
+		return recordInvitationChange(ctx, tx, audit.ActionInvitationCreate, actor, invitation.ID, map[string]models.FieldChange{
+			"status":     {New: invitation.Status},
+			"groups":     {New: invitation.Groups},
+			"expires_at": {New: invitation.ExpiresAt},
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// checkInvitationGroups fails with "group not found" unless every group
+// belongs to the organization.
+func checkInvitationGroups(ctx context.Context, q querier, tenantID uuid.UUID, groups []models.InvitationGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(groups))
+	for i, group := range groups {
+		ids[i] = group.GroupID
+	}
+
+	var found int
+	err := q.QueryRow(ctx, `SELECT COUNT(*) FROM groups WHERE tenant_id = $1 AND id = ANY($2)`, tenantID, ids).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("failed to get groups: %w", err)
+	}
+	if found != len(ids) {
+		return fmt.Errorf("group not found")
+	}
+	return nil
+}
+
+func asInvitationPending(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == "idx_invitations_pending_email" {
+		return fmt.Errorf("invitation already pending")
+	}
+	return nil
+}
+
+func (d *InvitationDAO) GetByID(ctx context.Context, id uuid.UUID) (*models.Invitation, error) {
+	return d.getInvitation(ctx, d.users.q, "id", id, false)
+}
+
+// GetByToken returns the invitation a token was issued for. It fails with
+// "invitation not found" for tokens that were replaced by a resend.
+func (d *InvitationDAO) GetByToken(ctx context.Context, token string) (*models.Invitation, error) {
+	return d.getInvitation(ctx, d.users.q, "token_hash", hashToken(token), false)
+}
+
+// getInvitation reads the invitation whose column matches value, optionally
+// locking it until the surrounding transaction ends.
+func (d *InvitationDAO) getInvitation(ctx context.Context, q querier, column string, value interface{}, lock bool) (*models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE ` + column + ` = $1 AND tenant_id = $2`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation, err := d.scanInvitation(ctx, q.QueryRow(ctx, query, value, tenantID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("invitation not found")
+		}
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+
+	return invitation, nil
+}
+
+// GetAll lists the organization's invitations, newest first, optionally only
+// those with status. Expired invitations are matched by their expiry rather
+// than their stored status.
+func (d *InvitationDAO) GetAll(ctx context.Context, status models.InvitationStatus, limit, offset int) ([]*models.Invitation, error) {
+	var where string
+	switch status {
+	case "":
+		where = `TRUE`
+	case models.InvitationStatusPending:
+		where = `status = 'pending' AND expires_at > $4`
+	case models.InvitationStatusExpired:
+		where = `(status = 'expired' OR (status = 'pending' AND expires_at <= $4))`
+	default:
+		where = `status = $4`
+	}
+
+	query := `
+		SELECT ` + invitationColumns + `
+		FROM invitations
+		WHERE tenant_id = $1 AND ` + where + `
+		ORDER BY created_at DESC, id
+		LIMIT $2 OFFSET $3
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{tenantID, limit, offset}
+	switch status {
+	case "":
+	case models.InvitationStatusPending, models.InvitationStatusExpired:
+		args = append(args, time.Now())
+	default:
+		args = append(args, status)
+	}
+
+	rows, err := d.users.q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invitations: %w", err)
+	}
+	defer rows.Close()
+
+	var invitations []*models.Invitation
+	for rows.Next() {
+		invitation, err := d.scanInvitation(ctx, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invitation: %w", err)
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate invitations: %w", rows.Err())
+	}
+
+	return invitations, nil
+}
+
+// MarkSent records that the invitation was delivered.
+func (d *InvitationDAO) MarkSent(ctx context.Context, id uuid.UUID) (*models.Invitation, error) {
+	query := `
+		UPDATE invitations
+		SET sent_at = $1, send_count = send_count + 1, updated_at = $1
+		WHERE id = $2 AND tenant_id = $3
+		RETURNING ` + invitationColumns
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation, err := d.scanInvitation(ctx, d.users.q.QueryRow(ctx, query, time.Now(), id, tenantID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("invitation not found")
+		}
+		return nil, fmt.Errorf("failed to mark invitation sent: %w", err)
+	}
+
+	return invitation, nil
+}
+
+// Renew gives a pending or expired invitation a new token and expiry, so
+// that it can be sent again, and returns the token. Earlier tokens stop
+// working. It fails with "invitation is not pending" once the invitation has
+// been answered or revoked, and with "invitation already pending" if the
+// email has been invited again since it expired.
+func (d *InvitationDAO) Renew(ctx context.Context, id uuid.UUID, ttl time.Duration, actor string) (*models.Invitation, string, error) {
+	query := `
+		UPDATE invitations
+		SET token_hash = $1, status = 'pending', expires_at = $2, updated_at = $3
+		WHERE id = $4 AND tenant_id = $5
+		RETURNING ` + invitationColumns
+
+	var invitation *models.Invitation
+	var token string
+	err := inTx(ctx, d.users.q, func(tx pgx.Tx) error {
+		before, err := d.getInvitation(ctx, tx, "id", id, true)
+		if err != nil {
+			return err
+		}
+		if before.Status != models.InvitationStatusPending && before.Status != models.InvitationStatusExpired {
+			return fmt.Errorf("invitation is not pending")
+		}
+
+		var tokenHash []byte
+		token, tokenHash, err = newTenantToken(before.TenantID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		invitation, err = d.scanInvitation(ctx, tx.QueryRow(ctx, query, tokenHash, now.Add(ttl), now, id, before.TenantID))
+		if err != nil {
+			if pending := asInvitationPending(err); pending != nil {
+				return pending
+			}
+			return fmt.Errorf("failed to renew invitation: %w", err)
+		}
+
+		return recordInvitationChange(ctx, tx, audit.ActionInvitationResend, actor, id, map[string]models.FieldChange{
+			"status":     {Old: before.Status, New: invitation.Status},
+			"expires_at": {Old: before.ExpiresAt, New: invitation.ExpiresAt},
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return invitation, token, nil
+}
+
+// Revoke withdraws a pending invitation. It fails with "invitation is not
+// pending" otherwise.
+func (d *InvitationDAO) Revoke(ctx context.Context, id uuid.UUID, actor string) (*models.Invitation, error) {
+	var invitation *models.Invitation
+	err := inTx(ctx, d.users.q, func(tx pgx.Tx) error {
+		before, err := d.getInvitation(ctx, tx, "id", id, true)
+		if err != nil {
+			return err
+		}
+
+		invitation, err = d.respond(ctx, tx, before, models.InvitationStatusRevoked, nil, audit.ActionInvitationRevoke, actor)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// Decline records that the invitee turned the invitation down. It fails with
+// "invitation is not pending" if it was already answered, revoked or has
+// expired.
+func (d *InvitationDAO) Decline(ctx context.Context, token string) (*models.Invitation, error) {
+	var invitation *models.Invitation
+	err := inTx(ctx, d.users.q, func(tx pgx.Tx) error {
+		before, err := d.getInvitation(ctx, tx, "token_hash", hashToken(token), true)
+		if err != nil {
+			return err
+		}
+
+		invitation, err = d.respond(ctx, tx, before, models.InvitationStatusDeclined, nil, audit.ActionInvitationDecline,
+			invitationActor(before))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// Accept redeems an invitation. If the organization has a user with the
+// invited email, that user is attached, and activated if still pending;
+// otherwise a user is created from profile, failing with a *ProfileError if
+// it is invalid. Either way the user is granted the invitation's groups,
+// keeping roles they already have where those are higher. Groups deleted
+// since are skipped. It reports whether the user was created, and fails
+// with "invitation is not pending" if the invitation was already answered,
+// revoked or has expired and with "user is suspended" if the existing user
+// is.
+func (d *InvitationDAO) Accept(ctx context.Context, token string, profile models.AcceptInvitationRequest) (*models.Invitation, *models.User, bool, error) {
+	var invitation *models.Invitation
+	var user *models.User
+	var created bool
+	err := d.users.InTx(ctx, func(tx *UserDAO) error {
+		before, err := d.getInvitation(ctx, tx.q, "token_hash", hashToken(token), true)
+		if err != nil {
+			return err
+		}
+		if before.Status != models.InvitationStatusPending {
+			return fmt.Errorf("invitation is not pending")
+		}
+		actor := invitationActor(before)
+
+		user, err = tx.GetByEmail(ctx, before.Email)
+		switch {
+		case err == nil && user.Status == models.UserStatusSuspended:
+			return fmt.Errorf("user is suspended")
+		case err == nil && user.Status == models.UserStatusPending:
+			user, err = tx.ChangeStatus(ctx, user.ID, models.UserStatusActive, "invitation accepted", actor)
+			if err != nil {
+				return err
+			}
+		case err == nil:
+		case err.Error() == "user not found":
+			user = &models.User{
+				Email:     before.Email,
+				Username:  profile.Username,
+				FirstName: profile.FirstName,
+				LastName:  profile.LastName,
+			}
+			if err := models.ValidateUser(user); err != nil {
+				return &ProfileError{Err: err}
+			}
+			if err := models.ValidateUsername(user.Username); err != nil {
+				return &ProfileError{Err: err}
+			}
+			if err := tx.Create(ctx, user, actor); err != nil {
+				return err
+			}
+			created = true
+		default:
+			return err
+		}
+
+		for _, group := range before.Groups {
+			if err := grantInvitationGroup(ctx, tx.q, before.TenantID, group, user.ID, actor); err != nil {
+				return err
+			}
+		}
+
+		invitation, err = d.respond(ctx, tx.q, before, models.InvitationStatusAccepted, &user.ID, audit.ActionInvitationAccept, actor)
+		return err
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	return invitation, user, created, nil
+}
+
+// grantInvitationGroup makes the user a member of the group with the
+// invitation's role, unless they already have that role or a higher one.
+// Groups that no longer exist are skipped.
+func grantInvitationGroup(ctx context.Context, q querier, tenantID uuid.UUID, group models.InvitationGroup, userID uuid.UUID, actor string) error {
+	var exists bool
+	err := q.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM groups WHERE id = $1 AND tenant_id = $2)`, group.GroupID, tenantID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to get group: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	change := models.FieldChange{New: group.Role}
+	var current models.GroupRole
+	err = q.QueryRow(ctx, `SELECT role FROM group_members WHERE group_id = $1 AND user_id = $2 FOR UPDATE`, group.GroupID, userID).Scan(&current)
+	switch {
+	case err == nil:
+		if current == group.Role || current == models.GroupRoleOwner {
+			return nil
+		}
+		change.Old = current
+	case err != pgx.ErrNoRows:
+		return fmt.Errorf("failed to get group member: %w", err)
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO group_members (group_id, user_id, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (group_id, user_id) DO UPDATE
+		SET role = EXCLUDED.role, updated_at = EXCLUDED.updated_at
+	`, group.GroupID, userID, group.Role, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to set group member: %w", err)
+	}
+
+	return recordGroupChange(ctx, q, audit.ActionGroupMemberSet, actor, group.GroupID, map[string]models.FieldChange{
+		"member." + userID.String(): change,
+	})
+}
+
+// respond moves a locked, pending invitation to its final status.
+func (d *InvitationDAO) respond(ctx context.Context, q querier, before *models.Invitation, status models.InvitationStatus, userID *uuid.UUID, action, actor string) (*models.Invitation, error) {
+	query := `
+		UPDATE invitations
+		SET status = $1, user_id = $2, responded_at = $3, updated_at = $3
+		WHERE id = $4 AND tenant_id = $5
+		RETURNING ` + invitationColumns
+
+	if before.Status != models.InvitationStatusPending {
+		return nil, fmt.Errorf("invitation is not pending")
+	}
+
+	invitation, err := d.scanInvitation(ctx, q.QueryRow(ctx, query, status, userID, time.Now(), before.ID, before.TenantID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update invitation: %w", err)
+	}
+
+	changes := map[string]models.FieldChange{
+		"status": {Old: before.Status, New: invitation.Status},
+	}
+	if userID != nil {
+		changes["user_id"] = models.FieldChange{New: *userID}
+	}
+	if err := recordInvitationChange(ctx, q, action, actor, invitation.ID, changes); err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// invitationActor is the actor recorded for what the invitee does through
+// the invitation.
+func invitationActor(invitation *models.Invitation) string {
+	return "invitation:" + invitation.ID.String()
+}
+
+func recordInvitationChange(ctx context.Context, q querier, action, actor string, invitationID uuid.UUID, changes map[string]models.FieldChange) error {
+	event, err := newAuditEvent(ctx, action, actor, "invitation", invitationID.String(), changes)
+	if err != nil {
+		return err
+	}
+	return appendAuditEvents(ctx, q, event)
+}
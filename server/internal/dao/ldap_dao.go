@@ -0,0 +1,102 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// LDAPDAO records which users and groups of the organization ctx acts for
+// LDAP sync manages, and the directory entries they come from.
+type LDAPDAO struct {
+	db *database.PostgresDB
+}
+
+func NewLDAPDAO(db *database.PostgresDB) *LDAPDAO {
+	return &LDAPDAO{db: db}
+}
+
+// GetLinks returns the links of all resources of a type, "User" or "Group".
+func (d *LDAPDAO) GetLinks(ctx context.Context, resourceType string) ([]*models.LDAPLink, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Pool.Query(ctx, `
+		SELECT resource_type, resource_id, entry_id, dn, deactivated, synced_at
+		FROM ldap_links WHERE tenant_id = $1 AND resource_type = $2
+		ORDER BY dn
+	`, tenantID, resourceType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ldap links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []*models.LDAPLink
+	for rows.Next() {
+		link := &models.LDAPLink{}
+		if err := rows.Scan(&link.ResourceType, &link.ResourceID, &link.EntryID, &link.DN, &link.Deactivated, &link.SyncedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan ldap link: %w", err)
+		}
+		links = append(links, link)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate ldap links: %w", rows.Err())
+	}
+
+	return links, nil
+}
+
+// SetLink links a resource to an entry, replacing any link either had.
+func (d *LDAPDAO) SetLink(ctx context.Context, link *models.LDAPLink) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			DELETE FROM ldap_links
+			WHERE tenant_id = $1 AND resource_type = $2 AND entry_id = $3 AND resource_id <> $4
+		`, tenantID, link.ResourceType, link.EntryID, link.ResourceID)
+		if err != nil {
+			return fmt.Errorf("failed to set ldap link: %w", err)
+		}
+
+		err = tx.QueryRow(ctx, `
+			INSERT INTO ldap_links (tenant_id, resource_type, resource_id, entry_id, dn, deactivated, synced_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW())
+			ON CONFLICT (resource_type, resource_id) DO UPDATE
+			SET entry_id = EXCLUDED.entry_id, dn = EXCLUDED.dn, deactivated = EXCLUDED.deactivated, synced_at = EXCLUDED.synced_at
+			RETURNING synced_at
+		`, tenantID, link.ResourceType, link.ResourceID, link.EntryID, link.DN, link.Deactivated).Scan(&link.SyncedAt)
+		if err != nil {
+			return fmt.Errorf("failed to set ldap link: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteLink unlinks a resource, leaving it to be managed by hand.
+func (d *LDAPDAO) DeleteLink(ctx context.Context, resourceType string, id uuid.UUID) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Pool.Exec(ctx, `
+		DELETE FROM ldap_links WHERE tenant_id = $1 AND resource_type = $2 AND resource_id = $3
+	`, tenantID, resourceType, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete ldap link: %w", err)
+	}
+
+	return nil
+}
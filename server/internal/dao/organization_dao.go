@@ -0,0 +1,195 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+const organizationColumns = `id, slug, name, created_at, updated_at`
+
+// OrganizationDAO manages the tenants of the deployment. Unlike the other
+// DAOs it is not scoped to a tenant; its writes are audited as part of the
+// organization they change.
+type OrganizationDAO struct {
+	db *database.PostgresDB
+}
+
+func NewOrganizationDAO(db *database.PostgresDB) *OrganizationDAO {
+	return &OrganizationDAO{db: db}
+}
+
+func scanOrganization(row pgx.Row) (*models.Organization, error) {
+	var org models.Organization
+	if err := row.Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt, &org.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// Create inserts org, filling in its ID and timestamps. It fails with
+// "organization slug already exists" if the slug is taken.
+func (d *OrganizationDAO) Create(ctx context.Context, org *models.Organization, actor string) error {
+	query := `
+		INSERT INTO organizations (id, slug, name, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	org.ID = uuid.New()
+	org.CreatedAt = time.Now()
+	org.UpdatedAt = org.CreatedAt
+
+	return inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query, org.ID, org.Slug, org.Name, org.CreatedAt, org.UpdatedAt)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+				return fmt.Errorf("organization slug already exists")
+			}
+			return fmt.Errorf("failed to create organization: %w", err)
+		}
+
+		return recordOrganizationChange(ctx, tx, audit.ActionOrganizationCreate, actor, org, map[string]models.FieldChange{
+			"slug": {New: org.Slug},
+			"name": {New: org.Name},
+		})
+	})
+}
+
+func (d *OrganizationDAO) GetByID(ctx context.Context, id uuid.UUID) (*models.Organization, error) {
+	query := `SELECT ` + organizationColumns + ` FROM organizations WHERE id = $1`
+
+	org, err := scanOrganization(d.db.Pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("organization not found")
+		}
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	return org, nil
+}
+
+func (d *OrganizationDAO) GetBySlug(ctx context.Context, slug string) (*models.Organization, error) {
+	query := `SELECT ` + organizationColumns + ` FROM organizations WHERE slug = $1`
+
+	org, err := scanOrganization(d.db.Pool.QueryRow(ctx, query, slug))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("organization not found")
+		}
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	return org, nil
+}
+
+func (d *OrganizationDAO) GetAll(ctx context.Context, limit, offset int) ([]*models.Organization, error) {
+	query := `
+		SELECT ` + organizationColumns + `
+		FROM organizations
+		ORDER BY created_at, id
+		LIMIT $1 OFFSET $2
+	`
+
+	rows, err := d.db.Pool.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organizations: %w", err)
+	}
+	defer rows.Close()
+
+	var orgs []*models.Organization
+	for rows.Next() {
+		org, err := scanOrganization(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		orgs = append(orgs, org)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate organizations: %w", rows.Err())
+	}
+
+	return orgs, nil
+}
+
+// GetAllIDs returns the ID of every organization, for work that has to visit
+// each tenant in turn.
+func (d *OrganizationDAO) GetAllIDs(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := d.db.Pool.Query(ctx, `SELECT id FROM organizations ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organizations: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan organization id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate organizations: %w", rows.Err())
+	}
+
+	return ids, nil
+}
+
+// Rename changes the organization's display name. Slugs never change.
+func (d *OrganizationDAO) Rename(ctx context.Context, id uuid.UUID, name, actor string) (*models.Organization, error) {
+	query := `
+		UPDATE organizations
+		SET name = $1, updated_at = $2
+		WHERE id = $3
+		RETURNING ` + organizationColumns
+
+	var org *models.Organization
+	err := inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		var previous string
+		err := tx.QueryRow(ctx, `SELECT name FROM organizations WHERE id = $1 FOR UPDATE`, id).Scan(&previous)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return fmt.Errorf("organization not found")
+			}
+			return fmt.Errorf("failed to get organization: %w", err)
+		}
+
+		org, err = scanOrganization(tx.QueryRow(ctx, query, name, time.Now(), id))
+		if err != nil {
+			return fmt.Errorf("failed to rename organization: %w", err)
+		}
+
+		return recordOrganizationChange(ctx, tx, audit.ActionOrganizationUpdate, actor, org, map[string]models.FieldChange{
+			"name": {Old: previous, New: name},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// recordOrganizationChange audits a change to org within the organization
+// itself, whichever tenant ctx acts for.
+func recordOrganizationChange(ctx context.Context, q querier, action, actor string, org *models.Organization, changes map[string]models.FieldChange) error {
+	ctx = tenant.WithID(ctx, org.ID)
+	event, err := newAuditEvent(ctx, action, actor, "organization", org.ID.String(), changes)
+	if err != nil {
+		return err
+	}
+	return appendAuditEvents(ctx, q, event)
+}
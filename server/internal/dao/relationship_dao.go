@@ -0,0 +1,440 @@
+package dao
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// RelationshipDAO keeps the follows and blocks between users of the
+// organization ctx acts for. Deleted users neither follow nor are followed:
+// they are left out of lists and counts, and cannot be followed or blocked.
+type RelationshipDAO struct {
+	db *database.PostgresDB
+}
+
+func NewRelationshipDAO(db *database.PostgresDB) *RelationshipDAO {
+	return &RelationshipDAO{db: db}
+}
+
+// Follow makes the user follow the target. It reports whether the follow is
+// new; following again changes nothing. It fails with "user is blocked" if
+// either user blocks the other.
+func (d *RelationshipDAO) Follow(ctx context.Context, userID, targetID uuid.UUID, actor string) (*models.Follow, bool, error) {
+	if userID == targetID {
+		return nil, false, fmt.Errorf("cannot follow yourself")
+	}
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	follow := &models.Follow{FollowerID: userID, FolloweeID: targetID}
+	created := false
+	err = inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		if err := lockPair(ctx, tx, tenantID, userID, targetID); err != nil {
+			return err
+		}
+
+		var blocked bool
+		err := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM user_blocks
+				WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+			)
+		`, userID, targetID).Scan(&blocked)
+		if err != nil {
+			return fmt.Errorf("failed to check blocks: %w", err)
+		}
+		if blocked {
+			return fmt.Errorf("user is blocked")
+		}
+
+		err = tx.QueryRow(ctx, `
+			INSERT INTO user_follows (tenant_id, follower_id, followee_id, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (follower_id, followee_id) DO NOTHING
+			RETURNING created_at
+		`, tenantID, userID, targetID, time.Now()).Scan(&follow.CreatedAt)
+		if err == pgx.ErrNoRows {
+			err = tx.QueryRow(ctx, `
+				SELECT created_at FROM user_follows WHERE follower_id = $1 AND followee_id = $2
+			`, userID, targetID).Scan(&follow.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to get follow: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to follow user: %w", err)
+		}
+
+		created = true
+		return recordRelationshipChange(ctx, tx, audit.ActionUserFollow, actor, userID, "following."+targetID.String(), true)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return follow, created, nil
+}
+
+// Unfollow stops the user following the target. It fails with "follow not
+// found" if it does not.
+func (d *RelationshipDAO) Unfollow(ctx context.Context, userID, targetID uuid.UUID, actor string) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+			DELETE FROM user_follows WHERE tenant_id = $1 AND follower_id = $2 AND followee_id = $3
+		`, tenantID, userID, targetID)
+		if err != nil {
+			return fmt.Errorf("failed to unfollow user: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("follow not found")
+		}
+
+		return recordRelationshipChange(ctx, tx, audit.ActionUserUnfollow, actor, userID, "following."+targetID.String(), false)
+	})
+}
+
+// Block makes the user block the target, ending any follow between the two
+// in either direction. It reports whether the block is new; blocking again
+// changes nothing.
+func (d *RelationshipDAO) Block(ctx context.Context, userID, targetID uuid.UUID, actor string) (*models.Block, bool, error) {
+	if userID == targetID {
+		return nil, false, fmt.Errorf("cannot block yourself")
+	}
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	block := &models.Block{BlockerID: userID, BlockedID: targetID}
+	created := false
+	err = inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		if err := lockPair(ctx, tx, tenantID, userID, targetID); err != nil {
+			return err
+		}
+
+		err := tx.QueryRow(ctx, `
+			INSERT INTO user_blocks (tenant_id, blocker_id, blocked_id, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (blocker_id, blocked_id) DO NOTHING
+			RETURNING created_at
+		`, tenantID, userID, targetID, time.Now()).Scan(&block.CreatedAt)
+		if err == pgx.ErrNoRows {
+			err = tx.QueryRow(ctx, `
+				SELECT created_at FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2
+			`, userID, targetID).Scan(&block.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to get block: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to block user: %w", err)
+		}
+		created = true
+
+		rows, err := tx.Query(ctx, `
+			DELETE FROM user_follows
+			WHERE (follower_id = $1 AND followee_id = $2) OR (follower_id = $2 AND followee_id = $1)
+			RETURNING follower_id, followee_id
+		`, userID, targetID)
+		if err != nil {
+			return fmt.Errorf("failed to end follows: %w", err)
+		}
+		var ended []models.Follow
+		for rows.Next() {
+			var follow models.Follow
+			if err := rows.Scan(&follow.FollowerID, &follow.FolloweeID); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan follow: %w", err)
+			}
+			ended = append(ended, follow)
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return fmt.Errorf("failed to end follows: %w", rows.Err())
+		}
+
+		changes := map[string]models.FieldChange{
+			"blocking." + targetID.String(): {Old: false, New: true},
+		}
+		for _, follow := range ended {
+			if follow.FollowerID == userID {
+				changes["following."+targetID.String()] = models.FieldChange{Old: true, New: false}
+			} else {
+				changes["followed_by."+targetID.String()] = models.FieldChange{Old: true, New: false}
+			}
+		}
+		event, err := newAuditEvent(ctx, audit.ActionUserBlock, actor, "user", userID.String(), changes)
+		if err != nil {
+			return err
+		}
+		return appendAuditEvents(ctx, tx, event)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return block, created, nil
+}
+
+// Unblock lifts the user's block of the target. Follows the block ended are
+// not restored. It fails with "block not found" if there is none.
+func (d *RelationshipDAO) Unblock(ctx context.Context, userID, targetID uuid.UUID, actor string) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+			DELETE FROM user_blocks WHERE tenant_id = $1 AND blocker_id = $2 AND blocked_id = $3
+		`, tenantID, userID, targetID)
+		if err != nil {
+			return fmt.Errorf("failed to unblock user: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("block not found")
+		}
+
+		return recordRelationshipChange(ctx, tx, audit.ActionUserUnblock, actor, userID, "blocking."+targetID.String(), false)
+	})
+}
+
+// GetFollowers lists the users following the user, newest first, limit at
+// a time. cursor is the NextCursor of the previous page, or empty for the
+// first. It fails with "invalid cursor" if cursor was not issued by a
+// followers list.
+func (d *RelationshipDAO) GetFollowers(ctx context.Context, userID uuid.UUID, cursor string, limit int) (*models.FollowPage, error) {
+	return d.getFollows(ctx, userID, "followee_id", "follower_id", cursor, limit)
+}
+
+// GetFollowing lists the users the user follows, newest first, as
+// GetFollowers does.
+func (d *RelationshipDAO) GetFollowing(ctx context.Context, userID uuid.UUID, cursor string, limit int) (*models.FollowPage, error) {
+	return d.getFollows(ctx, userID, "follower_id", "followee_id", cursor, limit)
+}
+
+// getFollows pages through the follows whose column is the user, ordered by
+// when they were made and then by the other user's ID. The cursor holds
+// the position of the last follow of the previous page, so pages neither
+// skip nor repeat follows as others are added or removed.
+func (d *RelationshipDAO) getFollows(ctx context.Context, userID uuid.UUID, column, other, cursor string, limit int) (*models.FollowPage, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var after *time.Time
+	var afterID *uuid.UUID
+	if cursor != "" {
+		at, id, err := decodeFollowCursor(cursor, other)
+		if err != nil {
+			return nil, err
+		}
+		after, afterID = &at, &id
+	}
+
+	if err := requireUsers(ctx, d.db.Pool, tenantID, userID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT f.follower_id, f.followee_id, f.created_at
+		FROM user_follows f
+		JOIN users u ON u.id = f.` + other + `
+		WHERE f.tenant_id = $1 AND f.` + column + ` = $2 AND u.status <> 'deleted'
+			AND ($3::timestamptz IS NULL OR (f.created_at, f.` + other + `) < ($3::timestamptz, $4::uuid))
+		ORDER BY f.created_at DESC, f.` + other + ` DESC
+		LIMIT $5
+	`
+
+	// One more than a page tells whether there is another
+	rows, err := d.db.Pool.Query(ctx, query, tenantID, userID, after, afterID, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get follows: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.FollowPage{Follows: []*models.Follow{}}
+	for rows.Next() {
+		follow := &models.Follow{}
+		if err := rows.Scan(&follow.FollowerID, &follow.FolloweeID, &follow.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan follow: %w", err)
+		}
+		page.Follows = append(page.Follows, follow)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate follows: %w", rows.Err())
+	}
+
+	if len(page.Follows) > limit {
+		page.Follows = page.Follows[:limit]
+		last := page.Follows[limit-1]
+		id := last.FolloweeID
+		if other == "follower_id" {
+			id = last.FollowerID
+		}
+		page.NextCursor = encodeFollowCursor(other, last.CreatedAt, id)
+	}
+
+	return page, nil
+}
+
+// CountFollows counts the user's followers and the users it follows.
+func (d *RelationshipDAO) CountFollows(ctx context.Context, userID uuid.UUID) (*models.FollowCounts, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireUsers(ctx, d.db.Pool, tenantID, userID); err != nil {
+		return nil, err
+	}
+
+	counts := &models.FollowCounts{}
+	err = d.db.Pool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM user_follows f JOIN users u ON u.id = f.follower_id
+				WHERE f.tenant_id = $1 AND f.followee_id = $2 AND u.status <> 'deleted'),
+			(SELECT COUNT(*) FROM user_follows f JOIN users u ON u.id = f.followee_id
+				WHERE f.tenant_id = $1 AND f.follower_id = $2 AND u.status <> 'deleted')
+	`, tenantID, userID).Scan(&counts.Followers, &counts.Following)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count follows: %w", err)
+	}
+
+	return counts, nil
+}
+
+// GetRelationship returns how the user relates to the target: whether each
+// follows or blocks the other.
+func (d *RelationshipDAO) GetRelationship(ctx context.Context, userID, targetID uuid.UUID) (*models.Relationship, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireUsers(ctx, d.db.Pool, tenantID, userID, targetID); err != nil {
+		return nil, err
+	}
+
+	rel := &models.Relationship{UserID: userID, TargetID: targetID}
+	err = d.db.Pool.QueryRow(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM user_follows WHERE follower_id = $1 AND followee_id = $2),
+			EXISTS (SELECT 1 FROM user_follows WHERE follower_id = $2 AND followee_id = $1),
+			EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2),
+			EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $2 AND blocked_id = $1)
+	`, userID, targetID).Scan(&rel.Following, &rel.FollowedBy, &rel.Blocking, &rel.BlockedBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get relationship: %w", err)
+	}
+	rel.Mutual = rel.Following && rel.FollowedBy
+
+	return rel, nil
+}
+
+// lockPair checks that both users exist and serializes changes to the
+// relationship between them, so that a follow cannot slip in beside a
+// block being made.
+func lockPair(ctx context.Context, tx pgx.Tx, tenantID, a, b uuid.UUID) error {
+	if err := requireUsers(ctx, tx, tenantID, a, b); err != nil {
+		return err
+	}
+
+	if b.String() < a.String() {
+		a, b = b, a
+	}
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`,
+		"user_relationship:"+a.String()+":"+b.String()); err != nil {
+		return fmt.Errorf("failed to lock relationship: %w", err)
+	}
+	return nil
+}
+
+// requireUsers fails with "user not found" unless every user belongs to the
+// organization and is not deleted.
+func requireUsers(ctx context.Context, q querier, tenantID uuid.UUID, ids ...uuid.UUID) error {
+	var count int
+	err := q.QueryRow(ctx, `
+		SELECT COUNT(*) FROM users WHERE id = ANY($1) AND tenant_id = $2 AND status <> 'deleted'
+	`, ids, tenantID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to get users: %w", err)
+	}
+	if count != len(ids) {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// Follow cursors are opaque to clients: the list they page through, and
+// the time and user ID of the last follow returned.
+func encodeFollowCursor(list string, at time.Time, id uuid.UUID) string {
+	raw := list + "," + at.UTC().Format(time.RFC3339Nano) + "," + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeFollowCursor(cursor, list string) (time.Time, uuid.UUID, error) {
+	invalid := fmt.Errorf("invalid cursor")
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, invalid
+	}
+	parts := strings.Split(string(raw), ",")
+	if len(parts) != 3 || parts[0] != list {
+		return time.Time{}, uuid.Nil, invalid
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return time.Time{}, uuid.Nil, invalid
+	}
+	id, err := uuid.Parse(parts[2])
+	if err != nil {
+		return time.Time{}, uuid.Nil, invalid
+	}
+	return at, id, nil
+}
+
+// deleteRelationships removes every follow and block the user is part of.
+func deleteRelationships(ctx context.Context, q querier, id uuid.UUID) error {
+	if _, err := q.Exec(ctx, `DELETE FROM user_follows WHERE follower_id = $1 OR followee_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete follows: %w", err)
+	}
+	if _, err := q.Exec(ctx, `DELETE FROM user_blocks WHERE blocker_id = $1 OR blocked_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete blocks: %w", err)
+	}
+	return nil
+}
+
+func recordRelationshipChange(ctx context.Context, q querier, action, actor string, userID uuid.UUID, field string, value bool) error {
+	event, err := newAuditEvent(ctx, action, actor, "user", userID.String(), map[string]models.FieldChange{
+		field: {Old: !value, New: value},
+	})
+	if err != nil {
+		return err
+	}
+	return appendAuditEvents(ctx, q, event)
+}
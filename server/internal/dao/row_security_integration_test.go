@@ -0,0 +1,267 @@
+//go:build integration
+
+package dao
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// careless are the tables the row-level security policies of migrations
+// 000015 to 000020 protect, and for each one a query that forgets to filter
+// on the organization: it returns exactly the rows a correct query would
+// not, i.e. those of other organizations or, without one, any at all.
+var careless = map[string]string{
+	"users":                  `SELECT COUNT(*) FROM users WHERE tenant_id IS DISTINCT FROM $1`,
+	"user_attribute_schemas": `SELECT COUNT(*) FROM user_attribute_schemas WHERE tenant_id IS DISTINCT FROM $1`,
+	"username_history":       `SELECT COUNT(*) FROM username_history t WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = t.user_id AND u.tenant_id = $1)`,
+	"user_preferences":       `SELECT COUNT(*) FROM user_preferences t WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = t.user_id AND u.tenant_id = $1)`,
+	"user_history":           `SELECT COUNT(*) FROM user_history t WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = t.user_id AND u.tenant_id = $1)`,
+	"groups":                 `SELECT COUNT(*) FROM groups WHERE tenant_id IS DISTINCT FROM $1`,
+	"group_members":          `SELECT COUNT(*) FROM group_members t WHERE NOT EXISTS (SELECT 1 FROM groups g WHERE g.id = t.group_id AND g.tenant_id = $1)`,
+	"group_subgroups":        `SELECT COUNT(*) FROM group_subgroups t WHERE NOT EXISTS (SELECT 1 FROM groups g WHERE g.id = t.parent_id AND g.tenant_id = $1)`,
+	"invitations":            `SELECT COUNT(*) FROM invitations WHERE tenant_id IS DISTINCT FROM $1`,
+	"scim_tokens":            `SELECT COUNT(*) FROM scim_tokens WHERE tenant_id IS DISTINCT FROM $1`,
+	"scim_external_ids":      `SELECT COUNT(*) FROM scim_external_ids WHERE tenant_id IS DISTINCT FROM $1`,
+	"ldap_links":             `SELECT COUNT(*) FROM ldap_links WHERE tenant_id IS DISTINCT FROM $1`,
+	"user_follows":           `SELECT COUNT(*) FROM user_follows WHERE tenant_id IS DISTINCT FROM $1`,
+	"user_blocks":            `SELECT COUNT(*) FROM user_blocks WHERE tenant_id IS DISTINCT FROM $1`,
+}
+
+// populate gives the organization ctx acts for rows in every table in
+// careless, and returns its users.
+func populate(t *testing.T, db *database.PostgresDB, ctx context.Context) []*models.User {
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users := testUserDAOOn(t, db)
+	ann, bob := testUser(t, users, ctx, "ann"), testUser(t, users, ctx, "bob")
+	_, err := users.SetAttributeSchema(ctx, []byte(`{"type": "object"}`), "test")
+	must(err)
+	theme := "dark"
+	must(users.SetPreferences(ctx, ann.ID, &models.PreferenceOverrides{Theme: &theme}, "test"))
+	must(users.Update(ctx, ann.ID, ann.Version, map[string]interface{}{"username": "anna"}, "test"))
+
+	groups := NewGroupDAO(db)
+	parent, child := &models.Group{Name: "parent"}, &models.Group{Name: "child"}
+	must(groups.Create(ctx, parent, "test"))
+	must(groups.Create(ctx, child, "test"))
+	_, _, err = groups.SetMember(ctx, child.ID, ann.ID, models.GroupRoleMember, "test")
+	must(err)
+	must(groups.AddSubgroup(ctx, parent.ID, child.ID, "test"))
+
+	_, err = NewInvitationDAO(users).Create(ctx, &models.Invitation{Email: "eve@example.com"}, time.Hour, "test")
+	must(err)
+
+	scim := NewSCIMDAO(db)
+	_, _, err = scim.CreateToken(ctx, "test", "test")
+	must(err)
+	must(scim.SetExternalID(ctx, "User", bob.ID, "ext-"+bob.ID.String()))
+
+	must(NewLDAPDAO(db).SetLink(ctx, &models.LDAPLink{
+		ResourceType: "User",
+		ResourceID:   bob.ID,
+		EntryID:      "entry-" + bob.ID.String(),
+		DN:           "uid=bob,ou=people,dc=example,dc=com",
+		SyncedAt:     time.Now(),
+	}))
+
+	relationships := NewRelationshipDAO(db)
+	_, _, err = relationships.Follow(ctx, ann.ID, bob.ID, "test")
+	must(err)
+	_, _, err = relationships.Block(ctx, bob.ID, testUser(t, users, ctx, "carol").ID, "test")
+	must(err)
+
+	return []*models.User{ann, bob}
+}
+
+// leaked runs every careless query as ctx and returns the tables that
+// showed rows they should not have.
+func leaked(t *testing.T, db *database.PostgresDB, ctx context.Context, tenantID interface{}) map[string]int64 {
+	t.Helper()
+	leaks := make(map[string]int64)
+	for table, query := range careless {
+		var count int64
+		if err := db.Pool.QueryRow(ctx, query, tenantID).Scan(&count); err != nil {
+			t.Fatalf("%s: %v", table, err)
+		}
+		if count > 0 {
+			leaks[table] = count
+		}
+	}
+	return leaks
+}
+
+func TestRowSecurityAppliesToRole(t *testing.T) {
+	db := testDB(t)
+
+	var bypassed bool
+	err := db.Pool.QueryRow(context.Background(),
+		`SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user`).Scan(&bypassed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bypassed {
+		t.Fatal("the test role bypasses row-level security; connect as a role without SUPERUSER or BYPASSRLS")
+	}
+
+	tables := make([]string, 0, len(careless))
+	for table := range careless {
+		tables = append(tables, table)
+	}
+	rows, err := db.Pool.Query(context.Background(), `
+		SELECT relname FROM pg_class
+		WHERE relname = ANY($1) AND relkind = 'r' AND relrowsecurity AND relforcerowsecurity
+	`, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	protected := make(map[string]bool)
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		protected[table] = true
+	}
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+
+	sort.Strings(tables)
+	for _, table := range tables {
+		if !protected[table] {
+			t.Errorf("row-level security is not enabled and forced on %s", table)
+		}
+	}
+}
+
+func TestRowSecurityHidesOtherTenants(t *testing.T) {
+	db := testDB(t)
+	orgA, ctxA := testOrganization(t, db)
+	orgB, ctxB := testOrganization(t, db)
+	usersA := populate(t, db, ctxA)
+	usersB := populate(t, db, ctxB)
+
+	// Each organization sees its own rows in every table, so that seeing
+	// none of the other's below means something
+	for table := range careless {
+		var count int64
+		if err := db.Pool.QueryRow(ctxB, `SELECT COUNT(*) FROM `+table).Scan(&count); err != nil {
+			t.Fatalf("%s: %v", table, err)
+		}
+		if count == 0 {
+			t.Errorf("B sees none of its own rows in %s", table)
+		}
+	}
+
+	for name, scope := range map[string]struct {
+		ctx      context.Context
+		tenantID interface{}
+	}{
+		"A":    {ctxA, orgA.ID},
+		"B":    {ctxB, orgB.ID},
+		"none": {context.Background(), nil},
+	} {
+		if leaks := leaked(t, db, scope.ctx, scope.tenantID); len(leaks) > 0 {
+			t.Errorf("as %s, careless queries saw rows of other organizations: %v", name, leaks)
+		}
+	}
+
+	// A query without any filter at all returns A's users and none of B's
+	rows, err := db.Pool.Query(ctxA, `SELECT id FROM users`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		seen[id] = true
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+	for _, user := range usersB {
+		if seen[user.ID] {
+			t.Errorf("SELECT id FROM users under A returned B's user %s", user.ID)
+		}
+	}
+	for _, user := range usersA {
+		if !seen[user.ID] {
+			t.Errorf("SELECT id FROM users under A left out A's user %s", user.ID)
+		}
+	}
+}
+
+func TestRowSecurityRejectsWritesToOtherTenants(t *testing.T) {
+	db := testDB(t)
+	_, ctxA := testOrganization(t, db)
+	orgB, ctxB := testOrganization(t, db)
+	usersA := populate(t, db, ctxA)
+	userB := populate(t, db, ctxB)[0]
+	users := testUserDAOOn(t, db)
+	before, err := users.PersonalData(ctxB, userB.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// B's rows cannot be changed or deleted from A
+	for _, query := range []string{
+		`UPDATE users SET first_name = 'Mallory' WHERE id = $1`,
+		`DELETE FROM user_preferences WHERE user_id = $1`,
+		`DELETE FROM user_follows WHERE follower_id = $1`,
+		`DELETE FROM group_members WHERE user_id = $1`,
+		`DELETE FROM users WHERE id = $1`,
+	} {
+		tag, err := db.Pool.Exec(ctxA, query, userB.ID)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if tag.RowsAffected() != 0 {
+			t.Errorf("%s under A affected %d of B's rows", query, tag.RowsAffected())
+		}
+	}
+
+	// Nor can A's rows be moved into B
+	if _, err := db.Pool.Exec(ctxA, `UPDATE users SET tenant_id = $1 WHERE id = $2`, orgB.ID, usersA[0].ID); err == nil {
+		t.Error("A moved one of its users into B")
+	}
+	if _, err := db.Pool.Exec(ctxA, `UPDATE groups SET tenant_id = $1`, orgB.ID); err == nil {
+		t.Error("A moved its groups into B")
+	}
+
+	// And nothing can be written without an organization
+	if tag, err := db.Pool.Exec(context.Background(), `UPDATE users SET first_name = 'Mallory'`); err != nil {
+		t.Fatal(err)
+	} else if tag.RowsAffected() != 0 {
+		t.Errorf("an update without an organization affected %d rows", tag.RowsAffected())
+	}
+
+	after, err := users.PersonalData(ctxB, userB.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.User.FirstName != before.User.FirstName || after.User.Version != before.User.Version ||
+		len(after.User.Attributes) != len(before.User.Attributes) {
+		t.Errorf("B's user changed from %+v to %+v", before.User, after.User)
+	}
+	if after.Preferences.Theme == nil {
+		t.Error("B's preferences were deleted")
+	}
+}
@@ -0,0 +1,313 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+const scimTokenColumns = `id, tenant_id, name, created_by, created_at, last_used_at, revoked_at`
+
+// scimTokenUseInterval is how stale a token's last_used_at may get before a
+// request updates it, so that busy provisioning does not write on every
+// request.
+const scimTokenUseInterval = time.Minute
+
+// SCIMDAO manages the SCIM tokens of the organization ctx acts for and the
+// external IDs identity providers gave its users and groups.
+type SCIMDAO struct {
+	db *database.PostgresDB
+}
+
+func NewSCIMDAO(db *database.PostgresDB) *SCIMDAO {
+	return &SCIMDAO{db: db}
+}
+
+func scanSCIMToken(row pgx.Row) (*models.SCIMToken, error) {
+	var token models.SCIMToken
+	err := row.Scan(&token.ID, &token.TenantID, &token.Name, &token.CreatedBy, &token.CreatedAt,
+		&token.LastUsedAt, &token.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ParseSCIMToken returns the organization a SCIM token belongs to. It fails
+// with "scim token not found" if the token is malformed.
+func ParseSCIMToken(token string) (uuid.UUID, error) {
+	tenantID, ok := tokenTenant(token)
+	if !ok {
+		return uuid.Nil, fmt.Errorf("scim token not found")
+	}
+	return tenantID, nil
+}
+
+// CreateToken stores a new token named name and returns it together with the
+// secret, which is not kept.
+func (d *SCIMDAO) CreateToken(ctx context.Context, name, actor string) (*models.SCIMToken, string, error) {
+	query := `
+		INSERT INTO scim_tokens (id, tenant_id, name, token_hash, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, secretHash, err := newTenantToken(tenantID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &models.SCIMToken{
+		ID:        uuid.New(),
+		TenantID:  tenantID,
+		Name:      name,
+		CreatedBy: nullIfEmpty(actor),
+		CreatedAt: time.Now(),
+	}
+
+	err = inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query, token.ID, token.TenantID, token.Name, secretHash, token.CreatedBy, token.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create scim token: %w", err)
+		}
+
+		return recordSCIMTokenChange(ctx, tx, audit.ActionSCIMTokenCreate, actor, token.ID, map[string]models.FieldChange{
+			"name": {New: token.Name},
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return token, secret, nil
+}
+
+// GetTokens lists the organization's tokens, revoked ones included, newest
+// first.
+func (d *SCIMDAO) GetTokens(ctx context.Context) ([]*models.SCIMToken, error) {
+	query := `SELECT ` + scimTokenColumns + ` FROM scim_tokens WHERE tenant_id = $1 ORDER BY created_at DESC, id`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Pool.Query(ctx, query, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scim tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*models.SCIMToken
+	for rows.Next() {
+		token, err := scanSCIMToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan scim token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate scim tokens: %w", rows.Err())
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken stops the token from authenticating. It fails with "scim
+// token not found" if there is no such token that is still valid.
+func (d *SCIMDAO) RevokeToken(ctx context.Context, id uuid.UUID, actor string) (*models.SCIMToken, error) {
+	query := `
+		UPDATE scim_tokens SET revoked_at = $3
+		WHERE id = $1 AND tenant_id = $2 AND revoked_at IS NULL
+		RETURNING ` + scimTokenColumns
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var token *models.SCIMToken
+	err = inTx(ctx, d.db.Pool, func(tx pgx.Tx) error {
+		token, err = scanSCIMToken(tx.QueryRow(ctx, query, id, tenantID, time.Now()))
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return fmt.Errorf("scim token not found")
+			}
+			return fmt.Errorf("failed to revoke scim token: %w", err)
+		}
+
+		return recordSCIMTokenChange(ctx, tx, audit.ActionSCIMTokenRevoke, actor, token.ID, map[string]models.FieldChange{
+			"revoked_at": {New: token.RevokedAt},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Authenticate returns the valid token secret belongs to and notes that it
+// was used. It fails with "scim token not found" for unknown and revoked
+// tokens.
+func (d *SCIMDAO) Authenticate(ctx context.Context, secret string) (*models.SCIMToken, error) {
+	query := `
+		SELECT ` + scimTokenColumns + ` FROM scim_tokens
+		WHERE token_hash = $1 AND tenant_id = $2 AND revoked_at IS NULL
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := scanSCIMToken(d.db.Pool.QueryRow(ctx, query, hashToken(secret), tenantID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("scim token not found")
+		}
+		return nil, fmt.Errorf("failed to get scim token: %w", err)
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= scimTokenUseInterval {
+		_, err := d.db.Pool.Exec(ctx, `UPDATE scim_tokens SET last_used_at = $2 WHERE id = $1`, token.ID, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update scim token: %w", err)
+		}
+		token.LastUsedAt = &now
+	}
+
+	return token, nil
+}
+
+// GetExternalID returns the external ID of a resource, or "" if it has none.
+// resourceType is "User" or "Group".
+func (d *SCIMDAO) GetExternalID(ctx context.Context, resourceType string, id uuid.UUID) (string, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var externalID string
+	err = d.db.Pool.QueryRow(ctx, `
+		SELECT external_id FROM scim_external_ids WHERE tenant_id = $1 AND resource_type = $2 AND resource_id = $3
+	`, tenantID, resourceType, id).Scan(&externalID)
+	if err != nil && err != pgx.ErrNoRows {
+		return "", fmt.Errorf("failed to get external id: %w", err)
+	}
+
+	return externalID, nil
+}
+
+// GetExternalIDs returns the external IDs of all resources of a type, by
+// resource ID.
+func (d *SCIMDAO) GetExternalIDs(ctx context.Context, resourceType string) (map[uuid.UUID]string, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.externalIDs(ctx, `
+		SELECT resource_id, external_id FROM scim_external_ids WHERE tenant_id = $1 AND resource_type = $2
+	`, tenantID, resourceType)
+}
+
+// GetExternalIDsOf returns the external IDs of the resources ids of a type,
+// by resource ID.
+func (d *SCIMDAO) GetExternalIDsOf(ctx context.Context, resourceType string, ids []uuid.UUID) (map[uuid.UUID]string, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.externalIDs(ctx, `
+		SELECT resource_id, external_id FROM scim_external_ids
+		WHERE tenant_id = $1 AND resource_type = $2 AND resource_id = ANY($3)
+	`, tenantID, resourceType, ids)
+}
+
+func (d *SCIMDAO) externalIDs(ctx context.Context, query string, args ...interface{}) (map[uuid.UUID]string, error) {
+	rows, err := d.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get external ids: %w", err)
+	}
+	defer rows.Close()
+
+	externalIDs := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var id uuid.UUID
+		var externalID string
+		if err := rows.Scan(&id, &externalID); err != nil {
+			return nil, fmt.Errorf("failed to scan external id: %w", err)
+		}
+		externalIDs[id] = externalID
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate external ids: %w", rows.Err())
+	}
+
+	return externalIDs, nil
+}
+
+// SetExternalID records the external ID of a resource. An empty externalID
+// removes it.
+func (d *SCIMDAO) SetExternalID(ctx context.Context, resourceType string, id uuid.UUID, externalID string) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	if externalID == "" {
+		return deleteExternalIDs(ctx, d.db.Pool, resourceType, id)
+	}
+
+	_, err = d.db.Pool.Exec(ctx, `
+		INSERT INTO scim_external_ids (tenant_id, resource_type, resource_id, external_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (resource_type, resource_id) DO UPDATE SET external_id = EXCLUDED.external_id
+	`, tenantID, resourceType, id, externalID)
+	if err != nil {
+		return fmt.Errorf("failed to set external id: %w", err)
+	}
+
+	return nil
+}
+
+// deleteExternalIDs forgets the external IDs and directory links of
+// resources that are gone.
+func deleteExternalIDs(ctx context.Context, q querier, resourceType string, ids ...uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := q.Exec(ctx, `DELETE FROM scim_external_ids WHERE resource_type = $1 AND resource_id = ANY($2)`, resourceType, ids)
+	if err != nil {
+		return fmt.Errorf("failed to delete external ids: %w", err)
+	}
+	_, err = q.Exec(ctx, `DELETE FROM ldap_links WHERE resource_type = $1 AND resource_id = ANY($2)`, resourceType, ids)
+	if err != nil {
+		return fmt.Errorf("failed to delete ldap links: %w", err)
+	}
+	return nil
+}
+
+func recordSCIMTokenChange(ctx context.Context, q querier, action, actor string, tokenID uuid.UUID, changes map[string]models.FieldChange) error {
+	event, err := newAuditEvent(ctx, action, actor, "scim_token", tokenID.String(), changes)
+	if err != nil {
+		return err
+	}
+	return appendAuditEvents(ctx, q, event)
+}
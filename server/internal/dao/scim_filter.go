@@ -0,0 +1,280 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/scim"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// scimColumn is how a SCIM attribute is stored, so that filters comparing
+// it can be evaluated in SQL.
+type scimColumn struct {
+	// expr holds the attribute's value in the form fold maps filter values
+	// onto
+	expr string
+	fold func(string) string
+	// in, if set, wraps a condition on expr in a subquery, for attributes
+	// kept in a table of their own. It has a single %s.
+	in string
+}
+
+func identity(s string) string { return s }
+
+// scimUserColumns are the User attributes filters are evaluated on in SQL.
+// Names and emails are encrypted and group memberships take a query per
+// user, so filters on them are matched in Go. userName compares as usernames
+// are normalized, and externalId exactly, as RFC 7643 defines it.
+var scimUserColumns = map[string]scimColumn{
+	"id":       {expr: "users.id::text", fold: strings.ToLower},
+	"username": {expr: "users.username_normalized", fold: models.NormalizeUsername},
+	"externalid": {
+		expr: "x.external_id",
+		fold: identity,
+		in: `EXISTS (SELECT 1 FROM scim_external_ids x WHERE x.tenant_id = users.tenant_id
+			AND x.resource_type = 'User' AND x.resource_id = users.id AND %s)`,
+	},
+}
+
+// scimGroupColumns are the Group attributes filters are evaluated on in SQL.
+var scimGroupColumns = map[string]scimColumn{
+	"id":          {expr: "groups.id::text", fold: strings.ToLower},
+	"displayname": {expr: "lower(groups.name)", fold: strings.ToLower},
+	"externalid": {
+		expr: "x.external_id",
+		fold: identity,
+		in: `EXISTS (SELECT 1 FROM scim_external_ids x WHERE x.tenant_id = groups.tenant_id
+			AND x.resource_type = 'Group' AND x.resource_id = groups.id AND %s)`,
+	},
+}
+
+// scimCondition renders f as an SQL condition over columns, appending its
+// arguments to args. It reports false if any part of f names an attribute
+// not in columns or compares with an operator other than eq, ne, sw, ew, co
+// and pr on a string; args is then returned as it was.
+func scimCondition(f scim.Filter, columns map[string]scimColumn, args []interface{}) (string, []interface{}, bool) {
+	switch f := f.(type) {
+	case *scim.Logical:
+		left, more, ok := scimCondition(f.Left, columns, args)
+		if !ok {
+			return "", args, false
+		}
+		right, more, ok := scimCondition(f.Right, columns, more)
+		if !ok {
+			return "", args, false
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(f.Op), right), more, true
+	case *scim.Not:
+		inner, more, ok := scimCondition(f.Filter, columns, args)
+		if !ok {
+			return "", args, false
+		}
+		return "NOT " + inner, more, true
+	case *scim.Comparison:
+		return scimComparison(f, columns, args)
+	}
+	return "", args, false
+}
+
+func scimComparison(f *scim.Comparison, columns map[string]scimColumn, args []interface{}) (string, []interface{}, bool) {
+	column, ok := columns[scim.AttributeName(f.Path)]
+	if !ok {
+		return "", args, false
+	}
+
+	var condition string
+	if f.Op == "pr" {
+		condition = column.expr + " <> ''"
+	} else {
+		value, ok := f.Value.(string)
+		if !ok {
+			return "", args, false
+		}
+		value = column.fold(value)
+		switch f.Op {
+		case "eq", "ne":
+			args = append(args, value)
+			condition = fmt.Sprintf("%s = $%d", column.expr, len(args))
+		case "sw":
+			args = append(args, escapeLike(value)+"%")
+			condition = fmt.Sprintf("%s LIKE $%d", column.expr, len(args))
+		case "ew":
+			args = append(args, "%"+escapeLike(value))
+			condition = fmt.Sprintf("%s LIKE $%d", column.expr, len(args))
+		case "co":
+			args = append(args, "%"+escapeLike(value)+"%")
+			condition = fmt.Sprintf("%s LIKE $%d", column.expr, len(args))
+		default:
+			return "", args, false
+		}
+	}
+
+	// Conditions never yield NULL, so that negating them agrees with Match
+	// on resources without the attribute
+	if column.in != "" {
+		condition = fmt.Sprintf(column.in, condition)
+	} else {
+		condition = "COALESCE(" + condition + ", false)"
+	}
+	if f.Op == "ne" {
+		condition = "NOT " + condition
+	}
+	return condition, args, true
+}
+
+// scimWhere renders filter as an SQL condition, appending its arguments to
+// args, and reports whether the condition is the whole filter. If it is not,
+// it is made of those parts of a top-level conjunction that translate, so
+// that it selects every match and the rest must be matched in Go.
+func scimWhere(filter scim.Filter, columns map[string]scimColumn, args []interface{}) (string, []interface{}, bool) {
+	if filter == nil {
+		return "TRUE", args, true
+	}
+	if condition, more, ok := scimCondition(filter, columns, args); ok {
+		return condition, more, true
+	}
+
+	conditions := []string{"TRUE"}
+	for _, f := range conjuncts(filter) {
+		if condition, more, ok := scimCondition(f, columns, args); ok {
+			conditions = append(conditions, condition)
+			args = more
+		}
+	}
+	return strings.Join(conditions, " AND "), args, false
+}
+
+// conjuncts splits a filter into the filters a top-level "and" joins.
+func conjuncts(f scim.Filter) []scim.Filter {
+	if logical, ok := f.(*scim.Logical); ok && logical.Op == "and" {
+		return append(conjuncts(logical.Left), conjuncts(logical.Right)...)
+	}
+	return []scim.Filter{f}
+}
+
+// FindSCIMUsers returns the users filter matches, oldest first, from offset
+// on and at most limit of them, along with how many it matches in all. It
+// reports false without querying if the filter cannot be evaluated in SQL;
+// StreamSCIMUsers then yields the candidates to match in Go.
+func (d *UserDAO) FindSCIMUsers(ctx context.Context, filter scim.Filter, offset, limit int) ([]*models.User, int, bool, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	where, args, exact := scimWhere(filter, scimUserColumns, []interface{}{tenantID})
+	if !exact {
+		return nil, 0, false, nil
+	}
+	where = "tenant_id = $1 AND status <> 'deleted' AND " + where
+
+	var total int
+	if err := d.q.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, true, fmt.Errorf("failed to count users: %w", err)
+	}
+	if total <= offset || limit == 0 {
+		return nil, total, true, nil
+	}
+
+	query := fmt.Sprintf(`
+		SELECT `+userColumns+`
+		FROM users
+		WHERE %s
+		ORDER BY created_at, id
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
+
+	rows, err := d.q.Query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, true, fmt.Errorf("failed to find users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := d.scanUser(ctx, rows)
+		if err != nil {
+			return nil, 0, true, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if rows.Err() != nil {
+		return nil, 0, true, fmt.Errorf("failed to iterate users: %w", rows.Err())
+	}
+
+	return users, total, true, nil
+}
+
+// StreamSCIMUsers calls fn for every user the parts of filter that can be
+// evaluated in SQL select, oldest first, as StreamUsers does. The callback
+// must still match the whole filter.
+func (d *UserDAO) StreamSCIMUsers(ctx context.Context, filter scim.Filter, fn func(*models.User) error) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+	where, args, _ := scimWhere(filter, scimUserColumns, []interface{}{tenantID})
+	return d.streamUsers(ctx, "tenant_id = $1 AND status <> 'deleted' AND "+where, args, fn)
+}
+
+// FindSCIMGroups returns the groups filter matches, by name, from offset on
+// and at most limit of them, along with how many it matches in all. It
+// reports false without querying if the filter cannot be evaluated in SQL;
+// GetSCIMCandidates then pages through the candidates to match in Go.
+func (d *GroupDAO) FindSCIMGroups(ctx context.Context, filter scim.Filter, offset, limit int) ([]*models.Group, int, bool, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	where, args, exact := scimWhere(filter, scimGroupColumns, []interface{}{tenantID})
+	if !exact {
+		return nil, 0, false, nil
+	}
+	where = "tenant_id = $1 AND " + where
+
+	var total int
+	if err := d.db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM groups WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, true, fmt.Errorf("failed to count groups: %w", err)
+	}
+	if total <= offset || limit == 0 {
+		return nil, total, true, nil
+	}
+
+	groups, err := d.findGroups(ctx, where, args, limit, offset)
+	if err != nil {
+		return nil, 0, true, err
+	}
+	return groups, total, true, nil
+}
+
+// GetSCIMCandidates returns a page of the groups the parts of filter that
+// can be evaluated in SQL select, by name. The caller must still match the
+// whole filter.
+func (d *GroupDAO) GetSCIMCandidates(ctx context.Context, filter scim.Filter, limit, offset int) ([]*models.Group, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	where, args, _ := scimWhere(filter, scimGroupColumns, []interface{}{tenantID})
+	return d.findGroups(ctx, "tenant_id = $1 AND "+where, args, limit, offset)
+}
+
+func (d *GroupDAO) findGroups(ctx context.Context, where string, args []interface{}, limit, offset int) ([]*models.Group, error) {
+	query := fmt.Sprintf(`
+		SELECT `+groupColumns+`
+		FROM groups
+		WHERE %s
+		ORDER BY name, id
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
+
+	rows, err := d.db.Pool.Query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find groups: %w", err)
+	}
+
+	return collectGroups(rows)
+}
@@ -0,0 +1,123 @@
+//go:build integration
+
+package dao
+
+import (
+	"testing"
+
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/scim"
+)
+
+func TestFindSCIMUsers(t *testing.T) {
+	db := testDB(t)
+	_, ctx := testOrganization(t, db)
+	users := testUserDAOOn(t, db)
+	ann, anna, bob := testUser(t, users, ctx, "ann"), testUser(t, users, ctx, "anna"), testUser(t, users, ctx, "bob")
+	if err := NewSCIMDAO(db).SetExternalID(ctx, scim.MemberTypeUser, bob.ID, "EXT-bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want []*models.User
+	}{
+		{`userName eq "ANN"`, []*models.User{ann}},
+		{`userName sw "ann"`, []*models.User{ann, anna}},
+		{`userName co "b"`, []*models.User{bob}},
+		{`userName ne "ann"`, []*models.User{anna, bob}},
+		{`externalId eq "EXT-bob"`, []*models.User{bob}},
+		{`externalId eq "ext-bob"`, nil},
+		{`externalId pr`, []*models.User{bob}},
+		{`not (externalId pr)`, []*models.User{ann, anna}},
+		{`id eq "` + anna.ID.String() + `"`, []*models.User{anna}},
+		{`userName eq "ann" or externalId sw "EXT"`, []*models.User{ann, bob}},
+	}
+	for _, tt := range tests {
+		filter, err := scim.ParseFilter(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, total, exact, err := users.FindSCIMUsers(ctx, filter, 0, 10)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if !exact {
+			t.Errorf("%s was not evaluated in SQL", tt.expr)
+			continue
+		}
+		if total != len(tt.want) || len(got) != len(tt.want) {
+			t.Errorf("%s: %d of %d users, want %d", tt.expr, len(got), total, len(tt.want))
+			continue
+		}
+		for i := range got {
+			if got[i].ID != tt.want[i].ID {
+				t.Errorf("%s: user %d = %s, want %s", tt.expr, i, got[i].Username, tt.want[i].Username)
+			}
+		}
+	}
+
+	// The database pages the result and counts all of it
+	got, total, _, err := users.FindSCIMUsers(ctx, nil, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(got) != 1 || got[0].ID != anna.ID {
+		t.Errorf("second page of one = %v of %d, want anna of 3", got, total)
+	}
+
+	// Filters on encrypted attributes stream the candidates the rest of the
+	// filter selects
+	filter, err := scim.ParseFilter(`emails co "example" and userName sw "ann"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, exact, err := users.FindSCIMUsers(ctx, filter, 0, 10); err != nil || exact {
+		t.Fatalf("a filter on emails was evaluated in SQL: %v", err)
+	}
+	var candidates []string
+	if err := users.StreamSCIMUsers(ctx, filter, func(user *models.User) error {
+		candidates = append(candidates, user.Username)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 || candidates[0] != "ann" || candidates[1] != "anna" {
+		t.Errorf("candidates = %v, want ann and anna", candidates)
+	}
+}
+
+func TestFindSCIMGroups(t *testing.T) {
+	db := testDB(t)
+	_, ctx := testOrganization(t, db)
+	groups := NewGroupDAO(db)
+	for _, name := range []string{"Admins", "Sales", "Sales EMEA"} {
+		if err := groups.Create(ctx, &models.Group{Name: name}, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter, err := scim.ParseFilter(`displayName sw "sales"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, total, exact, err := groups.FindSCIMGroups(ctx, filter, 1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exact || total != 2 || len(got) != 1 || got[0].Name != "Sales EMEA" {
+		t.Errorf("second page = %v of %d (exact %v), want Sales EMEA of 2", got, total, exact)
+	}
+
+	filter, err = scim.ParseFilter(`displayName eq "admins" and members pr`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates, err := groups.GetSCIMCandidates(ctx, filter, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Name != "Admins" {
+		t.Errorf("candidates = %v, want Admins", candidates)
+	}
+}
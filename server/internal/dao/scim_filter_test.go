@@ -0,0 +1,111 @@
+package dao
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spurge/p4rsec/server/internal/scim"
+)
+
+func TestSCIMWhere(t *testing.T) {
+	const (
+		userName   = "COALESCE(users.username_normalized = $2, false)"
+		externalID = "EXISTS (SELECT 1 FROM scim_external_ids x WHERE x.tenant_id = users.tenant_id AND x.resource_type = 'User' AND x.resource_id = users.id AND x.external_id = $2)"
+	)
+	tests := []struct {
+		expr  string
+		where string
+		args  []interface{}
+		exact bool
+	}{
+		{`userName eq "BJensen"`, userName, []interface{}{"tenant", "bjensen"}, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, userName, []interface{}{"tenant", "bjensen"}, true},
+		{`externalId eq "Ext-1"`, externalID, []interface{}{"tenant", "Ext-1"}, true},
+		{`userName ne "bjensen"`, "NOT " + userName, []interface{}{"tenant", "bjensen"}, true},
+		{
+			`userName sw "b_j%"`,
+			`COALESCE(users.username_normalized LIKE $2, false)`,
+			[]interface{}{"tenant", `b\_j\%%`},
+			true,
+		},
+		{
+			`userName ew "sen"`,
+			`COALESCE(users.username_normalized LIKE $2, false)`,
+			[]interface{}{"tenant", "%sen"},
+			true,
+		},
+		{
+			`id co "ABC"`,
+			`COALESCE(users.id::text LIKE $2, false)`,
+			[]interface{}{"tenant", "%abc%"},
+			true,
+		},
+		{
+			`externalId pr`,
+			"EXISTS (SELECT 1 FROM scim_external_ids x WHERE x.tenant_id = users.tenant_id AND x.resource_type = 'User' AND x.resource_id = users.id AND x.external_id <> '')",
+			[]interface{}{"tenant"},
+			true,
+		},
+		{
+			`userName eq "a" or not (userName sw "b")`,
+			`(COALESCE(users.username_normalized = $2, false) OR NOT COALESCE(users.username_normalized LIKE $3, false))`,
+			[]interface{}{"tenant", "a", "b%"},
+			true,
+		},
+		{
+			// Emails are encrypted, so only the rest of a conjunction
+			// narrows the candidates
+			`emails co "example" and userName eq "a" and externalId sw "x"`,
+			`TRUE AND COALESCE(users.username_normalized = $2, false) AND EXISTS (SELECT 1 FROM scim_external_ids x WHERE x.tenant_id = users.tenant_id AND x.resource_type = 'User' AND x.resource_id = users.id AND x.external_id LIKE $3)`,
+			[]interface{}{"tenant", "a", "x%"},
+			false,
+		},
+		{
+			// A disjunction with any part that does not translate selects
+			// everything
+			`userName eq "a" or emails co "example"`,
+			"TRUE",
+			[]interface{}{"tenant"},
+			false,
+		},
+		{`userName gt "a"`, "TRUE", []interface{}{"tenant"}, false},
+		{`userName eq null`, "TRUE", []interface{}{"tenant"}, false},
+		{`emails[type eq "work"]`, "TRUE", []interface{}{"tenant"}, false},
+	}
+	for _, tt := range tests {
+		filter, err := scim.ParseFilter(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseFilter(%s): %v", tt.expr, err)
+		}
+		where, args, exact := scimWhere(filter, scimUserColumns, []interface{}{"tenant"})
+		if where = strings.Join(strings.Fields(where), " "); where != tt.where {
+			t.Errorf("%s: where = %s\nwant %s", tt.expr, where, tt.where)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: args = %q, want %q", tt.expr, args, tt.args)
+		}
+		if exact != tt.exact {
+			t.Errorf("%s: exact = %v, want %v", tt.expr, exact, tt.exact)
+		}
+	}
+
+	where, args, exact := scimWhere(nil, scimUserColumns, []interface{}{"tenant"})
+	if where != "TRUE" || len(args) != 1 || !exact {
+		t.Errorf("without a filter: %s %v %v, want everything", where, args, exact)
+	}
+}
+
+func TestSCIMWhereGroups(t *testing.T) {
+	filter, err := scim.ParseFilter(`displayName eq "Admins" and members pr`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	where, args, exact := scimWhere(filter, scimGroupColumns, []interface{}{"tenant"})
+	if want := "TRUE AND COALESCE(lower(groups.name) = $2, false)"; where != want || exact {
+		t.Errorf("where = %s (exact %v), want %s", where, exact, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"tenant", "admins"}) {
+		t.Errorf("args = %q", args)
+	}
+}
@@ -0,0 +1,469 @@
+//go:build integration
+
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// isolationFixture is two organizations, where A's context is turned on the
+// users B holds.
+type isolationFixture struct {
+	d          *UserDAO
+	ctxA, ctxB context.Context
+	// userA is A's only user. userB is active and deletedB deleted, both
+	// in B, with history, preferences and a username change behind them.
+	userA, userB, deletedB *models.User
+}
+
+func newIsolationFixture(t *testing.T) *isolationFixture {
+	t.Helper()
+	db := testDB(t)
+	f := &isolationFixture{d: testUserDAOOn(t, db)}
+	_, f.ctxA = testOrganization(t, db)
+	_, f.ctxB = testOrganization(t, db)
+
+	f.userA = testUser(t, f.d, f.ctxA, "alice")
+	f.userB = testUser(t, f.d, f.ctxB, "bob")
+	f.deletedB = testUser(t, f.d, f.ctxB, "carol")
+
+	if _, err := f.d.SetAttributeSchema(f.ctxB, json.RawMessage(`{"type": "object"}`), "test"); err != nil {
+		t.Fatal(err)
+	}
+	theme := "dark"
+	if err := f.d.SetPreferences(f.ctxB, f.userB.ID, &models.PreferenceOverrides{Theme: &theme}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.d.Update(f.ctxB, f.userB.ID, f.userB.Version, map[string]interface{}{"username": "bobby"}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.d.Delete(f.ctxB, f.deletedB.ID, f.deletedB.Version, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.userB = f.reloadB(t, f.userB.ID)
+	f.deletedB = f.reloadB(t, f.deletedB.ID)
+	return f
+}
+
+// reloadB reads one of B's users as B sees it.
+func (f *isolationFixture) reloadB(t *testing.T, id uuid.UUID) *models.User {
+	t.Helper()
+	data, err := f.d.PersonalData(f.ctxB, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data.User
+}
+
+// assertBUnchanged fails if any of B's users changed since the fixture was
+// set up.
+func (f *isolationFixture) assertBUnchanged(t *testing.T) {
+	t.Helper()
+	for _, before := range []*models.User{f.userB, f.deletedB} {
+		after := f.reloadB(t, before.ID)
+		if after.Version != before.Version || after.Status != before.Status || after.Email != before.Email ||
+			after.Username != before.Username || after.FirstName != before.FirstName {
+			t.Errorf("B's user changed from %+v to %+v", before, after)
+		}
+	}
+
+	overrides, err := f.d.GetPreferences(f.ctxB, f.userB.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overrides.Theme == nil || *overrides.Theme != "dark" {
+		t.Errorf("B's preferences changed to %+v", overrides)
+	}
+
+	schema, err := f.d.GetAttributeSchema(f.ctxB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema == nil || schema.Version != 1 {
+		t.Errorf("B's attribute schema changed to %+v", schema)
+	}
+}
+
+// bIDs are the IDs of B's users.
+func (f *isolationFixture) bIDs() map[uuid.UUID]bool {
+	return map[uuid.UUID]bool{f.userB.ID: true, f.deletedB.ID: true}
+}
+
+func (f *isolationFixture) assertNoneOfB(t *testing.T, what string, users []*models.User) {
+	t.Helper()
+	for _, user := range users {
+		if f.bIDs()[user.ID] {
+			t.Errorf("%s under A returned B's user %s", what, user.ID)
+		}
+	}
+}
+
+func TestUserDAOReadsAcrossTenants(t *testing.T) {
+	f := newIsolationFixture(t)
+	ctx := f.ctxA
+
+	if _, err := f.d.GetByID(ctx, f.userB.ID); err == nil {
+		t.Error("GetByID found B's user")
+	}
+	if _, err := f.d.GetByEmail(ctx, f.userB.Email); err == nil {
+		t.Error("GetByEmail found B's user")
+	}
+	for _, username := range []string{f.userB.Username, "bob"} {
+		if user, _, err := f.d.GetByUsername(ctx, username); err == nil && user != nil {
+			t.Errorf("GetByUsername(%s) found B's user", username)
+		}
+	}
+
+	users, err := f.d.GetAll(ctx, models.UserFilter{}, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.assertNoneOfB(t, "GetAll", users)
+	users, err = f.d.GetAll(ctx, models.UserFilter{Query: "bob"}, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.assertNoneOfB(t, "GetAll with a query", users)
+
+	var streamed []*models.User
+	if err := f.d.StreamUsers(ctx, models.UserFilter{}, func(u *models.User) error {
+		streamed = append(streamed, u)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	f.assertNoneOfB(t, "StreamUsers", streamed)
+
+	deleted, err := f.d.GetDeleted(ctx, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.assertNoneOfB(t, "GetDeleted", deleted)
+
+	if count, err := f.d.Count(ctx); err != nil || count != 1 {
+		t.Errorf("Count = %d, %v, want A's 1 user", count, err)
+	}
+	if counts, err := f.d.CountUsersByKey(ctx); err != nil {
+		t.Fatal(err)
+	} else {
+		total := int64(0)
+		for _, count := range counts {
+			total += count
+		}
+		if total != 1 {
+			t.Errorf("CountUsersByKey counts %d users, want A's 1", total)
+		}
+	}
+
+	if exists, err := f.d.Exists(ctx, f.userB.ID); err != nil || exists {
+		t.Errorf("Exists = %v, %v, want false", exists, err)
+	}
+	if _, err := f.d.PersonalData(ctx, f.userB.ID); err == nil {
+		t.Error("PersonalData returned B's user")
+	}
+	if _, err := f.d.GetPreferences(ctx, f.userB.ID); err == nil {
+		t.Error("GetPreferences returned B's user's preferences")
+	}
+	if history, err := f.d.GetHistory(ctx, f.userB.ID, 100, 0); err == nil && len(history) > 0 {
+		t.Errorf("GetHistory returned %d of B's entries", len(history))
+	}
+	if _, err := f.d.GetAsOf(ctx, f.userB.ID, time.Now()); err == nil {
+		t.Error("GetAsOf returned B's user")
+	}
+	if changes, err := f.d.GetUsernameHistory(ctx, f.userB.ID); err == nil && len(changes) > 0 {
+		t.Errorf("GetUsernameHistory returned %d of B's changes", len(changes))
+	}
+	if schema, err := f.d.GetAttributeSchema(ctx); err != nil || schema != nil {
+		t.Errorf("GetAttributeSchema = %+v, %v, want none", schema, err)
+	}
+
+	if err := f.d.ForEachUserAttributes(ctx, func(id uuid.UUID, _ map[string]interface{}) error {
+		if f.bIDs()[id] {
+			t.Errorf("ForEachUserAttributes visited B's user %s", id)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := f.d.GetStatusesByEmail(ctx, []string{f.userB.Email, f.deletedB.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) > 0 {
+		t.Errorf("GetStatusesByEmail returned %v for B's emails", statuses)
+	}
+
+	// B's usernames, current and reserved, are free in A
+	conflicts, err := f.d.CheckImportUsernames(ctx, []*models.User{{Username: "bob"}, {Username: f.userB.Username}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) > 0 {
+		t.Errorf("CheckImportUsernames found conflicts with B: %v", conflicts)
+	}
+}
+
+func TestUserDAOWritesAcrossTenants(t *testing.T) {
+	f := newIsolationFixture(t)
+	ctx := f.ctxA
+	b, deleted := f.userB.ID, f.deletedB.ID
+
+	if err := f.d.Update(ctx, b, f.userB.Version, map[string]interface{}{"first_name": "Mallory"}, "test"); err == nil {
+		t.Error("Update changed B's user")
+	}
+	if err := f.d.Delete(ctx, b, f.userB.Version, "test"); err == nil {
+		t.Error("Delete deleted B's user")
+	}
+	if _, err := f.d.ChangeStatus(ctx, b, models.UserStatusSuspended, "test", "test"); err == nil {
+		t.Error("ChangeStatus suspended B's user")
+	}
+	if _, err := f.d.Restore(ctx, deleted, "test"); err == nil {
+		t.Error("Restore restored B's user")
+	}
+	if err := f.d.Purge(ctx, deleted, "test"); err == nil {
+		t.Error("Purge purged B's user")
+	}
+	purged, err := f.d.PurgeDeletedBefore(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range purged {
+		if f.bIDs()[id] {
+			t.Errorf("PurgeDeletedBefore purged B's user %s", id)
+		}
+	}
+	if _, err := f.d.Erase(ctx, b, "test"); err == nil {
+		t.Error("Erase erased B's user")
+	}
+
+	theme := "light"
+	if err := f.d.SetPreferences(ctx, b, &models.PreferenceOverrides{Theme: &theme}, "test"); err == nil {
+		t.Error("SetPreferences changed B's user's preferences")
+	}
+	_, err = f.d.UpdatePreferences(ctx, b, func(*models.PreferenceOverrides) (*models.PreferenceOverrides, error) {
+		return &models.PreferenceOverrides{Theme: &theme}, nil
+	}, "test")
+	if err == nil {
+		t.Error("UpdatePreferences changed B's user's preferences")
+	}
+
+	avatar := "avatars/mallory"
+	if _, _, err := f.d.SetAvatar(ctx, b, &avatar, &avatar, "test"); err == nil {
+		t.Error("SetAvatar changed B's user's avatar")
+	}
+
+	if _, err := f.d.SetAttributeSchema(ctx, json.RawMessage(`{"type": "object", "required": ["x"]}`), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renormalizing and rotating keys only visit A's users
+	if updated, conflicts, err := f.d.RenormalizeIdentities(ctx); err != nil {
+		t.Fatal(err)
+	} else if updated > 1 || len(conflicts) > 0 {
+		t.Errorf("RenormalizeIdentities updated %d users with conflicts %v, want at most A's 1", updated, conflicts)
+	}
+	if _, rotated, err := f.d.RotateKeys(ctx, uuid.Nil, 100); err != nil {
+		t.Fatal(err)
+	} else if rotated > 1 {
+		t.Errorf("RotateKeys re-encrypted %d users, want at most A's 1", rotated)
+	}
+	if _, rotated, err := f.d.RotateHistoryKeys(ctx, 0, 100); err != nil {
+		t.Fatal(err)
+	} else if rotated > 0 {
+		t.Errorf("RotateHistoryKeys re-encrypted %d entries, all of which are under the primary key", rotated)
+	}
+
+	// Importing B's user's email into A creates a user of A's own
+	results, err := f.d.UpsertBatch(ctx, []*models.User{{
+		Email:     f.userB.Email,
+		Username:  "bob-in-a",
+		FirstName: "Bob",
+		LastName:  "Imported",
+		Status:    models.UserStatusActive,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for email, result := range results {
+		if !result.Created || f.bIDs()[result.ID] {
+			t.Errorf("UpsertBatch of %s = %+v, want a new user in A", email, result)
+		}
+	}
+
+	f.assertBUnchanged(t)
+}
+
+func TestCacheDAOAcrossTenants(t *testing.T) {
+	cache := NewCacheDAO(testRedis(t))
+	ctxA := tenantContext(uuid.New())
+	ctxB := tenantContext(uuid.New())
+	userB := &models.User{ID: uuid.New(), Email: "bob@example.com", Username: "bob"}
+	id := userB.ID.String()
+	key := "key-" + uuid.NewString()
+
+	// B stores one of everything
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(cache.SetUser(ctxB, userB))
+	must(cache.SetPreferences(ctxB, id, &models.UserPreferences{Theme: "dark"}))
+	if set, err := cache.SetFollowCounts(ctxB, id, "", &models.FollowCounts{Followers: 3, Following: 4}); err != nil || !set {
+		t.Fatalf("B's follow counts were not set: %v", err)
+	}
+	must(cache.SetUsers(ctxB, []*models.User{userB}, 1, 10))
+	must(cache.SetImportReport(ctxB, key, map[string]int{"created": 1}))
+	must(cache.SetExportJob(ctxB, key, map[string]string{"status": "done"}, time.Minute))
+	must(cache.SetErasureJob(ctxB, key, map[string]string{"status": "done"}))
+	must(cache.SetLDAPSyncReport(ctxB, map[string]int{"created": 1}))
+	must(cache.SetIdempotencyRecord(ctxB, key, map[string]int{"status": 201}, time.Minute))
+	must(cache.SetSession(ctxB, key, id, time.Minute))
+	token, locked, err := cache.LockIdempotencyKey(ctxB, key, time.Minute)
+	must(err)
+	if !locked {
+		t.Fatal("B could not lock its idempotency key")
+	}
+
+	// A sees none of it
+	if _, err := cache.GetUser(ctxA, id); err == nil {
+		t.Error("GetUser returned B's user")
+	}
+	if _, err := cache.GetPreferences(ctxA, id); err == nil {
+		t.Error("GetPreferences returned B's preferences")
+	}
+	if _, err := cache.GetFollowCounts(ctxA, id); err == nil {
+		t.Error("GetFollowCounts returned B's counts")
+	}
+	if users, err := cache.GetUsers(ctxA, 1, 10); err == nil && len(users) > 0 {
+		t.Error("GetUsers returned B's list")
+	}
+	var report map[string]int
+	if err := cache.GetImportReport(ctxA, key, &report); err == nil {
+		t.Error("GetImportReport returned B's report")
+	}
+	var job map[string]string
+	if err := cache.GetExportJob(ctxA, key, &job); err == nil {
+		t.Error("GetExportJob returned B's job")
+	}
+	if err := cache.GetErasureJob(ctxA, key, &job); err == nil {
+		t.Error("GetErasureJob returned B's job")
+	}
+	if err := cache.GetLDAPSyncReport(ctxA, &report); err == nil {
+		t.Error("GetLDAPSyncReport returned B's report")
+	}
+	if found, err := cache.GetIdempotencyRecord(ctxA, key, &report); err != nil || found {
+		t.Errorf("GetIdempotencyRecord = %v, %v, want not found", found, err)
+	}
+	if session, err := cache.GetSession(ctxA, key); err == nil && session != "" {
+		t.Error("GetSession returned B's session")
+	}
+	if sessions, err := cache.GetUserSessions(ctxA, id); err == nil && len(sessions) > 0 {
+		t.Error("GetUserSessions returned B's sessions")
+	}
+
+	// A's key of the same name is its own to lock
+	tokenA, locked, err := cache.LockIdempotencyKey(ctxA, key, time.Minute)
+	must(err)
+	if !locked {
+		t.Error("B's lock holds A's idempotency key")
+	}
+	must(cache.UnlockIdempotencyKey(ctxA, key, tokenA))
+
+	// And A's deletes leave B's entries alone
+	must(cache.DeleteUser(ctxA, id))
+	must(cache.DeletePreferences(ctxA, id))
+	must(cache.DeleteFollowCounts(ctxA, id))
+	must(cache.InvalidateUsersList(ctxA))
+	must(cache.DeleteSession(ctxA, key))
+	if _, err := cache.DeleteUserSessions(ctxA, id); err != nil {
+		t.Fatal(err)
+	}
+	must(cache.UnlockIdempotencyKey(ctxA, key, token))
+
+	if _, err := cache.GetUser(ctxB, id); err != nil {
+		t.Errorf("B's user is gone: %v", err)
+	}
+	if _, err := cache.GetPreferences(ctxB, id); err != nil {
+		t.Errorf("B's preferences are gone: %v", err)
+	}
+	if _, err := cache.GetFollowCounts(ctxB, id); err != nil {
+		t.Errorf("B's follow counts are gone: %v", err)
+	}
+	if users, err := cache.GetUsers(ctxB, 1, 10); err != nil || len(users) != 1 {
+		t.Errorf("B's user list is gone: %v", err)
+	}
+	if session, err := cache.GetSession(ctxB, key); err != nil || session != id {
+		t.Errorf("B's session is gone: %q, %v", session, err)
+	}
+	if _, locked, err := cache.LockIdempotencyKey(ctxB, key, time.Minute); err != nil || locked {
+		t.Errorf("B's idempotency lock is gone: %v", err)
+	}
+	must(cache.UnlockIdempotencyKey(ctxB, key, token))
+	must(cache.DeleteUser(ctxB, id))
+	must(cache.InvalidateUsersList(ctxB))
+}
+
+func TestAuditDAOAcrossTenants(t *testing.T) {
+	db := testDB(t)
+	d := testUserDAOOn(t, db)
+	auditDAO := NewAuditDAO(db)
+	_, ctxA := testOrganization(t, db)
+	_, ctxB := testOrganization(t, db)
+
+	userB := testUser(t, d, ctxB, "bob")
+	if err := auditDAO.Record(ctxB, audit.ActionUserDataExport, userB.ID.String(), "user", userB.ID.String(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bEvents := 0
+	if err := auditDAO.ForEachUserEvent(ctxB, userB.ID.String(), func(*models.AuditEvent) error {
+		bEvents++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if bEvents < 2 {
+		t.Fatalf("B sees %d events about its user, want its creation and export", bEvents)
+	}
+
+	events, err := auditDAO.GetEvents(ctxA, models.AuditFilter{}, 1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if e.TargetID == userB.ID.String() {
+			t.Errorf("GetEvents under A returned B's event %d", e.ID)
+		}
+	}
+	for _, filter := range []models.AuditFilter{
+		{TargetType: "user", TargetID: userB.ID.String()},
+		{Actor: userB.ID.String()},
+		{Action: audit.ActionUserDataExport, TargetID: userB.ID.String()},
+	} {
+		events, err := auditDAO.GetEvents(ctxA, filter, 1000, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) > 0 {
+			t.Errorf("GetEvents(%+v) under A returned %d of B's events", filter, len(events))
+		}
+	}
+
+	if err := auditDAO.ForEachUserEvent(ctxA, userB.ID.String(), func(e *models.AuditEvent) error {
+		t.Errorf("ForEachUserEvent under A returned B's event %d", e.ID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+package dao
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// tokenSecretSize is the number of random bytes in a tenant token, after the
+// 16 bytes of its organization's ID.
+const tokenSecretSize = 32
+
+// newTenantToken returns a new secret token for the organization and the
+// hash it is stored as. The token carries the organization, so that it can
+// be looked up under row-level security without the request naming one.
+func newTenantToken(tenantID uuid.UUID) (string, []byte, error) {
+	raw := make([]byte, 16+tokenSecretSize)
+	copy(raw, tenantID[:])
+	if _, err := rand.Read(raw[16:]); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// tokenTenant returns the organization a token from newTenantToken was issued
+// for, or false if the token is malformed.
+func tokenTenant(token string) (uuid.UUID, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != 16+tokenSecretSize {
+		return uuid.Nil, false
+	}
+	var tenantID uuid.UUID
+	copy(tenantID[:], raw[:16])
+	return tenantID, true
+}
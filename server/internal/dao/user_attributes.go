@@ -0,0 +1,120 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// GetAttributeSchema returns the organization's current attribute schema, or
+// nil if none has been set.
+func (d *UserDAO) GetAttributeSchema(ctx context.Context) (*models.AttributeSchema, error) {
+	query := `
+		SELECT version, schema, created_at, created_by
+		FROM user_attribute_schemas
+		WHERE tenant_id = $1
+		ORDER BY version DESC
+		LIMIT 1
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema models.AttributeSchema
+	err = d.q.QueryRow(ctx, query, tenantID).Scan(&schema.Version, &schema.Schema, &schema.CreatedAt, &schema.CreatedBy)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get attribute schema: %w", err)
+	}
+
+	return &schema, nil
+}
+
+// SetAttributeSchema stores schema as the organization's new current
+// attribute schema. Earlier versions are kept.
+func (d *UserDAO) SetAttributeSchema(ctx context.Context, schema json.RawMessage, actor string) (*models.AttributeSchema, error) {
+	query := `
+		INSERT INTO user_attribute_schemas (schema, created_by, tenant_id)
+		VALUES ($1, $2, $3)
+		RETURNING version, schema, created_at, created_by
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored models.AttributeSchema
+	err = d.InTx(ctx, func(tx *UserDAO) error {
+		previous, err := tx.GetAttributeSchema(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = tx.q.QueryRow(ctx, query, string(schema), nullIfEmpty(actor), tenantID).
+			Scan(&stored.Version, &stored.Schema, &stored.CreatedAt, &stored.CreatedBy)
+		if err != nil {
+			return fmt.Errorf("failed to set attribute schema: %w", err)
+		}
+
+		change := models.FieldChange{New: stored.Schema}
+		if previous != nil {
+			change.Old = previous.Schema
+		}
+		event, err := newAuditEvent(ctx, audit.ActionAttributeSchemaUpdate, actor, "attribute_schema",
+			strconv.FormatInt(stored.Version, 10), map[string]models.FieldChange{"schema": change})
+		if err != nil {
+			return err
+		}
+		return appendAuditEvents(ctx, tx.q, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+// ForEachUserAttributes calls fn with the attributes of every user of the
+// organization that has not been deleted, stopping at the first error fn
+// returns.
+func (d *UserDAO) ForEachUserAttributes(ctx context.Context, fn func(id uuid.UUID, attributes map[string]interface{}) error) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows, err := d.q.Query(ctx, `SELECT id, attributes FROM users WHERE tenant_id = $1 AND status <> 'deleted'`, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to get user attributes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var attributes map[string]interface{}
+		if err := rows.Scan(&id, &attributes); err != nil {
+			return fmt.Errorf("failed to scan user attributes: %w", err)
+		}
+		if err := fn(id, attributes); err != nil {
+			return err
+		}
+	}
+
+	if rows.Err() != nil {
+		return fmt.Errorf("failed to iterate user attributes: %w", rows.Err())
+	}
+
+	return nil
+}
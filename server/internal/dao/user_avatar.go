@@ -0,0 +1,48 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// SetAvatar points the user's avatar at the thumbnails stored under key and
+// linked from url, or removes it when both are nil. It returns the updated
+// user and the key of the avatar it replaced, if any, so that the caller can
+// delete the old thumbnails.
+func (d *UserDAO) SetAvatar(ctx context.Context, id uuid.UUID, key, url *string, actor string) (*models.User, *string, error) {
+	query := `
+		UPDATE users
+		SET avatar_key = $1, avatar_url = $2, updated_at = $3, version = version + 1
+		WHERE id = $4 AND tenant_id = $5
+		RETURNING ` + userColumns
+
+	var user *models.User
+	var previous *string
+
+	err := d.InTx(ctx, func(tx *UserDAO) error {
+		before, err := tx.lockUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if before.Status == models.UserStatusDeleted {
+			return fmt.Errorf("user not found")
+		}
+		previous = before.AvatarKey
+
+		user, err = tx.scanUser(ctx, tx.q.QueryRow(ctx, query, key, url, time.Now(), id, before.TenantID))
+		if err != nil {
+			return fmt.Errorf("failed to set avatar: %w", err)
+		}
+
+		return tx.recordChange(ctx, models.UserChangeAvatar, actor, before, user)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, previous, nil
+}
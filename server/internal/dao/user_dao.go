@@ -2,72 +2,232 @@ package dao
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/config"
 	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/encryption"
 	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
 )
 
-type UserDAO struct {
-	db *database.PostgresDB
+// userColumns is the column list every user query selects, in the order
+// scanUser expects them.
+const userColumns = `id, tenant_id, email, username, first_name, last_name, status, status_reason, status_changed_at, status_changed_by, version, created_at, updated_at, deleted_at, attributes, avatar_url, avatar_key, erased_at, data_key, data_key_id`
+
+// ConflictError is returned when a write's expected version no longer matches
+// the stored row. Current holds the state the server has now so that callers
+// can merge and retry.
+type ConflictError struct {
+	Current *models.User
 }
 
-func NewUserDAO(db *database.PostgresDB) *UserDAO {
-	return &UserDAO{db: db}
+func (e *ConflictError) Error() string {
+	return "user version conflict"
 }
 
-func (d *UserDAO) Create(ctx context.Context, user *models.User) error {
-	query := `
-		INSERT INTO users (id, email, username, first_name, last_name, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`
+// TransitionError is returned when a status change is not allowed from the
+// user's current status.
+type TransitionError struct {
+	From models.UserStatus
+	To   models.UserStatus
+}
 
-	user.ID = uuid.New()
-	user.CreatedAt = time.Now()
-	user.UpdatedAt = time.Now()
-	user.IsActive = true
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot change user status from %s to %s", e.From, e.To)
+}
 
-	_, err := d.db.Pool.Exec(ctx, query,
-		user.ID,
-		user.Email,
-		user.Username,
-		user.FirstName,
-		user.LastName,
-		user.IsActive,
-		user.CreatedAt,
-		user.UpdatedAt,
-	)
+// DuplicateError is returned when a write would give a user the same
+// normalized email or username as another user.
+type DuplicateError struct {
+	Field string
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
-	}
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("user with this %s already exists", e.Field)
+}
+
+// uniqueViolationCode is the SQLSTATE for unique_violation.
+const uniqueViolationCode = "23505"
 
+// uniqueIndexFields maps the unique indexes on users to the field they guard.
+var uniqueIndexFields = map[string]string{
+	"idx_users_email_index":         "email",
+	"idx_users_email_normalized":    "email",
+	"idx_users_username_normalized": "username",
+}
+
+// asDuplicate returns the *DuplicateError err stands for, or nil if err is
+// not a violation of one of the identity indexes.
+func asDuplicate(err error) *DuplicateError {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		if field, ok := uniqueIndexFields[pgErr.ConstraintName]; ok {
+			return &DuplicateError{Field: field}
+		}
+	}
 	return nil
 }
 
-func (d *UserDAO) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
-	query := `
-		SELECT id, email, username, first_name, last_name, is_active, created_at, updated_at
-		FROM users
-		WHERE id = $1 AND is_active = true
-	`
+type UserDAO struct {
+	db     *database.PostgresDB
+	q      querier
+	cfg    config.Users
+	cipher *encryption.Cipher
+}
+
+func NewUserDAO(db *database.PostgresDB, cfg config.Users, cipher *encryption.Cipher) *UserDAO {
+	return &UserDAO{db: db, q: db.Pool, cfg: cfg, cipher: cipher}
+}
+
+// NormalizeEmail returns the form of email that uniqueness is checked on.
+func (d *UserDAO) NormalizeEmail(email string) string {
+	return models.NormalizeEmail(email, d.cfg.FoldPlusAddressing)
+}
 
+// InTx runs fn with a UserDAO bound to a single transaction, committing if fn
+// returns nil and rolling back otherwise.
+func (d *UserDAO) InTx(ctx context.Context, fn func(tx *UserDAO) error) error {
+	return inTx(ctx, d.q, func(tx pgx.Tx) error {
+		return fn(&UserDAO{db: d.db, q: tx, cfg: d.cfg, cipher: d.cipher})
+	})
+}
+
+// scanUser reads a row of userColumns, followed by any extra columns into
+// extra, and decrypts the user's encrypted fields.
+func (d *UserDAO) scanUser(ctx context.Context, row pgx.Row, extra ...interface{}) (*models.User, error) {
 	var user models.User
-	err := d.db.Pool.QueryRow(ctx, query, id).Scan(
+	var dataKey []byte
+	var dataKeyID *string
+	dest := []interface{}{
 		&user.ID,
+		&user.TenantID,
 		&user.Email,
 		&user.Username,
 		&user.FirstName,
 		&user.LastName,
-		&user.IsActive,
+		&user.Status,
+		&user.StatusReason,
+		&user.StatusChangedAt,
+		&user.StatusChangedBy,
+		&user.Version,
 		&user.CreatedAt,
 		&user.UpdatedAt,
-	)
+		&user.DeletedAt,
+		&user.Attributes,
+		&user.AvatarURL,
+		&user.AvatarKey,
+		&user.ErasedAt,
+		&dataKey,
+		&dataKeyID,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	if err := d.openUser(ctx, &user, dataKeyID, dataKey); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Create inserts user, filling in its ID, timestamps and version, and
+// records it in the user's history.
+func (d *UserDAO) Create(ctx context.Context, user *models.User, actor string) error {
+	query := `
+		INSERT INTO users (id, email, username, first_name, last_name, status, status_changed_at, version, created_at, updated_at,
+			email_index, username_normalized, attributes, data_key, data_key_id, tenant_id)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16
+		WHERE NOT EXISTS (
+			SELECT 1 FROM username_history h JOIN users u ON u.id = h.user_id
+			WHERE u.tenant_id = $16 AND h.username_normalized = $12 AND h.reserved_until > $9
+		)
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	user.ID = uuid.New()
+	user.TenantID = tenantID
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	if user.Status == "" {
+		user.Status = models.UserStatusActive
+	}
+	user.StatusChangedAt = user.CreatedAt
+	user.Version = 1
+	if user.Attributes == nil {
+		user.Attributes = map[string]interface{}{}
+	}
+
+	return d.InTx(ctx, func(tx *UserDAO) error {
+		if err := tx.checkPlaintextEmail(ctx, user.Email, user.ID); err != nil {
+			return err
+		}
+
+		sealed, err := tx.sealUser(ctx, user.Email, user.FirstName, user.LastName)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.q.Exec(ctx, query,
+			user.ID,
+			sealed.email,
+			user.Username,
+			sealed.firstName,
+			sealed.lastName,
+			user.Status,
+			user.StatusChangedAt,
+			user.Version,
+			user.CreatedAt,
+			user.UpdatedAt,
+			sealed.emailIndex,
+			models.NormalizeUsername(user.Username),
+			user.Attributes,
+			sealed.dataKey,
+			sealed.dataKeyID,
+			tenantID,
+		)
+
+		if err != nil {
+			if duplicate := asDuplicate(err); duplicate != nil {
+				return duplicate
+			}
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+
+		// Someone gave up this username recently and it is still held for them
+		if result.RowsAffected() == 0 {
+			return &DuplicateError{Field: "username"}
+		}
+
+		return tx.recordChange(ctx, models.UserChangeCreate, actor, nil, user)
+	})
+}
 
+func (d *UserDAO) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = $1 AND tenant_id = $2 AND status <> 'deleted'
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := d.scanUser(ctx, d.q.QueryRow(ctx, query, id, tenantID))
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -75,28 +235,22 @@ func (d *UserDAO) GetByID(ctx context.Context, id uuid.UUID) (*models.User, erro
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 func (d *UserDAO) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	query := `
-		SELECT id, email, username, first_name, last_name, is_active, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
-		WHERE email = $1 AND is_active = true
+		WHERE ` + emailMatch + ` AND tenant_id = $3 AND status <> 'deleted'
 	`
 
-	var user models.User
-	err := d.db.Pool.QueryRow(ctx, query, email).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Username,
-		&user.FirstName,
-		&user.LastName,
-		&user.IsActive,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
 
+	user, err := d.scanUser(ctx, d.q.QueryRow(ctx, query, d.emailIndex(email), d.NormalizeEmail(email), tenantID))
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -104,19 +258,23 @@ func (d *UserDAO) GetByEmail(ctx context.Context, email string) (*models.User, e
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-func (d *UserDAO) GetAll(ctx context.Context, limit, offset int) ([]*models.User, error) {
-	query := `
-		SELECT id, email, username, first_name, last_name, is_active, created_at, updated_at
+func (d *UserDAO) GetAll(ctx context.Context, filter models.UserFilter, limit, offset int) ([]*models.User, error) {
+	where, args, err := d.userFilterClause(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf(`
+		SELECT `+userColumns+`
 		FROM users
-		WHERE is_active = true
+		WHERE %s
 		ORDER BY created_at DESC
-		LIMIT $1 OFFSET $2
-	`
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
 
-	rows, err := d.db.Pool.Query(ctx, query, limit, offset)
+	rows, err := d.q.Query(ctx, query, append(args, limit, offset)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get users: %w", err)
 	}
@@ -124,21 +282,11 @@ func (d *UserDAO) GetAll(ctx context.Context, limit, offset int) ([]*models.User
 
 	var users []*models.User
 	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.Username,
-			&user.FirstName,
-			&user.LastName,
-			&user.IsActive,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
+		user, err := d.scanUser(ctx, rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
-		users = append(users, &user)
+		users = append(users, user)
 	}
 
 	if rows.Err() != nil {
@@ -148,77 +296,380 @@ func (d *UserDAO) GetAll(ctx context.Context, limit, offset int) ([]*models.User
 	return users, nil
 }
 
-func (d *UserDAO) Update(ctx context.Context, id uuid.UUID, updates map[string]interface{}) error {
+// userFilterClause renders filter as a WHERE condition on the organization
+// ctx acts for, with positional arguments starting at $1. Emails and names
+// are encrypted, so the query matches parts of usernames and whole email
+// addresses only.
+func (d *UserDAO) userFilterClause(ctx context.Context, filter models.UserFilter) (string, []interface{}, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	conditions := []string{"tenant_id = $1"}
+	args := []interface{}{tenantID}
+
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	} else {
+		conditions = append(conditions, "status <> 'deleted'")
+	}
+	if filter.Query != "" {
+		args = append(args, "%"+escapeLike(filter.Query)+"%", d.emailIndex(filter.Query), d.NormalizeEmail(filter.Query))
+		conditions = append(conditions, fmt.Sprintf(
+			"(username ILIKE $%d OR email_index = $%d OR email_normalized = $%d)", len(args)-2, len(args)-1, len(args)))
+	}
+	if filter.CreatedAfter != nil {
+		args = append(args, *filter.CreatedAfter)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if filter.CreatedBefore != nil {
+		args = append(args, *filter.CreatedBefore)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	names := make([]string, 0, len(filter.Attributes))
+	for name := range filter.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, name, filter.Attributes[name])
+		conditions = append(conditions, fmt.Sprintf("attributes ->> $%d = $%d", len(args)-1, len(args)))
+	}
+
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Update applies updates to the user if its stored version still equals
+// version, incrementing the version on success and recording the change in
+// the user's history. A stale version yields a *ConflictError carrying the
+// current row. Username changes are subject to the change cooldown and
+// reservations; see changeUsername.
+func (d *UserDAO) Update(ctx context.Context, id uuid.UUID, version int64, updates map[string]interface{}, actor string) error {
 	if len(updates) == 0 {
 		return fmt.Errorf("no updates provided")
 	}
 
-	// Build dynamic update query
-	setParts := make([]string, 0, len(updates))
-	args := make([]interface{}, 0, len(updates)+2)
-	argIndex := 1
+	// Keep the normalized username in step with the username; the email's
+	// index is kept in step when the row is resealed
+	if username, ok := updates["username"].(string); ok {
+		updates["username_normalized"] = models.NormalizeUsername(username)
+		return d.InTx(ctx, func(tx *UserDAO) error {
+			return tx.changeUsername(ctx, id, version, username, updates, actor)
+		})
+	}
+
+	return d.update(ctx, id, version, updates, actor)
+}
+
+func (d *UserDAO) update(ctx context.Context, id uuid.UUID, version int64, updates map[string]interface{}, actor string) error {
+	return d.InTx(ctx, func(tx *UserDAO) error {
+		before, err := tx.lockCurrent(ctx, id, version)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.sealUpdates(ctx, before, updates); err != nil {
+			return err
+		}
+
+		// Build dynamic update query
+		setParts := make([]string, 0, len(updates)+2)
+		args := make([]interface{}, 0, len(updates)+2)
+		argIndex := 1
+
+		for field, value := range updates {
+			setParts = append(setParts, fmt.Sprintf("%s = $%d", field, argIndex))
+			args = append(args, value)
+			argIndex++
+		}
 
-	for field, value := range updates {
-		setParts = append(setParts, fmt.Sprintf("%s = $%d", field, argIndex))
-		args = append(args, value)
+		// Add updated_at and bump the version
+		setParts = append(setParts, fmt.Sprintf("updated_at = $%d", argIndex), "version = version + 1")
+		args = append(args, time.Now())
 		argIndex++
-	}
 
-	// Add updated_at
-	setParts = append(setParts, fmt.Sprintf("updated_at = $%d", argIndex))
-	args = append(args, time.Now())
-	argIndex++
+		// Add ID and tenant for WHERE clause
+		args = append(args, id, before.TenantID)
 
-	// Add ID for WHERE clause
-	args = append(args, id)
+		// Build the complete query
+		setClause := strings.Join(setParts, ", ")
+		query := fmt.Sprintf(`
+			UPDATE users
+			SET %s
+			WHERE id = $%d AND tenant_id = $%d
+			RETURNING %s
+		`, setClause, argIndex, argIndex+1, userColumns)
 
-	// Build the complete query
-	setClause := strings.Join(setParts, ", ")
-	query := fmt.Sprintf(`
+		after, err := tx.scanUser(ctx, tx.q.QueryRow(ctx, query, args...))
+		if err != nil {
+			if duplicate := asDuplicate(err); duplicate != nil {
+				return duplicate
+			}
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+
+		return tx.recordChange(ctx, models.UserChangeUpdate, actor, before, after)
+	})
+}
+
+// Delete soft-deletes the user if its stored version still equals version.
+// The row is kept until it is restored or purged.
+func (d *UserDAO) Delete(ctx context.Context, id uuid.UUID, version int64, actor string) error {
+	query := `
 		UPDATE users
-		SET %s
-		WHERE id = $%d AND is_active = true
-	`, setClause, argIndex)
+		SET status = 'deleted', status_reason = NULL, status_changed_at = $1, status_changed_by = $2,
+			deleted_at = $1, updated_at = $1, version = version + 1
+		WHERE id = $3 AND tenant_id = $4
+		RETURNING ` + userColumns
 
-	result, err := d.db.Pool.Exec(ctx, query, args...)
+	return d.InTx(ctx, func(tx *UserDAO) error {
+		before, err := tx.lockCurrent(ctx, id, version)
+		if err != nil {
+			return err
+		}
+
+		after, err := tx.scanUser(ctx, tx.q.QueryRow(ctx, query, time.Now(), nullIfEmpty(actor), id, before.TenantID))
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+
+		return tx.recordChange(ctx, models.UserChangeDelete, actor, before, after)
+	})
+}
+
+// ChangeStatus moves the user to status, recording why and by whom. The
+// transition is checked against the lifecycle while the row is locked; a
+// disallowed one yields a *TransitionError. Deleted users only leave that
+// state through Restore.
+func (d *UserDAO) ChangeStatus(ctx context.Context, id uuid.UUID, status models.UserStatus, reason, actor string) (*models.User, error) {
+	query := `
+		UPDATE users
+		SET status = $1, status_reason = $2, status_changed_at = $3, status_changed_by = $4,
+			deleted_at = CASE WHEN $1 = 'deleted' THEN $3 END,
+			updated_at = $3, version = version + 1
+		WHERE id = $5 AND tenant_id = $6
+		RETURNING ` + userColumns
+
+	var user *models.User
+	err := d.InTx(ctx, func(tx *UserDAO) error {
+		before, err := tx.lockUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if before.Status == models.UserStatusDeleted || !before.Status.CanTransitionTo(status) {
+			return &TransitionError{From: before.Status, To: status}
+		}
+
+		user, err = tx.scanUser(ctx, tx.q.QueryRow(ctx, query,
+			status, nullIfEmpty(reason), time.Now(), nullIfEmpty(actor), id, before.TenantID))
+		if err != nil {
+			return fmt.Errorf("failed to change user status: %w", err)
+		}
+
+		return tx.recordChange(ctx, models.UserChangeStatus, actor, before, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (d *UserDAO) GetDeleted(ctx context.Context, limit, offset int) ([]*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE tenant_id = $1 AND status = 'deleted'
+		ORDER BY deleted_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	tenantID, err := tenant.Require(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
+		return nil, err
 	}
 
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("user not found or no changes made")
+	rows, err := d.q.Query(ctx, query, tenantID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deleted users: %w", err)
 	}
+	defer rows.Close()
 
-	return nil
+	var users []*models.User
+	for rows.Next() {
+		user, err := d.scanUser(ctx, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate users: %w", rows.Err())
+	}
+
+	return users, nil
 }
 
-func (d *UserDAO) Delete(ctx context.Context, id uuid.UUID) error {
+// Restore brings a soft-deleted user back to active and returns it. Erased
+// users cannot be restored.
+func (d *UserDAO) Restore(ctx context.Context, id uuid.UUID, actor string) (*models.User, error) {
 	query := `
 		UPDATE users
-		SET is_active = false, updated_at = $1
-		WHERE id = $2 AND is_active = true
-	`
+		SET status = 'active', status_reason = NULL, status_changed_at = $1, status_changed_by = $2,
+			deleted_at = NULL, updated_at = $1, version = version + 1
+		WHERE id = $3 AND tenant_id = $4
+		RETURNING ` + userColumns
+
+	var user *models.User
+	err := d.InTx(ctx, func(tx *UserDAO) error {
+		before, err := tx.lockUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if before.Status != models.UserStatusDeleted || before.ErasedAt != nil {
+			return fmt.Errorf("user not found")
+		}
+
+		user, err = tx.scanUser(ctx, tx.q.QueryRow(ctx, query, time.Now(), nullIfEmpty(actor), id, before.TenantID))
+		if err != nil {
+			return fmt.Errorf("failed to restore user: %w", err)
+		}
 
-	result, err := d.db.Pool.Exec(ctx, query, time.Now(), id)
+		return tx.recordChange(ctx, models.UserChangeRestore, actor, before, user)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return nil, err
 	}
 
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("user not found")
+	return user, nil
+}
+
+// Purge permanently removes a soft-deleted user.
+func (d *UserDAO) Purge(ctx context.Context, id uuid.UUID, actor string) error {
+	query := `DELETE FROM users WHERE id = $1 AND tenant_id = $2 AND status = 'deleted'`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return d.InTx(ctx, func(tx *UserDAO) error {
+		result, err := tx.q.Exec(ctx, query, id, tenantID)
+		if err != nil {
+			return fmt.Errorf("failed to purge user: %w", err)
+		}
+
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("user not found")
+		}
+		if err := deleteExternalIDs(ctx, tx.q, "User", id); err != nil {
+			return err
+		}
+
+		event, err := newAuditEvent(ctx, audit.ActionUserPurge, actor, "user", id.String(), nil)
+		if err != nil {
+			return err
+		}
+		return appendAuditEvents(ctx, tx.q, event)
+	})
+}
+
+// PurgeDeletedBefore permanently removes the organization's users
+// soft-deleted before cutoff and returns their IDs.
+func (d *UserDAO) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) ([]uuid.UUID, error) {
+	query := `DELETE FROM users WHERE tenant_id = $1 AND status = 'deleted' AND deleted_at < $2 RETURNING id`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uuid.UUID
+	err = d.InTx(ctx, func(tx *UserDAO) error {
+		rows, err := tx.q.Query(ctx, query, tenantID, cutoff)
+		if err != nil {
+			return fmt.Errorf("failed to purge deleted users: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err != nil {
+				return fmt.Errorf("failed to scan user id: %w", err)
+			}
+			ids = append(ids, id)
+		}
+
+		if rows.Err() != nil {
+			return fmt.Errorf("failed to iterate purged users: %w", rows.Err())
+		}
+		if err := deleteExternalIDs(ctx, tx.q, "User", ids...); err != nil {
+			return err
+		}
+
+		events := make([]*models.AuditEvent, 0, len(ids))
+		for _, id := range ids {
+			event, err := newAuditEvent(ctx, audit.ActionUserPurge, "", "user", id.String(), nil)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return appendAuditEvents(ctx, tx.q, events...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// lockCurrent locks the user for a compare-and-swap write. It fails with
+// "user not found" for missing and deleted users and with a *ConflictError
+// when the stored version is no longer version.
+func (d *UserDAO) lockCurrent(ctx context.Context, id uuid.UUID, version int64) (*models.User, error) {
+	current, err := d.lockUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status == models.UserStatusDeleted {
+		return nil, fmt.Errorf("user not found")
+	}
+	if current.Version != version {
+		return nil, &ConflictError{Current: current}
+	}
+	return current, nil
 }
 
 func (d *UserDAO) Count(ctx context.Context) (int64, error) {
-	query := `SELECT COUNT(*) FROM users WHERE is_active = true`
+	query := `SELECT COUNT(*) FROM users WHERE tenant_id = $1 AND status <> 'deleted'`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, err
+	}
 
 	var count int64
-	err := d.db.Pool.QueryRow(ctx, query).Scan(&count)
+	err = d.q.QueryRow(ctx, query, tenantID).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count users: %w", err)
 	}
 
 	return count, nil
 }
+
+// nullIfEmpty stores empty optional text as NULL.
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
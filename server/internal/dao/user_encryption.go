@@ -0,0 +1,336 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// emailMatch finds a user by email: by its blind index ($1) once the row is
+// encrypted, and by its normalized plaintext ($2) until then.
+const emailMatch = `(email_index = $1 OR (email_index IS NULL AND email_normalized = $2))`
+
+// emailIndexField names the blind index of the normalized email.
+const emailIndexField = "email"
+
+// sealedUser is the stored form of a user's encrypted fields.
+type sealedUser struct {
+	email, firstName, lastName string
+	emailIndex                 []byte
+	dataKey                    []byte
+	dataKeyID                  string
+}
+
+// emailIndex returns the blind index of email, which is normalized first so
+// that emails that would collide also share an index.
+func (d *UserDAO) emailIndex(email string) []byte {
+	return d.cipher.BlindIndex(emailIndexField, d.NormalizeEmail(email))
+}
+
+// sealUser encrypts a user's email and names under a new data key.
+func (d *UserDAO) sealUser(ctx context.Context, email, firstName, lastName string) (*sealedUser, error) {
+	envelope, err := d.cipher.NewEnvelope(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt user: %w", err)
+	}
+
+	sealed := &sealedUser{
+		emailIndex: d.emailIndex(email),
+		dataKey:    envelope.WrappedKey,
+		dataKeyID:  envelope.KeyID,
+	}
+	for _, f := range []struct {
+		name      string
+		plaintext string
+		sealed    *string
+	}{
+		{"email", email, &sealed.email},
+		{"first_name", firstName, &sealed.firstName},
+		{"last_name", lastName, &sealed.lastName},
+	} {
+		if *f.sealed, err = envelope.Seal(f.name, f.plaintext); err != nil {
+			return nil, fmt.Errorf("failed to encrypt user: %w", err)
+		}
+	}
+
+	return sealed, nil
+}
+
+// openUser decrypts the user's encrypted fields in place. Rows without a
+// data key have not been encrypted yet and are left as they are.
+func (d *UserDAO) openUser(ctx context.Context, user *models.User, dataKeyID *string, dataKey []byte) error {
+	if dataKeyID == nil {
+		return nil
+	}
+
+	envelope, err := d.cipher.OpenEnvelope(ctx, *dataKeyID, dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt user %s: %w", user.ID, err)
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"email", &user.Email},
+		{"first_name", &user.FirstName},
+		{"last_name", &user.LastName},
+	} {
+		if *f.value, err = envelope.Open(f.name, *f.value); err != nil {
+			return fmt.Errorf("failed to decrypt user %s: %w", user.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// sealUpdates replaces the plaintext encrypted fields in updates with their
+// sealed form. The row gets a new data key, so all of its encrypted fields
+// are resealed, taking those that are not updated from before.
+func (d *UserDAO) sealUpdates(ctx context.Context, before *models.User, updates map[string]interface{}) error {
+	email, firstName, lastName := before.Email, before.FirstName, before.LastName
+	changed := false
+	for field, value := range map[string]*string{"email": &email, "first_name": &firstName, "last_name": &lastName} {
+		if v, ok := updates[field].(string); ok {
+			*value = v
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if err := d.checkPlaintextEmail(ctx, email, before.ID); err != nil {
+		return err
+	}
+
+	sealed, err := d.sealUser(ctx, email, firstName, lastName)
+	if err != nil {
+		return err
+	}
+
+	updates["email"] = sealed.email
+	updates["first_name"] = sealed.firstName
+	updates["last_name"] = sealed.lastName
+	updates["email_index"] = sealed.emailIndex
+	updates["email_normalized"] = nil
+	updates["data_key"] = sealed.dataKey
+	updates["data_key_id"] = sealed.dataKeyID
+	return nil
+}
+
+// checkPlaintextEmail fails with a *DuplicateError if a user other than id
+// that has not been encrypted yet has email. The unique index on email_index
+// only covers encrypted users, and no new plaintext users are written, so
+// checking those that remain is enough.
+func (d *UserDAO) checkPlaintextEmail(ctx context.Context, email string, id uuid.UUID) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	var taken bool
+	err = d.q.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM users WHERE tenant_id = $3 AND email_index IS NULL AND email_normalized = $1 AND id <> $2
+		)
+	`, d.NormalizeEmail(email), id, tenantID).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed to check email: %w", err)
+	}
+	if taken {
+		return &DuplicateError{Field: "email"}
+	}
+	return nil
+}
+
+// RotateKeys re-encrypts up to limit users of the organization whose data is
+// not under the primary key, or not encrypted at all, in one transaction.
+// Each gets a new data key. Users are visited in ID order starting after
+// after; it returns the last ID visited and how many users were
+// re-encrypted, which is less than limit once none are left.
+func (d *UserDAO) RotateKeys(ctx context.Context, after uuid.UUID, limit int) (uuid.UUID, int, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE tenant_id = $4 AND id > $1 AND data_key_id IS DISTINCT FROM $2
+		ORDER BY id
+		LIMIT $3
+		FOR UPDATE
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return after, 0, err
+	}
+
+	last := after
+	rotated := 0
+	err = d.InTx(ctx, func(tx *UserDAO) error {
+		rows, err := tx.q.Query(ctx, query, after, d.cipher.PrimaryKeyID(), limit, tenantID)
+		if err != nil {
+			return fmt.Errorf("failed to get users to rotate: %w", err)
+		}
+
+		var users []*models.User
+		for rows.Next() {
+			user, err := tx.scanUser(ctx, rows)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan user: %w", err)
+			}
+			users = append(users, user)
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return fmt.Errorf("failed to iterate users: %w", rows.Err())
+		}
+
+		for _, user := range users {
+			sealed, err := tx.sealUser(ctx, user.Email, user.FirstName, user.LastName)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.q.Exec(ctx, `
+				UPDATE users
+				SET email = $1, first_name = $2, last_name = $3, email_index = $4, email_normalized = NULL,
+					data_key = $5, data_key_id = $6
+				WHERE id = $7 AND tenant_id = $8
+			`, sealed.email, sealed.firstName, sealed.lastName, sealed.emailIndex, sealed.dataKey, sealed.dataKeyID, user.ID, tenantID)
+			if err != nil {
+				if duplicate := asDuplicate(err); duplicate != nil {
+					return fmt.Errorf("user %s: %w", user.ID, duplicate)
+				}
+				return fmt.Errorf("failed to re-encrypt user %s: %w", user.ID, err)
+			}
+
+			last = user.ID
+			rotated++
+		}
+		return nil
+	})
+	if err != nil {
+		return after, 0, err
+	}
+
+	return last, rotated, nil
+}
+
+// RotateHistoryKeys re-encrypts up to limit history entries of the
+// organization's users whose personal fields are not under the primary key,
+// or not encrypted at all, in one transaction, like RotateKeys. Entries are
+// visited in ID order starting after after; it returns the last ID visited
+// and how many entries were re-encrypted.
+func (d *UserDAO) RotateHistoryKeys(ctx context.Context, after int64, limit int) (int64, int, error) {
+	query := `
+		SELECT h.id, h.changes, h.snapshot, h.data_key, h.data_key_id
+		FROM user_history h
+		JOIN users u ON u.id = h.user_id
+		WHERE u.tenant_id = $4 AND h.id > $1 AND h.data_key_id IS DISTINCT FROM $2
+		ORDER BY h.id
+		LIMIT $3
+		FOR UPDATE OF h
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return after, 0, err
+	}
+
+	type entry struct {
+		id        int64
+		changes   map[string]models.FieldChange
+		snapshot  map[string]interface{}
+		dataKey   []byte
+		dataKeyID *string
+	}
+
+	last := after
+	rotated := 0
+	err = d.InTx(ctx, func(tx *UserDAO) error {
+		rows, err := tx.q.Query(ctx, query, after, d.cipher.PrimaryKeyID(), limit, tenantID)
+		if err != nil {
+			return fmt.Errorf("failed to get user history to rotate: %w", err)
+		}
+
+		var entries []*entry
+		for rows.Next() {
+			var e entry
+			if err := rows.Scan(&e.id, &e.changes, &e.snapshot, &e.dataKey, &e.dataKeyID); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan user change: %w", err)
+			}
+			entries = append(entries, &e)
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return fmt.Errorf("failed to iterate user history: %w", rows.Err())
+		}
+
+		for _, e := range entries {
+			if err := tx.openHistory(ctx, e.snapshot, e.changes, e.dataKeyID, e.dataKey); err != nil {
+				return fmt.Errorf("user change %d: %w", e.id, err)
+			}
+			envelope, err := tx.cipher.NewEnvelope(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt user history: %w", err)
+			}
+			if err := mapPersonalValues(e.snapshot, e.changes, sealHistoryValue(envelope)); err != nil {
+				return fmt.Errorf("user change %d: %w", e.id, err)
+			}
+
+			_, err = tx.q.Exec(ctx, `
+				UPDATE user_history
+				SET changes = $1, snapshot = $2, data_key = $3, data_key_id = $4
+				WHERE id = $5
+			`, e.changes, e.snapshot, envelope.WrappedKey, envelope.KeyID, e.id)
+			if err != nil {
+				return fmt.Errorf("failed to re-encrypt user change %d: %w", e.id, err)
+			}
+
+			last = e.id
+			rotated++
+		}
+		return nil
+	})
+	if err != nil {
+		return after, 0, err
+	}
+
+	return last, rotated, nil
+}
+
+// CountUsersByKey returns how many users of the organization are encrypted
+// under each key. Users not encrypted yet are counted under "".
+func (d *UserDAO) CountUsersByKey(ctx context.Context) (map[string]int64, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.q.Query(ctx, `SELECT COALESCE(data_key_id, ''), COUNT(*) FROM users WHERE tenant_id = $1 GROUP BY 1`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count users by key: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var keyID string
+		var count int64
+		if err := rows.Scan(&keyID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan key count: %w", err)
+		}
+		counts[keyID] = count
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate key counts: %w", rows.Err())
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,176 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// personalDataHistoryPage is how many history entries PersonalData reads at a
+// time.
+const personalDataHistoryPage = 1000
+
+// PersonalData is everything stored about a user in the database, apart from
+// the audit log.
+type PersonalData struct {
+	User            *models.User                `json:"user"`
+	Preferences     *models.PreferenceOverrides `json:"preferences"`
+	UsernameHistory []*models.UsernameChange    `json:"username_history"`
+	History         []*models.UserChange        `json:"history"`
+}
+
+// PersonalData returns everything stored about the user, whatever its
+// status, as of a single point in time.
+func (d *UserDAO) PersonalData(ctx context.Context, id uuid.UUID) (*PersonalData, error) {
+	data := &PersonalData{Preferences: &models.PreferenceOverrides{}}
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.InTx(ctx, func(tx *UserDAO) error {
+		if _, err := tx.q.Exec(ctx, `SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY`); err != nil {
+			return fmt.Errorf("failed to start snapshot: %w", err)
+		}
+
+		user, err := tx.scanUser(ctx, tx.q.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1 AND tenant_id = $2`,
+			id, tenantID))
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return fmt.Errorf("user not found")
+			}
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		data.User = user
+
+		err = tx.q.QueryRow(ctx, `SELECT preferences FROM user_preferences WHERE user_id = $1`, id).Scan(data.Preferences)
+		if err != nil && err != pgx.ErrNoRows {
+			return fmt.Errorf("failed to get preferences: %w", err)
+		}
+
+		if data.UsernameHistory, err = tx.GetUsernameHistory(ctx, id); err != nil {
+			return err
+		}
+
+		for offset := 0; ; offset += personalDataHistoryPage {
+			page, err := tx.GetHistory(ctx, id, personalDataHistoryPage, offset)
+			if err != nil {
+				return err
+			}
+			data.History = append(data.History, page...)
+			if len(page) < personalDataHistoryPage {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Exists reports whether the user exists, whatever its status.
+func (d *UserDAO) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err = d.q.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2)`, id, tenantID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+	return exists, nil
+}
+
+// Erasure describes what Erase did.
+type Erasure struct {
+	User *models.User `json:"user"`
+	// PreviousAvatarKey is the storage prefix of the avatar the user had, for
+	// the caller to delete.
+	PreviousAvatarKey *string `json:"-"`
+	// RedactedEvents counts the audit events whose changes were cleared, and
+	// UnredactableEvents those that predate changes digests and were kept.
+	RedactedEvents     int64 `json:"redacted_events"`
+	UnredactableEvents int64 `json:"unredactable_events"`
+}
+
+// Erase anonymizes the user's personal data in place. The row keeps its ID,
+// so everything that refers to it stays valid, but its identity and profile
+// are replaced with placeholders, it is marked deleted and erased, and its
+// preferences, username history, change history, the invitations it
+// accepted, its follows and blocks and its SCIM external ID are removed.
+// Audit events about the user are kept with their changes redacted. Erasing
+// a user again repeats the process.
+func (d *UserDAO) Erase(ctx context.Context, id uuid.UUID, actor string) (*Erasure, error) {
+	query := `
+		UPDATE users
+		SET email = $1, email_normalized = NULL, email_index = $2, username = $3, username_normalized = $4,
+			first_name = $5, last_name = $6, data_key = $7, data_key_id = $8,
+			attributes = '{}', avatar_key = NULL, avatar_url = NULL,
+			status = 'deleted', status_reason = NULL, status_changed_at = $9, status_changed_by = $10,
+			deleted_at = COALESCE(deleted_at, $9), erased_at = $9, updated_at = $9, version = version + 1
+		WHERE id = $11 AND tenant_id = $12
+		RETURNING ` + userColumns
+
+	// The placeholders are derived from the ID so that they stay unique
+	placeholder := "erased-" + strings.ReplaceAll(id.String(), "-", "")
+	email := placeholder + "@erased.invalid"
+
+	erasure := &Erasure{}
+	err := d.InTx(ctx, func(tx *UserDAO) error {
+		before, err := tx.lockUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		erasure.PreviousAvatarKey = before.AvatarKey
+
+		sealed, err := tx.sealUser(ctx, email, "", "")
+		if err != nil {
+			return err
+		}
+
+		erasure.User, err = tx.scanUser(ctx, tx.q.QueryRow(ctx, query,
+			sealed.email, sealed.emailIndex, placeholder, models.NormalizeUsername(placeholder),
+			sealed.firstName, sealed.lastName, sealed.dataKey, sealed.dataKeyID,
+			time.Now(), nullIfEmpty(actor), id, before.TenantID))
+		if err != nil {
+			return fmt.Errorf("failed to erase user: %w", err)
+		}
+
+		for _, table := range []string{"user_preferences", "username_history", "user_history", "invitations"} {
+			if _, err := tx.q.Exec(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, id); err != nil {
+				return fmt.Errorf("failed to erase %s: %w", table, err)
+			}
+		}
+		if err := deleteExternalIDs(ctx, tx.q, "User", id); err != nil {
+			return err
+		}
+		if err := deleteRelationships(ctx, tx.q, id); err != nil {
+			return err
+		}
+
+		erasure.RedactedEvents, erasure.UnredactableEvents, err = redactAuditChanges(ctx, tx.q, "user", id.String())
+		if err != nil {
+			return err
+		}
+
+		// Recorded as if the user were new, so that the entry holds none of
+		// the erased values
+		return tx.recordChange(ctx, models.UserChangeErase, actor, nil, erasure.User)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return erasure, nil
+}
@@ -0,0 +1,78 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// exportFetchSize is the number of rows fetched from the cursor at a time.
+const exportFetchSize = 1000
+
+// StreamUsers calls fn for every user matching filter, oldest first. Rows are
+// read through a server-side cursor in a read-only snapshot, so the result
+// set is never held in memory and the export is consistent.
+func (d *UserDAO) StreamUsers(ctx context.Context, filter models.UserFilter, fn func(*models.User) error) error {
+	where, args, err := d.userFilterClause(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return d.streamUsers(ctx, where, args, fn)
+}
+
+// streamUsers calls fn for every user matching the condition where, oldest
+// first, as StreamUsers describes.
+func (d *UserDAO) streamUsers(ctx context.Context, where string, args []interface{}, fn func(*models.User) error) error {
+	tx, err := d.db.Pool.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	declare := fmt.Sprintf(`
+		DECLARE user_export NO SCROLL CURSOR FOR
+		SELECT `+userColumns+`
+		FROM users
+		WHERE %s
+		ORDER BY created_at, id
+	`, where)
+
+	if _, err := tx.Exec(ctx, declare, args...); err != nil {
+		return fmt.Errorf("failed to open export cursor: %w", err)
+	}
+
+	fetch := fmt.Sprintf(`FETCH FORWARD %d FROM user_export`, exportFetchSize)
+	for {
+		rows, err := tx.Query(ctx, fetch)
+		if err != nil {
+			return fmt.Errorf("failed to fetch users: %w", err)
+		}
+
+		fetched := 0
+		for rows.Next() {
+			user, err := d.scanUser(ctx, rows)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan user: %w", err)
+			}
+			fetched++
+			if err := fn(user); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+
+		if rows.Err() != nil {
+			return fmt.Errorf("failed to iterate users: %w", rows.Err())
+		}
+		if fetched < exportFetchSize {
+			return nil
+		}
+	}
+}
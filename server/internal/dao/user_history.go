@@ -0,0 +1,366 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/encryption"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// historyColumns are the user_history columns userChangeRow fills.
+var historyColumns = []string{"user_id", "version", "operation", "actor", "changed_at", "changes", "snapshot",
+	"data_key", "data_key_id"}
+
+// historyPersonalFields are the user fields encrypted in the users table. A
+// history entry seals them in its snapshot and changes with the data key it
+// stores, and audit events leave their values out.
+var historyPersonalFields = []string{"email", "first_name", "last_name"}
+
+// historyIgnoredFields change on every write, so diffs leave them out.
+var historyIgnoredFields = map[string]bool{
+	"id":                true,
+	"version":           true,
+	"created_at":        true,
+	"updated_at":        true,
+	"status_changed_at": true,
+}
+
+// lockUser reads the user, deleted or not, and locks its row until the
+// surrounding transaction ends.
+func (d *UserDAO) lockUser(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = $1 AND tenant_id = $2
+		FOR UPDATE
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := d.scanUser(ctx, d.q.QueryRow(ctx, query, id, tenantID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// recordChange adds a history entry and an audit event for a write that
+// moved a user from before, nil for a new user, to after.
+func (d *UserDAO) recordChange(ctx context.Context, operation, actor string, before, after *models.User) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	var dataKey []byte
+	var dataKeyID *string
+	err = d.q.QueryRow(ctx, `SELECT data_key, data_key_id FROM users WHERE id = $1 AND tenant_id = $2`,
+		after.ID, tenantID).Scan(&dataKey, &dataKeyID)
+	if err != nil {
+		return fmt.Errorf("failed to get user data key: %w", err)
+	}
+	envelope, err := d.historyEnvelope(ctx, dataKeyID, dataKey)
+	if err != nil {
+		return err
+	}
+
+	row, changes, err := userChangeRow(operation, actor, before, after, envelope)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.q.Exec(ctx, `
+		INSERT INTO user_history (user_id, version, operation, actor, changed_at, changes, snapshot, data_key, data_key_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, row...)
+	if err != nil {
+		return fmt.Errorf("failed to record user change: %w", err)
+	}
+
+	event, err := newAuditEvent(ctx, audit.UserAction(operation), actor, "user", after.ID.String(), changes)
+	if err != nil {
+		return err
+	}
+	return appendAuditEvents(ctx, d.q, event)
+}
+
+// historyEnvelope returns the envelope a user's history entry is sealed
+// with: the one the user row is encrypted under, or a new one for rows that
+// have not been encrypted yet.
+func (d *UserDAO) historyEnvelope(ctx context.Context, dataKeyID *string, dataKey []byte) (*encryption.Envelope, error) {
+	if dataKeyID == nil {
+		envelope, err := d.cipher.NewEnvelope(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt user history: %w", err)
+		}
+		return envelope, nil
+	}
+
+	envelope, err := d.cipher.OpenEnvelope(ctx, *dataKeyID, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt user history: %w", err)
+	}
+	return envelope, nil
+}
+
+// userChangeRow returns the values of historyColumns for a write, with the
+// personal fields sealed by envelope, along with the encoded field changes
+// for the audit log, which leave their values out.
+func userChangeRow(operation, actor string, before, after *models.User, envelope *encryption.Envelope) ([]interface{}, json.RawMessage, error) {
+	plain, err := json.Marshal(after)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode user: %w", err)
+	}
+
+	changes, err := diffUsers(before, plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	audited, err := json.Marshal(redactPersonalChanges(changes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode user changes: %w", err)
+	}
+
+	snapshot := map[string]interface{}{}
+	if err := json.Unmarshal(plain, &snapshot); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode user: %w", err)
+	}
+	if err := mapPersonalValues(snapshot, changes, sealHistoryValue(envelope)); err != nil {
+		return nil, nil, err
+	}
+	sealedSnapshot, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode user: %w", err)
+	}
+	sealedChanges, err := json.Marshal(changes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode user changes: %w", err)
+	}
+
+	row := []interface{}{after.ID, after.Version, operation, nullIfEmpty(actor), after.UpdatedAt, sealedChanges,
+		sealedSnapshot, envelope.WrappedKey, envelope.KeyID}
+	return row, audited, nil
+}
+
+// redactPersonalChanges returns changes with the values of the personal
+// fields left out. They are still recorded as changed.
+func redactPersonalChanges(changes map[string]models.FieldChange) map[string]models.FieldChange {
+	redacted := make(map[string]models.FieldChange, len(changes))
+	for field, change := range changes {
+		redacted[field] = change
+	}
+	for _, field := range historyPersonalFields {
+		if _, ok := redacted[field]; ok {
+			redacted[field] = models.FieldChange{Redacted: true}
+		}
+	}
+	return redacted
+}
+
+// mapPersonalValues replaces the string values of the personal fields in a
+// decoded snapshot and in changes, either of which may be nil, with what fn
+// makes of them.
+func mapPersonalValues(snapshot map[string]interface{}, changes map[string]models.FieldChange,
+	fn func(field, value string) (string, error)) error {
+	var err error
+	for _, field := range historyPersonalFields {
+		if value, ok := snapshot[field].(string); ok {
+			if snapshot[field], err = fn(field, value); err != nil {
+				return err
+			}
+		}
+
+		change, ok := changes[field]
+		if !ok {
+			continue
+		}
+		for _, v := range []*interface{}{&change.Old, &change.New} {
+			if value, ok := (*v).(string); ok {
+				if *v, err = fn(field, value); err != nil {
+					return err
+				}
+			}
+		}
+		changes[field] = change
+	}
+	return nil
+}
+
+// historySealedField is what a personal field is sealed as in user history,
+// so that it cannot be passed off as the user's current value.
+func historySealedField(field string) string {
+	return "user_history." + field
+}
+
+func sealHistoryValue(envelope *encryption.Envelope) func(field, value string) (string, error) {
+	return func(field, value string) (string, error) {
+		sealed, err := envelope.Seal(historySealedField(field), value)
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt user history: %w", err)
+		}
+		return sealed, nil
+	}
+}
+
+func openHistoryValue(envelope *encryption.Envelope) func(field, value string) (string, error) {
+	return func(field, value string) (string, error) {
+		plaintext, err := envelope.Open(historySealedField(field), value)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt user history: %w", err)
+		}
+		return plaintext, nil
+	}
+}
+
+// openHistory decrypts the personal fields of a history entry in place.
+// Entries without a data key have not been encrypted yet and are left as
+// they are.
+func (d *UserDAO) openHistory(ctx context.Context, snapshot map[string]interface{}, changes map[string]models.FieldChange,
+	dataKeyID *string, dataKey []byte) error {
+	if dataKeyID == nil {
+		return nil
+	}
+
+	envelope, err := d.cipher.OpenEnvelope(ctx, *dataKeyID, dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt user history: %w", err)
+	}
+	return mapPersonalValues(snapshot, changes, openHistoryValue(envelope))
+}
+
+// diffUsers compares the JSON representations of a user before and after a
+// write, field by field.
+func diffUsers(before *models.User, after []byte) (map[string]models.FieldChange, error) {
+	old := map[string]interface{}{}
+	if before != nil {
+		encoded, err := json.Marshal(before)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode user: %w", err)
+		}
+		if err := json.Unmarshal(encoded, &old); err != nil {
+			return nil, fmt.Errorf("failed to decode user: %w", err)
+		}
+	}
+
+	current := map[string]interface{}{}
+	if err := json.Unmarshal(after, &current); err != nil {
+		return nil, fmt.Errorf("failed to decode user: %w", err)
+	}
+
+	changes := make(map[string]models.FieldChange)
+	for field, value := range current {
+		if !historyIgnoredFields[field] && !reflect.DeepEqual(old[field], value) {
+			changes[field] = models.FieldChange{Old: old[field], New: value}
+		}
+	}
+	for field, value := range old {
+		if _, ok := current[field]; !ok && !historyIgnoredFields[field] {
+			changes[field] = models.FieldChange{Old: value, New: nil}
+		}
+	}
+
+	return changes, nil
+}
+
+// GetHistory returns the recorded writes to a user, newest first.
+func (d *UserDAO) GetHistory(ctx context.Context, id uuid.UUID, limit, offset int) ([]*models.UserChange, error) {
+	query := `
+		SELECT h.id, h.user_id, h.version, h.operation, h.actor, h.changed_at, h.changes, h.data_key, h.data_key_id
+		FROM user_history h
+		JOIN users u ON u.id = h.user_id
+		WHERE h.user_id = $1 AND u.tenant_id = $2
+		ORDER BY h.changed_at DESC, h.id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.q.Query(ctx, query, id, tenantID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []*models.UserChange
+	for rows.Next() {
+		var change models.UserChange
+		var dataKey []byte
+		var dataKeyID *string
+		if err := rows.Scan(&change.ID, &change.UserID, &change.Version, &change.Operation, &change.Actor,
+			&change.ChangedAt, &change.Changes, &dataKey, &dataKeyID); err != nil {
+			return nil, fmt.Errorf("failed to scan user change: %w", err)
+		}
+		if err := d.openHistory(ctx, nil, change.Changes, dataKeyID, dataKey); err != nil {
+			return nil, err
+		}
+		history = append(history, &change)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate user history: %w", rows.Err())
+	}
+
+	return history, nil
+}
+
+// GetAsOf returns the user as it was at the given time, including while it
+// was deleted. It fails with "user not found" if nothing was recorded for the
+// user by then.
+func (d *UserDAO) GetAsOf(ctx context.Context, id uuid.UUID, at time.Time) (*models.User, error) {
+	query := `
+		SELECT h.snapshot, h.data_key, h.data_key_id
+		FROM user_history h
+		JOIN users u ON u.id = h.user_id
+		WHERE h.user_id = $1 AND u.tenant_id = $2 AND h.changed_at <= $3
+		ORDER BY h.changed_at DESC, h.id DESC
+		LIMIT 1
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot map[string]interface{}
+	var dataKey []byte
+	var dataKeyID *string
+	if err := d.q.QueryRow(ctx, query, id, tenantID, at).Scan(&snapshot, &dataKey, &dataKeyID); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user history: %w", err)
+	}
+	if err := d.openHistory(ctx, snapshot, nil, dataKeyID, dataKey); err != nil {
+		return nil, err
+	}
+
+	// Back through JSON, now that the personal fields are decrypted
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode user snapshot: %w", err)
+	}
+	var user models.User
+	if err := json.Unmarshal(encoded, &user); err != nil {
+		return nil, fmt.Errorf("failed to decode user snapshot: %w", err)
+	}
+
+	return &user, nil
+}
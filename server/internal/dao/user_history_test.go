@@ -0,0 +1,161 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/encryption"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// plainKeys is a KeyProvider that stores data keys as they are.
+type plainKeys struct{}
+
+func (plainKeys) PrimaryKeyID() string { return "test" }
+
+func (plainKeys) WrapKey(_ context.Context, _ string, dataKey []byte) ([]byte, error) {
+	return dataKey, nil
+}
+
+func (plainKeys) UnwrapKey(_ context.Context, _ string, wrapped []byte) ([]byte, error) {
+	return wrapped, nil
+}
+
+func testUserDAO(t *testing.T) *UserDAO {
+	t.Helper()
+	cipher, err := encryption.NewCipher(plainKeys{}, make([]byte, encryption.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &UserDAO{cfg: config.Users{}, cipher: cipher}
+}
+
+func historyUsers() (*models.User, *models.User) {
+	before := &models.User{
+		ID:        uuid.New(),
+		Email:     "ann@example.com",
+		Username:  "ann",
+		FirstName: "Ann",
+		LastName:  "Berg",
+		Status:    models.UserStatusActive,
+		Version:   1,
+		UpdatedAt: time.Date(2024, 5, 14, 9, 0, 0, 0, time.UTC),
+	}
+	after := *before
+	after.Email = "ann@example.org"
+	after.LastName = "Lind"
+	after.Username = "anna"
+	after.Version = 2
+	return before, &after
+}
+
+func TestUserChangeRowSealsPersonalFields(t *testing.T) {
+	ctx := context.Background()
+	d := testUserDAO(t)
+	before, after := historyUsers()
+	envelope, err := d.cipher.NewEnvelope(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row, audited, err := userChangeRow("update", "user-1", before, after, envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(row) != len(historyColumns) {
+		t.Fatalf("row has %d values for %d columns", len(row), len(historyColumns))
+	}
+
+	stored := strings.Join([]string{string(row[5].([]byte)), string(row[6].([]byte)), string(audited)}, "\n")
+	for _, plaintext := range []string{"ann@example.com", "ann@example.org", "Ann", "Berg", "Lind"} {
+		if strings.Contains(stored, `"`+plaintext+`"`) {
+			t.Errorf("%q is stored in the clear:\n%s", plaintext, stored)
+		}
+	}
+
+	// The audit changes name the personal fields but leave out their values
+	var auditChanges map[string]models.FieldChange
+	if err := json.Unmarshal(audited, &auditChanges); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"email", "last_name"} {
+		if change, ok := auditChanges[field]; !ok || !change.Redacted || change.Old != nil || change.New != nil {
+			t.Errorf("audit change of %s = %+v, want it redacted", field, change)
+		}
+	}
+	if change := auditChanges["username"]; change.Old != "ann" || change.New != "anna" {
+		t.Errorf("audit change of username = %+v, want it kept", change)
+	}
+	if _, ok := auditChanges["first_name"]; ok {
+		t.Error("unchanged first_name is recorded as changed")
+	}
+
+	// And the stored entry opens to the plaintext again
+	var snapshot map[string]interface{}
+	var changes map[string]models.FieldChange
+	if err := json.Unmarshal(row[6].([]byte), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(row[5].([]byte), &changes); err != nil {
+		t.Fatal(err)
+	}
+	keyID := row[8].(string)
+	if err := d.openHistory(ctx, snapshot, changes, &keyID, row[7].([]byte)); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot["email"] != "ann@example.org" || snapshot["first_name"] != "Ann" || snapshot["last_name"] != "Lind" {
+		t.Errorf("opened snapshot = %v", snapshot)
+	}
+	if change := changes["email"]; change.Old != "ann@example.com" || change.New != "ann@example.org" {
+		t.Errorf("opened email change = %+v", change)
+	}
+	if change := changes["username"]; change.Old != "ann" || change.New != "anna" {
+		t.Errorf("opened username change = %+v", change)
+	}
+}
+
+func TestHistoryValuesAreBoundToHistory(t *testing.T) {
+	d := testUserDAO(t)
+	envelope, err := d.cipher.NewEnvelope(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A value sealed for a user row does not open as history, and the other
+	// way around
+	sealed, err := envelope.Seal("email", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openHistoryValue(envelope)("email", sealed); err == nil {
+		t.Error("a user row value opened as history")
+	}
+
+	sealed, err = sealHistoryValue(envelope)("email", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := envelope.Open("email", sealed); err == nil {
+		t.Error("a history value opened as a user row value")
+	}
+	if _, err := openHistoryValue(envelope)("first_name", sealed); err == nil {
+		t.Error("a history email opened as first_name")
+	}
+}
+
+func TestOpenHistoryLeavesUnencryptedEntries(t *testing.T) {
+	d := testUserDAO(t)
+	snapshot := map[string]interface{}{"email": "ann@example.com"}
+	changes := map[string]models.FieldChange{"email": {Old: nil, New: "ann@example.com"}}
+	if err := d.openHistory(context.Background(), snapshot, changes, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot["email"] != "ann@example.com" || changes["email"].New != "ann@example.com" {
+		t.Errorf("unencrypted entry changed: %v %v", snapshot, changes)
+	}
+}
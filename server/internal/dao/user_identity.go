@@ -0,0 +1,119 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// IdentityConflict is a user whose recomputed email or username collides
+// with another user's.
+type IdentityConflict struct {
+	UserID uuid.UUID
+	Field  string
+}
+
+// RenormalizeIdentities recomputes the normalized email and username of every
+// user of the organization, deleted or not, with the server's current rules.
+// For encrypted users the email's blind index is recomputed instead. Users
+// whose new values would collide with another user keep their old ones and
+// are returned as conflicts.
+func (d *UserDAO) RenormalizeIdentities(ctx context.Context) (int, []IdentityConflict, error) {
+	type identity struct {
+		id                  uuid.UUID
+		email, username     string
+		emailIndex          []byte
+		emailNorm, userNorm string
+	}
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := d.q.Query(ctx, `
+		SELECT `+userColumns+`, email_index, COALESCE(email_normalized, ''), username_normalized
+		FROM users
+		WHERE tenant_id = $1
+	`, tenantID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get user identities: %w", err)
+	}
+
+	var stale []identity
+	for rows.Next() {
+		var u identity
+		user, err := d.scanUser(ctx, rows, &u.emailIndex, &u.emailNorm, &u.userNorm)
+		if err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan user identity: %w", err)
+		}
+		u.id, u.email, u.username = user.ID, user.Email, user.Username
+
+		emailStale := u.emailNorm != d.NormalizeEmail(u.email)
+		if u.emailIndex != nil {
+			emailStale = !bytes.Equal(u.emailIndex, d.emailIndex(u.email))
+		}
+		if emailStale || u.userNorm != models.NormalizeUsername(u.username) {
+			stale = append(stale, u)
+		}
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, nil, fmt.Errorf("failed to iterate user identities: %w", rows.Err())
+	}
+
+	updated := 0
+	var conflicts []IdentityConflict
+	for _, u := range stale {
+		userNorm := models.NormalizeUsername(u.username)
+		err := d.InTx(ctx, func(tx *UserDAO) error {
+			changes := make(map[string]models.FieldChange)
+			if userNorm != u.userNorm {
+				changes["username_normalized"] = models.FieldChange{Old: u.userNorm, New: userNorm}
+			}
+
+			var err error
+			if u.emailIndex != nil {
+				emailIndex := d.emailIndex(u.email)
+				_, err = tx.q.Exec(ctx, `UPDATE users SET email_index = $1, username_normalized = $2 WHERE id = $3 AND tenant_id = $4`,
+					emailIndex, userNorm, u.id, tenantID)
+				if !bytes.Equal(emailIndex, u.emailIndex) {
+					changes["email_index"] = models.FieldChange{Old: hex.EncodeToString(u.emailIndex), New: hex.EncodeToString(emailIndex)}
+				}
+			} else {
+				emailNorm := d.NormalizeEmail(u.email)
+				_, err = tx.q.Exec(ctx, `UPDATE users SET email_normalized = $1, username_normalized = $2 WHERE id = $3 AND tenant_id = $4`,
+					emailNorm, userNorm, u.id, tenantID)
+				if emailNorm != u.emailNorm {
+					changes["email_normalized"] = models.FieldChange{Redacted: true}
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			event, err := newAuditEvent(ctx, audit.ActionUserRenormalize, "", "user", u.id.String(), changes)
+			if err != nil {
+				return err
+			}
+			return appendAuditEvents(ctx, tx.q, event)
+		})
+		if err != nil {
+			if duplicate := asDuplicate(err); duplicate != nil {
+				conflicts = append(conflicts, IdentityConflict{UserID: u.id, Field: duplicate.Field})
+				continue
+			}
+			return updated, conflicts, fmt.Errorf("failed to update user identity: %w", err)
+		}
+		updated++
+	}
+
+	return updated, conflicts, nil
+}
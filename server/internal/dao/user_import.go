@@ -0,0 +1,508 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// UpsertResult describes what UpsertBatch did with one user.
+type UpsertResult struct {
+	ID      uuid.UUID
+	Created bool
+}
+
+// InvalidUsernameError is returned when an import would give a user a
+// username that breaks the naming policy.
+type InvalidUsernameError struct {
+	Err error
+}
+
+func (e *InvalidUsernameError) Error() string {
+	return e.Err.Error()
+}
+
+// UpsertBatch inserts or updates users by normalized email in a single
+// transaction, returning the results keyed by normalized email. The users
+// the batch matches are locked and compared first; the rows to write are
+// encrypted, streamed into a temporary table with COPY and merged from there.
+// Users whose email belongs to a deleted account, or whose fields already
+// match, are left alone and missing from the result. Usernames are held to
+// the same rules as other changes, see CheckImportUsernames; the first user
+// that breaks them fails the batch. Every created or changed user gets an
+// import entry in its history.
+func (d *UserDAO) UpsertBatch(ctx context.Context, users []*models.User) (map[string]UpsertResult, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := d.q.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	indexes := make([][]byte, len(users))
+	normalized := make([]string, len(users))
+	for i, user := range users {
+		indexes[i] = d.emailIndex(user.Email)
+		normalized[i] = d.NormalizeEmail(user.Email)
+	}
+
+	// Remember the users about to change so their history records diffs
+	before, err := d.existingImportUsers(ctx, tx, tenantID, indexes, normalized, true)
+	if err != nil {
+		return nil, err
+	}
+
+	failures, err := d.checkImportUsernames(ctx, tx, tenantID, users, normalized, before)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		if err := failures[i]; err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		CREATE TEMP TABLE user_import (
+			id UUID NOT NULL,
+			email TEXT NOT NULL,
+			username VARCHAR(100) NOT NULL,
+			first_name TEXT NOT NULL,
+			last_name TEXT NOT NULL,
+			email_index BYTEA NOT NULL,
+			data_key BYTEA NOT NULL,
+			data_key_id TEXT NOT NULL,
+			username_normalized VARCHAR(100) NOT NULL
+		) ON COMMIT DROP
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create import table: %w", err)
+	}
+
+	emails := make(map[uuid.UUID]string, len(users))
+	changed := make(map[uuid.UUID]*models.User, len(before))
+	rows := make([][]interface{}, 0, len(users))
+	for i, user := range users {
+		id, email := uuid.New(), user.Email
+		if prev, ok := before[normalized[i]]; ok {
+			if !importChanges(prev, user) {
+				continue
+			}
+			// Existing users keep the email they have
+			id, email = prev.ID, prev.Email
+			changed[id] = prev
+		}
+
+		sealed, err := d.sealUser(ctx, email, user.FirstName, user.LastName)
+		if err != nil {
+			return nil, err
+		}
+		emails[id] = normalized[i]
+		rows = append(rows, []interface{}{id, sealed.email, user.Username, sealed.firstName, sealed.lastName,
+			sealed.emailIndex, sealed.dataKey, sealed.dataKeyID, models.NormalizeUsername(user.Username)})
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"user_import"},
+		[]string{"id", "email", "username", "first_name", "last_name", "email_index", "data_key", "data_key_id", "username_normalized"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy users: %w", err)
+	}
+
+	now := time.Now()
+
+	// Renames are recorded so the old names redirect and stay held
+	_, err = tx.Exec(ctx, `
+		INSERT INTO username_history (user_id, username, username_normalized, changed_at, reserved_until)
+		SELECT u.id, u.username, u.username_normalized, $1, $2
+		FROM users u
+		JOIN user_import i ON i.id = u.id
+		WHERE u.username_normalized <> i.username_normalized
+	`, now, now.Add(d.cfg.UsernameReservation))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record username changes: %w", err)
+	}
+
+	// Changed users are resealed whole, which also encrypts those that were
+	// not yet
+	query := `
+		INSERT INTO users (id, email, username, first_name, last_name, status, status_changed_at, version, created_at, updated_at,
+			email_index, data_key, data_key_id, username_normalized, tenant_id)
+		SELECT id, email, username, first_name, last_name, 'active', $1, 1, $1, $1,
+			email_index, data_key, data_key_id, username_normalized, $2
+		FROM user_import
+		ON CONFLICT (id) DO UPDATE
+		SET email = EXCLUDED.email,
+			email_index = EXCLUDED.email_index,
+			email_normalized = NULL,
+			username = EXCLUDED.username,
+			username_normalized = EXCLUDED.username_normalized,
+			first_name = EXCLUDED.first_name,
+			last_name = EXCLUDED.last_name,
+			data_key = EXCLUDED.data_key,
+			data_key_id = EXCLUDED.data_key_id,
+			updated_at = EXCLUDED.updated_at,
+			version = users.version + 1
+		WHERE users.tenant_id = EXCLUDED.tenant_id
+		RETURNING id, xmax = 0
+	`
+
+	result, err := tx.Query(ctx, query, now, tenantID)
+	if err != nil {
+		if duplicate := asDuplicate(err); duplicate != nil {
+			return nil, duplicate
+		}
+		return nil, fmt.Errorf("failed to upsert users: %w", err)
+	}
+	defer result.Close()
+
+	upserted := make(map[string]UpsertResult, len(users))
+	for result.Next() {
+		var r UpsertResult
+		if err := result.Scan(&r.ID, &r.Created); err != nil {
+			return nil, fmt.Errorf("failed to scan upsert result: %w", err)
+		}
+		upserted[emails[r.ID]] = r
+	}
+
+	if result.Err() != nil {
+		if duplicate := asDuplicate(result.Err()); duplicate != nil {
+			return nil, duplicate
+		}
+		return nil, fmt.Errorf("failed to upsert users: %w", result.Err())
+	}
+
+	if err := d.recordImportChanges(ctx, tx, tenantID, upserted, changed); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+
+	return upserted, nil
+}
+
+// importChanges reports whether importing user writes to prev, the user
+// with the same email. Deleted users and users whose fields already match
+// are left alone.
+func importChanges(prev, user *models.User) bool {
+	if prev.Status == models.UserStatusDeleted {
+		return false
+	}
+	return prev.Username != user.Username || prev.FirstName != user.FirstName || prev.LastName != user.LastName
+}
+
+// existingImportUsers returns the users of the organization with the given
+// email indexes or, for users not yet encrypted, normalized emails, keyed by
+// normalized email. With lock, their rows are locked until the transaction
+// q belongs to ends.
+func (d *UserDAO) existingImportUsers(ctx context.Context, q querier, tenantID uuid.UUID, indexes [][]byte, normalized []string, lock bool) (map[string]*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE tenant_id = $3 AND (email_index = ANY($1) OR (email_index IS NULL AND email_normalized = ANY($2)))
+	`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	rows, err := q.Query(ctx, query, indexes, normalized, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing users: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]*models.User)
+	for rows.Next() {
+		user, err := d.scanUser(ctx, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		existing[d.NormalizeEmail(user.Email)] = user
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate existing users: %w", rows.Err())
+	}
+
+	return existing, nil
+}
+
+// CheckImportUsernames applies the username rules UpsertBatch enforces to
+// users about to be imported, without writing anything, so that a dry run
+// reports the same failures as a real one. It returns the error for every
+// user, by index, that would fail.
+func (d *UserDAO) CheckImportUsernames(ctx context.Context, users []*models.User) (map[int]error, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([][]byte, len(users))
+	normalized := make([]string, len(users))
+	for i, user := range users {
+		indexes[i] = d.emailIndex(user.Email)
+		normalized[i] = d.NormalizeEmail(user.Email)
+	}
+
+	existing, err := d.existingImportUsers(ctx, d.q, tenantID, indexes, normalized, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.checkImportUsernames(ctx, d.q, tenantID, users, normalized, existing)
+}
+
+// checkImportUsernames holds the usernames users would be written with to
+// the rules of Create and Update: a new username must follow the naming
+// policy, while existing ones may predate it; a username may not belong to
+// another user, including deleted ones, nor be reserved for one; and an
+// existing user may only be renamed once the cooldown since their last
+// change has passed. Users are matched to existing ones by normalized email;
+// those the import leaves alone are not checked.
+func (d *UserDAO) checkImportUsernames(ctx context.Context, q querier, tenantID uuid.UUID, users []*models.User,
+	emails []string, existing map[string]*models.User) (map[int]error, error) {
+	failures := make(map[int]error)
+
+	// The user each written row belongs to, or uuid.Nil for new ones
+	owners := make(map[int]uuid.UUID)
+	var names []string
+	var renamed []uuid.UUID
+	for i, user := range users {
+		name := models.NormalizeUsername(user.Username)
+		prev, ok := existing[emails[i]]
+		if ok && (!importChanges(prev, user) || prev.Username == user.Username) {
+			continue
+		}
+		if err := models.ValidateUsername(user.Username); err != nil {
+			failures[i] = &InvalidUsernameError{Err: err}
+			continue
+		}
+		if !ok {
+			owners[i] = uuid.Nil
+			names = append(names, name)
+			continue
+		}
+		// Changes of case or width keep the name, which stays the user's
+		if models.NormalizeUsername(prev.Username) == name {
+			continue
+		}
+		owners[i] = prev.ID
+		names = append(names, name)
+		renamed = append(renamed, prev.ID)
+	}
+	if len(names) == 0 {
+		return failures, nil
+	}
+
+	taken := make(map[string]uuid.UUID)
+	rows, err := q.Query(ctx, `
+		SELECT username_normalized, id FROM users WHERE tenant_id = $1 AND username_normalized = ANY($2)
+	`, tenantID, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check usernames: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		var id uuid.UUID
+		if err := rows.Scan(&name, &id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan username: %w", err)
+		}
+		taken[name] = id
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to check usernames: %w", rows.Err())
+	}
+
+	now := time.Now()
+	reserved := make(map[string][]uuid.UUID)
+	rows, err = q.Query(ctx, `
+		SELECT h.username_normalized, h.user_id FROM username_history h JOIN users u ON u.id = h.user_id
+		WHERE u.tenant_id = $1 AND h.username_normalized = ANY($2) AND h.reserved_until > $3
+	`, tenantID, names, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check username reservations: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		var id uuid.UUID
+		if err := rows.Scan(&name, &id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan username reservation: %w", err)
+		}
+		reserved[name] = append(reserved[name], id)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to check username reservations: %w", rows.Err())
+	}
+
+	lastChanges := make(map[uuid.UUID]time.Time)
+	if len(renamed) > 0 && d.cfg.UsernameChangeCooldown > 0 {
+		rows, err = q.Query(ctx, `
+			SELECT user_id, max(changed_at) FROM username_history WHERE user_id = ANY($1) GROUP BY user_id
+		`, renamed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get username history: %w", err)
+		}
+		for rows.Next() {
+			var id uuid.UUID
+			var changedAt time.Time
+			if err := rows.Scan(&id, &changedAt); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan username history: %w", err)
+			}
+			lastChanges[id] = changedAt
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return nil, fmt.Errorf("failed to get username history: %w", rows.Err())
+		}
+	}
+
+	for i, owner := range owners {
+		name := models.NormalizeUsername(users[i].Username)
+		if id, ok := taken[name]; ok && id != owner {
+			failures[i] = &DuplicateError{Field: "username"}
+			continue
+		}
+		for _, id := range reserved[name] {
+			if id != owner {
+				failures[i] = &DuplicateError{Field: "username"}
+				break
+			}
+		}
+		if failures[i] != nil {
+			continue
+		}
+		if changedAt, ok := lastChanges[owner]; ok {
+			if retryAt := changedAt.Add(d.cfg.UsernameChangeCooldown); now.Before(retryAt) {
+				failures[i] = &UsernameCooldownError{RetryAt: retryAt}
+			}
+		}
+	}
+
+	return failures, nil
+}
+
+// recordImportChanges adds a history entry and an audit event for every user
+// an import created or changed. before holds the changed users as they were.
+func (d *UserDAO) recordImportChanges(ctx context.Context, tx pgx.Tx, tenantID uuid.UUID, upserted map[string]UpsertResult, before map[uuid.UUID]*models.User) error {
+	if len(upserted) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(upserted))
+	for _, r := range upserted {
+		ids = append(ids, r.ID)
+	}
+
+	// The data key is read a second time for sealing the history entries
+	rows, err := tx.Query(ctx, `SELECT `+userColumns+`, data_key, data_key_id FROM users WHERE id = ANY($1) AND tenant_id = $2`,
+		ids, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to get imported users: %w", err)
+	}
+	defer rows.Close()
+
+	history := make([][]interface{}, 0, len(ids))
+	events := make([]*models.AuditEvent, 0, len(ids))
+	for rows.Next() {
+		var dataKey []byte
+		var dataKeyID *string
+		after, err := d.scanUser(ctx, rows, &dataKey, &dataKeyID)
+		if err != nil {
+			return fmt.Errorf("failed to scan user: %w", err)
+		}
+		envelope, err := d.historyEnvelope(ctx, dataKeyID, dataKey)
+		if err != nil {
+			return err
+		}
+		row, changes, err := userChangeRow(models.UserChangeImport, "", before[after.ID], after, envelope)
+		if err != nil {
+			return err
+		}
+		history = append(history, row)
+
+		event, err := newAuditEvent(ctx, audit.UserAction(models.UserChangeImport), "", "user", after.ID.String(), changes)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("failed to iterate imported users: %w", rows.Err())
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"user_history"}, historyColumns, pgx.CopyFromRows(history))
+	if err != nil {
+		return fmt.Errorf("failed to record user changes: %w", err)
+	}
+
+	return appendAuditEvents(ctx, tx, events...)
+}
+
+// GetStatusesByEmail returns the status of every user of the organization,
+// deleted or not, whose email is in emails, keyed by normalized email.
+func (d *UserDAO) GetStatusesByEmail(ctx context.Context, emails []string) (map[string]models.UserStatus, error) {
+	query := `
+		SELECT email_index, email_normalized, status
+		FROM users
+		WHERE tenant_id = $3 AND (email_index = ANY($1) OR (email_index IS NULL AND email_normalized = ANY($2)))
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([][]byte, len(emails))
+	normalized := make([]string, len(emails))
+	byIndex := make(map[string]string, len(emails))
+	for i, email := range emails {
+		indexes[i] = d.emailIndex(email)
+		normalized[i] = d.NormalizeEmail(email)
+		byIndex[string(indexes[i])] = normalized[i]
+	}
+
+	rows, err := d.q.Query(ctx, query, indexes, normalized, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]models.UserStatus, len(emails))
+	for rows.Next() {
+		var index []byte
+		var email *string
+		var status models.UserStatus
+		if err := rows.Scan(&index, &email, &status); err != nil {
+			return nil, fmt.Errorf("failed to scan user status: %w", err)
+		}
+		if index != nil {
+			statuses[byIndex[string(index)]] = status
+		} else if email != nil {
+			statuses[*email] = status
+		}
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate user statuses: %w", rows.Err())
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,100 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// GetPreferences returns the settings the user has chosen. A user who has
+// chosen none gets empty overrides.
+func (d *UserDAO) GetPreferences(ctx context.Context, id uuid.UUID) (*models.PreferenceOverrides, error) {
+	query := `
+		SELECT p.preferences
+		FROM users u
+		LEFT JOIN user_preferences p ON p.user_id = u.id
+		WHERE u.id = $1 AND u.tenant_id = $2 AND u.status <> 'deleted'
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides *models.PreferenceOverrides
+	if err := d.q.QueryRow(ctx, query, id, tenantID).Scan(&overrides); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
+
+	if overrides == nil {
+		overrides = &models.PreferenceOverrides{}
+	}
+	return overrides, nil
+}
+
+// SetPreferences replaces the settings the user has chosen.
+func (d *UserDAO) SetPreferences(ctx context.Context, id uuid.UUID, overrides *models.PreferenceOverrides, actor string) error {
+	_, err := d.UpdatePreferences(ctx, id, func(*models.PreferenceOverrides) (*models.PreferenceOverrides, error) {
+		return overrides, nil
+	}, actor)
+	return err
+}
+
+// UpdatePreferences replaces the settings the user has chosen with what
+// update makes of the current ones, and returns the result. The user is
+// locked from the read to the write, so concurrent updates apply one after
+// the other instead of overwriting each other. update must not modify the
+// settings it is given; an error from it is returned as is and changes
+// nothing.
+func (d *UserDAO) UpdatePreferences(ctx context.Context, id uuid.UUID,
+	update func(*models.PreferenceOverrides) (*models.PreferenceOverrides, error), actor string) (*models.PreferenceOverrides, error) {
+	query := `
+		INSERT INTO user_preferences (user_id, preferences, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET preferences = EXCLUDED.preferences, updated_at = EXCLUDED.updated_at
+	`
+
+	var updated *models.PreferenceOverrides
+	err := d.InTx(ctx, func(tx *UserDAO) error {
+		user, err := tx.lockUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user.Status == models.UserStatusDeleted {
+			return fmt.Errorf("user not found")
+		}
+
+		previous, err := tx.GetPreferences(ctx, id)
+		if err != nil {
+			return err
+		}
+		if updated, err = update(previous); err != nil {
+			return err
+		}
+
+		if _, err := tx.q.Exec(ctx, query, id, updated, time.Now()); err != nil {
+			return fmt.Errorf("failed to set preferences: %w", err)
+		}
+
+		event, err := newAuditEvent(ctx, audit.ActionUserPreferences, actor, "user", id.String(),
+			map[string]models.FieldChange{"preferences": {Old: previous, New: updated}})
+		if err != nil {
+			return err
+		}
+		return appendAuditEvents(ctx, tx.q, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
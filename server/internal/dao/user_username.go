@@ -0,0 +1,176 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// UsernameCooldownError is returned when a user changes username again
+// before the cooldown since their last change has passed.
+type UsernameCooldownError struct {
+	RetryAt time.Time
+}
+
+func (e *UsernameCooldownError) Error() string {
+	return fmt.Sprintf("username was changed recently, try again after %s", e.RetryAt.Format(time.RFC3339))
+}
+
+// changeUsername applies updates, which include a new username, inside a
+// transaction. Changes that only alter case or width are applied directly.
+// Real changes must respect the cooldown since the user's last change and
+// may not take a name another user gave up within the reservation period.
+// The old name is recorded in username_history and held for its owner.
+func (d *UserDAO) changeUsername(ctx context.Context, id uuid.UUID, version int64, username string, updates map[string]interface{}, actor string) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	var current, currentNormalized string
+	err = d.q.QueryRow(ctx, `
+		SELECT username, username_normalized FROM users
+		WHERE id = $1 AND tenant_id = $2 AND status <> 'deleted'
+		FOR UPDATE
+	`, id, tenantID).Scan(&current, &currentNormalized)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("user not found")
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	normalized := models.NormalizeUsername(username)
+	if normalized == currentNormalized {
+		return d.update(ctx, id, version, updates, actor)
+	}
+
+	now := time.Now()
+
+	var lastChange *time.Time
+	err = d.q.QueryRow(ctx, `SELECT max(changed_at) FROM username_history WHERE user_id = $1`, id).Scan(&lastChange)
+	if err != nil {
+		return fmt.Errorf("failed to get username history: %w", err)
+	}
+	if lastChange != nil && d.cfg.UsernameChangeCooldown > 0 {
+		if retryAt := lastChange.Add(d.cfg.UsernameChangeCooldown); now.Before(retryAt) {
+			return &UsernameCooldownError{RetryAt: retryAt}
+		}
+	}
+
+	var reserved bool
+	err = d.q.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM username_history h JOIN users u ON u.id = h.user_id
+			WHERE u.tenant_id = $4 AND h.username_normalized = $1 AND h.user_id <> $2 AND h.reserved_until > $3
+		)
+	`, normalized, id, now, tenantID).Scan(&reserved)
+	if err != nil {
+		return fmt.Errorf("failed to check username reservations: %w", err)
+	}
+	if reserved {
+		return &DuplicateError{Field: "username"}
+	}
+
+	if err := d.update(ctx, id, version, updates, actor); err != nil {
+		return err
+	}
+
+	_, err = d.q.Exec(ctx, `
+		INSERT INTO username_history (user_id, username, username_normalized, changed_at, reserved_until)
+		VALUES ($1, $2, $3, $4, $5)
+	`, id, current, currentNormalized, now, now.Add(d.cfg.UsernameReservation))
+	if err != nil {
+		return fmt.Errorf("failed to record username change: %w", err)
+	}
+
+	return nil
+}
+
+// GetByUsername returns the user currently named username. Failing that, it
+// returns the user who most recently gave the name up, if the name is still
+// reserved for them, and reports that the name is a former one.
+func (d *UserDAO) GetByUsername(ctx context.Context, username string) (*models.User, bool, error) {
+	normalized := models.NormalizeUsername(username)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE username_normalized = $1 AND tenant_id = $2 AND status <> 'deleted'
+	`
+
+	user, err := d.scanUser(ctx, d.q.QueryRow(ctx, query, normalized, tenantID))
+	if err == nil {
+		return user, false, nil
+	}
+	if err != pgx.ErrNoRows {
+		return nil, false, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var userID uuid.UUID
+	err = d.q.QueryRow(ctx, `
+		SELECT h.user_id FROM username_history h JOIN users u ON u.id = h.user_id
+		WHERE u.tenant_id = $3 AND h.username_normalized = $1 AND h.reserved_until > $2
+		ORDER BY h.changed_at DESC
+		LIMIT 1
+	`, normalized, time.Now(), tenantID).Scan(&userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, false, fmt.Errorf("user not found")
+		}
+		return nil, false, fmt.Errorf("failed to get username history: %w", err)
+	}
+
+	user, err = d.GetByID(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// GetUsernameHistory returns the usernames a user has given up, newest first.
+func (d *UserDAO) GetUsernameHistory(ctx context.Context, id uuid.UUID) ([]*models.UsernameChange, error) {
+	query := `
+		SELECT h.user_id, h.username, h.changed_at, h.reserved_until
+		FROM username_history h
+		JOIN users u ON u.id = h.user_id
+		WHERE h.user_id = $1 AND u.tenant_id = $2
+		ORDER BY h.changed_at DESC
+	`
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.q.Query(ctx, query, id, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get username history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []*models.UsernameChange
+	for rows.Next() {
+		var change models.UsernameChange
+		if err := rows.Scan(&change.UserID, &change.Username, &change.ChangedAt, &change.ReservedUntil); err != nil {
+			return nil, fmt.Errorf("failed to scan username change: %w", err)
+		}
+		history = append(history, &change)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to iterate username history: %w", rows.Err())
+	}
+
+	return history, nil
+}
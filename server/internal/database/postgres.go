@@ -36,6 +36,11 @@ func NewPostgresConnection(cfg config.Database) (*PostgresDB, error) {
 	config.MaxConnLifetime = cfg.ConnMaxLifetime
 	config.MaxConnIdleTime = 30 * time.Minute
 
+	// Scope every connection to the request it serves, for row-level security
+	scopes := &sessionScopes{}
+	config.BeforeAcquire = scopes.acquire
+	config.BeforeClose = scopes.close
+
 	// Create connection pool
 	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
@@ -65,6 +65,59 @@ func (r *RedisDB) SetNX(ctx context.Context, key string, value interface{}, expi
 	return r.Client.SetNX(ctx, key, value, expiration).Result()
 }
 
+// deleteIfEqualScript deletes KEYS[1] only while it holds ARGV[1].
+var deleteIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// DeleteIfEqual deletes key if its value is value, checking and deleting
+// atomically, and reports whether it did.
+func (r *RedisDB) DeleteIfEqual(ctx context.Context, key, value string) (bool, error) {
+	deleted, err := deleteIfEqualScript.Run(ctx, r.Client, []string{key}, value).Int()
+	if err != nil {
+		return false, err
+	}
+	return deleted == 1, nil
+}
+
+// setIfEqualScript sets KEYS[2] to ARGV[2] for ARGV[3] milliseconds only
+// while KEYS[1] holds ARGV[1]; an empty ARGV[1] stands for a missing key.
+var setIfEqualScript = redis.NewScript(`
+if (redis.call("GET", KEYS[1]) or "") == ARGV[1] then
+	redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// SetIfEqual sets key to value if guard holds guardValue, or is missing and
+// guardValue is "", checking and setting atomically, and reports whether
+// it did.
+func (r *RedisDB) SetIfEqual(ctx context.Context, guard, guardValue, key string, value interface{}, expiration time.Duration) (bool, error) {
+	set, err := setIfEqualScript.Run(ctx, r.Client, []string{guard, key}, guardValue, value, expiration.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return set == 1, nil
+}
+
+// IncrWithExpiry increments key and sets its expiry in one transaction.
+func (r *RedisDB) IncrWithExpiry(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, expiration)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
 func (r *RedisDB) Incr(ctx context.Context, key string) (int64, error) {
 	return r.Client.Incr(ctx, key).Result()
 }
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// The session variables the row-level security policies read. They hold the
+// organization and authenticated user of the request a connection serves,
+// or are empty when it serves none.
+const (
+	TenantSetting = "app.tenant_id"
+	UserSetting   = "app.user_id"
+)
+
+// sessionScope is what a connection's session variables are set to.
+type sessionScope struct {
+	tenantID string
+	user     string
+}
+
+// sessionScopes remembers the scope of every pooled connection, so that
+// acquiring a connection only costs a round trip when its scope changes.
+type sessionScopes struct {
+	scopes sync.Map
+}
+
+// acquire sets conn's session variables to the organization and user ctx
+// acts for, clearing them when ctx has none, so that a connection never
+// keeps the scope of the request it served before. The variables are set
+// outside any transaction and survive until the next change. It reports
+// whether conn may be used; connections that could not be scoped are
+// discarded.
+func (s *sessionScopes) acquire(ctx context.Context, conn *pgx.Conn) bool {
+	var scope sessionScope
+	if id, ok := tenant.FromContext(ctx); ok {
+		scope.tenantID = id.String()
+	}
+	scope.user, _ = tenant.UserFromContext(ctx)
+
+	if current, ok := s.scopes.Load(conn); ok && current.(sessionScope) == scope {
+		return true
+	}
+
+	_, err := conn.Exec(ctx, `SELECT set_config($1, $2, false), set_config($3, $4, false)`,
+		TenantSetting, scope.tenantID, UserSetting, scope.user)
+	if err != nil {
+		s.scopes.Delete(conn)
+		return false
+	}
+
+	s.scopes.Store(conn, scope)
+	return true
+}
+
+func (s *sessionScopes) close(conn *pgx.Conn) {
+	s.scopes.Delete(conn)
+}
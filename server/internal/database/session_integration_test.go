@@ -0,0 +1,127 @@
+//go:build integration
+
+package database
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// testPool connects to the database named by TEST_DATABASE_URL with a pool
+// of a single connection, so that every query reuses it.
+func testPool(t *testing.T) *PostgresDB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("invalid TEST_DATABASE_URL: %v", err)
+	}
+	password, _ := u.User.Password()
+	cfg := config.Database{
+		Host:         u.Hostname(),
+		Port:         u.Port(),
+		User:         u.User.Username(),
+		Password:     password,
+		Name:         strings.TrimPrefix(u.Path, "/"),
+		SSLMode:      u.Query().Get("sslmode"),
+		MaxOpenConns: 1,
+	}
+	if cfg.Port == "" {
+		cfg.Port = "5432"
+	}
+	if cfg.SSLMode == "" {
+		cfg.SSLMode = "disable"
+	}
+
+	db, err := NewPostgresConnection(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// scopeOf returns the session variables of the connection a query as ctx
+// runs on.
+func scopeOf(t *testing.T, db *PostgresDB, ctx context.Context) sessionScope {
+	t.Helper()
+	var scope sessionScope
+	err := db.Pool.QueryRow(ctx, `SELECT current_setting($1, true), current_setting($2, true)`,
+		TenantSetting, UserSetting).Scan(&scope.tenantID, &scope.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scope
+}
+
+func TestSessionScopeFollowsContext(t *testing.T) {
+	db := testPool(t)
+	a, b := uuid.New(), uuid.New()
+	ctxA := tenant.WithUser(tenant.WithID(context.Background(), a), "ann")
+	ctxB := tenant.WithID(context.Background(), b)
+
+	// The pool has a single connection, which each step takes over from
+	// the one before
+	steps := []struct {
+		name string
+		ctx  context.Context
+		want sessionScope
+	}{
+		{"no organization", context.Background(), sessionScope{}},
+		{"A with a user", ctxA, sessionScope{a.String(), "ann"}},
+		{"A again", ctxA, sessionScope{a.String(), "ann"}},
+		{"B without a user", ctxB, sessionScope{b.String(), ""}},
+		{"no organization after B", context.Background(), sessionScope{}},
+		{"A after none", ctxA, sessionScope{a.String(), "ann"}},
+		{"only a user", tenant.WithUser(context.Background(), "bob"), sessionScope{"", "bob"}},
+	}
+	for _, step := range steps {
+		if got := scopeOf(t, db, step.ctx); got != step.want {
+			t.Errorf("%s: session scope = %+v, want %+v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestSessionScopeInTransaction(t *testing.T) {
+	db := testPool(t)
+	a := uuid.New()
+	ctxA := tenant.WithID(context.Background(), a)
+
+	// Scope the connection to no organization first, so that beginning the
+	// transaction has to change it
+	scopeOf(t, db, context.Background())
+
+	tx, err := db.Pool.Begin(ctxA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tenantID string
+	if err := tx.QueryRow(ctxA, `SELECT current_setting($1, true)`, TenantSetting).Scan(&tenantID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(ctxA); err != nil {
+		t.Fatal(err)
+	}
+	if tenantID != a.String() {
+		t.Errorf("transaction runs as %q, want %s", tenantID, a)
+	}
+
+	// Rolling back does not undo the scope, which was set outside of it
+	if got := scopeOf(t, db, ctxA); got.tenantID != a.String() {
+		t.Errorf("session scope after rollback = %+v", got)
+	}
+	if got := scopeOf(t, db, context.Background()); got != (sessionScope{}) {
+		t.Errorf("session scope after the transaction = %+v, want none", got)
+	}
+}
@@ -0,0 +1,201 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/spurge/p4rsec/server/internal/config"
+)
+
+// KeySize is the length in bytes of data keys, key-encryption keys and the
+// blind index key.
+const KeySize = 32
+
+// sealedPrefix marks the format of sealed values so that it can change later.
+const sealedPrefix = "v1:"
+
+// dataKeyCacheSize bounds how many unwrapped data keys a Cipher keeps, so
+// that reading the same rows again does not go back to the key provider.
+const dataKeyCacheSize = 10000
+
+// ErrUnknownKey is returned when data was encrypted under a key the provider
+// does not have.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyProvider holds key-encryption keys and uses them to wrap and unwrap data
+// keys, the way a KMS does. Keys are named by ID; data keys are always
+// wrapped with the primary key, but any key the provider still has can
+// unwrap.
+type KeyProvider interface {
+	PrimaryKeyID() string
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Cipher encrypts record fields with envelope encryption: each record gets
+// its own data key, stored wrapped by the provider next to the ciphertext.
+// It also computes blind indexes, keyed hashes that allow exact-match lookups
+// on encrypted fields.
+type Cipher struct {
+	provider KeyProvider
+	indexKey []byte
+
+	mu       sync.Mutex
+	dataKeys map[string]cipher.AEAD
+}
+
+// New returns a Cipher using the keyring file named in cfg.
+func New(cfg config.Encryption) (*Cipher, error) {
+	if cfg.KeyringFile == "" {
+		return nil, fmt.Errorf("encryption.keyring_file is not set")
+	}
+
+	keyring, err := LoadKeyring(cfg.KeyringFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewCipher(keyring, keyring.IndexKey())
+}
+
+func NewCipher(provider KeyProvider, indexKey []byte) (*Cipher, error) {
+	if len(indexKey) != KeySize {
+		return nil, fmt.Errorf("blind index key must be %d bytes", KeySize)
+	}
+	return &Cipher{
+		provider: provider,
+		indexKey: indexKey,
+		dataKeys: make(map[string]cipher.AEAD),
+	}, nil
+}
+
+// PrimaryKeyID names the key new envelopes are wrapped with.
+func (c *Cipher) PrimaryKeyID() string {
+	return c.provider.PrimaryKeyID()
+}
+
+// BlindIndex returns the keyed hash of value for the named field. Equal
+// values give equal indexes, so values must be normalized first.
+func (c *Cipher) BlindIndex(field, value string) []byte {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// Envelope is a data key together with its wrapped form and the ID of the key
+// that wrapped it. The wrapped form and key ID are stored with the record.
+type Envelope struct {
+	KeyID      string
+	WrappedKey []byte
+	aead       cipher.AEAD
+}
+
+// NewEnvelope generates a data key and wraps it with the primary key.
+func (c *Cipher) NewEnvelope(ctx context.Context) (*Envelope, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	keyID := c.provider.PrimaryKeyID()
+	wrapped, err := c.provider.WrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{KeyID: keyID, WrappedKey: wrapped, aead: aead}, nil
+}
+
+// OpenEnvelope unwraps a stored data key.
+func (c *Cipher) OpenEnvelope(ctx context.Context, keyID string, wrapped []byte) (*Envelope, error) {
+	cacheKey := keyID + ":" + string(wrapped)
+
+	c.mu.Lock()
+	aead, ok := c.dataKeys[cacheKey]
+	c.mu.Unlock()
+
+	if !ok {
+		dataKey, err := c.provider.UnwrapKey(ctx, keyID, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+		}
+		if aead, err = newAEAD(dataKey); err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		if len(c.dataKeys) >= dataKeyCacheSize {
+			c.dataKeys = make(map[string]cipher.AEAD)
+		}
+		c.dataKeys[cacheKey] = aead
+		c.mu.Unlock()
+	}
+
+	return &Envelope{KeyID: keyID, WrappedKey: wrapped, aead: aead}, nil
+}
+
+// Seal encrypts the value of the named field. The field name is
+// authenticated, so a sealed value cannot be moved to another field.
+func (e *Envelope) Seal(field, plaintext string) (string, error) {
+	sealed, err := seal(e.aead, []byte(plaintext), []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed for the named field.
+func (e *Envelope) Open(field, sealed string) (string, error) {
+	if !strings.HasPrefix(sealed, sealedPrefix) {
+		return "", fmt.Errorf("%s is not sealed", field)
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("%s is not sealed: %w", field, err)
+	}
+
+	plaintext, err := open(e.aead, data, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", field, err)
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with AES-GCM, prefixing the random nonce.
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
@@ -0,0 +1,268 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeKeyring writes a keyring file with the named keys and returns its
+// path.
+func writeKeyring(t *testing.T, primary string, keys map[string][]byte, indexKey []byte) string {
+	t.Helper()
+	file := keyringFile{Primary: primary, Keys: make(map[string]string), IndexKey: base64.StdEncoding.EncodeToString(indexKey)}
+	for id, key := range keys {
+		file.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testCipher(t *testing.T, primary string, keys map[string][]byte, indexKey []byte) *Cipher {
+	t.Helper()
+	keyring, err := LoadKeyring(writeKeyring(t, primary, keys, indexKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCipher(keyring, keyring.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	keys := map[string][]byte{"2024-06": randomKey(t)}
+	c := testCipher(t, "2024-06", keys, randomKey(t))
+
+	envelope, err := c.NewEnvelope(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.KeyID != "2024-06" {
+		t.Errorf("key ID = %q, want the primary", envelope.KeyID)
+	}
+
+	for _, plaintext := range []string{"ann@example.com", "", "Åsa Öberg ✓"} {
+		sealed, err := envelope.Seal("email", plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext != "" && strings.Contains(sealed, plaintext) {
+			t.Errorf("sealed value %q contains the plaintext", sealed)
+		}
+		if !strings.HasPrefix(sealed, sealedPrefix) {
+			t.Errorf("sealed value %q lacks the %s prefix", sealed, sealedPrefix)
+		}
+
+		// A Cipher without the data key cached opens it from its stored form
+		other := testCipher(t, "2024-06", keys, randomKey(t))
+		opened, err := other.OpenEnvelope(ctx, envelope.KeyID, envelope.WrappedKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := opened.Open("email", sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != plaintext {
+			t.Errorf("Open = %q, want %q", got, plaintext)
+		}
+	}
+}
+
+func TestSealIsRandomized(t *testing.T) {
+	c := testCipher(t, "k", map[string][]byte{"k": randomKey(t)}, randomKey(t))
+	envelope, err := c.NewEnvelope(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := envelope.Seal("email", "ann@example.com")
+	b, _ := envelope.Seal("email", "ann@example.com")
+	if a == b {
+		t.Error("sealing the same value twice gives the same ciphertext")
+	}
+
+	other, err := c.NewEnvelope(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(envelope.WrappedKey, other.WrappedKey) {
+		t.Error("two envelopes share a data key")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	ctx := context.Background()
+	c := testCipher(t, "k", map[string][]byte{"k": randomKey(t)}, randomKey(t))
+	envelope, err := c.NewEnvelope(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := c.NewEnvelope(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := envelope.Seal("email", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	raw[len(raw)-1] ^= 1
+	tampered := sealedPrefix + base64.RawStdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name     string
+		envelope *Envelope
+		field    string
+		sealed   string
+	}{
+		{"another field", envelope, "first_name", sealed},
+		{"another data key", other, "email", sealed},
+		{"tampered ciphertext", envelope, "email", tampered},
+		{"plaintext", envelope, "email", "ann@example.com"},
+		{"invalid base64", envelope, "email", sealedPrefix + "!!"},
+		{"too short", envelope, "email", sealedPrefix + "AAAA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.envelope.Open(tt.field, tt.sealed); err == nil {
+				t.Errorf("Open = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestEnvelopeAfterRotation(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey, indexKey := randomKey(t), randomKey(t), randomKey(t)
+
+	before := testCipher(t, "old", map[string][]byte{"old": oldKey}, indexKey)
+	envelope, err := before.NewEnvelope(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := envelope.Seal("email", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the new key is primary, the old one still unwraps
+	after := testCipher(t, "new", map[string][]byte{"old": oldKey, "new": newKey}, indexKey)
+	if after.PrimaryKeyID() != "new" {
+		t.Errorf("primary = %q, want new", after.PrimaryKeyID())
+	}
+	opened, err := after.OpenEnvelope(ctx, "old", envelope.WrappedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := opened.Open("email", sealed); err != nil || got != "ann@example.com" {
+		t.Errorf("Open = %q, %v", got, err)
+	}
+	fresh, err := after.NewEnvelope(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.KeyID != "new" {
+		t.Errorf("new envelope key ID = %q, want new", fresh.KeyID)
+	}
+
+	// A wrapped key only opens under the ID it was stored with
+	if _, err := after.OpenEnvelope(ctx, "new", envelope.WrappedKey); err == nil {
+		t.Error("a data key wrapped by old opened under new")
+	}
+
+	// And not at all once the old key is removed
+	removed := testCipher(t, "new", map[string][]byte{"new": newKey}, indexKey)
+	if _, err := removed.OpenEnvelope(ctx, "old", envelope.WrappedKey); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	indexKey := randomKey(t)
+	c := testCipher(t, "k", map[string][]byte{"k": randomKey(t)}, indexKey)
+
+	a := c.BlindIndex("email", "ann@example.com")
+	if len(a) != 32 {
+		t.Errorf("index is %d bytes, want 32", len(a))
+	}
+	if !bytes.Equal(a, c.BlindIndex("email", "ann@example.com")) {
+		t.Error("equal values give different indexes")
+	}
+	if bytes.Equal(a, c.BlindIndex("email", "Ann@example.com")) {
+		t.Error("different values give the same index")
+	}
+	if bytes.Equal(a, c.BlindIndex("username", "ann@example.com")) {
+		t.Error("the same value in different fields gives the same index")
+	}
+	// The field and value are separated, so they cannot run into each other
+	if bytes.Equal(c.BlindIndex("ab", "c"), c.BlindIndex("a", "bc")) {
+		t.Error("field and value are not separated")
+	}
+
+	// The index only depends on the index key, not the data keys
+	rotated := testCipher(t, "other", map[string][]byte{"other": randomKey(t)}, indexKey)
+	if !bytes.Equal(a, rotated.BlindIndex("email", "ann@example.com")) {
+		t.Error("the index changed with the key-encryption key")
+	}
+	other := testCipher(t, "k", map[string][]byte{"k": randomKey(t)}, randomKey(t))
+	if bytes.Equal(a, other.BlindIndex("email", "ann@example.com")) {
+		t.Error("different index keys give the same index")
+	}
+}
+
+func TestNewCipherRejectsShortIndexKey(t *testing.T) {
+	keyring, err := LoadKeyring(writeKeyring(t, "k", map[string][]byte{"k": randomKey(t)}, randomKey(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCipher(keyring, make([]byte, 16)); err == nil {
+		t.Error("NewCipher accepted a 16-byte index key")
+	}
+}
+
+func TestLoadKeyringRejects(t *testing.T) {
+	key := randomKey(t)
+	tests := []struct {
+		name     string
+		primary  string
+		keys     map[string][]byte
+		indexKey []byte
+	}{
+		{"missing primary", "other", map[string][]byte{"k": key}, key},
+		{"short key", "k", map[string][]byte{"k": key[:16]}, key},
+		{"short index key", "k", map[string][]byte{"k": key}, key[:16]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadKeyring(writeKeyring(t, tt.primary, tt.keys, tt.indexKey)); err == nil {
+				t.Error("LoadKeyring succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Keyring is a KeyProvider backed by a local JSON file:
+//
+//	{
+//	  "primary": "2024-06",
+//	  "keys": {"2024-06": "<base64>", "2023-11": "<base64>"},
+//	  "index_key": "<base64>"
+//	}
+//
+// Every key is 32 random bytes, base64-encoded. Keys that are no longer
+// primary stay in the file until no data is wrapped with them.
+type Keyring struct {
+	primary  string
+	keys     map[string]cipher.AEAD
+	indexKey []byte
+}
+
+type keyringFile struct {
+	Primary  string            `json:"primary"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+// LoadKeyring reads and checks a keyring file.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	keyring := &Keyring{primary: file.Primary, keys: make(map[string]cipher.AEAD, len(file.Keys))}
+	for id, encoded := range file.Keys {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("keyring key %q: %w", id, err)
+		}
+		if keyring.keys[id], err = newAEAD(key); err != nil {
+			return nil, fmt.Errorf("keyring key %q: %w", id, err)
+		}
+	}
+	if _, ok := keyring.keys[file.Primary]; !ok {
+		return nil, fmt.Errorf("keyring primary key %q is not in keys", file.Primary)
+	}
+
+	if keyring.indexKey, err = decodeKey(file.IndexKey); err != nil {
+		return nil, fmt.Errorf("keyring index_key: %w", err)
+	}
+
+	return keyring, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primary
+}
+
+// IndexKey is the key blind indexes are computed with. It is not rotated:
+// changing it would require recomputing every index at once.
+func (k *Keyring) IndexKey() []byte {
+	return k.indexKey
+}
+
+// WrapKey encrypts dataKey with the key named keyID. The key ID is
+// authenticated, so a wrapped key only opens under the ID it was stored with.
+func (k *Keyring) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	return seal(aead, dataKey, []byte(keyID))
+}
+
+func (k *Keyring) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
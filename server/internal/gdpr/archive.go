@@ -0,0 +1,222 @@
+package gdpr
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/avatar"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/storage"
+)
+
+// ArchiveVersion is bumped whenever the layout of archives changes.
+const ArchiveVersion = 1
+
+// Archiver assembles everything stored about a user into a zip archive of
+// JSON files, along with the user's avatar.
+type Archiver struct {
+	userDAO  *dao.UserDAO
+	auditDAO *dao.AuditDAO
+	cacheDAO *dao.CacheDAO
+	storage  storage.Storage
+}
+
+func NewArchiver(userDAO *dao.UserDAO, auditDAO *dao.AuditDAO, cacheDAO *dao.CacheDAO, store storage.Storage) *Archiver {
+	return &Archiver{
+		userDAO:  userDAO,
+		auditDAO: auditDAO,
+		cacheDAO: cacheDAO,
+		storage:  store,
+	}
+}
+
+// Archive is the data collected for one user, ready to be written.
+type Archive struct {
+	archiver    *Archiver
+	data        *dao.PersonalData
+	sessions    []dao.UserSession
+	generatedAt time.Time
+}
+
+// Collect reads the user's data and records the export in the audit log. The
+// audit events and avatar are only read when the archive is written, so that
+// they are streamed rather than held in memory.
+func (a *Archiver) Collect(ctx context.Context, id uuid.UUID, actor string) (*Archive, error) {
+	data, err := a.userDAO.PersonalData(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := a.cacheDAO.GetUserSessions(ctx, id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.auditDAO.Record(ctx, audit.ActionUserDataExport, actor, "user", id.String(), nil); err != nil {
+		return nil, err
+	}
+
+	return &Archive{
+		archiver:    a,
+		data:        data,
+		sessions:    sessions,
+		generatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// FileName is the name the archive is downloaded under.
+func (ar *Archive) FileName() string {
+	return fmt.Sprintf("user-%s.zip", ar.data.User.ID)
+}
+
+// Write writes the archive to w.
+func (ar *Archive) Write(ctx context.Context, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	// Empty lists are written as [] rather than null
+	usernameHistory := ar.data.UsernameHistory
+	if usernameHistory == nil {
+		usernameHistory = []*models.UsernameChange{}
+	}
+	history := ar.data.History
+	if history == nil {
+		history = []*models.UserChange{}
+	}
+
+	userID := ar.data.User.ID.String()
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{"manifest.json", map[string]interface{}{
+			"version":      ArchiveVersion,
+			"user_id":      userID,
+			"generated_at": ar.generatedAt,
+		}},
+		{"profile.json", ar.data.User},
+		{"preferences.json", map[string]interface{}{
+			"overrides": ar.data.Preferences,
+			"effective": ar.data.Preferences.Apply(),
+		}},
+		{"username_history.json", usernameHistory},
+		{"history.json", history},
+		{"sessions.json", ar.sessions},
+	}
+
+	for _, f := range files {
+		if err := writeJSON(zw, f.name, ar.generatedAt, f.value); err != nil {
+			return err
+		}
+	}
+
+	if err := ar.writeAuditEvents(ctx, zw, userID); err != nil {
+		return err
+	}
+
+	if err := ar.writeAvatar(ctx, zw); err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return nil
+}
+
+// writeAuditEvents writes the events about the user or made by it as a JSON
+// array. The changes of events about anyone else are left out, as they are
+// that person's data.
+func (ar *Archive) writeAuditEvents(ctx context.Context, zw *zip.Writer, userID string) error {
+	f, err := create(zw, "audit_events.json", ar.generatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(f, "["); err != nil {
+		return fmt.Errorf("failed to write audit events: %w", err)
+	}
+
+	first := true
+	err = ar.archiver.auditDAO.ForEachUserEvent(ctx, userID, func(e *models.AuditEvent) error {
+		if e.TargetType != "user" || e.TargetID != userID {
+			e.Changes = nil
+		}
+
+		encoded, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit event: %w", err)
+		}
+		if !first {
+			encoded = append([]byte(","), encoded...)
+		}
+		first = false
+
+		if _, err := f.Write(encoded); err != nil {
+			return fmt.Errorf("failed to write audit events: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(f, "]"); err != nil {
+		return fmt.Errorf("failed to write audit events: %w", err)
+	}
+	return nil
+}
+
+// writeAvatar adds the largest size of the user's avatar, if it has one.
+func (ar *Archive) writeAvatar(ctx context.Context, zw *zip.Writer) error {
+	if ar.data.User.AvatarKey == nil {
+		return nil
+	}
+
+	body, _, err := ar.archiver.storage.Open(ctx, avatar.ObjectKey(*ar.data.User.AvatarKey, avatar.Sizes[len(avatar.Sizes)-1]))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to open avatar: %w", err)
+	}
+	defer body.Close()
+
+	f, err := create(zw, "avatar.jpg", ar.generatedAt)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		return fmt.Errorf("failed to write avatar: %w", err)
+	}
+	return nil
+}
+
+func create(zw *zip.Writer, name string, modified time.Time) (io.Writer, error) {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add %s to archive: %w", name, err)
+	}
+	return f, nil
+}
+
+func writeJSON(zw *zip.Writer, name string, modified time.Time, value interface{}) error {
+	f, err := create(zw, name, modified)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(value); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package gdpr
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/avatar"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/storage"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+// ErasureJob is an asynchronous erasure of one user's personal data. Its
+// state lives in Redis.
+type ErasureJob struct {
+	ID                 uuid.UUID  `json:"id"`
+	UserID             uuid.UUID  `json:"user_id"`
+	Status             JobStatus  `json:"status"`
+	RequestedBy        *string    `json:"requested_by,omitempty"`
+	RedactedEvents     int64      `json:"redacted_events"`
+	UnredactableEvents int64      `json:"unredactable_events"`
+	RevokedSessions    int        `json:"revoked_sessions"`
+	Error              string     `json:"error,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	FinishedAt         *time.Time `json:"finished_at,omitempty"`
+}
+
+// Eraser runs erasure jobs in the background. The database is anonymized in
+// one transaction; the avatar, sessions and cached copies are removed after
+// it commits.
+type Eraser struct {
+	userDAO  *dao.UserDAO
+	cacheDAO *dao.CacheDAO
+	storage  storage.Storage
+	logger   *logger.Logger
+}
+
+func NewEraser(userDAO *dao.UserDAO, cacheDAO *dao.CacheDAO, store storage.Storage, logger *logger.Logger) *Eraser {
+	return &Eraser{
+		userDAO:  userDAO,
+		cacheDAO: cacheDAO,
+		storage:  store,
+		logger:   logger,
+	}
+}
+
+// Start records a new job and runs it in the background. It fails with
+// "user not found" if the user does not exist.
+func (e *Eraser) Start(ctx context.Context, userID uuid.UUID, actor string) (*ErasureJob, error) {
+	exists, err := e.userDAO.Exists(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	job := &ErasureJob{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Status:    JobPending,
+		CreatedAt: time.Now(),
+	}
+	if actor != "" {
+		job.RequestedBy = &actor
+	}
+	if err := e.save(ctx, job); err != nil {
+		return nil, err
+	}
+
+	// The job outlives the request but is audited as part of it, within the
+	// same organization
+	go e.run(audit.WithMetadata(tenant.Detach(ctx), audit.MetadataFrom(ctx)), job)
+
+	return job, nil
+}
+
+func (e *Eraser) Get(ctx context.Context, id uuid.UUID) (*ErasureJob, error) {
+	var job ErasureJob
+	if err := e.cacheDAO.GetErasureJob(ctx, id.String(), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (e *Eraser) run(ctx context.Context, job *ErasureJob) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	job.Status = JobRunning
+	if err := e.save(ctx, job); err != nil {
+		e.logger.Warn("Failed to update erasure job", "error", err, "job_id", job.ID)
+	}
+
+	err := e.erase(ctx, job)
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+		e.logger.Error("Erasure job failed", "error", err, "job_id", job.ID, "user_id", job.UserID)
+	} else {
+		job.Status = JobCompleted
+		e.logger.Info("Erasure job completed", "job_id", job.ID, "user_id", job.UserID,
+			"redacted_events", job.RedactedEvents, "unredactable_events", job.UnredactableEvents)
+	}
+
+	if err := e.save(ctx, job); err != nil {
+		e.logger.Error("Failed to update erasure job", "error", err, "job_id", job.ID)
+	}
+}
+
+// erase anonymizes the user and then removes what lives outside the
+// database. Once the database is anonymized nothing refers to the avatar any
+// more, so a failure to delete it is reported with its key for cleanup.
+func (e *Eraser) erase(ctx context.Context, job *ErasureJob) error {
+	actor := ""
+	if job.RequestedBy != nil {
+		actor = *job.RequestedBy
+	}
+
+	erasure, err := e.userDAO.Erase(ctx, job.UserID, actor)
+	if err != nil {
+		return err
+	}
+	job.RedactedEvents = erasure.RedactedEvents
+	job.UnredactableEvents = erasure.UnredactableEvents
+
+	userID := job.UserID.String()
+	if err := e.cacheDAO.DeleteUser(ctx, userID); err != nil {
+		e.logger.Warn("Failed to invalidate user cache", "error", err, "user_id", userID)
+	}
+	if err := e.cacheDAO.DeletePreferences(ctx, userID); err != nil {
+		e.logger.Warn("Failed to invalidate preferences cache", "error", err, "user_id", userID)
+	}
+	if err := e.cacheDAO.InvalidateUsersList(ctx); err != nil {
+		e.logger.Warn("Failed to invalidate users list cache", "error", err)
+	}
+
+	if job.RevokedSessions, err = e.cacheDAO.DeleteUserSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	if key := erasure.PreviousAvatarKey; key != nil {
+		for _, size := range avatar.Sizes {
+			if err := e.storage.Delete(ctx, avatar.ObjectKey(*key, size)); err != nil {
+				return fmt.Errorf("failed to delete avatar %s: %w", *key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *Eraser) save(ctx context.Context, job *ErasureJob) error {
+	return e.cacheDAO.SetErasureJob(ctx, job.ID.String(), job)
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// requestActor returns the authenticated user making the request, to be
+// recorded in user history, status changes and the audit log. It is empty
+// for unauthenticated requests.
+func requestActor(c *fiber.Ctx) string {
+	user, _ := tenant.UserFromContext(c.UserContext())
+	return user
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+func TestRequestActor(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		if sub := c.Query("sub"); sub != "" {
+			c.SetUserContext(tenant.WithUser(c.UserContext(), sub))
+		}
+		return c.SendString(requestActor(c))
+	})
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"authenticated", "/?sub=user-1", "user-1"},
+		{"unauthenticated", "/", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodGet, tt.url, nil)
+			req.Header.Set("X-Actor-ID", "spoofed")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := make([]byte, 64)
+			n, _ := resp.Body.Read(body)
+			if got := string(body[:n]); got != tt.want {
+				t.Errorf("actor = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
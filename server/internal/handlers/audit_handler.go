@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+type AuditHandler struct {
+	auditDAO *dao.AuditDAO
+	logger   *logger.Logger
+}
+
+func NewAuditHandler(auditDAO *dao.AuditDAO, logger *logger.Logger) *AuditHandler {
+	return &AuditHandler{
+		auditDAO: auditDAO,
+		logger:   logger,
+	}
+}
+
+// GetAuditEvents lists audit events, newest first, filtered by action,
+// actor, target_type, target_id, request_id, and from and to (RFC 3339).
+func (h *AuditHandler) GetAuditEvents(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	events, err := h.auditDAO.GetEvents(ctx, filter, limit, (page-1)*limit)
+	if err != nil {
+		h.logger.Error("Failed to get audit events", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve audit events",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"events": events,
+		"page":   page,
+		"limit":  limit,
+	})
+}
+
+func parseAuditFilter(c *fiber.Ctx) (models.AuditFilter, error) {
+	filter := models.AuditFilter{
+		Action:     c.Query("action"),
+		Actor:      c.Query("actor"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		RequestID:  c.Query("request_id"),
+	}
+
+	for param, dst := range map[string]**time.Time{
+		"from": &filter.From,
+		"to":   &filter.To,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+		}
+		*dst = &t
+	}
+
+	return filter, nil
+}
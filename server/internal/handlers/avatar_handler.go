@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/avatar"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/storage"
+)
+
+// avatarFormField is the multipart field an avatar is uploaded in.
+const avatarFormField = "avatar"
+
+// avatarRoute is where avatars are served from when storage has no public URL.
+const avatarRoute = "/api/v1/avatars/"
+
+type AvatarHandler struct {
+	userDAO   *dao.UserDAO
+	cacheDAO  *dao.CacheDAO
+	storage   storage.Storage
+	logger    *logger.Logger
+	cfg       config.Avatars
+	publicURL string
+}
+
+func NewAvatarHandler(userDAO *dao.UserDAO, cacheDAO *dao.CacheDAO, store storage.Storage, logger *logger.Logger, cfg config.Avatars, publicURL string) *AvatarHandler {
+	return &AvatarHandler{
+		userDAO:   userDAO,
+		cacheDAO:  cacheDAO,
+		storage:   store,
+		logger:    logger,
+		cfg:       cfg,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+	}
+}
+
+// UploadAvatar replaces the user's avatar with the image in the "avatar"
+// form field. The image is cropped to a square and stored as a JPEG
+// thumbnail in every size in avatar.Sizes.
+func (h *AvatarHandler) UploadAvatar(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 30*time.Second)
+	defer cancel()
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	// Refuse oversized uploads before reading them, leaving room for the
+	// multipart framing around the file
+	if length := c.Request().Header.ContentLength(); length > 0 && int64(length) > h.cfg.MaxBytes+64*1024 {
+		return h.tooLarge(c)
+	}
+
+	file, err := c.FormFile(avatarFormField)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": fmt.Sprintf("Avatar must be uploaded as multipart/form-data in the %q field", avatarFormField),
+		})
+	}
+	if file.Size > h.cfg.MaxBytes {
+		return h.tooLarge(c)
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		h.logger.Error("Failed to open avatar upload", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to upload avatar",
+		})
+	}
+	data, err := io.ReadAll(io.LimitReader(f, h.cfg.MaxBytes+1))
+	f.Close()
+	if err != nil {
+		h.logger.Error("Failed to read avatar upload", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to upload avatar",
+		})
+	}
+	if int64(len(data)) > h.cfg.MaxBytes {
+		return h.tooLarge(c)
+	}
+
+	processed, err := avatar.Process(data, h.cfg.MaxPixels)
+	if err != nil {
+		if errors.Is(err, avatar.ErrUnsupportedFormat) {
+			return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+				"error":   true,
+				"message": err.Error(),
+			})
+		}
+		if errors.Is(err, avatar.ErrInvalidImage) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error":   true,
+				"message": err.Error(),
+			})
+		}
+		h.logger.Error("Failed to process avatar", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to upload avatar",
+		})
+	}
+
+	// Thumbnails live under a prefix named after their content, so their URLs
+	// never serve stale images and can be cached indefinitely
+	key := fmt.Sprintf("avatars/%s/%s", userID, processed.Hash)
+	for _, size := range avatar.Sizes {
+		if err := h.storage.Put(ctx, avatar.ObjectKey(key, size), processed.Thumbnails[size], avatar.ContentType); err != nil {
+			h.logger.Error("Failed to store avatar", "error", err, "user_id", userID)
+			h.deleteAvatar(ctx, key)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   true,
+				"message": "Failed to upload avatar",
+			})
+		}
+	}
+
+	url := h.avatarURL(key, avatar.DefaultSize)
+	user, previous, err := h.userDAO.SetAvatar(ctx, userID, &key, &url, requestActor(c))
+	if err != nil {
+		h.deleteAvatar(ctx, key)
+		return h.avatarError(c, err, userID, "Failed to upload avatar")
+	}
+	if previous != nil && *previous != key {
+		h.deleteAvatar(ctx, *previous)
+	}
+
+	h.invalidateUser(ctx, userID)
+
+	h.logger.Info("Avatar uploaded successfully", "user_id", userID, "key", key)
+
+	c.Set(fiber.HeaderETag, userETag(user))
+	return c.JSON(fiber.Map{
+		"user": user,
+	})
+}
+
+// DeleteAvatar removes the user's avatar and its thumbnails.
+func (h *AvatarHandler) DeleteAvatar(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	user, previous, err := h.userDAO.SetAvatar(ctx, userID, nil, nil, requestActor(c))
+	if err != nil {
+		return h.avatarError(c, err, userID, "Failed to delete avatar")
+	}
+	if previous != nil {
+		h.deleteAvatar(ctx, *previous)
+	}
+
+	h.invalidateUser(ctx, userID)
+
+	h.logger.Info("Avatar deleted successfully", "user_id", userID)
+
+	c.Set(fiber.HeaderETag, userETag(user))
+	return c.JSON(fiber.Map{
+		"user": user,
+	})
+}
+
+// ServeAvatar streams a stored thumbnail. It is only used when storage has no
+// public URL of its own.
+func (h *AvatarHandler) ServeAvatar(c *fiber.Ctx) error {
+	key := "avatars/" + c.Params("*")
+	if !storage.ValidKey(key) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Avatar not found",
+		})
+	}
+
+	// The body is streamed after the handler returns, so the read owns its
+	// context
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	body, contentType, err := h.storage.Open(ctx, key)
+	if err != nil {
+		cancel()
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "Avatar not found",
+			})
+		}
+		h.logger.Error("Failed to open avatar", "error", err, "key", key)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve avatar",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+	return c.SendStream(&cancelOnClose{ReadCloser: body, cancel: cancel})
+}
+
+// avatarURL returns the URL of the thumbnail of size under key.
+func (h *AvatarHandler) avatarURL(key string, size int) string {
+	objectKey := avatar.ObjectKey(key, size)
+	if h.publicURL != "" {
+		return h.publicURL + "/" + objectKey
+	}
+	return avatarRoute + strings.TrimPrefix(objectKey, "avatars/")
+}
+
+// deleteAvatar removes every thumbnail under key. Failures only leave
+// unreferenced files behind, so they are logged rather than returned.
+func (h *AvatarHandler) deleteAvatar(ctx context.Context, key string) {
+	for _, size := range avatar.Sizes {
+		if err := h.storage.Delete(ctx, avatar.ObjectKey(key, size)); err != nil {
+			h.logger.Warn("Failed to delete avatar", "error", err, "key", key)
+		}
+	}
+}
+
+func (h *AvatarHandler) invalidateUser(ctx context.Context, userID uuid.UUID) {
+	if err := h.cacheDAO.DeleteUser(ctx, userID.String()); err != nil {
+		h.logger.Warn("Failed to invalidate user cache", "error", err, "user_id", userID)
+	}
+	if err := h.cacheDAO.InvalidateUsersList(ctx); err != nil {
+		h.logger.Warn("Failed to invalidate users list cache", "error", err)
+	}
+}
+
+func (h *AvatarHandler) tooLarge(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+		"error":   true,
+		"message": fmt.Sprintf("Avatar must be at most %d bytes", h.cfg.MaxBytes),
+	})
+}
+
+func (h *AvatarHandler) avatarError(c *fiber.Ctx, err error, userID uuid.UUID, message string) error {
+	if err.Error() == "user not found" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "User not found",
+		})
+	}
+	h.logger.Error(message, "error", err, "user_id", userID)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   true,
+		"message": message,
+	})
+}
+
+// cancelOnClose releases a context once the reader using it is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnClose) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// userETag returns a strong entity tag for a single user. It is derived from
+// the user ID and the row version, which every write increments.
+func userETag(user *models.User) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", user.ID, user.Version)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// usersETag returns a strong entity tag for a page of users.
+func usersETag(users []*models.User, page, limit int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%d", page, limit)
+	for _, user := range users {
+		fmt.Fprintf(h, "|%s:%d", user.ID, user.Version)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether etag is listed in an If-Match or If-None-Match
+// header value. A wildcard matches any current representation. If-None-Match
+// compares weakly, ignoring W/ prefixes that proxies and compression add;
+// If-Match compares strongly, so a weak candidate never matches.
+func etagMatches(header, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag header and reports whether the request's
+// If-None-Match header allows a 304 response.
+func notModified(c *fiber.Ctx, etag string) bool {
+	c.Set(fiber.HeaderETag, etag)
+	header := c.Get(fiber.HeaderIfNoneMatch)
+	return header != "" && etagMatches(header, etag, true)
+}
+
+// checkIfMatch enforces the If-Match precondition for a write against the
+// current representation. It returns a non-nil error only after a response
+// has been written.
+func checkIfMatch(c *fiber.Ctx, current *models.User) (bool, error) {
+	header := c.Get(fiber.HeaderIfMatch)
+	if header == "" {
+		return false, c.Status(fiber.StatusPreconditionRequired).JSON(fiber.Map{
+			"error":   true,
+			"message": "If-Match header is required",
+		})
+	}
+
+	if !etagMatches(header, userETag(current), false) {
+		c.Set(fiber.HeaderETag, userETag(current))
+		return false, c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+			"error":   true,
+			"message": "User has been modified since it was retrieved",
+		})
+	}
+
+	return true, nil
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"abc"`
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{`"abc"`, true, true},
+		{`W/"abc"`, true, true},
+		{`"xyz", W/"abc"`, true, true},
+		{`*`, true, true},
+		{`"xyz"`, true, false},
+		{`W/"xyz"`, true, false},
+		{`"abc"`, false, true},
+		{`"xyz" , "abc"`, false, true},
+		{`*`, false, true},
+		{`W/"abc"`, false, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag, tt.weak); got != tt.want {
+			t.Errorf("etagMatches(%s, weak=%v) = %v, want %v", tt.header, tt.weak, got, tt.want)
+		}
+	}
+	if !etagMatches(`"abc"`, `W/"abc"`, true) {
+		t.Error("a weak current tag does not match weakly")
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+	"github.com/spurge/p4rsec/server/internal/userexport"
+)
+
+type ExportHandler struct {
+	userDAO  *dao.UserDAO
+	exporter *userexport.Exporter
+	logger   *logger.Logger
+}
+
+func NewExportHandler(userDAO *dao.UserDAO, exporter *userexport.Exporter, logger *logger.Logger) *ExportHandler {
+	return &ExportHandler{
+		userDAO:  userDAO,
+		exporter: exporter,
+		logger:   logger,
+	}
+}
+
+// ExportUsers streams every matching user straight into the response.
+func (h *ExportHandler) ExportUsers(c *fiber.Ctx) error {
+	format, err := userexport.ParseFormat(c.Query("format"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	filter, err := parseUserFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, format.ContentType())
+	c.Attachment("users." + format.Extension())
+
+	// The writer runs after the handler returns, so it owns its context
+	background := tenant.Detach(c.UserContext())
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(background, 30*time.Minute)
+		defer cancel()
+
+		// The 200 is already sent, so a failure is only visible to the
+		// client as the error marker Export ends the body with
+		rows, err := userexport.Export(ctx, h.userDAO, filter, format, w)
+		if flushErr := w.Flush(); flushErr != nil {
+			h.logger.Warn("Failed to flush export", "error", flushErr)
+		}
+		if err != nil {
+			h.logger.Error("Failed to export users", "error", err, "rows", rows)
+			return
+		}
+		h.logger.Info("Users exported", "format", format, "rows", rows)
+	})
+
+	return nil
+}
+
+// StartExport queues an export job that writes to local storage.
+func (h *ExportHandler) StartExport(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	format, err := userexport.ParseFormat(c.Query("format"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	filter, err := parseUserFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	job, err := h.exporter.Start(ctx, format, filter)
+	if err != nil {
+		h.logger.Error("Failed to start export", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to start export",
+		})
+	}
+
+	c.Location("/api/v1/admin/users/exports/" + job.ID.String())
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"job": job,
+	})
+}
+
+func (h *ExportHandler) GetExport(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	jobID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid export ID format",
+		})
+	}
+
+	job, err := h.exporter.Get(ctx, jobID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Export not found or expired",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"job": job,
+	})
+}
+
+func (h *ExportHandler) DownloadExport(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	jobID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid export ID format",
+		})
+	}
+
+	job, err := h.exporter.Get(ctx, jobID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Export not found or expired",
+		})
+	}
+
+	if job.Status != userexport.JobCompleted {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":   true,
+			"message": "Export is " + string(job.Status),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, job.Format.ContentType())
+	return c.Download(h.exporter.Path(job), job.FileName())
+}
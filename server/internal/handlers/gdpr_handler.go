@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/gdpr"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+type GDPRHandler struct {
+	archiver *gdpr.Archiver
+	eraser   *gdpr.Eraser
+	logger   *logger.Logger
+}
+
+func NewGDPRHandler(archiver *gdpr.Archiver, eraser *gdpr.Eraser, logger *logger.Logger) *GDPRHandler {
+	return &GDPRHandler{
+		archiver: archiver,
+		eraser:   eraser,
+		logger:   logger,
+	}
+}
+
+// ExportUserData streams a zip archive of everything stored about the user,
+// whatever its status.
+func (h *GDPRHandler) ExportUserData(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	archive, err := h.archiver.Collect(ctx, userID, requestActor(c))
+	if err != nil {
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found",
+			})
+		}
+		h.logger.Error("Failed to collect user data", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to export user data",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Attachment(archive.FileName())
+
+	// The writer runs after the handler returns, so it owns its context
+	background := tenant.Detach(c.UserContext())
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(background, 10*time.Minute)
+		defer cancel()
+
+		if err := archive.Write(ctx, w); err != nil {
+			h.logger.Error("Failed to write user data archive", "error", err, "user_id", userID)
+		}
+		if err := w.Flush(); err != nil {
+			h.logger.Warn("Failed to flush user data archive", "error", err)
+		}
+		h.logger.Info("User data exported", "user_id", userID)
+	})
+
+	return nil
+}
+
+// EraseUser queues the erasure of the user's personal data.
+func (h *GDPRHandler) EraseUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	job, err := h.eraser.Start(ctx, userID, requestActor(c))
+	if err != nil {
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found",
+			})
+		}
+		h.logger.Error("Failed to start erasure", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to start erasure",
+		})
+	}
+
+	h.logger.Info("User erasure requested", "user_id", userID, "job_id", job.ID)
+
+	c.Location("/api/v1/admin/erasures/" + job.ID.String())
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"job": job,
+	})
+}
+
+func (h *GDPRHandler) GetErasure(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	jobID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid erasure ID format",
+		})
+	}
+
+	job, err := h.eraser.Get(ctx, jobID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Erasure not found or expired",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"job": job,
+	})
+}
@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+type GroupHandler struct {
+	groupDAO *dao.GroupDAO
+	logger   *logger.Logger
+}
+
+func NewGroupHandler(groupDAO *dao.GroupDAO, logger *logger.Logger) *GroupHandler {
+	return &GroupHandler{
+		groupDAO: groupDAO,
+		logger:   logger,
+	}
+}
+
+func (h *GroupHandler) GetGroups(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	page, limit := groupPage(c)
+
+	groups, err := h.groupDAO.GetAll(ctx, limit, (page-1)*limit)
+	if err != nil {
+		h.logger.Error("Failed to get groups", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve groups",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"groups": groups,
+		"page":   page,
+		"limit":  limit,
+	})
+}
+
+func (h *GroupHandler) CreateGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	var req models.CreateGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	group := &models.Group{
+		Name:        strings.TrimSpace(req.Name),
+		Description: strings.TrimSpace(req.Description),
+	}
+	if err := models.ValidateGroup(group); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	if err := h.groupDAO.Create(ctx, group, requestActor(c)); err != nil {
+		return h.groupError(c, err, "Failed to create group")
+	}
+
+	h.logger.Info("Group created", "group_id", group.ID, "organization_id", group.TenantID)
+
+	return c.Status(fiber.StatusCreated).JSON(group)
+}
+
+func (h *GroupHandler) GetGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidGroupID(c)
+	}
+
+	group, err := h.groupDAO.GetByID(ctx, id)
+	if err != nil {
+		return h.groupError(c, err, "Failed to retrieve group")
+	}
+
+	return c.JSON(group)
+}
+
+func (h *GroupHandler) UpdateGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidGroupID(c)
+	}
+
+	var req models.UpdateGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	update := &models.Group{
+		Name:        strings.TrimSpace(req.Name),
+		Description: strings.TrimSpace(req.Description),
+	}
+	if err := models.ValidateGroup(update); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	group, err := h.groupDAO.Update(ctx, id, update.Name, update.Description, requestActor(c))
+	if err != nil {
+		return h.groupError(c, err, "Failed to update group")
+	}
+
+	return c.JSON(group)
+}
+
+func (h *GroupHandler) DeleteGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidGroupID(c)
+	}
+
+	if err := h.groupDAO.Delete(ctx, id, requestActor(c)); err != nil {
+		return h.groupError(c, err, "Failed to delete group")
+	}
+
+	h.logger.Info("Group deleted", "group_id", id)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetMembers lists a group's members; with ?transitive=true, members of its
+// subgroups too.
+func (h *GroupHandler) GetMembers(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidGroupID(c)
+	}
+
+	page, limit := groupPage(c)
+	transitive := c.QueryBool("transitive", false)
+
+	members, err := h.groupDAO.GetMembers(ctx, id, transitive, limit, (page-1)*limit)
+	if err != nil {
+		return h.groupError(c, err, "Failed to retrieve group members")
+	}
+
+	return c.JSON(fiber.Map{
+		"members":    members,
+		"transitive": transitive,
+		"page":       page,
+		"limit":      limit,
+	})
+}
+
+// CheckMember reports whether a user belongs to a group, directly or through
+// any of its subgroups.
+func (h *GroupHandler) CheckMember(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	groupID, userID, invalid := groupIDParams(c, "userId", "Invalid user ID")
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	if _, err := h.groupDAO.GetByID(ctx, groupID); err != nil {
+		return h.groupError(c, err, "Failed to check group membership")
+	}
+
+	member, err := h.groupDAO.IsMember(ctx, groupID, userID)
+	if err != nil {
+		return h.groupError(c, err, "Failed to check group membership")
+	}
+
+	return c.JSON(fiber.Map{
+		"group_id": groupID,
+		"user_id":  userID,
+		"member":   member,
+	})
+}
+
+// SetMember adds a user to a group or changes their role in it. The role
+// defaults to member.
+func (h *GroupHandler) SetMember(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	groupID, userID, invalid := groupIDParams(c, "userId", "Invalid user ID")
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	var req models.SetGroupMemberRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   true,
+				"message": "Invalid request body",
+			})
+		}
+	}
+	if req.Role == "" {
+		req.Role = models.GroupRoleMember
+	}
+	if !req.Role.Valid() {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": "role must be one of: owner, member",
+		})
+	}
+
+	member, created, err := h.groupDAO.SetMember(ctx, groupID, userID, req.Role, requestActor(c))
+	if err != nil {
+		return h.groupError(c, err, "Failed to set group member")
+	}
+
+	if created {
+		return c.Status(fiber.StatusCreated).JSON(member)
+	}
+	return c.JSON(member)
+}
+
+func (h *GroupHandler) RemoveMember(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	groupID, userID, invalid := groupIDParams(c, "userId", "Invalid user ID")
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	if err := h.groupDAO.RemoveMember(ctx, groupID, userID, requestActor(c)); err != nil {
+		return h.groupError(c, err, "Failed to remove group member")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *GroupHandler) GetSubgroups(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidGroupID(c)
+	}
+
+	groups, err := h.groupDAO.GetSubgroups(ctx, id)
+	if err != nil {
+		return h.groupError(c, err, "Failed to retrieve subgroups")
+	}
+
+	return c.JSON(fiber.Map{
+		"subgroups": groups,
+	})
+}
+
+func (h *GroupHandler) AddSubgroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	parentID, childID, invalid := groupIDParams(c, "childId", "Invalid group ID")
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	if err := h.groupDAO.AddSubgroup(ctx, parentID, childID, requestActor(c)); err != nil {
+		return h.groupError(c, err, "Failed to add subgroup")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *GroupHandler) RemoveSubgroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	parentID, childID, invalid := groupIDParams(c, "childId", "Invalid group ID")
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	if err := h.groupDAO.RemoveSubgroup(ctx, parentID, childID, requestActor(c)); err != nil {
+		return h.groupError(c, err, "Failed to remove subgroup")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetUserGroups lists the groups a user is a member of; with
+// ?transitive=true, the groups those are nested in too.
+func (h *GroupHandler) GetUserGroups(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID",
+		})
+	}
+
+	transitive := c.QueryBool("transitive", false)
+
+	memberships, err := h.groupDAO.GetUserGroups(ctx, id, transitive)
+	if err != nil {
+		return h.groupError(c, err, "Failed to retrieve user groups")
+	}
+
+	return c.JSON(fiber.Map{
+		"groups":     memberships,
+		"transitive": transitive,
+	})
+}
+
+func (h *GroupHandler) groupError(c *fiber.Ctx, err error, message string) error {
+	switch err.Error() {
+	case "group not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Group not found",
+		})
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "User not found",
+		})
+	case "group member not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Group member not found",
+		})
+	case "subgroup not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Subgroup not found",
+		})
+	case "group name already exists":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":   true,
+			"message": "Group name already exists",
+		})
+	case "subgroup would create a cycle":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":   true,
+			"message": "Subgroup would create a cycle",
+		})
+	}
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   true,
+		"message": message,
+	})
+}
+
+func groupPage(c *fiber.Ctx) (int, int) {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	return page, limit
+}
+
+func invalidGroupID(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error":   true,
+		"message": "Invalid group ID",
+	})
+}
+
+// groupIDParams parses the group ID in the id route parameter and the other
+// ID in param, returning the message to respond with if either is invalid.
+func groupIDParams(c *fiber.Ctx, param, invalid string) (uuid.UUID, uuid.UUID, string) {
+	groupID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, "Invalid group ID"
+	}
+	otherID, err := uuid.Parse(c.Params(param))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, invalid
+	}
+	return groupID, otherID, ""
+}
@@ -32,7 +32,7 @@ type ServiceInfo struct {
 }
 
 func (h *HealthHandler) Health(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), 5*time.Second)
 	defer cancel()
 
 	response := HealthResponse{
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/invitation"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+type InvitationHandler struct {
+	invitationDAO *dao.InvitationDAO
+	orgDAO        *dao.OrganizationDAO
+	cacheDAO      *dao.CacheDAO
+	inviter       *invitation.Inviter
+	logger        *logger.Logger
+}
+
+func NewInvitationHandler(invitationDAO *dao.InvitationDAO, orgDAO *dao.OrganizationDAO, cacheDAO *dao.CacheDAO,
+	inviter *invitation.Inviter, logger *logger.Logger) *InvitationHandler {
+	return &InvitationHandler{
+		invitationDAO: invitationDAO,
+		orgDAO:        orgDAO,
+		cacheDAO:      cacheDAO,
+		inviter:       inviter,
+		logger:        logger,
+	}
+}
+
+func (h *InvitationHandler) GetInvitations(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	status := models.InvitationStatus(c.Query("status"))
+	switch status {
+	case "", models.InvitationStatusPending, models.InvitationStatusAccepted, models.InvitationStatusDeclined,
+		models.InvitationStatusRevoked, models.InvitationStatusExpired:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "status must be one of: pending, accepted, declined, revoked, expired",
+		})
+	}
+
+	invitations, err := h.invitationDAO.GetAll(ctx, status, limit, (page-1)*limit)
+	if err != nil {
+		h.logger.Error("Failed to get invitations", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve invitations",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"invitations": invitations,
+		"page":        page,
+		"limit":       limit,
+	})
+}
+
+// CreateInvitation invites someone by email and sends them the invitation.
+// If the email cannot be sent the invitation is kept, to be resent.
+func (h *InvitationHandler) CreateInvitation(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	var req models.CreateInvitationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	inv := &models.Invitation{
+		Email:  strings.TrimSpace(req.Email),
+		Groups: req.Groups,
+	}
+	for i := range inv.Groups {
+		if inv.Groups[i].Role == "" {
+			inv.Groups[i].Role = models.GroupRoleMember
+		}
+	}
+	if err := models.ValidateInvitation(inv); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	sent, err := h.inviter.Invite(ctx, inv, requestActor(c))
+	if err != nil {
+		return h.invitationError(c, err, "Failed to create invitation")
+	}
+
+	h.logger.Info("Invitation sent", "invitation_id", sent.ID, "organization_id", sent.TenantID)
+
+	return c.Status(fiber.StatusCreated).JSON(sent)
+}
+
+func (h *InvitationHandler) GetInvitation(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidInvitationID(c)
+	}
+
+	inv, err := h.invitationDAO.GetByID(ctx, id)
+	if err != nil {
+		return h.invitationError(c, err, "Failed to retrieve invitation")
+	}
+
+	return c.JSON(inv)
+}
+
+// ResendInvitation sends a pending or expired invitation again with a new
+// token and expiry.
+func (h *InvitationHandler) ResendInvitation(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidInvitationID(c)
+	}
+
+	sent, err := h.inviter.Resend(ctx, id, requestActor(c))
+	if err != nil {
+		return h.invitationError(c, err, "Failed to resend invitation")
+	}
+
+	h.logger.Info("Invitation resent", "invitation_id", sent.ID, "send_count", sent.SendCount)
+
+	return c.JSON(sent)
+}
+
+func (h *InvitationHandler) RevokeInvitation(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidInvitationID(c)
+	}
+
+	inv, err := h.invitationDAO.Revoke(ctx, id, requestActor(c))
+	if err != nil {
+		return h.invitationError(c, err, "Failed to revoke invitation")
+	}
+
+	return c.JSON(inv)
+}
+
+// LookupInvitation shows the invitee what they were invited to.
+func (h *InvitationHandler) LookupInvitation(c *fiber.Ctx) error {
+	var req models.InvitationTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	ctx, cancel, err := invitationContext(c, req.Token)
+	if err != nil {
+		return h.invitationError(c, err, "Failed to retrieve invitation")
+	}
+	defer cancel()
+
+	inv, err := h.invitationDAO.GetByToken(ctx, req.Token)
+	if err != nil {
+		return h.invitationError(c, err, "Failed to retrieve invitation")
+	}
+
+	org, err := h.orgDAO.GetByID(ctx, inv.TenantID)
+	if err != nil {
+		return h.invitationError(c, err, "Failed to retrieve invitation")
+	}
+
+	return c.JSON(fiber.Map{
+		"organization": org,
+		"email":        inv.Email,
+		"status":       inv.Status,
+		"expires_at":   inv.ExpiresAt,
+	})
+}
+
+// AcceptInvitation redeems an invitation, creating the invitee's user from
+// the profile in the request unless they already have one.
+func (h *InvitationHandler) AcceptInvitation(c *fiber.Ctx) error {
+	var req models.AcceptInvitationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	ctx, cancel, err := invitationContext(c, req.Token)
+	if err != nil {
+		return h.invitationError(c, err, "Failed to accept invitation")
+	}
+	defer cancel()
+
+	req.Username = strings.TrimSpace(req.Username)
+	req.FirstName = strings.TrimSpace(req.FirstName)
+	req.LastName = strings.TrimSpace(req.LastName)
+
+	inv, user, created, err := h.invitationDAO.Accept(ctx, req.Token, req)
+	if err != nil {
+		return h.invitationError(c, err, "Failed to accept invitation")
+	}
+
+	if err := h.cacheDAO.SetUser(ctx, user); err != nil {
+		h.logger.Warn("Failed to cache invited user", "error", err, "user_id", user.ID)
+	}
+	if err := h.cacheDAO.InvalidateUsersList(ctx); err != nil {
+		h.logger.Warn("Failed to invalidate users list cache", "error", err)
+	}
+
+	h.logger.Info("Invitation accepted", "invitation_id", inv.ID, "user_id", user.ID, "created", created)
+
+	status := fiber.StatusOK
+	if created {
+		status = fiber.StatusCreated
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"invitation": inv,
+		"user":       user,
+		"created":    created,
+	})
+}
+
+func (h *InvitationHandler) DeclineInvitation(c *fiber.Ctx) error {
+	var req models.InvitationTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	ctx, cancel, err := invitationContext(c, req.Token)
+	if err != nil {
+		return h.invitationError(c, err, "Failed to decline invitation")
+	}
+	defer cancel()
+
+	inv, err := h.invitationDAO.Decline(ctx, req.Token)
+	if err != nil {
+		return h.invitationError(c, err, "Failed to decline invitation")
+	}
+
+	return c.JSON(fiber.Map{
+		"status": inv.Status,
+	})
+}
+
+// invitationContext returns the request context acting for the organization
+// the invitation token belongs to. The invitee is not a member yet, so the
+// organization cannot come from the Tenant middleware.
+func invitationContext(c *fiber.Ctx, token string) (context.Context, context.CancelFunc, error) {
+	tenantID, err := dao.ParseInvitationToken(strings.TrimSpace(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(tenant.WithID(c.UserContext(), tenantID), 10*time.Second)
+	return ctx, cancel, nil
+}
+
+func (h *InvitationHandler) invitationError(c *fiber.Ctx, err error, message string) error {
+	var delivery *invitation.DeliveryError
+	if errors.As(err, &delivery) {
+		h.logger.Error("Failed to deliver invitation", "error", delivery.Err, "invitation_id", delivery.Invitation.ID)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error":      true,
+			"message":    "Invitation saved but could not be delivered; resend it",
+			"invitation": delivery.Invitation,
+		})
+	}
+
+	var profile *dao.ProfileError
+	if errors.As(err, &profile) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": profile.Error(),
+		})
+	}
+
+	var duplicate *dao.DuplicateError
+	if errors.As(err, &duplicate) {
+		return duplicateConflict(c, duplicate)
+	}
+
+	switch err.Error() {
+	case "invitation not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invitation not found",
+		})
+	case "group not found":
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": "Group not found",
+		})
+	case "invitation already pending":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":   true,
+			"message": "An invitation for this email is already pending",
+		})
+	case "invitation is not pending":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invitation is no longer pending",
+		})
+	case "user is suspended":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":   true,
+			"message": "User is suspended",
+		})
+	}
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   true,
+		"message": message,
+	})
+}
+
+func invalidInvitationID(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error":   true,
+		"message": "Invalid invitation ID",
+	})
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/ldapsync"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+type LDAPSyncHandler struct {
+	syncer   *ldapsync.Syncer
+	cacheDAO *dao.CacheDAO
+	logger   *logger.Logger
+}
+
+func NewLDAPSyncHandler(syncer *ldapsync.Syncer, cacheDAO *dao.CacheDAO, logger *logger.Logger) *LDAPSyncHandler {
+	return &LDAPSyncHandler{
+		syncer:   syncer,
+		cacheDAO: cacheDAO,
+		logger:   logger,
+	}
+}
+
+// StartSync starts syncing the organization from its directory. With
+// dry_run=true the sync only reports what it would change. The report is
+// available from GetSyncReport once the sync finishes.
+func (h *LDAPSyncHandler) StartSync(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	if status, message := h.checkOrganization(ctx); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error":   true,
+			"message": message,
+		})
+	}
+
+	dryRun := c.QueryBool("dry_run", false)
+	if err := h.syncer.Start(context.WithoutCancel(c.UserContext()), dryRun, requestActor(c)); err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":   true,
+			"message": "An LDAP sync is already running",
+		})
+	}
+
+	h.logger.Info("LDAP sync started", "dry_run", dryRun)
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "LDAP sync started",
+		"dry_run": dryRun,
+	})
+}
+
+// GetSyncReport returns the report of the organization's latest sync.
+func (h *LDAPSyncHandler) GetSyncReport(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	if status, message := h.checkOrganization(ctx); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error":   true,
+			"message": message,
+		})
+	}
+
+	var report ldapsync.Report
+	if err := h.cacheDAO.GetLDAPSyncReport(ctx, &report); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "No LDAP sync report found",
+		})
+	}
+
+	return c.JSON(report)
+}
+
+// checkOrganization returns the status and message to answer with unless
+// the request acts for the organization synced from the directory.
+func (h *LDAPSyncHandler) checkOrganization(ctx context.Context) (int, string) {
+	if !h.syncer.Enabled() {
+		return fiber.StatusNotFound, "LDAP sync is not configured"
+	}
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return fiber.StatusBadRequest, "Organization required"
+	}
+
+	orgID, err := h.syncer.OrganizationID(ctx)
+	if err != nil {
+		h.logger.Error("Failed to get LDAP sync organization", "error", err)
+		return fiber.StatusInternalServerError, "Failed to retrieve LDAP sync configuration"
+	}
+
+	if orgID != tenantID {
+		return fiber.StatusNotFound, "LDAP sync is not configured for this organization"
+	}
+
+	return 0, ""
+}
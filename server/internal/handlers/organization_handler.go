@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+type OrganizationHandler struct {
+	orgDAO *dao.OrganizationDAO
+	logger *logger.Logger
+}
+
+func NewOrganizationHandler(orgDAO *dao.OrganizationDAO, logger *logger.Logger) *OrganizationHandler {
+	return &OrganizationHandler{
+		orgDAO: orgDAO,
+		logger: logger,
+	}
+}
+
+func (h *OrganizationHandler) GetOrganizations(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	orgs, err := h.orgDAO.GetAll(ctx, limit, (page-1)*limit)
+	if err != nil {
+		h.logger.Error("Failed to get organizations", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve organizations",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"organizations": orgs,
+		"page":          page,
+		"limit":         limit,
+	})
+}
+
+func (h *OrganizationHandler) CreateOrganization(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	var req models.CreateOrganizationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	org := &models.Organization{
+		Slug: strings.ToLower(strings.TrimSpace(req.Slug)),
+		Name: strings.TrimSpace(req.Name),
+	}
+	if err := models.ValidateOrganization(org); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	if err := h.orgDAO.Create(ctx, org, requestActor(c)); err != nil {
+		if err.Error() == "organization slug already exists" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":   true,
+				"message": "Organization slug already exists",
+			})
+		}
+		h.logger.Error("Failed to create organization", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to create organization",
+		})
+	}
+
+	h.logger.Info("Organization created", "organization_id", org.ID, "slug", org.Slug)
+
+	return c.Status(fiber.StatusCreated).JSON(org)
+}
+
+func (h *OrganizationHandler) GetOrganization(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid organization ID",
+		})
+	}
+
+	org, err := h.orgDAO.GetByID(ctx, id)
+	if err != nil {
+		return h.organizationError(c, err, "Failed to retrieve organization")
+	}
+
+	return c.JSON(org)
+}
+
+// RenameOrganization changes an organization's name. Its slug is fixed.
+func (h *OrganizationHandler) RenameOrganization(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid organization ID",
+		})
+	}
+
+	var req models.UpdateOrganizationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if err := models.ValidateOrganization(&models.Organization{Slug: "x", Name: name}); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	org, err := h.orgDAO.Rename(ctx, id, name, requestActor(c))
+	if err != nil {
+		return h.organizationError(c, err, "Failed to update organization")
+	}
+
+	return c.JSON(org)
+}
+
+func (h *OrganizationHandler) organizationError(c *fiber.Ctx, err error, message string) error {
+	if err.Error() == "organization not found" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Organization not found",
+		})
+	}
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   true,
+		"message": message,
+	})
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+type RelationshipHandler struct {
+	relationshipDAO *dao.RelationshipDAO
+	cacheDAO        *dao.CacheDAO
+	logger          *logger.Logger
+}
+
+func NewRelationshipHandler(relationshipDAO *dao.RelationshipDAO, cacheDAO *dao.CacheDAO, logger *logger.Logger) *RelationshipHandler {
+	return &RelationshipHandler{
+		relationshipDAO: relationshipDAO,
+		cacheDAO:        cacheDAO,
+		logger:          logger,
+	}
+}
+
+func (h *RelationshipHandler) Follow(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, targetID, invalid := userIDParams(c)
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	follow, created, err := h.relationshipDAO.Follow(ctx, userID, targetID, requestActor(c))
+	if err != nil {
+		return h.relationshipError(c, err, "Failed to follow user")
+	}
+
+	if !created {
+		return c.JSON(follow)
+	}
+	h.invalidateCounts(ctx, userID, targetID)
+	return c.Status(fiber.StatusCreated).JSON(follow)
+}
+
+func (h *RelationshipHandler) Unfollow(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, targetID, invalid := userIDParams(c)
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	if err := h.relationshipDAO.Unfollow(ctx, userID, targetID, requestActor(c)); err != nil {
+		return h.relationshipError(c, err, "Failed to unfollow user")
+	}
+
+	h.invalidateCounts(ctx, userID, targetID)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Block blocks the target user, which also ends any follow between the two.
+func (h *RelationshipHandler) Block(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, targetID, invalid := userIDParams(c)
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	block, created, err := h.relationshipDAO.Block(ctx, userID, targetID, requestActor(c))
+	if err != nil {
+		return h.relationshipError(c, err, "Failed to block user")
+	}
+
+	if !created {
+		return c.JSON(block)
+	}
+	h.invalidateCounts(ctx, userID, targetID)
+	return c.Status(fiber.StatusCreated).JSON(block)
+}
+
+func (h *RelationshipHandler) Unblock(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, targetID, invalid := userIDParams(c)
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	if err := h.relationshipDAO.Unblock(ctx, userID, targetID, requestActor(c)); err != nil {
+		return h.relationshipError(c, err, "Failed to unblock user")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetFollowers lists the user's followers, newest first. Pass the
+// next_cursor of a page as cursor to fetch the one after it.
+func (h *RelationshipHandler) GetFollowers(c *fiber.Ctx) error {
+	return h.getFollows(c, h.relationshipDAO.GetFollowers, "Failed to retrieve followers")
+}
+
+// GetFollowing lists the users the user follows, as GetFollowers does.
+func (h *RelationshipHandler) GetFollowing(c *fiber.Ctx) error {
+	return h.getFollows(c, h.relationshipDAO.GetFollowing, "Failed to retrieve followed users")
+}
+
+func (h *RelationshipHandler) getFollows(c *fiber.Ctx,
+	list func(context.Context, uuid.UUID, string, int) (*models.FollowPage, error), message string) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID",
+		})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	page, err := list(ctx, id, c.Query("cursor"), limit)
+	if err != nil {
+		return h.relationshipError(c, err, message)
+	}
+
+	return c.JSON(fiber.Map{
+		"follows":     page.Follows,
+		"next_cursor": page.NextCursor,
+		"limit":       limit,
+	})
+}
+
+// GetFollowCounts returns how many users follow the user and how many it
+// follows, from the cache when it holds them.
+func (h *RelationshipHandler) GetFollowCounts(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID",
+		})
+	}
+
+	if counts, err := h.cacheDAO.GetFollowCounts(ctx, id.String()); err == nil {
+		return c.JSON(counts)
+	}
+
+	// The version is read before counting, so counts that a follow or block
+	// changes while they are counted are not cached
+	version, versionErr := h.cacheDAO.FollowCountsVersion(ctx, id.String())
+	if versionErr != nil {
+		h.logger.Warn("Failed to read follow counts version", "error", versionErr)
+	}
+
+	counts, err := h.relationshipDAO.CountFollows(ctx, id)
+	if err != nil {
+		return h.relationshipError(c, err, "Failed to count follows")
+	}
+
+	if versionErr == nil {
+		if _, err := h.cacheDAO.SetFollowCounts(ctx, id.String(), version, counts); err != nil {
+			h.logger.Warn("Failed to cache follow counts", "error", err)
+		}
+	}
+
+	return c.JSON(counts)
+}
+
+// GetRelationship returns whether the user and the target follow or block
+// each other, and whether they follow each other mutually.
+func (h *RelationshipHandler) GetRelationship(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, targetID, invalid := userIDParams(c)
+	if invalid != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": invalid,
+		})
+	}
+
+	rel, err := h.relationshipDAO.GetRelationship(ctx, userID, targetID)
+	if err != nil {
+		return h.relationshipError(c, err, "Failed to retrieve relationship")
+	}
+
+	return c.JSON(rel)
+}
+
+// invalidateCounts drops the cached follow counts of both users once a
+// follow between them has been made or ended.
+func (h *RelationshipHandler) invalidateCounts(ctx context.Context, userID, targetID uuid.UUID) {
+	if err := h.cacheDAO.DeleteFollowCounts(ctx, userID.String(), targetID.String()); err != nil {
+		h.logger.Warn("Failed to invalidate follow counts cache", "error", err)
+	}
+}
+
+func (h *RelationshipHandler) relationshipError(c *fiber.Ctx, err error, message string) error {
+	switch err.Error() {
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "User not found",
+		})
+	case "follow not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Follow not found",
+		})
+	case "block not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Block not found",
+		})
+	case "invalid cursor":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid cursor",
+		})
+	case "cannot follow yourself":
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": "Users cannot follow themselves",
+		})
+	case "cannot block yourself":
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": "Users cannot block themselves",
+		})
+	case "user is blocked":
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":   true,
+			"message": "Cannot follow a user while either blocks the other",
+		})
+	}
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   true,
+		"message": message,
+	})
+}
+
+// userIDParams parses the user ID in the id route parameter and the target
+// user ID in targetId, returning the message to respond with if either is
+// invalid.
+func userIDParams(c *fiber.Ctx) (uuid.UUID, uuid.UUID, string) {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, "Invalid user ID"
+	}
+	targetID, err := uuid.Parse(c.Params("targetId"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, "Invalid target user ID"
+	}
+	return userID, targetID, ""
+}
@@ -0,0 +1,835 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/middleware"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/scim"
+)
+
+// scimPageSize is the number of group members or groups read at a time
+// when a SCIM request needs all of them.
+const scimPageSize = 500
+
+// SCIMHandler serves the SCIM 2.0 protocol (RFC 7644) to identity providers,
+// mapping Users onto the organization's users and Groups onto its groups.
+// Requests are authenticated by middleware.SCIMAuth.
+type SCIMHandler struct {
+	userDAO  *dao.UserDAO
+	groupDAO *dao.GroupDAO
+	scimDAO  *dao.SCIMDAO
+	cacheDAO *dao.CacheDAO
+	logger   *logger.Logger
+}
+
+func NewSCIMHandler(userDAO *dao.UserDAO, groupDAO *dao.GroupDAO, scimDAO *dao.SCIMDAO, cacheDAO *dao.CacheDAO,
+	logger *logger.Logger) *SCIMHandler {
+	return &SCIMHandler{
+		userDAO:  userDAO,
+		groupDAO: groupDAO,
+		scimDAO:  scimDAO,
+		cacheDAO: cacheDAO,
+		logger:   logger,
+	}
+}
+
+func (h *SCIMHandler) GetServiceProviderConfig(c *fiber.Ctx) error {
+	return scimJSON(c, fiber.StatusOK, scim.ServiceProviderConfig(scimBaseURL(c)))
+}
+
+func (h *SCIMHandler) GetResourceTypes(c *fiber.Ctx) error {
+	types := scim.ResourceTypes(scimBaseURL(c))
+	return scimJSON(c, fiber.StatusOK, scim.ListResponse(types, len(types), 1))
+}
+
+func (h *SCIMHandler) GetResourceType(c *fiber.Ctx) error {
+	for _, r := range scim.ResourceTypes(scimBaseURL(c)) {
+		if strings.EqualFold(scim.String(r, "id"), c.Params("id")) {
+			return scimJSON(c, fiber.StatusOK, r)
+		}
+	}
+	return scimError(c, scim.NewError(fiber.StatusNotFound, "", "Resource type not found"))
+}
+
+func (h *SCIMHandler) GetSchemas(c *fiber.Ctx) error {
+	schemas := scim.Schemas(scimBaseURL(c))
+	return scimJSON(c, fiber.StatusOK, scim.ListResponse(schemas, len(schemas), 1))
+}
+
+func (h *SCIMHandler) GetSchema(c *fiber.Ctx) error {
+	for _, r := range scim.Schemas(scimBaseURL(c)) {
+		if strings.EqualFold(scim.String(r, "id"), c.Params("id")) {
+			return scimJSON(c, fiber.StatusOK, r)
+		}
+	}
+	return scimError(c, scim.NewError(fiber.StatusNotFound, "", "Schema not found"))
+}
+
+// GetUsers lists users matching the filter query parameter. Filters on
+// attributes stored in columns of their own are evaluated and paged in SQL;
+// others are matched in Go against the users the rest of the filter selects.
+func (h *SCIMHandler) GetUsers(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 30*time.Second)
+	defer cancel()
+
+	var filter scim.Filter
+	if expr := c.Query("filter"); expr != "" {
+		var err error
+		if filter, err = scim.ParseFilter(expr); err != nil {
+			return h.scimFail(c, err, "Failed to list users")
+		}
+	}
+	page := scim.ParsePage(c.Query("startIndex"), c.Query("count"))
+	attributes, excluded := c.Query("attributes"), c.Query("excludedAttributes")
+
+	matched, total, exact, err := h.userDAO.FindSCIMUsers(ctx, filter, page.StartIndex-1, page.Count)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to list users")
+	}
+
+	var externalIDs map[uuid.UUID]string
+	if exact {
+		ids := make([]uuid.UUID, len(matched))
+		for i, user := range matched {
+			ids[i] = user.ID
+		}
+		if externalIDs, err = h.scimDAO.GetExternalIDsOf(ctx, scim.MemberTypeUser, ids); err != nil {
+			return h.scimFail(c, err, "Failed to list users")
+		}
+	} else {
+		if externalIDs, err = h.scimDAO.GetExternalIDs(ctx, scim.MemberTypeUser); err != nil {
+			return h.scimFail(c, err, "Failed to list users")
+		}
+		// Group memberships take a query per user, so they are only read
+		// for filters that need them
+		filterGroups := strings.Contains(strings.ToLower(c.Query("filter")), "groups")
+		match := func(user *models.User) error {
+			var groups []*models.GroupMembership
+			if filterGroups {
+				if groups, err = h.groupDAO.GetUserGroups(ctx, user.ID, false); err != nil {
+					return err
+				}
+			}
+			r := scim.UserResource(user, externalIDs[user.ID], groups, scimLocation(c, "Users", user.ID))
+			if !filter.Match(r) {
+				return nil
+			}
+			if page.Contains(total) {
+				matched = append(matched, user)
+			}
+			total++
+			return nil
+		}
+		if err := h.userDAO.StreamSCIMUsers(ctx, filter, match); err != nil {
+			return h.scimFail(c, err, "Failed to list users")
+		}
+	}
+
+	resources := make([]scim.Resource, 0, len(matched))
+	for _, user := range matched {
+		var groups []*models.GroupMembership
+		if scim.Requested("groups", attributes, excluded) {
+			if groups, err = h.groupDAO.GetUserGroups(ctx, user.ID, false); err != nil {
+				return h.scimFail(c, err, "Failed to list users")
+			}
+		}
+		r := scim.UserResource(user, externalIDs[user.ID], groups, scimLocation(c, "Users", user.ID))
+		resources = append(resources, scim.Project(r, attributes, excluded))
+	}
+
+	return scimJSON(c, fiber.StatusOK, scim.ListResponse(resources, total, page.StartIndex))
+}
+
+func (h *SCIMHandler) GetUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	user, err := h.scimUser(ctx, c.Params("id"))
+	if err != nil {
+		return h.scimFail(c, err, "Failed to retrieve user")
+	}
+
+	r, err := h.userResource(ctx, c, user)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to retrieve user")
+	}
+
+	return scimJSON(c, fiber.StatusOK, scim.Project(r, c.Query("attributes"), c.Query("excludedAttributes")))
+}
+
+// CreateUser provisions a user. Users created inactive start suspended.
+func (h *SCIMHandler) CreateUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	r, err := scimBody(c)
+	if err != nil {
+		return scimError(c, err)
+	}
+	fields, err := scim.ReadUser(r)
+	if err != nil {
+		return scimError(c, err)
+	}
+	if err := models.ValidateUsername(fields.UserName); err != nil {
+		return scimError(c, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+	}
+
+	user := &models.User{
+		Email:     fields.Email,
+		Username:  fields.UserName,
+		FirstName: fields.FirstName,
+		LastName:  fields.LastName,
+	}
+	if err := models.ValidateUser(user); err != nil {
+		return scimError(c, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+	}
+
+	if existing, err := h.userDAO.GetByEmail(ctx, user.Email); err == nil && existing != nil {
+		return h.scimFail(c, &dao.DuplicateError{Field: "email"}, "Failed to create user")
+	}
+
+	actor := scimActor(c)
+	if err := h.userDAO.Create(ctx, user, actor); err != nil {
+		return h.scimFail(c, err, "Failed to create user")
+	}
+	if fields.Active != nil && !*fields.Active {
+		if user, err = h.userDAO.ChangeStatus(ctx, user.ID, models.UserStatusSuspended, "Provisioned inactive", actor); err != nil {
+			return h.scimFail(c, err, "Failed to create user")
+		}
+	}
+	if err := h.scimDAO.SetExternalID(ctx, scim.MemberTypeUser, user.ID, fields.ExternalID); err != nil {
+		return h.scimFail(c, err, "Failed to create user")
+	}
+
+	h.userChanged(ctx, user)
+	h.logger.Info("User provisioned over SCIM", "user_id", user.ID, "scim_token_id", scimToken(c).ID)
+
+	r, err = h.userResource(ctx, c, user)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to retrieve user")
+	}
+	c.Set(fiber.HeaderLocation, scimLocation(c, "Users", user.ID))
+	return scimJSON(c, fiber.StatusCreated, r)
+}
+
+// ReplaceUser replaces a user's attributes with those in the request.
+func (h *SCIMHandler) ReplaceUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	current, err := h.scimUser(ctx, c.Params("id"))
+	if err != nil {
+		return h.scimFail(c, err, "Failed to update user")
+	}
+
+	r, err := scimBody(c)
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	return h.applyUser(ctx, c, current, r)
+}
+
+// PatchUser applies PATCH operations to the user's resource and stores the
+// result as ReplaceUser would.
+func (h *SCIMHandler) PatchUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	current, err := h.scimUser(ctx, c.Params("id"))
+	if err != nil {
+		return h.scimFail(c, err, "Failed to update user")
+	}
+
+	operations, err := scim.ParsePatch(c.Body())
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	externalID, err := h.scimDAO.GetExternalID(ctx, scim.MemberTypeUser, current.ID)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to update user")
+	}
+	r := scim.UserResource(current, externalID, nil, scimLocation(c, "Users", current.ID))
+	if err := scim.Apply(r, operations); err != nil {
+		return scimError(c, err)
+	}
+
+	return h.applyUser(ctx, c, current, r)
+}
+
+// applyUser stores the user r describes over current and responds with the
+// result. active maps onto the active and suspended statuses.
+func (h *SCIMHandler) applyUser(ctx context.Context, c *fiber.Ctx, current *models.User, r scim.Resource) error {
+	fields, err := scim.ReadUser(r)
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	updated := *current
+	updated.Email, updated.Username = fields.Email, fields.UserName
+	updated.FirstName, updated.LastName = fields.FirstName, fields.LastName
+	if err := models.ValidateUser(&updated); err != nil {
+		return scimError(c, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+	}
+
+	updates := make(map[string]interface{})
+	if updated.Email != current.Email {
+		updates["email"] = updated.Email
+	}
+	if updated.Username != current.Username {
+		if err := models.ValidateUsername(updated.Username); err != nil {
+			return scimError(c, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+		}
+		updates["username"] = updated.Username
+	}
+	if updated.FirstName != current.FirstName {
+		updates["first_name"] = updated.FirstName
+	}
+	if updated.LastName != current.LastName {
+		updates["last_name"] = updated.LastName
+	}
+
+	actor := scimActor(c)
+	if len(updates) > 0 {
+		if err := h.userDAO.Update(ctx, current.ID, current.Version, updates, actor); err != nil {
+			return h.scimFail(c, err, "Failed to update user")
+		}
+	}
+
+	if fields.Active != nil {
+		var status models.UserStatus
+		switch {
+		case *fields.Active && current.Status != models.UserStatusActive:
+			status = models.UserStatusActive
+		case !*fields.Active && current.Status == models.UserStatusActive:
+			status = models.UserStatusSuspended
+		}
+		if status != "" {
+			if _, err := h.userDAO.ChangeStatus(ctx, current.ID, status, "Deprovisioned over SCIM", actor); err != nil {
+				return h.scimFail(c, err, "Failed to update user")
+			}
+		}
+	}
+
+	if err := h.scimDAO.SetExternalID(ctx, scim.MemberTypeUser, current.ID, fields.ExternalID); err != nil {
+		return h.scimFail(c, err, "Failed to update user")
+	}
+
+	user, err := h.userDAO.GetByID(ctx, current.ID)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to retrieve user")
+	}
+	h.userChanged(ctx, user)
+
+	resource, err := h.userResource(ctx, c, user)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to retrieve user")
+	}
+	return scimJSON(c, fiber.StatusOK, resource)
+}
+
+// DeleteUser soft-deletes the user, so that it can still be restored.
+func (h *SCIMHandler) DeleteUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	user, err := h.scimUser(ctx, c.Params("id"))
+	if err != nil {
+		return h.scimFail(c, err, "Failed to delete user")
+	}
+
+	if err := h.userDAO.Delete(ctx, user.ID, user.Version, scimActor(c)); err != nil {
+		return h.scimFail(c, err, "Failed to delete user")
+	}
+
+	if err := h.cacheDAO.DeleteUser(ctx, user.ID.String()); err != nil {
+		h.logger.Warn("Failed to invalidate user cache", "error", err, "user_id", user.ID)
+	}
+	if err := h.cacheDAO.InvalidateUsersList(ctx); err != nil {
+		h.logger.Warn("Failed to invalidate users list cache", "error", err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetGroups lists groups matching the filter query parameter, evaluating
+// and paging it in SQL where it can, as GetUsers does.
+func (h *SCIMHandler) GetGroups(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 30*time.Second)
+	defer cancel()
+
+	var filter scim.Filter
+	if expr := c.Query("filter"); expr != "" {
+		var err error
+		if filter, err = scim.ParseFilter(expr); err != nil {
+			return h.scimFail(c, err, "Failed to list groups")
+		}
+	}
+	page := scim.ParsePage(c.Query("startIndex"), c.Query("count"))
+	attributes, excluded := c.Query("attributes"), c.Query("excludedAttributes")
+	withMembers := scim.Requested("members", attributes, excluded)
+
+	matched, total, exact, err := h.groupDAO.FindSCIMGroups(ctx, filter, page.StartIndex-1, page.Count)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to list groups")
+	}
+
+	if exact {
+		ids := make([]uuid.UUID, len(matched))
+		for i, group := range matched {
+			ids[i] = group.ID
+		}
+		externalIDs, err := h.scimDAO.GetExternalIDsOf(ctx, scim.MemberTypeGroup, ids)
+		if err != nil {
+			return h.scimFail(c, err, "Failed to list groups")
+		}
+
+		resources := make([]scim.Resource, 0, len(matched))
+		for _, group := range matched {
+			var members []scim.GroupMember
+			if withMembers {
+				if members, err = h.groupMembers(ctx, group.ID); err != nil {
+					return h.scimFail(c, err, "Failed to list groups")
+				}
+			}
+			r := scim.GroupResource(group, externalIDs[group.ID], members, scimLocation(c, "Groups", group.ID))
+			resources = append(resources, scim.Project(r, attributes, excluded))
+		}
+		return scimJSON(c, fiber.StatusOK, scim.ListResponse(resources, total, page.StartIndex))
+	}
+
+	filterMembers := strings.Contains(strings.ToLower(c.Query("filter")), "members")
+	externalIDs, err := h.scimDAO.GetExternalIDs(ctx, scim.MemberTypeGroup)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to list groups")
+	}
+
+	var resources []scim.Resource
+	for offset := 0; ; offset += scimPageSize {
+		groups, err := h.groupDAO.GetSCIMCandidates(ctx, filter, scimPageSize, offset)
+		if err != nil {
+			return h.scimFail(c, err, "Failed to list groups")
+		}
+
+		for _, group := range groups {
+			var members []scim.GroupMember
+			if filterMembers {
+				if members, err = h.groupMembers(ctx, group.ID); err != nil {
+					return h.scimFail(c, err, "Failed to list groups")
+				}
+			}
+			r := scim.GroupResource(group, externalIDs[group.ID], members, scimLocation(c, "Groups", group.ID))
+			if !filter.Match(r) {
+				continue
+			}
+
+			if page.Contains(total) {
+				if withMembers && !filterMembers {
+					if members, err = h.groupMembers(ctx, group.ID); err != nil {
+						return h.scimFail(c, err, "Failed to list groups")
+					}
+					r = scim.GroupResource(group, externalIDs[group.ID], members, scimLocation(c, "Groups", group.ID))
+				}
+				resources = append(resources, scim.Project(r, attributes, excluded))
+			}
+			total++
+		}
+
+		if len(groups) < scimPageSize {
+			break
+		}
+	}
+
+	return scimJSON(c, fiber.StatusOK, scim.ListResponse(resources, total, page.StartIndex))
+}
+
+func (h *SCIMHandler) GetGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	group, err := h.scimGroup(ctx, c.Params("id"))
+	if err != nil {
+		return h.scimFail(c, err, "Failed to retrieve group")
+	}
+
+	r, err := h.groupResource(ctx, c, group)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to retrieve group")
+	}
+
+	return scimJSON(c, fiber.StatusOK, scim.Project(r, c.Query("attributes"), c.Query("excludedAttributes")))
+}
+
+// CreateGroup creates a group with the members in the request. Members that
+// are groups become its subgroups.
+func (h *SCIMHandler) CreateGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 30*time.Second)
+	defer cancel()
+
+	r, err := scimBody(c)
+	if err != nil {
+		return scimError(c, err)
+	}
+	fields, err := scim.ReadGroup(r)
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	group := &models.Group{Name: fields.DisplayName}
+	if err := models.ValidateGroup(group); err != nil {
+		return scimError(c, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+	}
+
+	actor := scimActor(c)
+	if err := h.groupDAO.Create(ctx, group, actor); err != nil {
+		return h.scimFail(c, err, "Failed to create group")
+	}
+	if err := h.scimDAO.SetExternalID(ctx, scim.MemberTypeGroup, group.ID, fields.ExternalID); err != nil {
+		return h.scimFail(c, err, "Failed to create group")
+	}
+	if err := h.syncMembers(ctx, group.ID, fields.Members, actor); err != nil {
+		return h.scimFail(c, err, "Failed to set group members")
+	}
+
+	h.logger.Info("Group provisioned over SCIM", "group_id", group.ID, "scim_token_id", scimToken(c).ID)
+
+	r, err = h.groupResource(ctx, c, group)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to retrieve group")
+	}
+	c.Set(fiber.HeaderLocation, scimLocation(c, "Groups", group.ID))
+	return scimJSON(c, fiber.StatusCreated, r)
+}
+
+func (h *SCIMHandler) ReplaceGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 30*time.Second)
+	defer cancel()
+
+	current, err := h.scimGroup(ctx, c.Params("id"))
+	if err != nil {
+		return h.scimFail(c, err, "Failed to update group")
+	}
+
+	r, err := scimBody(c)
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	return h.applyGroup(ctx, c, current, r)
+}
+
+// PatchGroup applies PATCH operations to the group's resource, typically
+// adding or removing members, and stores the result as ReplaceGroup would.
+func (h *SCIMHandler) PatchGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 30*time.Second)
+	defer cancel()
+
+	current, err := h.scimGroup(ctx, c.Params("id"))
+	if err != nil {
+		return h.scimFail(c, err, "Failed to update group")
+	}
+
+	operations, err := scim.ParsePatch(c.Body())
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	r, err := h.groupResource(ctx, c, current)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to update group")
+	}
+	if err := scim.Apply(r, operations); err != nil {
+		return scimError(c, err)
+	}
+
+	return h.applyGroup(ctx, c, current, r)
+}
+
+// applyGroup stores the group r describes over current and responds with the
+// result. The group's description is not part of SCIM and is kept.
+func (h *SCIMHandler) applyGroup(ctx context.Context, c *fiber.Ctx, current *models.Group, r scim.Resource) error {
+	fields, err := scim.ReadGroup(r)
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	actor := scimActor(c)
+	group := current
+	if fields.DisplayName != current.Name {
+		renamed := &models.Group{Name: fields.DisplayName, Description: current.Description}
+		if err := models.ValidateGroup(renamed); err != nil {
+			return scimError(c, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+		}
+		if group, err = h.groupDAO.Update(ctx, current.ID, renamed.Name, renamed.Description, actor); err != nil {
+			return h.scimFail(c, err, "Failed to update group")
+		}
+	}
+
+	if err := h.scimDAO.SetExternalID(ctx, scim.MemberTypeGroup, current.ID, fields.ExternalID); err != nil {
+		return h.scimFail(c, err, "Failed to update group")
+	}
+	if err := h.syncMembers(ctx, current.ID, fields.Members, actor); err != nil {
+		return h.scimFail(c, err, "Failed to set group members")
+	}
+
+	resource, err := h.groupResource(ctx, c, group)
+	if err != nil {
+		return h.scimFail(c, err, "Failed to retrieve group")
+	}
+	return scimJSON(c, fiber.StatusOK, resource)
+}
+
+func (h *SCIMHandler) DeleteGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	group, err := h.scimGroup(ctx, c.Params("id"))
+	if err != nil {
+		return h.scimFail(c, err, "Failed to delete group")
+	}
+
+	if err := h.groupDAO.Delete(ctx, group.ID, scimActor(c)); err != nil {
+		return h.scimFail(c, err, "Failed to delete group")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// syncMembers makes the group's direct members and subgroups exactly those
+// in want. Existing members keep their role; new ones join as members.
+func (h *SCIMHandler) syncMembers(ctx context.Context, groupID uuid.UUID, want []scim.GroupMember, actor string) error {
+	current, err := h.groupMembers(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	have := make(map[uuid.UUID]string, len(current))
+	for _, member := range current {
+		have[member.ID] = member.Type
+	}
+
+	wanted := make(map[uuid.UUID]bool, len(want))
+	for _, member := range want {
+		wanted[member.ID] = true
+		if _, ok := have[member.ID]; ok {
+			continue
+		}
+
+		kind, err := h.memberType(ctx, member)
+		if err != nil {
+			return err
+		}
+		if kind == scim.MemberTypeGroup {
+			err = h.groupDAO.AddSubgroup(ctx, groupID, member.ID, actor)
+		} else {
+			_, _, err = h.groupDAO.SetMember(ctx, groupID, member.ID, models.GroupRoleMember, actor)
+		}
+		if err != nil {
+			return err
+		}
+		have[member.ID] = kind
+	}
+
+	for _, member := range current {
+		if wanted[member.ID] {
+			continue
+		}
+		if member.Type == scim.MemberTypeGroup {
+			err = h.groupDAO.RemoveSubgroup(ctx, groupID, member.ID, actor)
+		} else {
+			err = h.groupDAO.RemoveMember(ctx, groupID, member.ID, actor)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memberType returns whether a member names a user or a group, looking it
+// up when the request did not say. It fails with a SCIM error if the
+// member does not exist.
+func (h *SCIMHandler) memberType(ctx context.Context, member scim.GroupMember) (string, error) {
+	if member.Type != scim.MemberTypeGroup {
+		if _, err := h.userDAO.GetByID(ctx, member.ID); err == nil {
+			return scim.MemberTypeUser, nil
+		} else if err.Error() != "user not found" {
+			return "", err
+		}
+	}
+	if member.Type != scim.MemberTypeUser {
+		if _, err := h.groupDAO.GetByID(ctx, member.ID); err == nil {
+			return scim.MemberTypeGroup, nil
+		} else if err.Error() != "group not found" {
+			return "", err
+		}
+	}
+	return "", scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, "Member "+member.ID.String()+" not found")
+}
+
+// groupMembers returns the group's direct members followed by its
+// subgroups.
+func (h *SCIMHandler) groupMembers(ctx context.Context, groupID uuid.UUID) ([]scim.GroupMember, error) {
+	var members []scim.GroupMember
+	for offset := 0; ; offset += scimPageSize {
+		page, err := h.groupDAO.GetMembers(ctx, groupID, false, scimPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range page {
+			members = append(members, scim.GroupMember{ID: member.UserID, Type: scim.MemberTypeUser})
+		}
+		if len(page) < scimPageSize {
+			break
+		}
+	}
+
+	subgroups, err := h.groupDAO.GetSubgroups(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	for _, subgroup := range subgroups {
+		members = append(members, scim.GroupMember{ID: subgroup.ID, Type: scim.MemberTypeGroup, Display: subgroup.Name})
+	}
+	return members, nil
+}
+
+func (h *SCIMHandler) userResource(ctx context.Context, c *fiber.Ctx, user *models.User) (scim.Resource, error) {
+	externalID, err := h.scimDAO.GetExternalID(ctx, scim.MemberTypeUser, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := h.groupDAO.GetUserGroups(ctx, user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	return scim.UserResource(user, externalID, groups, scimLocation(c, "Users", user.ID)), nil
+}
+
+func (h *SCIMHandler) groupResource(ctx context.Context, c *fiber.Ctx, group *models.Group) (scim.Resource, error) {
+	externalID, err := h.scimDAO.GetExternalID(ctx, scim.MemberTypeGroup, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := h.groupMembers(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	return scim.GroupResource(group, externalID, members, scimLocation(c, "Groups", group.ID)), nil
+}
+
+// scimUser reads the user a path parameter names. IDs that do not parse
+// name no user.
+func (h *SCIMHandler) scimUser(ctx context.Context, param string) (*models.User, error) {
+	id, err := uuid.Parse(param)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	return h.userDAO.GetByID(ctx, id)
+}
+
+func (h *SCIMHandler) scimGroup(ctx context.Context, param string) (*models.Group, error) {
+	id, err := uuid.Parse(param)
+	if err != nil {
+		return nil, errors.New("group not found")
+	}
+	return h.groupDAO.GetByID(ctx, id)
+}
+
+// userChanged refreshes the caches after a user was provisioned or changed.
+func (h *SCIMHandler) userChanged(ctx context.Context, user *models.User) {
+	if err := h.cacheDAO.SetUser(ctx, user); err != nil {
+		h.logger.Warn("Failed to cache user", "error", err, "user_id", user.ID)
+	}
+	if err := h.cacheDAO.InvalidateUsersList(ctx); err != nil {
+		h.logger.Warn("Failed to invalidate users list cache", "error", err)
+	}
+}
+
+// scimFail answers with the SCIM error err maps to.
+func (h *SCIMHandler) scimFail(c *fiber.Ctx, err error, message string) error {
+	var scimErr *scim.Error
+	if errors.As(err, &scimErr) {
+		return scimError(c, scimErr)
+	}
+
+	var duplicate *dao.DuplicateError
+	if errors.As(err, &duplicate) {
+		return scimError(c, scim.NewError(fiber.StatusConflict, scim.ErrorUniqueness,
+			"User with this "+duplicate.Field+" already exists"))
+	}
+	var conflict *dao.ConflictError
+	if errors.As(err, &conflict) {
+		return scimError(c, scim.NewError(fiber.StatusConflict, "", "User has been modified by another request"))
+	}
+	var cooldown *dao.UsernameCooldownError
+	if errors.As(err, &cooldown) {
+		return scimError(c, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, cooldown.Error()))
+	}
+	var transition *dao.TransitionError
+	if errors.As(err, &transition) {
+		return scimError(c, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, transition.Error()))
+	}
+
+	switch err.Error() {
+	case "user not found":
+		return scimError(c, scim.NewError(fiber.StatusNotFound, "", "User not found"))
+	case "group not found":
+		return scimError(c, scim.NewError(fiber.StatusNotFound, "", "Group not found"))
+	case "group name already exists":
+		return scimError(c, scim.NewError(fiber.StatusConflict, scim.ErrorUniqueness, "A group with this displayName already exists"))
+	case "subgroup would create a cycle":
+		return scimError(c, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidValue, "Group membership would create a cycle"))
+	}
+
+	h.logger.Error(message, "error", err)
+	return scimError(c, scim.NewError(fiber.StatusInternalServerError, "", message))
+}
+
+func scimJSON(c *fiber.Ctx, status int, body interface{}) error {
+	return c.Status(status).JSON(body, scim.ContentType)
+}
+
+func scimError(c *fiber.Ctx, err error) error {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		scimErr = scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidSyntax, err.Error())
+	}
+	return scimJSON(c, scimErr.Status, scimErr.Body())
+}
+
+// scimBody decodes the request body as a resource.
+func scimBody(c *fiber.Ctx) (scim.Resource, error) {
+	var r scim.Resource
+	if err := json.Unmarshal(c.Body(), &r); err != nil || r == nil {
+		return nil, scim.NewError(fiber.StatusBadRequest, scim.ErrorInvalidSyntax, "Invalid request body")
+	}
+	return r, nil
+}
+
+func scimBaseURL(c *fiber.Ctx) string {
+	return c.BaseURL() + "/scim/v2"
+}
+
+func scimLocation(c *fiber.Ctx, endpoint string, id uuid.UUID) string {
+	return scimBaseURL(c) + "/" + endpoint + "/" + id.String()
+}
+
+func scimToken(c *fiber.Ctx) *models.SCIMToken {
+	token, _ := c.Locals(middleware.SCIMTokenLocal).(*models.SCIMToken)
+	return token
+}
+
+// scimActor names the token a change was made with in the audit log.
+func scimActor(c *fiber.Ctx) string {
+	return "scim:" + scimToken(c).ID.String()
+}
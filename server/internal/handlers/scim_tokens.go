@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+func (h *SCIMHandler) GetTokens(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	tokens, err := h.scimDAO.GetTokens(ctx)
+	if err != nil {
+		h.logger.Error("Failed to get SCIM tokens", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve SCIM tokens",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"tokens": tokens,
+	})
+}
+
+// CreateToken issues a SCIM token for the organization. The secret is only
+// returned in this response.
+func (h *SCIMHandler) CreateToken(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	var req models.CreateSCIMTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	token := &models.SCIMToken{Name: strings.TrimSpace(req.Name)}
+	if err := models.ValidateSCIMToken(token); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	token, secret, err := h.scimDAO.CreateToken(ctx, token.Name, requestActor(c))
+	if err != nil {
+		h.logger.Error("Failed to create SCIM token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to create SCIM token",
+		})
+	}
+
+	h.logger.Info("SCIM token created", "scim_token_id", token.ID, "organization_id", token.TenantID)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"token":  token,
+		"secret": secret,
+	})
+}
+
+func (h *SCIMHandler) RevokeToken(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid SCIM token ID",
+		})
+	}
+
+	token, err := h.scimDAO.RevokeToken(ctx, id, requestActor(c))
+	if err != nil {
+		if err.Error() == "scim token not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "SCIM token not found",
+			})
+		}
+		h.logger.Error("Failed to revoke SCIM token", "error", err, "scim_token_id", id)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to revoke SCIM token",
+		})
+	}
+
+	h.logger.Info("SCIM token revoked", "scim_token_id", token.ID)
+
+	return c.JSON(token)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func (h *UserHandler) GetDeletedUsers(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	// Parse query parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	offset := (page - 1) * limit
+
+	users, err := h.userDAO.GetDeleted(ctx, limit, offset)
+	if err != nil {
+		h.logger.Error("Failed to get deleted users", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve deleted users",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"users": users,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+func (h *UserHandler) RestoreUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	idStr := c.Params("id")
+	userID, err := uuid.Parse(idStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	user, err := h.userDAO.Restore(ctx, userID, requestActor(c))
+	if err != nil {
+		h.logger.Error("Failed to restore user", "error", err, "user_id", userID)
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "Deleted user not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to restore user",
+		})
+	}
+
+	// Cache the restored user
+	if err := h.cacheDAO.SetUser(ctx, user); err != nil {
+		h.logger.Warn("Failed to cache restored user", "error", err, "user_id", userID)
+	}
+	if err := h.cacheDAO.InvalidateUsersList(ctx); err != nil {
+		h.logger.Warn("Failed to invalidate users list cache", "error", err)
+	}
+
+	h.logger.Info("User restored successfully", "user_id", userID)
+
+	c.Set(fiber.HeaderETag, userETag(user))
+
+	return c.JSON(fiber.Map{
+		"user": user,
+	})
+}
+
+func (h *UserHandler) PurgeUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	idStr := c.Params("id")
+	userID, err := uuid.Parse(idStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	// Only soft-deleted users can be purged
+	if err := h.userDAO.Purge(ctx, userID, requestActor(c)); err != nil {
+		h.logger.Error("Failed to purge user", "error", err, "user_id", userID)
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "Deleted user not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to purge user",
+		})
+	}
+
+	if err := h.cacheDAO.DeleteUser(ctx, userID.String()); err != nil {
+		h.logger.Warn("Failed to invalidate user cache", "error", err, "user_id", userID)
+	}
+
+	h.logger.Info("User purged successfully", "user_id", userID)
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
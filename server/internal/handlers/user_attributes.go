@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/jsonschema"
+)
+
+// maxInvalidUserSample bounds the user IDs reported when a new attribute
+// schema would reject existing users.
+const maxInvalidUserSample = 20
+
+// attributeValidator checks user attributes against the current schema,
+// recompiling it only when its version changes.
+type attributeValidator struct {
+	userDAO *dao.UserDAO
+
+	mu      sync.Mutex
+	version int64
+	schema  *jsonschema.Schema
+}
+
+func newAttributeValidator(userDAO *dao.UserDAO) *attributeValidator {
+	return &attributeValidator{userDAO: userDAO}
+}
+
+// validate returns a *jsonschema.ValidationError if attributes do not satisfy
+// the current schema. Without a schema any object is accepted.
+func (v *attributeValidator) validate(ctx context.Context, attributes map[string]interface{}) error {
+	current, err := v.userDAO.GetAttributeSchema(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return nil
+	}
+
+	v.mu.Lock()
+	if v.schema == nil || v.version != current.Version {
+		schema, err := jsonschema.Compile(current.Schema)
+		if err != nil {
+			v.mu.Unlock()
+			return fmt.Errorf("stored attribute schema %d is invalid: %w", current.Version, err)
+		}
+		v.schema, v.version = schema, current.Version
+	}
+	schema := v.schema
+	v.mu.Unlock()
+
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+	return schema.Validate(attributes)
+}
+
+// checkAttributes validates attributes and writes a 422 listing the issues
+// if they are invalid. It reports whether the request may proceed.
+func (h *UserHandler) checkAttributes(ctx context.Context, c *fiber.Ctx, attributes map[string]interface{}) (bool, error) {
+	err := h.attributes.validate(ctx, attributes)
+	if err == nil {
+		return true, nil
+	}
+
+	var invalid *jsonschema.ValidationError
+	if errors.As(err, &invalid) {
+		return false, c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": "Attributes do not match the attribute schema",
+			"issues":  invalid.Issues,
+		})
+	}
+
+	h.logger.Error("Failed to validate attributes", "error", err)
+	return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   true,
+		"message": "Failed to validate attributes",
+	})
+}
+
+func (h *UserHandler) GetAttributeSchema(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	schema, err := h.userDAO.GetAttributeSchema(ctx)
+	if err != nil {
+		h.logger.Error("Failed to get attribute schema", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve attribute schema",
+		})
+	}
+	if schema == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "No attribute schema has been set",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"schema": schema,
+	})
+}
+
+// SetAttributeSchema replaces the attribute schema with the request body.
+// If existing users would not satisfy the new schema it is refused with the
+// number of such users, unless force=true is given.
+func (h *UserHandler) SetAttributeSchema(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 60*time.Second)
+	defer cancel()
+
+	body := c.Body()
+	if !json.Valid(body) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	schema, err := jsonschema.Compile(body)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	if !c.QueryBool("force") {
+		invalid := 0
+		sample := make([]uuid.UUID, 0, maxInvalidUserSample)
+		err := h.userDAO.ForEachUserAttributes(ctx, func(id uuid.UUID, attributes map[string]interface{}) error {
+			if schema.Validate(attributes) != nil {
+				invalid++
+				if len(sample) < maxInvalidUserSample {
+					sample = append(sample, id)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			h.logger.Error("Failed to check existing attributes", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   true,
+				"message": "Failed to check existing users against the schema",
+			})
+		}
+		if invalid > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":         true,
+				"message":       "Existing users do not match the schema; pass force=true to apply it anyway",
+				"invalid_users": invalid,
+				"sample":        sample,
+			})
+		}
+	}
+
+	stored, err := h.userDAO.SetAttributeSchema(ctx, body, requestActor(c))
+	if err != nil {
+		h.logger.Error("Failed to set attribute schema", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to set attribute schema",
+		})
+	}
+
+	h.logger.Info("Attribute schema updated", "version", stored.Version, "actor", requestActor(c))
+
+	return c.JSON(fiber.Map{
+		"schema": stored,
+	})
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/jsonschema"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// maxBatchOperations bounds the work a single batch request can queue.
+const maxBatchOperations = 100
+
+// errBatchAborted rolls back an atomic batch after an operation fails.
+var errBatchAborted = errors.New("batch aborted")
+
+func (h *UserHandler) BatchUsers(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 30*time.Second)
+	defer cancel()
+
+	var req models.BatchUsersRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+	}
+
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchOperations {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": fmt.Sprintf("A batch must contain between 1 and %d operations", maxBatchOperations),
+		})
+	}
+
+	actor := requestActor(c)
+	results := make([]models.BatchOperationResult, len(req.Operations))
+	touched := make([]string, 0, len(req.Operations))
+	status := fiber.StatusOK
+
+	if req.Atomic {
+		failed := -1
+		err := h.userDAO.InTx(ctx, func(tx *dao.UserDAO) error {
+			for i, op := range req.Operations {
+				results[i] = h.applyBatchOperation(ctx, tx, i, op, actor)
+				if results[i].Status >= 400 {
+					failed = i
+					return errBatchAborted
+				}
+			}
+			return nil
+		})
+
+		if err != nil {
+			if failed < 0 {
+				h.logger.Error("Failed to commit user batch", "error", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error":   true,
+					"message": "Failed to commit batch",
+				})
+			}
+
+			// Nothing was persisted; report every other operation accordingly
+			for i, op := range req.Operations {
+				switch {
+				case i < failed:
+					results[i] = models.BatchOperationResult{Index: i, Op: op.Op, Status: fiber.StatusFailedDependency,
+						ID: results[i].ID, Error: "rolled back"}
+				case i > failed:
+					results[i] = models.BatchOperationResult{Index: i, Op: op.Op, Status: fiber.StatusFailedDependency,
+						Error: "not executed"}
+				}
+			}
+			status = results[failed].Status
+		} else {
+			for _, result := range results {
+				touched = append(touched, result.ID.String())
+			}
+		}
+	} else {
+		for i, op := range req.Operations {
+			results[i] = h.applyBatchOperation(ctx, h.userDAO, i, op, actor)
+			if results[i].Status < 400 {
+				touched = append(touched, results[i].ID.String())
+			}
+		}
+	}
+
+	// Invalidate cache once for the whole batch
+	if len(touched) > 0 {
+		if err := h.cacheDAO.DeleteUser(ctx, touched...); err != nil {
+			h.logger.Warn("Failed to invalidate user cache", "error", err)
+		}
+		if err := h.cacheDAO.InvalidateUsersList(ctx); err != nil {
+			h.logger.Warn("Failed to invalidate users list cache", "error", err)
+		}
+	}
+
+	h.logger.Info("User batch processed", "operations", len(req.Operations), "applied", len(touched), "atomic", req.Atomic)
+
+	return c.Status(status).JSON(fiber.Map{
+		"atomic":  req.Atomic,
+		"results": results,
+	})
+}
+
+// applyBatchOperation runs one operation with userDAO, which may be bound to
+// a transaction, and describes the outcome with an HTTP status code.
+func (h *UserHandler) applyBatchOperation(ctx context.Context, userDAO *dao.UserDAO, index int, op models.BatchOperation, actor string) models.BatchOperationResult {
+	result := models.BatchOperationResult{Index: index, Op: op.Op, ID: op.ID}
+
+	fail := func(status int, err error) models.BatchOperationResult {
+		result.Status = status
+		result.Error = err.Error()
+		var conflict *dao.ConflictError
+		if errors.As(err, &conflict) {
+			result.Error = "User has been modified by another request"
+			result.User = conflict.Current
+		}
+		var duplicate *dao.DuplicateError
+		if errors.As(err, &duplicate) {
+			result.Field = duplicate.Field
+		}
+		return result
+	}
+
+	switch op.Op {
+	case models.BatchOpCreate:
+		var req models.CreateUserRequest
+		if err := json.Unmarshal(op.User, &req); err != nil {
+			return fail(fiber.StatusBadRequest, fmt.Errorf("invalid user: %w", err))
+		}
+		user := &models.User{
+			Email:     req.Email,
+			Username:  req.Username,
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+		}
+		if err := models.ValidateUser(user); err != nil {
+			return fail(fiber.StatusUnprocessableEntity, err)
+		}
+		if err := models.ValidateUsername(user.Username); err != nil {
+			return fail(fiber.StatusUnprocessableEntity, err)
+		}
+		if err := h.attributes.validate(ctx, req.Attributes); err != nil {
+			return fail(attributeErrorStatus(err), err)
+		}
+		user.Attributes = req.Attributes
+		if existing, err := userDAO.GetByEmail(ctx, req.Email); err == nil && existing != nil {
+			return fail(fiber.StatusConflict, &dao.DuplicateError{Field: "email"})
+		}
+		if err := userDAO.Create(ctx, user, actor); err != nil {
+			var duplicate *dao.DuplicateError
+			if errors.As(err, &duplicate) {
+				return fail(fiber.StatusConflict, duplicate)
+			}
+			h.logger.Error("Failed to create user in batch", "error", err, "index", index)
+			return fail(fiber.StatusInternalServerError, fmt.Errorf("failed to create user"))
+		}
+		result.Status = fiber.StatusCreated
+		result.ID = &user.ID
+		result.User = user
+		return result
+
+	case models.BatchOpUpdate:
+		if op.ID == nil || op.Version == nil {
+			return fail(fiber.StatusBadRequest, fmt.Errorf("update requires id and version"))
+		}
+		var req models.UpdateUserRequest
+		if err := json.Unmarshal(op.User, &req); err != nil {
+			return fail(fiber.StatusBadRequest, fmt.Errorf("invalid user: %w", err))
+		}
+		updates := userUpdates(&req)
+		if len(updates) == 0 {
+			return fail(fiber.StatusBadRequest, fmt.Errorf("no updates provided"))
+		}
+
+		// Validate the user as it would look after the update
+		current, err := userDAO.GetByID(ctx, *op.ID)
+		if err != nil {
+			return fail(batchErrorStatus(err), err)
+		}
+		merged := *current
+		if req.Email != nil {
+			merged.Email = *req.Email
+		}
+		if req.Username != nil {
+			merged.Username = *req.Username
+		}
+		if req.FirstName != nil {
+			merged.FirstName = *req.FirstName
+		}
+		if req.LastName != nil {
+			merged.LastName = *req.LastName
+		}
+		if err := models.ValidateUser(&merged); err != nil {
+			return fail(fiber.StatusUnprocessableEntity, err)
+		}
+		if req.Username != nil && *req.Username != current.Username {
+			if err := models.ValidateUsername(*req.Username); err != nil {
+				return fail(fiber.StatusUnprocessableEntity, err)
+			}
+		}
+		if req.Attributes != nil {
+			if err := h.attributes.validate(ctx, req.Attributes); err != nil {
+				return fail(attributeErrorStatus(err), err)
+			}
+		}
+
+		if err := userDAO.Update(ctx, *op.ID, *op.Version, updates, actor); err != nil {
+			return fail(batchErrorStatus(err), err)
+		}
+		user, err := userDAO.GetByID(ctx, *op.ID)
+		if err != nil {
+			return fail(batchErrorStatus(err), err)
+		}
+		result.Status = fiber.StatusOK
+		result.User = user
+		return result
+
+	case models.BatchOpDelete:
+		if op.ID == nil || op.Version == nil {
+			return fail(fiber.StatusBadRequest, fmt.Errorf("delete requires id and version"))
+		}
+		if err := userDAO.Delete(ctx, *op.ID, *op.Version, actor); err != nil {
+			return fail(batchErrorStatus(err), err)
+		}
+		result.Status = fiber.StatusNoContent
+		return result
+
+	default:
+		return fail(fiber.StatusBadRequest, fmt.Errorf("unknown op %q", op.Op))
+	}
+}
+
+func attributeErrorStatus(err error) int {
+	var invalid *jsonschema.ValidationError
+	if errors.As(err, &invalid) {
+		return fiber.StatusUnprocessableEntity
+	}
+	return fiber.StatusInternalServerError
+}
+
+func batchErrorStatus(err error) int {
+	var conflict *dao.ConflictError
+	var duplicate *dao.DuplicateError
+	var cooldown *dao.UsernameCooldownError
+	switch {
+	case errors.As(err, &conflict), errors.As(err, &duplicate):
+		return fiber.StatusConflict
+	case errors.As(err, &cooldown):
+		return fiber.StatusTooManyRequests
+	case err.Error() == "user not found":
+		return fiber.StatusNotFound
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// attributeParamPrefix marks query parameters that filter on attributes,
+// e.g. attr.department=sales.
+const attributeParamPrefix = "attr."
+
+// parseUserFilter reads the listing filters from the query string:
+// status, q, created_after and created_before (RFC 3339), and attr.<name>.
+func parseUserFilter(c *fiber.Ctx) (models.UserFilter, error) {
+	filter := models.UserFilter{
+		Status: models.UserStatus(c.Query("status")),
+		Query:  strings.TrimSpace(c.Query("q")),
+	}
+
+	switch filter.Status {
+	case "", models.UserStatusPending, models.UserStatusActive, models.UserStatusSuspended, models.UserStatusDeleted:
+	default:
+		return filter, fmt.Errorf("invalid status %q", filter.Status)
+	}
+
+	for param, dst := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+		}
+		*dst = &t
+	}
+
+	for param, value := range c.Queries() {
+		name, ok := strings.CutPrefix(param, attributeParamPrefix)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			return filter, fmt.Errorf("attribute filter %q needs a name", param)
+		}
+		if filter.Attributes == nil {
+			filter.Attributes = make(map[string]string)
+		}
+		filter.Attributes[name] = value
+	}
+
+	return filter, nil
+}
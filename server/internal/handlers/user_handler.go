@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"time"
 
@@ -13,21 +14,23 @@ import (
 )
 
 type UserHandler struct {
-	userDAO  *dao.UserDAO
-	cacheDAO *dao.CacheDAO
-	logger   *logger.Logger
+	userDAO    *dao.UserDAO
+	cacheDAO   *dao.CacheDAO
+	logger     *logger.Logger
+	attributes *attributeValidator
 }
 
 func NewUserHandler(userDAO *dao.UserDAO, cacheDAO *dao.CacheDAO, logger *logger.Logger) *UserHandler {
 	return &UserHandler{
-		userDAO:  userDAO,
-		cacheDAO: cacheDAO,
-		logger:   logger,
+		userDAO:    userDAO,
+		cacheDAO:   cacheDAO,
+		logger:     logger,
+		attributes: newAttributeValidator(userDAO),
 	}
 }
 
 func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
 	defer cancel()
 
 	// Parse query parameters
@@ -43,18 +46,31 @@ func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 
 	offset := (page - 1) * limit
 
-	// Try to get from cache first
-	if users, err := h.cacheDAO.GetUsers(ctx, page, limit); err == nil {
-		h.logger.Debug("Users retrieved from cache", "page", page, "limit", limit)
-		return c.JSON(fiber.Map{
-			"users": users,
-			"page":  page,
-			"limit": limit,
+	filter, err := parseUserFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
 		})
 	}
 
+	// Try to get from cache first; only the unfiltered listing is cached
+	if filter.IsZero() {
+		if users, err := h.cacheDAO.GetUsers(ctx, page, limit); err == nil {
+			h.logger.Debug("Users retrieved from cache", "page", page, "limit", limit)
+			if notModified(c, usersETag(users, page, limit)) {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+			return c.JSON(fiber.Map{
+				"users": users,
+				"page":  page,
+				"limit": limit,
+			})
+		}
+	}
+
 	// Get from database
-	users, err := h.userDAO.GetAll(ctx, limit, offset)
+	users, err := h.userDAO.GetAll(ctx, filter, limit, offset)
 	if err != nil {
 		h.logger.Error("Failed to get users", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -64,8 +80,14 @@ func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 	}
 
 	// Cache the result
-	if err := h.cacheDAO.SetUsers(ctx, users, page, limit); err != nil {
-		h.logger.Warn("Failed to cache users", "error", err)
+	if filter.IsZero() {
+		if err := h.cacheDAO.SetUsers(ctx, users, page, limit); err != nil {
+			h.logger.Warn("Failed to cache users", "error", err)
+		}
+	}
+
+	if notModified(c, usersETag(users, page, limit)) {
+		return c.SendStatus(fiber.StatusNotModified)
 	}
 
 	return c.JSON(fiber.Map{
@@ -76,7 +98,7 @@ func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 }
 
 func (h *UserHandler) GetUser(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
 	defer cancel()
 
 	idStr := c.Params("id")
@@ -88,9 +110,16 @@ func (h *UserHandler) GetUser(c *fiber.Ctx) error {
 		})
 	}
 
+	if asOf := c.Query("as_of"); asOf != "" {
+		return h.getUserAsOf(ctx, c, userID, asOf)
+	}
+
 	// Try cache first
 	if user, err := h.cacheDAO.GetUser(ctx, userID.String()); err == nil {
 		h.logger.Debug("User retrieved from cache", "user_id", userID)
+		if notModified(c, userETag(user)) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
 		return c.JSON(fiber.Map{
 			"user": user,
 		})
@@ -117,13 +146,17 @@ func (h *UserHandler) GetUser(c *fiber.Ctx) error {
 		h.logger.Warn("Failed to cache user", "error", err, "user_id", userID)
 	}
 
+	if notModified(c, userETag(user)) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
 	return c.JSON(fiber.Map{
 		"user": user,
 	})
 }
 
 func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
 	defer cancel()
 
 	var req models.CreateUserRequest
@@ -141,25 +174,37 @@ func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
 			"message": "All fields are required",
 		})
 	}
+	if err := models.ValidateUsername(req.Username); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	if ok, err := h.checkAttributes(ctx, c, req.Attributes); !ok {
+		return err
+	}
 
 	// Check if user already exists
 	if existingUser, err := h.userDAO.GetByEmail(ctx, req.Email); err == nil && existingUser != nil {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-			"error":   true,
-			"message": "User with this email already exists",
-		})
+		return duplicateConflict(c, &dao.DuplicateError{Field: "email"})
 	}
 
 	// Create user
 	user := &models.User{
-		Email:     req.Email,
-		Username:  req.Username,
-		FirstName: req.FirstName,
-		LastName:  req.LastName,
+		Email:      req.Email,
+		Username:   req.Username,
+		FirstName:  req.FirstName,
+		LastName:   req.LastName,
+		Attributes: req.Attributes,
 	}
 
-	if err := h.userDAO.Create(ctx, user); err != nil {
+	if err := h.userDAO.Create(ctx, user, requestActor(c)); err != nil {
 		h.logger.Error("Failed to create user", "error", err)
+		var duplicate *dao.DuplicateError
+		if errors.As(err, &duplicate) {
+			return duplicateConflict(c, duplicate)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   true,
 			"message": "Failed to create user",
@@ -178,13 +223,15 @@ func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
 
 	h.logger.Info("User created successfully", "user_id", user.ID, "email", user.Email)
 
+	c.Set(fiber.HeaderETag, userETag(user))
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"user": user,
 	})
 }
 
 func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
 	defer cancel()
 
 	idStr := c.Params("id")
@@ -205,22 +252,7 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 	}
 
 	// Build updates map
-	updates := make(map[string]interface{})
-	if req.Email != nil {
-		updates["email"] = *req.Email
-	}
-	if req.Username != nil {
-		updates["username"] = *req.Username
-	}
-	if req.FirstName != nil {
-		updates["first_name"] = *req.FirstName
-	}
-	if req.LastName != nil {
-		updates["last_name"] = *req.LastName
-	}
-	if req.IsActive != nil {
-		updates["is_active"] = *req.IsActive
-	}
+	updates := userUpdates(&req)
 
 	if len(updates) == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -229,9 +261,61 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Check the client's precondition against the current state
+	current, err := h.userDAO.GetByID(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to get user", "error", err, "user_id", userID)
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve user",
+		})
+	}
+	if ok, err := checkIfMatch(c, current); !ok {
+		return err
+	}
+
+	// Existing usernames predate the naming policy; only new ones must follow it
+	if req.Username != nil && *req.Username != current.Username {
+		if err := models.ValidateUsername(*req.Username); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error":   true,
+				"message": err.Error(),
+			})
+		}
+	}
+
+	if req.Attributes != nil {
+		if ok, err := h.checkAttributes(ctx, c, req.Attributes); !ok {
+			return err
+		}
+	}
+
+	version := current.Version
+	if req.Version != nil {
+		version = *req.Version
+	}
+
 	// Update user
-	if err := h.userDAO.Update(ctx, userID, updates); err != nil {
+	if err := h.userDAO.Update(ctx, userID, version, updates, requestActor(c)); err != nil {
 		h.logger.Error("Failed to update user", "error", err, "user_id", userID)
+		var conflict *dao.ConflictError
+		if errors.As(err, &conflict) {
+			return versionConflict(c, conflict)
+		}
+		var duplicate *dao.DuplicateError
+		if errors.As(err, &duplicate) {
+			return duplicateConflict(c, duplicate)
+		}
+		var cooldown *dao.UsernameCooldownError
+		if errors.As(err, &cooldown) {
+			return usernameCooldown(c, cooldown)
+		}
 		if err.Error() == "user not found" {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error":   true,
@@ -269,13 +353,15 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 
 	h.logger.Info("User updated successfully", "user_id", userID)
 
+	c.Set(fiber.HeaderETag, userETag(user))
+
 	return c.JSON(fiber.Map{
 		"user": user,
 	})
 }
 
 func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
 	defer cancel()
 
 	idStr := c.Params("id")
@@ -287,9 +373,32 @@ func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Check the client's precondition against the current state
+	current, err := h.userDAO.GetByID(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to get user", "error", err, "user_id", userID)
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve user",
+		})
+	}
+	if ok, err := checkIfMatch(c, current); !ok {
+		return err
+	}
+
 	// Delete user (soft delete)
-	if err := h.userDAO.Delete(ctx, userID); err != nil {
+	if err := h.userDAO.Delete(ctx, userID, current.Version, requestActor(c)); err != nil {
 		h.logger.Error("Failed to delete user", "error", err, "user_id", userID)
+		var conflict *dao.ConflictError
+		if errors.As(err, &conflict) {
+			return versionConflict(c, conflict)
+		}
 		if err.Error() == "user not found" {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error":   true,
@@ -314,3 +423,56 @@ func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusNoContent).Send(nil)
 }
+
+// versionConflict reports a lost compare-and-swap along with the server's
+// current copy of the user so the client can merge and retry.
+func versionConflict(c *fiber.Ctx, conflict *dao.ConflictError) error {
+	c.Set(fiber.HeaderETag, userETag(conflict.Current))
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":   true,
+		"message": "User has been modified by another request",
+		"current": conflict.Current,
+	})
+}
+
+// duplicateConflict reports that a write would reuse another user's email or
+// username, naming the field so clients can point at it.
+func duplicateConflict(c *fiber.Ctx, duplicate *dao.DuplicateError) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":   true,
+		"message": "User with this " + duplicate.Field + " already exists",
+		"field":   duplicate.Field,
+	})
+}
+
+// usernameCooldown reports that the user changed username too recently.
+func usernameCooldown(c *fiber.Ctx, cooldown *dao.UsernameCooldownError) error {
+	retryAfter := int(time.Until(cooldown.RetryAt).Seconds()) + 1
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":    true,
+		"message":  "Username was changed recently",
+		"retry_at": cooldown.RetryAt,
+	})
+}
+
+// userUpdates maps the fields set in req to their database columns.
+func userUpdates(req *models.UpdateUserRequest) map[string]interface{} {
+	updates := make(map[string]interface{})
+	if req.Email != nil {
+		updates["email"] = *req.Email
+	}
+	if req.Username != nil {
+		updates["username"] = *req.Username
+	}
+	if req.FirstName != nil {
+		updates["first_name"] = *req.FirstName
+	}
+	if req.LastName != nil {
+		updates["last_name"] = *req.LastName
+	}
+	if req.Attributes != nil {
+		updates["attributes"] = req.Attributes
+	}
+	return updates
+}
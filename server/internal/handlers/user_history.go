@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetUserHistory lists the recorded writes to a user, newest first, with the
+// old and new value of every field each one changed.
+func (h *UserHandler) GetUserHistory(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	history, err := h.userDAO.GetHistory(ctx, userID, limit, (page-1)*limit)
+	if err != nil {
+		h.logger.Error("Failed to get user history", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve user history",
+		})
+	}
+
+	// Every user has at least the entry for its creation
+	if len(history) == 0 && page == 1 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "User not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"history": history,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+// getUserAsOf answers GET /users/:id?as_of=<RFC 3339 time> with the user as
+// it was at that time, rebuilt from its history.
+func (h *UserHandler) getUserAsOf(ctx context.Context, c *fiber.Ctx, userID uuid.UUID, asOf string) error {
+	at, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "as_of must be an RFC 3339 timestamp",
+		})
+	}
+
+	user, err := h.userDAO.GetAsOf(ctx, userID, at)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found at that time",
+			})
+		}
+		h.logger.Error("Failed to get user history", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve user",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"user":  user,
+		"as_of": at,
+	})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/userimport"
+)
+
+func (h *UserHandler) ImportUsers(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 5*time.Minute)
+	defer cancel()
+
+	formatName := c.Query("format")
+	if formatName == "" {
+		formatName = c.Get(fiber.HeaderContentType)
+	}
+	format, err := userimport.ParseFormat(formatName)
+	if err != nil {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error":   true,
+			"message": "Import format must be text/csv or application/x-ndjson",
+		})
+	}
+
+	dryRun := c.QueryBool("dry_run", false)
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	report, err := userimport.New(h.userDAO).Run(ctx, format, body, dryRun)
+	if err != nil {
+		h.logger.Error("Failed to import users", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	// Invalidate cache for everything the import touched
+	if changed := report.ChangedUserIDs(); len(changed) > 0 {
+		keys := make([]string, len(changed))
+		for i, id := range changed {
+			keys[i] = id.String()
+		}
+		if err := h.cacheDAO.DeleteUser(ctx, keys...); err != nil {
+			h.logger.Warn("Failed to invalidate user cache", "error", err)
+		}
+		if err := h.cacheDAO.InvalidateUsersList(ctx); err != nil {
+			h.logger.Warn("Failed to invalidate users list cache", "error", err)
+		}
+	}
+
+	reportID := uuid.New().String()
+	if err := h.cacheDAO.SetImportReport(ctx, reportID, report); err != nil {
+		h.logger.Warn("Failed to store import report", "error", err, "report_id", reportID)
+	}
+
+	h.logger.Info("Users imported", "report_id", reportID, "dry_run", dryRun, "totals", report.String())
+
+	return c.JSON(fiber.Map{
+		"report_id":  reportID,
+		"dry_run":    report.DryRun,
+		"totals":     report.Totals,
+		"report_url": "/api/v1/admin/users/import/" + reportID + "/report",
+	})
+}
+
+func (h *UserHandler) GetImportReport(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	reportID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid report ID format",
+		})
+	}
+
+	var report userimport.Report
+	if err := h.cacheDAO.GetImportReport(ctx, reportID.String(), &report); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "Import report not found or expired",
+		})
+	}
+
+	if c.Query("format", "csv") == "json" {
+		return c.JSON(report)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		h.logger.Error("Failed to render import report", "error", err, "report_id", reportID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to render import report",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Attachment("import-" + reportID.String() + ".csv")
+	return c.Send(buf.Bytes())
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/patch"
+)
+
+// acceptPatch lists the patch formats PatchUser understands.
+var acceptPatch = patch.MergePatchContentType + ", " + patch.JSONPatchContentType
+
+// patchableUserFields maps the editable members of a user document to their
+// database columns. Every other member is read-only.
+var patchableUserFields = map[string]string{
+	"email":      "email",
+	"username":   "username",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"attributes": "attributes",
+}
+
+func (h *UserHandler) PatchUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	idStr := c.Params("id")
+	userID, err := uuid.Parse(idStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	var apply func(doc, p []byte) ([]byte, error)
+	mediaType := strings.TrimSpace(strings.SplitN(c.Get(fiber.HeaderContentType), ";", 2)[0])
+	switch strings.ToLower(mediaType) {
+	case patch.MergePatchContentType:
+		apply = patch.MergePatch
+	case patch.JSONPatchContentType:
+		apply = patch.JSONPatch
+	default:
+		c.Set("Accept-Patch", acceptPatch)
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error":   true,
+			"message": "Content-Type must be one of " + acceptPatch,
+		})
+	}
+
+	// Check the client's precondition against the current state
+	current, err := h.userDAO.GetByID(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to get user", "error", err, "user_id", userID)
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve user",
+		})
+	}
+	if ok, err := checkIfMatch(c, current); !ok {
+		return err
+	}
+
+	// Apply the patch to the user's JSON representation
+	original, err := json.Marshal(current)
+	if err != nil {
+		h.logger.Error("Failed to encode user", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to patch user",
+		})
+	}
+
+	patched, err := apply(original, c.Body())
+	if err != nil {
+		if errors.Is(err, patch.ErrTestFailed) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":   true,
+				"message": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	// Validate the resulting document before persisting anything
+	updates, err := userPatchUpdates(original, patched)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	if attributes, ok := updates["attributes"]; ok {
+		// Removing attributes clears them
+		if attributes == nil {
+			attributes = map[string]interface{}{}
+			updates["attributes"] = attributes
+		}
+		if ok, err := h.checkAttributes(ctx, c, attributes.(map[string]interface{})); !ok {
+			return err
+		}
+	}
+
+	if len(updates) == 0 {
+		c.Set(fiber.HeaderETag, userETag(current))
+		return c.JSON(fiber.Map{
+			"user": current,
+		})
+	}
+
+	if err := h.userDAO.Update(ctx, userID, current.Version, updates, requestActor(c)); err != nil {
+		h.logger.Error("Failed to patch user", "error", err, "user_id", userID)
+		var conflict *dao.ConflictError
+		if errors.As(err, &conflict) {
+			return versionConflict(c, conflict)
+		}
+		var duplicate *dao.DuplicateError
+		if errors.As(err, &duplicate) {
+			return duplicateConflict(c, duplicate)
+		}
+		var cooldown *dao.UsernameCooldownError
+		if errors.As(err, &cooldown) {
+			return usernameCooldown(c, cooldown)
+		}
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to patch user",
+		})
+	}
+
+	// Invalidate cache
+	if err := h.cacheDAO.DeleteUser(ctx, userID.String()); err != nil {
+		h.logger.Warn("Failed to invalidate user cache", "error", err, "user_id", userID)
+	}
+	if err := h.cacheDAO.InvalidateUsersList(ctx); err != nil {
+		h.logger.Warn("Failed to invalidate users list cache", "error", err)
+	}
+
+	// Get updated user
+	user, err := h.userDAO.GetByID(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to get patched user", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "User patched but failed to retrieve updated data",
+		})
+	}
+
+	// Cache the updated user
+	if err := h.cacheDAO.SetUser(ctx, user); err != nil {
+		h.logger.Warn("Failed to cache patched user", "error", err, "user_id", userID)
+	}
+
+	h.logger.Info("User patched successfully", "user_id", userID)
+
+	c.Set(fiber.HeaderETag, userETag(user))
+
+	return c.JSON(fiber.Map{
+		"user": user,
+	})
+}
+
+// userPatchUpdates compares a patched user document with the original and
+// returns the column updates it implies. Read-only members must be left
+// untouched, editable members that were removed or set to null are cleared,
+// and the result must still be a valid user.
+func userPatchUpdates(original, patched []byte) (map[string]interface{}, error) {
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(original, &before); err != nil {
+		return nil, fmt.Errorf("failed to decode user: %w", err)
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return nil, fmt.Errorf("patched document must be a JSON object")
+	}
+
+	for member, value := range after {
+		if _, ok := patchableUserFields[member]; ok {
+			continue
+		}
+		old, ok := before[member]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", member)
+		}
+		if !reflect.DeepEqual(old, value) {
+			return nil, fmt.Errorf("field %q is read-only", member)
+		}
+	}
+	for member := range before {
+		if _, ok := patchableUserFields[member]; ok {
+			continue
+		}
+		if _, ok := after[member]; !ok {
+			return nil, fmt.Errorf("field %q is read-only", member)
+		}
+	}
+
+	// Absent and null members decode to the zero value, i.e. cleared
+	var user models.User
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	if err := decoder.Decode(&user); err != nil {
+		return nil, fmt.Errorf("invalid user document: %w", err)
+	}
+	if err := models.ValidateUser(&user); err != nil {
+		return nil, err
+	}
+
+	updates := make(map[string]interface{})
+	for member, column := range patchableUserFields {
+		if !reflect.DeepEqual(before[member], after[member]) {
+			updates[column] = after[member]
+		}
+	}
+
+	// Existing usernames predate the naming policy; only new ones must follow it
+	if _, ok := updates["username"]; ok {
+		if err := models.ValidateUsername(user.Username); err != nil {
+			return nil, err
+		}
+	}
+
+	return updates, nil
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/patch"
+)
+
+func (h *UserHandler) GetPreferences(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	// Try cache first
+	if prefs, err := h.cacheDAO.GetPreferences(ctx, userID.String()); err == nil {
+		h.logger.Debug("Preferences retrieved from cache", "user_id", userID)
+		return c.JSON(fiber.Map{
+			"preferences": prefs,
+		})
+	}
+
+	overrides, err := h.userDAO.GetPreferences(ctx, userID)
+	if err != nil {
+		return h.preferencesError(c, err, userID, "Failed to retrieve preferences")
+	}
+
+	prefs := overrides.Apply()
+	if err := h.cacheDAO.SetPreferences(ctx, userID.String(), &prefs); err != nil {
+		h.logger.Warn("Failed to cache preferences", "error", err, "user_id", userID)
+	}
+
+	return c.JSON(fiber.Map{
+		"preferences": prefs,
+	})
+}
+
+// UpdatePreferences merges the request body into the user's chosen settings
+// following JSON Merge Patch: members that are present are set, members set
+// to null go back to the server default, and absent members are unchanged.
+func (h *UserHandler) UpdatePreferences(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	// The patch is applied while the user is locked, so that concurrent
+	// updates of different settings do not undo each other
+	updated, err := h.userDAO.UpdatePreferences(ctx, userID, func(overrides *models.PreferenceOverrides) (*models.PreferenceOverrides, error) {
+		return mergePreferences(overrides, c.Body())
+	}, requestActor(c))
+	if err != nil {
+		var invalid *invalidPreferencesError
+		if errors.As(err, &invalid) {
+			return c.Status(invalid.status).JSON(fiber.Map{
+				"error":   true,
+				"message": invalid.message,
+			})
+		}
+		return h.preferencesError(c, err, userID, "Failed to update preferences")
+	}
+
+	// Dropped rather than set, as a concurrent update may have committed
+	// after this one and cached first
+	if err := h.cacheDAO.DeletePreferences(ctx, userID.String()); err != nil {
+		h.logger.Warn("Failed to invalidate preferences cache", "error", err, "user_id", userID)
+	}
+
+	h.logger.Info("Preferences updated successfully", "user_id", userID)
+
+	return c.JSON(fiber.Map{
+		"preferences": updated.Apply(),
+	})
+}
+
+// ResetPreferences returns every setting to the server default.
+func (h *UserHandler) ResetPreferences(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	if err := h.userDAO.SetPreferences(ctx, userID, &models.PreferenceOverrides{}, requestActor(c)); err != nil {
+		return h.preferencesError(c, err, userID, "Failed to reset preferences")
+	}
+
+	if err := h.cacheDAO.DeletePreferences(ctx, userID.String()); err != nil {
+		h.logger.Warn("Failed to invalidate preferences cache", "error", err, "user_id", userID)
+	}
+
+	h.logger.Info("Preferences reset successfully", "user_id", userID)
+
+	return c.JSON(fiber.Map{
+		"preferences": models.DefaultUserPreferences,
+	})
+}
+
+// invalidPreferencesError is a preferences update that the client got wrong.
+type invalidPreferencesError struct {
+	status  int
+	message string
+}
+
+func (e *invalidPreferencesError) Error() string {
+	return e.message
+}
+
+// mergePreferences applies a JSON Merge Patch to the user's chosen settings
+// and validates the result.
+func mergePreferences(overrides *models.PreferenceOverrides, body []byte) (*models.PreferenceOverrides, error) {
+	original, err := json.Marshal(overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode preferences: %w", err)
+	}
+
+	patched, err := patch.MergePatch(original, body)
+	if err != nil {
+		return nil, &invalidPreferencesError{status: fiber.StatusBadRequest, message: err.Error()}
+	}
+
+	var updated models.PreferenceOverrides
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&updated); err != nil {
+		return nil, &invalidPreferencesError{
+			status:  fiber.StatusUnprocessableEntity,
+			message: "Invalid preferences: " + err.Error(),
+		}
+	}
+	if err := updated.Validate(); err != nil {
+		return nil, &invalidPreferencesError{status: fiber.StatusUnprocessableEntity, message: err.Error()}
+	}
+	return &updated, nil
+}
+
+func (h *UserHandler) preferencesError(c *fiber.Ctx, err error, userID uuid.UUID, message string) error {
+	if err.Error() == "user not found" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "User not found",
+		})
+	}
+	h.logger.Error(message, "error", err, "user_id", userID)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   true,
+		"message": message,
+	})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+func TestMergePreferences(t *testing.T) {
+	dark, weekly, yes := "dark", "weekly", true
+	current := &models.PreferenceOverrides{
+		Theme:         &dark,
+		Notifications: &models.NotificationOverrides{Push: &yes, Digest: &weekly},
+	}
+
+	updated, err := mergePreferences(current, []byte(`{"locale": "sv-SE", "notifications": {"digest": null}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated.Theme == nil || *updated.Theme != "dark" {
+		t.Errorf("theme = %v, want it kept", updated.Theme)
+	}
+	if updated.Locale == nil || *updated.Locale != "sv-SE" {
+		t.Errorf("locale = %v, want sv-SE", updated.Locale)
+	}
+	if updated.Notifications == nil || updated.Notifications.Push == nil || updated.Notifications.Digest != nil {
+		t.Errorf("notifications = %+v, want push kept and digest reset", updated.Notifications)
+	}
+	if current.Locale != nil || current.Notifications.Digest == nil {
+		t.Error("the current settings were modified")
+	}
+}
+
+func TestMergePreferencesInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"not JSON", `{"theme":`, fiber.StatusBadRequest},
+		{"unknown setting", `{"colour": "red"}`, fiber.StatusUnprocessableEntity},
+		{"wrong type", `{"theme": 1}`, fiber.StatusUnprocessableEntity},
+		{"invalid value", `{"theme": "purple"}`, fiber.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mergePreferences(&models.PreferenceOverrides{}, []byte(tt.body))
+			var invalid *invalidPreferencesError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("error = %v, want an invalidPreferencesError", err)
+			}
+			if invalid.status != tt.status {
+				t.Errorf("status = %d, want %d", invalid.status, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+func (h *UserHandler) SuspendUser(c *fiber.Ctx) error {
+	return h.changeUserStatus(c, models.UserStatusSuspended)
+}
+
+func (h *UserHandler) ReactivateUser(c *fiber.Ctx) error {
+	return h.changeUserStatus(c, models.UserStatusActive)
+}
+
+func (h *UserHandler) changeUserStatus(c *fiber.Ctx, status models.UserStatus) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	idStr := c.Params("id")
+	userID, err := uuid.Parse(idStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	var req models.ChangeUserStatusRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   true,
+				"message": "Invalid request body",
+			})
+		}
+	}
+
+	if utf8.RuneCountInString(req.Reason) > 500 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Reason must be at most 500 characters",
+		})
+	}
+
+	user, err := h.userDAO.ChangeStatus(ctx, userID, status, req.Reason, requestActor(c))
+	if err != nil {
+		h.logger.Error("Failed to change user status", "error", err, "user_id", userID, "status", status)
+		var transition *dao.TransitionError
+		if errors.As(err, &transition) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":   true,
+				"message": err.Error(),
+			})
+		}
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to change user status",
+		})
+	}
+
+	// Refresh cache
+	if err := h.cacheDAO.SetUser(ctx, user); err != nil {
+		h.logger.Warn("Failed to cache user", "error", err, "user_id", userID)
+	}
+	if err := h.cacheDAO.InvalidateUsersList(ctx); err != nil {
+		h.logger.Warn("Failed to invalidate users list cache", "error", err)
+	}
+
+	h.logger.Info("User status changed successfully", "user_id", userID, "status", status)
+
+	c.Set(fiber.HeaderETag, userETag(user))
+
+	return c.JSON(fiber.Map{
+		"user": user,
+	})
+}
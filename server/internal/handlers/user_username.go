@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetUserByUsername looks a user up by username. A name the user has given
+// up, while still reserved for them, redirects to their current name.
+func (h *UserHandler) GetUserByUsername(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	username, err := url.PathUnescape(c.Params("username"))
+	if err != nil || username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid username",
+		})
+	}
+
+	user, former, err := h.userDAO.GetByUsername(ctx, username)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found",
+			})
+		}
+		h.logger.Error("Failed to get user by username", "error", err, "username", username)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve user",
+		})
+	}
+
+	if former {
+		location := path.Join(path.Dir(c.Path()), url.PathEscape(user.Username))
+		return c.Redirect(location, fiber.StatusMovedPermanently)
+	}
+
+	if notModified(c, userETag(user)) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	return c.JSON(fiber.Map{
+		"user": user,
+	})
+}
+
+func (h *UserHandler) GetUsernameHistory(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+
+	idStr := c.Params("id")
+	userID, err := uuid.Parse(idStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid user ID format",
+		})
+	}
+
+	if _, err := h.userDAO.GetByID(ctx, userID); err != nil {
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   true,
+				"message": "User not found",
+			})
+		}
+		h.logger.Error("Failed to get user", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve user",
+		})
+	}
+
+	history, err := h.userDAO.GetUsernameHistory(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to get username history", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to retrieve username history",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"history": history,
+	})
+}
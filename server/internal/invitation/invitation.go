@@ -0,0 +1,111 @@
+package invitation
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/mailer"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// DeliveryError is returned when an invitation was stored but its email could
+// not be sent. Invitation is the stored invitation, which can be resent.
+type DeliveryError struct {
+	Invitation *models.Invitation
+	Err        error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("failed to deliver invitation: %v", e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// Inviter issues invitations and emails their tokens. The token only ever
+// leaves the server in the email.
+type Inviter struct {
+	invitationDAO *dao.InvitationDAO
+	orgDAO        *dao.OrganizationDAO
+	mailer        mailer.Mailer
+	cfg           config.Invitations
+}
+
+func NewInviter(invitationDAO *dao.InvitationDAO, orgDAO *dao.OrganizationDAO, mailer mailer.Mailer, cfg config.Invitations) *Inviter {
+	return &Inviter{
+		invitationDAO: invitationDAO,
+		orgDAO:        orgDAO,
+		mailer:        mailer,
+		cfg:           cfg,
+	}
+}
+
+// Invite stores invitation and emails it to the invitee.
+func (i *Inviter) Invite(ctx context.Context, invitation *models.Invitation, actor string) (*models.Invitation, error) {
+	token, err := i.invitationDAO.Create(ctx, invitation, i.cfg.TTL, actor)
+	if err != nil {
+		return nil, err
+	}
+	return i.send(ctx, invitation, token)
+}
+
+// Resend emails a pending or expired invitation again with a new token and
+// expiry. The earlier token stops working.
+func (i *Inviter) Resend(ctx context.Context, id uuid.UUID, actor string) (*models.Invitation, error) {
+	invitation, token, err := i.invitationDAO.Renew(ctx, id, i.cfg.TTL, actor)
+	if err != nil {
+		return nil, err
+	}
+	return i.send(ctx, invitation, token)
+}
+
+func (i *Inviter) send(ctx context.Context, invitation *models.Invitation, token string) (*models.Invitation, error) {
+	org, err := i.orgDAO.GetByID(ctx, invitation.TenantID)
+	if err != nil {
+		return nil, &DeliveryError{Invitation: invitation, Err: err}
+	}
+
+	link, err := i.acceptLink(token)
+	if err != nil {
+		return nil, &DeliveryError{Invitation: invitation, Err: err}
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "You have been invited to join %s.\n\n", org.Name)
+	fmt.Fprintf(&body, "Accept or decline the invitation here:\n\n%s\n\n", link)
+	fmt.Fprintf(&body, "The invitation expires on %s. If you were not expecting it, you can ignore this email.\n",
+		invitation.ExpiresAt.UTC().Format("2 January 2006 at 15:04 MST"))
+
+	err = i.mailer.Send(ctx, mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You have been invited to join %s", org.Name),
+		Body:    body.String(),
+	})
+	if err != nil {
+		return nil, &DeliveryError{Invitation: invitation, Err: err}
+	}
+
+	sent, err := i.invitationDAO.MarkSent(ctx, invitation.ID)
+	if err != nil {
+		return nil, err
+	}
+	return sent, nil
+}
+
+// acceptLink adds token to the configured accept URL.
+func (i *Inviter) acceptLink(token string) (string, error) {
+	link, err := url.Parse(i.cfg.AcceptURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid invitations accept URL: %w", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/ldapsync"
+	"github.com/spurge/p4rsec/server/internal/logger"
+)
+
+const ldapSyncActor = "job:ldap-sync"
+
+// LDAPSync syncs the configured organization from its directory on an
+// interval. With dryRun it only reports what it would change.
+type LDAPSync struct {
+	syncer   *ldapsync.Syncer
+	logger   *logger.Logger
+	interval time.Duration
+	dryRun   bool
+}
+
+func NewLDAPSync(syncer *ldapsync.Syncer, logger *logger.Logger, interval time.Duration, dryRun bool) *LDAPSync {
+	return &LDAPSync{
+		syncer:   syncer,
+		logger:   logger,
+		interval: interval,
+		dryRun:   dryRun,
+	}
+}
+
+// Run syncs once immediately and then on every interval until ctx is done.
+// It returns straight away if no directory is configured or interval is not
+// positive.
+func (j *LDAPSync) Run(ctx context.Context) {
+	if !j.syncer.Enabled() || j.interval <= 0 {
+		j.logger.Info("Scheduled LDAP sync disabled")
+		return
+	}
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.sync(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *LDAPSync) sync(ctx context.Context) {
+	ctx = audit.WithMetadata(ctx, audit.Metadata{Actor: ldapSyncActor})
+
+	if _, err := j.syncer.Sync(ctx, j.dryRun, ldapSyncActor); err != nil {
+		if err == ldapsync.ErrRunning {
+			j.logger.Info("Skipping LDAP sync, the previous one is still running")
+			return
+		}
+		j.logger.Error("LDAP sync failed", "error", err)
+	}
+}
@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/audit"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// PurgeDeletedUsers permanently removes users that have been soft-deleted for
+// longer than the configured retention period, one organization at a time.
+type PurgeDeletedUsers struct {
+	userDAO   *dao.UserDAO
+	cacheDAO  *dao.CacheDAO
+	orgDAO    *dao.OrganizationDAO
+	logger    *logger.Logger
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewPurgeDeletedUsers(userDAO *dao.UserDAO, cacheDAO *dao.CacheDAO, orgDAO *dao.OrganizationDAO, logger *logger.Logger, retention, interval time.Duration) *PurgeDeletedUsers {
+	return &PurgeDeletedUsers{
+		userDAO:   userDAO,
+		cacheDAO:  cacheDAO,
+		orgDAO:    orgDAO,
+		logger:    logger,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+// Run purges once immediately and then on every interval until ctx is done.
+// It returns straight away if retention or interval is not positive.
+func (j *PurgeDeletedUsers) Run(ctx context.Context) {
+	if j.retention <= 0 || j.interval <= 0 {
+		j.logger.Info("Deleted user purging disabled")
+		return
+	}
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *PurgeDeletedUsers) purge(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	ctx = audit.WithMetadata(ctx, audit.Metadata{Actor: "job:purge-deleted-users"})
+
+	orgIDs, err := j.orgDAO.GetAllIDs(ctx)
+	if err != nil {
+		j.logger.Error("Failed to get organizations to purge", "error", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-j.retention)
+	for _, orgID := range orgIDs {
+		j.purgeOrganization(tenant.WithID(ctx, orgID), orgID, cutoff)
+	}
+}
+
+func (j *PurgeDeletedUsers) purgeOrganization(ctx context.Context, orgID uuid.UUID, cutoff time.Time) {
+	ids, err := j.userDAO.PurgeDeletedBefore(ctx, cutoff)
+	if err != nil {
+		j.logger.Error("Failed to purge deleted users", "error", err, "organization_id", orgID)
+		return
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	for _, id := range ids {
+		if err := j.cacheDAO.DeleteUser(ctx, id.String()); err != nil {
+			j.logger.Warn("Failed to invalidate user cache", "error", err, "user_id", id)
+		}
+	}
+
+	j.logger.Info("Purged deleted users", "count", len(ids), "cutoff", cutoff, "organization_id", orgID)
+}
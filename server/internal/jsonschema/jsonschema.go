@@ -0,0 +1,444 @@
+// Package jsonschema validates decoded JSON values against a JSON Schema.
+//
+// Only the keywords needed to describe flat profile attributes are
+// supported: type, enum, const, properties, required, additionalProperties,
+// minProperties, maxProperties, minLength, maxLength, pattern, format (email,
+// date, date-time, uri), minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, items, minItems and maxItems. Annotations such as title
+// and description are ignored. Compile rejects keywords that would change
+// the outcome of validation but are not implemented, such as $ref or anyOf,
+// rather than silently accepting everything.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// unsupportedKeywords affect validation but are not implemented.
+var unsupportedKeywords = []string{
+	"$ref", "$dynamicRef", "allOf", "anyOf", "oneOf", "not", "if", "then", "else",
+	"dependentRequired", "dependentSchemas", "patternProperties", "propertyNames",
+	"prefixItems", "contains", "uniqueItems", "multipleOf", "unevaluatedProperties",
+	"unevaluatedItems",
+}
+
+var knownTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Schema is a compiled schema.
+type Schema struct {
+	types                []string
+	enum                 []interface{}
+	constValue           *interface{}
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	noAdditional         bool
+	minProperties        *int
+	maxProperties        *int
+	minLength            *int
+	maxLength            *int
+	pattern              *regexp.Regexp
+	format               string
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	items                *Schema
+	minItems             *int
+	maxItems             *int
+}
+
+// Compile parses a JSON Schema document.
+func Compile(data []byte) (*Schema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	return compile(doc, "#")
+}
+
+func compile(doc interface{}, path string) (*Schema, error) {
+	if b, ok := doc.(bool); ok {
+		// true accepts everything, false nothing
+		if b {
+			return &Schema{}, nil
+		}
+		return &Schema{enum: []interface{}{}}, nil
+	}
+
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", path)
+	}
+
+	for _, keyword := range unsupportedKeywords {
+		if _, ok := m[keyword]; ok {
+			return nil, fmt.Errorf("%s: keyword %q is not supported", path, keyword)
+		}
+	}
+
+	s := &Schema{}
+	var err error
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: must be a string or array of strings", path)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: must be a string or array of strings", path)
+	}
+	for _, name := range s.types {
+		if !knownTypes[name] {
+			return nil, fmt.Errorf("%s/type: unknown type %q", path, name)
+		}
+	}
+
+	if v, ok := m["enum"]; ok {
+		values, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/enum: must be an array", path)
+		}
+		s.enum = values
+	}
+	if v, ok := m["const"]; ok {
+		s.constValue = &v
+	}
+
+	if v, ok := m["properties"]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: must be an object", path)
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = compile(sub, path+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		names, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/required: must be an array of strings", path)
+		}
+		for _, n := range names {
+			name, ok := n.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: must be an array of strings", path)
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	switch v := m["additionalProperties"].(type) {
+	case nil:
+	case bool:
+		s.noAdditional = !v
+	default:
+		if s.additionalProperties, err = compile(v, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+
+	for keyword, dst := range map[string]**int{
+		"minProperties": &s.minProperties,
+		"maxProperties": &s.maxProperties,
+		"minLength":     &s.minLength,
+		"maxLength":     &s.maxLength,
+		"minItems":      &s.minItems,
+		"maxItems":      &s.maxItems,
+	} {
+		if *dst, err = intKeyword(m, keyword, path); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, dst := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+	} {
+		if *dst, err = numberKeyword(m, keyword, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := m["pattern"]; ok {
+		pattern, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", path)
+		}
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", path, err)
+		}
+	}
+	if v, ok := m["format"]; ok {
+		format, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/format: must be a string", path)
+		}
+		switch format {
+		case "email", "date", "date-time", "uri":
+			s.format = format
+		default:
+			return nil, fmt.Errorf("%s/format: unsupported format %q", path, format)
+		}
+	}
+
+	if v, ok := m["items"]; ok {
+		if s.items, err = compile(v, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func intKeyword(m map[string]interface{}, keyword, path string) (*int, error) {
+	v, ok := m[keyword]
+	if !ok {
+		return nil, nil
+	}
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return nil, fmt.Errorf("%s/%s: must be a non-negative integer", path, keyword)
+	}
+	n := int(f)
+	return &n, nil
+}
+
+func numberKeyword(m map[string]interface{}, keyword, path string) (*float64, error) {
+	v, ok := m[keyword]
+	if !ok {
+		return nil, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s: must be a number", path, keyword)
+	}
+	return &f, nil
+}
+
+// Issue is one reason a value does not match the schema. Path is a JSON
+// Pointer to the offending value.
+type Issue struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every issue found in a value.
+type ValidationError struct {
+	Issues []Issue
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		parts[i] = issue.Path + ": " + issue.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Validate checks a value decoded by encoding/json against the schema. It
+// returns a *ValidationError listing every issue, or nil.
+func (s *Schema) Validate(value interface{}) error {
+	var issues []Issue
+	s.validate(value, "", &issues)
+	if len(issues) == 0 {
+		return nil
+	}
+	return &ValidationError{Issues: issues}
+}
+
+func (s *Schema) validate(value interface{}, path string, issues *[]Issue) {
+	report := func(format string, args ...interface{}) {
+		p := path
+		if p == "" {
+			p = "/"
+		}
+		*issues = append(*issues, Issue{Path: p, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.types) > 0 && !s.matchesType(value) {
+		report("must be of type %s", strings.Join(s.types, " or "))
+		return
+	}
+
+	if s.enum != nil && !containsValue(s.enum, value) {
+		if len(s.enum) == 0 {
+			report("no value is allowed")
+		} else {
+			report("must be one of the allowed values")
+		}
+	}
+	if s.constValue != nil && !reflect.DeepEqual(*s.constValue, value) {
+		report("must equal the constant value")
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(v, path, issues, report)
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			report("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			report("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, fmt.Sprintf("%s/%d", path, i), issues)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			report("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			report("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			report("must match pattern %s", s.pattern)
+		}
+		if s.format != "" && !validFormat(s.format, v) {
+			report("must be a valid %s", s.format)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			report("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			report("must be at most %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			report("must be greater than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			report("must be less than %v", *s.exclusiveMaximum)
+		}
+	}
+}
+
+func (s *Schema) validateObject(v map[string]interface{}, path string, issues *[]Issue, report func(string, ...interface{})) {
+	if s.minProperties != nil && len(v) < *s.minProperties {
+		report("must have at least %d properties", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(v) > *s.maxProperties {
+		report("must have at most %d properties", *s.maxProperties)
+	}
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			*issues = append(*issues, Issue{Path: path + "/" + escapePointer(name), Message: "is required"})
+		}
+	}
+
+	// Visit members in a stable order so issues are reported consistently
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		memberPath := path + "/" + escapePointer(name)
+		if sub, ok := s.properties[name]; ok {
+			sub.validate(v[name], memberPath, issues)
+			continue
+		}
+		if s.noAdditional {
+			*issues = append(*issues, Issue{Path: memberPath, Message: "is not an allowed property"})
+			continue
+		}
+		if s.additionalProperties != nil {
+			s.additionalProperties.validate(v[name], memberPath, issues)
+		}
+	}
+}
+
+func (s *Schema) matchesType(value interface{}) bool {
+	for _, t := range s.types {
+		switch t {
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := value.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return true
+			}
+		case "null":
+			if value == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func validFormat(format, value string) bool {
+	switch format {
+	case "email":
+		addr, err := mail.ParseAddress(value)
+		return err == nil && addr.Address == value
+	case "date":
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	case "uri":
+		u, err := url.Parse(value)
+		return err == nil && u.Scheme != ""
+	}
+	return true
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,185 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// issuePaths validates instance against schema and returns the paths of the
+// issues found, or nil if it is valid.
+func issuePaths(t *testing.T, schema, instance string) []string {
+	t.Helper()
+	s, err := Compile([]byte(schema))
+	if err != nil {
+		t.Fatalf("Compile(%s): %v", schema, err)
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(instance), &value); err != nil {
+		t.Fatalf("invalid instance %s: %v", instance, err)
+	}
+
+	err = s.Validate(value)
+	if err == nil {
+		return nil
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate returned %T, want *ValidationError", err)
+	}
+	paths := make([]string, len(verr.Issues))
+	for i, issue := range verr.Issues {
+		paths[i] = issue.Path
+	}
+	return paths
+}
+
+func TestValidateKeywords(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		instance string
+		issues   []string
+	}{
+		// type
+		{"type string", `{"type": "string"}`, `"a"`, nil},
+		{"type string mismatch", `{"type": "string"}`, `1`, []string{"/"}},
+		{"type integer", `{"type": "integer"}`, `2.0`, nil},
+		{"type integer fraction", `{"type": "integer"}`, `2.5`, []string{"/"}},
+		{"type number", `{"type": "number"}`, `2.5`, nil},
+		{"type boolean", `{"type": "boolean"}`, `"true"`, []string{"/"}},
+		{"type null", `{"type": "null"}`, `null`, nil},
+		{"type array", `{"type": "array"}`, `{}`, []string{"/"}},
+		{"type object", `{"type": "object"}`, `[]`, []string{"/"}},
+		{"type list", `{"type": ["string", "null"]}`, `null`, nil},
+		{"type list mismatch", `{"type": ["string", "null"]}`, `false`, []string{"/"}},
+
+		// required
+		{"required present", `{"required": ["a"]}`, `{"a": null}`, nil},
+		{"required missing", `{"required": ["a", "b/c"]}`, `{}`, []string{"/a", "/b~1c"}},
+		{"required ignores non-objects", `{"required": ["a"]}`, `"a"`, nil},
+
+		// enum and const
+		{"enum", `{"enum": ["red", 1, null]}`, `1`, nil},
+		{"enum mismatch", `{"enum": ["red", 1, null]}`, `"blue"`, []string{"/"}},
+		{"enum is exact", `{"enum": ["red"]}`, `"Red"`, []string{"/"}},
+		{"empty enum", `{"enum": []}`, `"red"`, []string{"/"}},
+		{"const", `{"const": {"a": [1]}}`, `{"a": [1]}`, nil},
+		{"const mismatch", `{"const": {"a": [1]}}`, `{"a": [2]}`, []string{"/"}},
+
+		// minimum and maximum
+		{"minimum", `{"minimum": 1}`, `1`, nil},
+		{"below minimum", `{"minimum": 1}`, `0.5`, []string{"/"}},
+		{"maximum", `{"maximum": 10}`, `10`, nil},
+		{"above maximum", `{"maximum": 10}`, `11`, []string{"/"}},
+		{"exclusive minimum", `{"exclusiveMinimum": 1}`, `1`, []string{"/"}},
+		{"exclusive maximum", `{"exclusiveMaximum": 10}`, `9.99`, nil},
+		{"bounds ignore strings", `{"minimum": 1}`, `"0"`, nil},
+
+		// string lengths, counted in characters
+		{"min length", `{"minLength": 2}`, `"é"`, []string{"/"}},
+		{"max length", `{"maxLength": 2}`, `"éé"`, nil},
+		{"over max length", `{"maxLength": 2}`, `"abc"`, []string{"/"}},
+
+		// pattern, which is unanchored
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc"`, nil},
+		{"pattern mismatch", `{"pattern": "^[a-z]+$"}`, `"abc1"`, []string{"/"}},
+		{"pattern unanchored", `{"pattern": "[0-9]"}`, `"abc1"`, nil},
+
+		// format
+		{"email", `{"format": "email"}`, `"ann@example.com"`, nil},
+		{"email with name", `{"format": "email"}`, `"Ann <ann@example.com>"`, []string{"/"}},
+		{"date", `{"format": "date"}`, `"2024-02-29"`, nil},
+		{"invalid date", `{"format": "date"}`, `"2023-02-29"`, []string{"/"}},
+		{"date-time", `{"format": "date-time"}`, `"2024-01-02T03:04:05Z"`, nil},
+		{"uri without scheme", `{"format": "uri"}`, `"example.com"`, []string{"/"}},
+
+		// arrays
+		{"items", `{"items": {"type": "integer"}}`, `[1, "a", 3, null]`, []string{"/1", "/3"}},
+		{"min items", `{"minItems": 1}`, `[]`, []string{"/"}},
+		{"max items", `{"maxItems": 1}`, `[1, 2]`, []string{"/"}},
+
+		// nested properties
+		{
+			"nested properties",
+			`{"properties": {"address": {"type": "object", "required": ["city"], "properties": {"zip": {"pattern": "^[0-9]{5}$"}}}}}`,
+			`{"address": {"city": "Lund", "zip": "22100"}}`,
+			nil,
+		},
+		{
+			"nested properties issues",
+			`{"properties": {"address": {"type": "object", "required": ["city"], "properties": {"zip": {"pattern": "^[0-9]{5}$"}}}}}`,
+			`{"address": {"zip": "221"}}`,
+			[]string{"/address/city", "/address/zip"},
+		},
+		{"nested type mismatch", `{"properties": {"a": {"properties": {"b": {"type": "string"}}}}}`, `{"a": {"b": 1}}`, []string{"/a/b"}},
+		{"properties allow others", `{"properties": {"a": {"type": "string"}}}`, `{"b": 1}`, nil},
+
+		// additionalProperties
+		{"no additional", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1}`, nil},
+		{"no additional extra", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "c": 2, "b": 3}`, []string{"/b", "/c"}},
+		{"additional schema", `{"properties": {"a": {}}, "additionalProperties": {"type": "string"}}`, `{"a": 1, "b": "x"}`, nil},
+		{"additional schema mismatch", `{"properties": {"a": {}}, "additionalProperties": {"type": "string"}}`, `{"a": 1, "b": 2}`, []string{"/b"}},
+		{"additional true", `{"additionalProperties": true}`, `{"b": 2}`, nil},
+
+		// property counts
+		{"min properties", `{"minProperties": 2}`, `{"a": 1}`, []string{"/"}},
+		{"max properties", `{"maxProperties": 1}`, `{"a": 1, "b": 2}`, []string{"/"}},
+
+		// boolean schemas
+		{"true schema", `true`, `{"anything": [1]}`, nil},
+		{"false schema", `false`, `null`, []string{"/"}},
+		{"false property", `{"properties": {"a": false}}`, `{"a": 1}`, []string{"/a"}},
+
+		// annotations are ignored
+		{"annotations", `{"title": "Age", "description": "In years", "type": "integer"}`, `3`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := issuePaths(t, tt.schema, tt.instance)
+			if !reflect.DeepEqual(got, tt.issues) {
+				t.Errorf("issues at %v, want %v", got, tt.issues)
+			}
+		})
+	}
+}
+
+func TestValidateTypeMismatchSkipsOtherKeywords(t *testing.T) {
+	got := issuePaths(t, `{"type": "string", "minLength": 5, "enum": ["hello"]}`, `1`)
+	if len(got) != 1 {
+		t.Errorf("issues at %v, want only the type issue", got)
+	}
+}
+
+func TestCompileRejectsInvalidSchemas(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"not JSON", `{`},
+		{"not an object", `"string"`},
+		{"unknown type", `{"type": "text"}`},
+		{"type not a string", `{"type": 1}`},
+		{"enum not an array", `{"enum": "a"}`},
+		{"required not strings", `{"required": [1]}`},
+		{"properties not an object", `{"properties": []}`},
+		{"negative min length", `{"minLength": -1}`},
+		{"fractional max items", `{"maxItems": 1.5}`},
+		{"minimum not a number", `{"minimum": "1"}`},
+		{"invalid pattern", `{"pattern": "("}`},
+		{"unsupported format", `{"format": "ipv4"}`},
+		{"unsupported keyword", `{"anyOf": [{"type": "string"}]}`},
+		{"nested unsupported keyword", `{"properties": {"a": {"$ref": "#/defs/a"}}}`},
+		{"invalid items", `{"items": 1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile([]byte(tt.schema)); err == nil {
+				t.Errorf("Compile(%s) succeeded, want an error", tt.schema)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tags used by LDAP (RFC 4511 section 5.1). Class and constructed bits
+// are folded into the tag byte; LDAP never needs multi-byte tags.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// maxMessageSize bounds the messages read from a server.
+const maxMessageSize = 64 << 20
+
+// element is one decoded BER TLV.
+type element struct {
+	tag     byte
+	content []byte
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var digits []byte
+	for ; n > 0; n >>= 8 {
+		digits = append([]byte{byte(n)}, digits...)
+	}
+	return append([]byte{0x80 | byte(len(digits))}, digits...)
+}
+
+// tlv encodes content under tag.
+func tlv(tag byte, content []byte) []byte {
+	out := append([]byte{tag}, encodeLength(len(content))...)
+	return append(out, content...)
+}
+
+// seq encodes parts, already encoded, as a constructed element.
+func seq(tag byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, part := range parts {
+		content = append(content, part...)
+	}
+	return tlv(tag, content)
+}
+
+func octets(tag byte, s string) []byte {
+	return tlv(tag, []byte(s))
+}
+
+func boolean(tag byte, b bool) []byte {
+	if b {
+		return tlv(tag, []byte{0xff})
+	}
+	return tlv(tag, []byte{0x00})
+}
+
+func integer(tag byte, n int64) []byte {
+	var content []byte
+	for {
+		content = append([]byte{byte(n)}, content...)
+		if (n >= -0x80 && n < 0x80) || len(content) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return tlv(tag, content)
+}
+
+func decodeInteger(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, errors.New("ldap: malformed integer")
+	}
+	n := int64(int8(content[0]))
+	for _, b := range content[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+// readElement reads one complete TLV from r.
+func readElement(r *bufio.Reader) (*element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, errors.New("ldap: unsupported length encoding")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxMessageSize {
+		return nil, fmt.Errorf("ldap: message of %d bytes is too large", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return &element{tag: tag, content: content}, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// children decodes the elements inside a constructed element.
+func (e *element) children() ([]*element, error) {
+	var children []*element
+	for rest := e.content; len(rest) > 0; {
+		if len(rest) < 2 {
+			return nil, errors.New("ldap: truncated element")
+		}
+		tag, first := rest[0], rest[1]
+		rest = rest[2:]
+
+		length := int(first)
+		if first&0x80 != 0 {
+			n := int(first & 0x7f)
+			if n == 0 || n > 4 || len(rest) < n {
+				return nil, errors.New("ldap: malformed length")
+			}
+			length = 0
+			for _, b := range rest[:n] {
+				length = length<<8 | int(b)
+			}
+			rest = rest[n:]
+		}
+		if length < 0 || length > len(rest) {
+			return nil, errors.New("ldap: truncated element")
+		}
+
+		children = append(children, &element{tag: tag, content: rest[:length]})
+		rest = rest[length:]
+	}
+	return children, nil
+}
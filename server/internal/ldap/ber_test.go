@@ -0,0 +1,250 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEncodeLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x81, 0x80}},
+		{255, []byte{0x81, 0xff}},
+		{256, []byte{0x82, 0x01, 0x00}},
+		{70000, []byte{0x83, 0x01, 0x11, 0x70}},
+	}
+	for _, tt := range tests {
+		if got := encodeLength(tt.n); !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeLength(%d) = % x, want % x", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestInteger(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{1, []byte{0x02, 0x01, 0x01}},
+		{127, []byte{0x02, 0x01, 0x7f}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{256, []byte{0x02, 0x02, 0x01, 0x00}},
+		{-1, []byte{0x02, 0x01, 0xff}},
+		{-128, []byte{0x02, 0x01, 0x80}},
+		{-129, []byte{0x02, 0x02, 0xff, 0x7f}},
+		{1<<31 - 1, []byte{0x02, 0x04, 0x7f, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		got := integer(tagInteger, tt.n)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("integer(%d) = % x, want % x", tt.n, got, tt.want)
+		}
+		n, err := decodeInteger(got[2:])
+		if err != nil || n != tt.n {
+			t.Errorf("decodeInteger(% x) = %d, %v, want %d", got[2:], n, err, tt.n)
+		}
+	}
+
+	for _, content := range [][]byte{nil, make([]byte, 9)} {
+		if _, err := decodeInteger(content); err == nil {
+			t.Errorf("decodeInteger(% x) succeeded", content)
+		}
+	}
+}
+
+func TestPrimitives(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"octets", octets(tagOctetString, "cn"), []byte{0x04, 0x02, 'c', 'n'}},
+		{"empty octets", octets(tagOctetString, ""), []byte{0x04, 0x00}},
+		{"true", boolean(tagBoolean, true), []byte{0x01, 0x01, 0xff}},
+		{"false", boolean(tagBoolean, false), []byte{0x01, 0x01, 0x00}},
+		{"sequence", seq(tagSequence, integer(tagInteger, 1), octets(tagOctetString, "a")),
+			[]byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x04, 0x01, 'a'}},
+		{"empty sequence", seq(tagSequence), []byte{0x30, 0x00}},
+	}
+	for _, tt := range tests {
+		if !bytes.Equal(tt.got, tt.want) {
+			t.Errorf("%s = % x, want % x", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestReadElement(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	stream := append(octets(tagOctetString, "short"), seq(tagSequence, octets(tagOctetString, long))...)
+	r := bufio.NewReader(bytes.NewReader(stream))
+
+	e, err := readElement(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.tag != tagOctetString || string(e.content) != "short" {
+		t.Errorf("first element = %x %q", e.tag, e.content)
+	}
+
+	e, err = readElement(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := e.children()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.tag != tagSequence || len(children) != 1 || string(children[0].content) != long {
+		t.Errorf("second element = %x with %d children", e.tag, len(children))
+	}
+
+	if _, err := readElement(r); err != io.EOF {
+		t.Errorf("reading past the end = %v, want EOF", err)
+	}
+}
+
+func TestReadElementRejectsMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"no length":         {0x30},
+		"truncated content": {0x04, 0x05, 'a', 'b'},
+		"truncated length":  {0x04, 0x82, 0x01},
+		"indefinite length": {0x30, 0x80, 0x00, 0x00},
+		"five length bytes": {0x04, 0x85, 0x00, 0x00, 0x00, 0x00, 0x01, 'a'},
+		"too large":         {0x04, 0x84, 0x7f, 0xff, 0xff, 0xff},
+	}
+	for name, stream := range tests {
+		if _, err := readElement(bufio.NewReader(bytes.NewReader(stream))); err == nil || err == io.EOF {
+			t.Errorf("%s: error = %v", name, err)
+		}
+	}
+}
+
+func TestChildrenRejectsMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"lone tag":          {0x04},
+		"truncated content": {0x04, 0x03, 'a'},
+		"truncated length":  {0x04, 0x82, 0x01},
+		"indefinite length": {0x04, 0x80},
+	}
+	for name, content := range tests {
+		if _, err := (&element{tag: tagSequence, content: content}).children(); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+}
+
+// message encodes a response message as a server would.
+func message(id int64, op []byte, controls ...[]byte) []byte {
+	parts := [][]byte{integer(tagInteger, id), op}
+	if len(controls) > 0 {
+		parts = append(parts, seq(controlsTag, controls...))
+	}
+	return seq(tagSequence, parts...)
+}
+
+func result(tag byte, code int64, message string) []byte {
+	return seq(tag, integer(tagEnumerated, code), octets(tagOctetString, ""), octets(tagOctetString, message))
+}
+
+func searchEntry(dn string, attributes map[string][]string) []byte {
+	var attrs [][]byte
+	for name, values := range attributes {
+		encoded := make([][]byte, len(values))
+		for i, value := range values {
+			encoded[i] = octets(tagOctetString, value)
+		}
+		attrs = append(attrs, seq(tagSequence, octets(tagOctetString, name), seq(tagSet, encoded...)))
+	}
+	return seq(opSearchEntry, octets(tagOctetString, dn), seq(tagSequence, attrs...))
+}
+
+func pagedControl(cookie string) []byte {
+	value := seq(tagSequence, integer(tagInteger, 0), octets(tagOctetString, cookie))
+	return seq(tagSequence, octets(tagOctetString, oidPagedResults), octets(tagOctetString, string(value)))
+}
+
+func TestParseResult(t *testing.T) {
+	decode := func(b []byte) *element {
+		e, err := readElement(bufio.NewReader(bytes.NewReader(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	if err := parseResult(decode(result(opBindResponse, ResultSuccess, ""))); err != nil {
+		t.Errorf("success = %v", err)
+	}
+	err := parseResult(decode(result(opBindResponse, ResultInvalidCredentials, "bad password")))
+	if resultErr, ok := err.(*ResultError); !ok || resultErr.Code != ResultInvalidCredentials || resultErr.Message != "bad password" {
+		t.Errorf("invalid credentials = %v", err)
+	}
+	if err := parseResult(decode(seq(opBindResponse, integer(tagEnumerated, 0)))); err == nil {
+		t.Error("a result without a message parsed")
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	b := searchEntry("uid=ann,dc=example,dc=com", map[string][]string{
+		"mail":       {"ann@example.com", "a@example.com"},
+		"objectGUID": {"\x00\xff\x10"},
+	})
+	op, err := readElement(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := parseEntry(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.DN != "uid=ann,dc=example,dc=com" {
+		t.Errorf("DN = %q", entry.DN)
+	}
+	if mail := entry.Values("MAIL"); len(mail) != 2 || mail[1] != "a@example.com" {
+		t.Errorf("mail = %v", mail)
+	}
+	if guid := entry.Value("objectGUID"); guid != "\x00\xff\x10" {
+		t.Errorf("binary values are not kept: %q", guid)
+	}
+}
+
+func TestPagedCookie(t *testing.T) {
+	decode := func(b []byte) *element {
+		e, err := readElement(bufio.NewReader(bytes.NewReader(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	other := seq(tagSequence, octets(tagOctetString, "1.2.3.4"), octets(tagOctetString, "x"))
+	tests := []struct {
+		name     string
+		controls *element
+		want     string
+	}{
+		{"no controls", nil, ""},
+		{"more pages", decode(seq(controlsTag, other, pagedControl("next"))), "next"},
+		{"last page", decode(seq(controlsTag, pagedControl(""))), ""},
+		{"other controls only", decode(seq(controlsTag, other)), ""},
+	}
+	for _, tt := range tests {
+		got, err := pagedCookie(tt.controls)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: cookie = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	malformed := seq(controlsTag, seq(tagSequence, octets(tagOctetString, oidPagedResults), octets(tagOctetString, "junk")))
+	if _, err := pagedCookie(decode(malformed)); err == nil {
+		t.Error("a malformed paged results control parsed")
+	}
+}
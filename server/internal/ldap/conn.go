@@ -0,0 +1,391 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	oidStartTLS     = "1.3.6.1.4.1.1466.20037"
+	oidPagedResults = "1.2.840.113556.1.4.319"
+)
+
+// Protocol operations (RFC 4511 section 4.2 onwards).
+const (
+	opBindRequest       = classApplication | constructed | 0
+	opBindResponse      = classApplication | constructed | 1
+	opUnbindRequest     = classApplication | 2
+	opSearchRequest     = classApplication | constructed | 3
+	opSearchEntry       = classApplication | constructed | 4
+	opSearchDone        = classApplication | constructed | 5
+	opSearchReference   = classApplication | constructed | 19
+	opExtendedRequest   = classApplication | constructed | 23
+	opExtendedResponse  = classApplication | constructed | 24
+	controlsTag         = classContext | constructed | 0
+	scopeWholeSubtree   = 2
+	derefNever          = 0
+	unsolicitedNotifyID = 0
+)
+
+// Conn is a connection to a directory server. It runs one operation at a
+// time and is not safe for concurrent use.
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	nextID int64
+}
+
+// Dial connects to the server at rawURL, "ldap://host[:389]" or
+// "ldaps://host[:636]". With startTLS a plain connection is upgraded before
+// anything else is sent. tlsConfig may be nil.
+func Dial(ctx context.Context, rawURL string, startTLS bool, tlsConfig *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid url: %w", err)
+	}
+
+	port := u.Port()
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+		if startTLS {
+			return nil, errors.New("ldap: StartTLS cannot be used with ldaps")
+		}
+	default:
+		return nil, fmt.Errorf("ldap: unsupported url scheme %q", u.Scheme)
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: failed to connect: %w", err)
+	}
+
+	if u.Scheme == "ldaps" {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: TLS handshake failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	c := &Conn{conn: conn, r: bufio.NewReader(conn)}
+	if startTLS {
+		if err := c.startTLS(ctx, tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Conn) startTLS(ctx context.Context, tlsConfig *tls.Config) error {
+	resp, _, err := c.roundTrip(ctx, seq(opExtendedRequest, octets(classContext|0, oidStartTLS)))
+	if err != nil {
+		return err
+	}
+	if resp.tag != opExtendedResponse {
+		return fmt.Errorf("ldap: unexpected response 0x%02x to StartTLS", resp.tag)
+	}
+	if err := parseResult(resp); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("ldap: TLS handshake failed: %w", err)
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind authenticates as dn with a simple bind. An empty dn binds
+// anonymously; a dn without a password is refused, as servers would treat
+// it as an unauthenticated bind that always succeeds.
+func (c *Conn) Bind(ctx context.Context, dn, password string) error {
+	if dn != "" && password == "" {
+		return fmt.Errorf("ldap: no password to bind as %s", dn)
+	}
+
+	resp, _, err := c.roundTrip(ctx, seq(opBindRequest,
+		integer(tagInteger, 3),
+		octets(tagOctetString, dn),
+		octets(classContext|0, password),
+	))
+	if err != nil {
+		return err
+	}
+	if resp.tag != opBindResponse {
+		return fmt.Errorf("ldap: unexpected response 0x%02x to bind", resp.tag)
+	}
+	return parseResult(resp)
+}
+
+// Search returns every entry in the subtree under req.BaseDN that matches
+// req.Filter. Servers that do not support paging return everything at once.
+func (c *Conn) Search(ctx context.Context, req *SearchRequest) ([]*Entry, error) {
+	filter, err := ParseFilter(searchFilter(req))
+	if err != nil {
+		return nil, err
+	}
+	attributes := make([][]byte, len(req.Attributes))
+	for i, attr := range req.Attributes {
+		attributes[i] = octets(tagOctetString, attr)
+	}
+
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	var entries []*Entry
+	cookie := ""
+	for {
+		op := seq(opSearchRequest,
+			octets(tagOctetString, req.BaseDN),
+			integer(tagEnumerated, scopeWholeSubtree),
+			integer(tagEnumerated, derefNever),
+			integer(tagInteger, 0),
+			integer(tagInteger, 0),
+			boolean(tagBoolean, false),
+			filter.encode(),
+			seq(tagSequence, attributes...),
+		)
+		var controls [][]byte
+		if req.PageSize > 0 {
+			value := seq(tagSequence, integer(tagInteger, int64(req.PageSize)), octets(tagOctetString, cookie))
+			controls = append(controls, seq(tagSequence,
+				octets(tagOctetString, oidPagedResults),
+				octets(tagOctetString, string(value)),
+			))
+		}
+
+		id, err := c.send(ctx, op, controls...)
+		if err != nil {
+			return nil, err
+		}
+
+		cookie = ""
+	results:
+		for {
+			resp, respControls, err := c.receive(id)
+			if err != nil {
+				return nil, err
+			}
+			switch resp.tag {
+			case opSearchEntry:
+				entry, err := parseEntry(resp)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, entry)
+			case opSearchReference:
+				// Referrals to other servers are not followed
+			case opSearchDone:
+				if err := parseResult(resp); err != nil {
+					return nil, err
+				}
+				if cookie, err = pagedCookie(respControls); err != nil {
+					return nil, err
+				}
+				break results
+			default:
+				return nil, fmt.Errorf("ldap: unexpected response 0x%02x to search", resp.tag)
+			}
+		}
+
+		if cookie == "" || req.PageSize <= 0 {
+			return entries, nil
+		}
+	}
+}
+
+func searchFilter(req *SearchRequest) string {
+	if req.Filter == "" {
+		return "(objectClass=*)"
+	}
+	return req.Filter
+}
+
+// Close unbinds and closes the connection.
+func (c *Conn) Close() error {
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	c.nextID++
+	c.conn.Write(seq(tagSequence, integer(tagInteger, c.nextID), tlv(opUnbindRequest, nil)))
+	return c.conn.Close()
+}
+
+// roundTrip sends a request and reads its single response.
+func (c *Conn) roundTrip(ctx context.Context, op []byte) (*element, *element, error) {
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	id, err := c.send(ctx, op)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.receive(id)
+}
+
+func (c *Conn) send(ctx context.Context, op []byte, controls ...[]byte) (int64, error) {
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+
+	c.nextID++
+	parts := [][]byte{integer(tagInteger, c.nextID), op}
+	if len(controls) > 0 {
+		parts = append(parts, seq(controlsTag, controls...))
+	}
+	if _, err := c.conn.Write(seq(tagSequence, parts...)); err != nil {
+		return 0, fmt.Errorf("ldap: failed to send request: %w", err)
+	}
+	return c.nextID, nil
+}
+
+// receive reads messages until one answers the request id and returns its
+// protocol operation and controls, if any.
+func (c *Conn) receive(id int64) (*element, *element, error) {
+	for {
+		msg, err := readElement(c.r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ldap: failed to read response: %w", err)
+		}
+		if msg.tag != tagSequence {
+			return nil, nil, errors.New("ldap: malformed message")
+		}
+		parts, err := msg.children()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(parts) < 2 || parts[0].tag != tagInteger {
+			return nil, nil, errors.New("ldap: malformed message")
+		}
+		msgID, err := decodeInteger(parts[0].content)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if msgID == unsolicitedNotifyID {
+			// The server is about to drop the connection
+			if err := parseResult(parts[1]); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, errors.New("ldap: server closed the connection")
+		}
+		if msgID != id {
+			continue
+		}
+
+		var controls *element
+		if len(parts) > 2 && parts[2].tag == controlsTag {
+			controls = parts[2]
+		}
+		return parts[1], controls, nil
+	}
+}
+
+// parseResult returns the error an LDAPResult reports, if any.
+func parseResult(op *element) error {
+	parts, err := op.children()
+	if err != nil {
+		return err
+	}
+	if len(parts) < 3 || parts[0].tag != tagEnumerated {
+		return errors.New("ldap: malformed result")
+	}
+	code, err := decodeInteger(parts[0].content)
+	if err != nil {
+		return err
+	}
+	if code == ResultSuccess {
+		return nil
+	}
+	return &ResultError{Code: int(code), Message: string(parts[2].content)}
+}
+
+func parseEntry(op *element) (*Entry, error) {
+	parts, err := op.children()
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) < 2 {
+		return nil, errors.New("ldap: malformed entry")
+	}
+
+	entry := &Entry{DN: string(parts[0].content), Attributes: make(map[string][]string)}
+	attributes, err := parts[1].children()
+	if err != nil {
+		return nil, err
+	}
+	for _, attribute := range attributes {
+		pair, err := attribute.children()
+		if err != nil {
+			return nil, err
+		}
+		if len(pair) < 2 {
+			return nil, errors.New("ldap: malformed attribute")
+		}
+		values, err := pair[1].children()
+		if err != nil {
+			return nil, err
+		}
+		name := string(pair[0].content)
+		for _, value := range values {
+			entry.Attributes[name] = append(entry.Attributes[name], string(value.content))
+		}
+	}
+	return entry, nil
+}
+
+// pagedCookie returns the cookie of the paged results control in controls,
+// or "" once the last page has been read.
+func pagedCookie(controls *element) (string, error) {
+	if controls == nil {
+		return "", nil
+	}
+	list, err := controls.children()
+	if err != nil {
+		return "", err
+	}
+	for _, control := range list {
+		parts, err := control.children()
+		if err != nil {
+			return "", err
+		}
+		if len(parts) < 2 || string(parts[0].content) != oidPagedResults {
+			continue
+		}
+
+		value := parts[len(parts)-1]
+		outer, err := (&element{content: value.content}).children()
+		if err != nil || len(outer) != 1 {
+			return "", errors.New("ldap: malformed paged results control")
+		}
+		fields, err := outer[0].children()
+		if err != nil || len(fields) < 2 {
+			return "", errors.New("ldap: malformed paged results control")
+		}
+		return string(fields[1].content), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,168 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeServer answers the requests of a Conn over an in-memory pipe. handle
+// is given each request's ID, operation and controls and returns the
+// messages to send back.
+func fakeServer(t *testing.T, handle func(id int64, op, controls *element) [][]byte) *Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		for {
+			msg, err := readElement(r)
+			if err != nil {
+				return
+			}
+			parts, err := msg.children()
+			if err != nil || len(parts) < 2 {
+				t.Errorf("malformed request % x", msg.content)
+				return
+			}
+			id, _ := decodeInteger(parts[0].content)
+			var controls *element
+			if len(parts) > 2 {
+				controls = parts[2]
+			}
+			if parts[1].tag == opUnbindRequest {
+				return
+			}
+			for _, response := range handle(id, parts[1], controls) {
+				if _, err := server.Write(response); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return &Conn{conn: client, r: bufio.NewReader(client)}
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestBind(t *testing.T) {
+	conn := fakeServer(t, func(id int64, op, _ *element) [][]byte {
+		parts, _ := op.children()
+		if op.tag != opBindRequest || len(parts) != 3 {
+			t.Errorf("bind request = %x with %d parts", op.tag, len(parts))
+			return nil
+		}
+		if string(parts[2].content) != "secret" {
+			return [][]byte{message(id, result(opBindResponse, ResultInvalidCredentials, "invalid credentials"))}
+		}
+		return [][]byte{message(id, result(opBindResponse, ResultSuccess, ""))}
+	})
+	ctx := testContext(t)
+
+	if err := conn.Bind(ctx, "cn=sync,dc=example,dc=com", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	err := conn.Bind(ctx, "cn=sync,dc=example,dc=com", "wrong")
+	if resultErr, ok := err.(*ResultError); !ok || resultErr.Code != ResultInvalidCredentials {
+		t.Errorf("bind with a wrong password = %v", err)
+	}
+	if err := conn.Bind(ctx, "cn=sync,dc=example,dc=com", ""); err == nil {
+		t.Error("bound with a DN and no password")
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	const filter = "(&(objectClass=person)(!(uid=bob)))"
+	parsed, err := ParseFilter(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cookies []string
+	conn := fakeServer(t, func(id int64, op, controls *element) [][]byte {
+		parts, _ := op.children()
+		if op.tag != opSearchRequest || len(parts) != 8 {
+			t.Errorf("search request = %x with %d parts", op.tag, len(parts))
+			return nil
+		}
+		if string(parts[0].content) != "dc=example,dc=com" {
+			t.Errorf("base DN = %q", parts[0].content)
+		}
+		encoded := append([]byte{parts[6].tag}, encodeLength(len(parts[6].content))...)
+		if encoded = append(encoded, parts[6].content...); !bytes.Equal(encoded, parsed.encode()) {
+			t.Errorf("filter = % x, want % x", encoded, parsed.encode())
+		}
+
+		// Read the cookie of the paged results control the client sent
+		list, _ := controls.children()
+		control, _ := list[0].children()
+		outer, _ := (&element{content: control[1].content}).children()
+		fields, _ := outer[0].children()
+		size, _ := decodeInteger(fields[0].content)
+		if size != 1 {
+			t.Errorf("page size = %d", size)
+		}
+		cookie := string(fields[1].content)
+		cookies = append(cookies, cookie)
+
+		switch cookie {
+		case "":
+			return [][]byte{
+				message(id, searchEntry("uid=ann,dc=example,dc=com", map[string][]string{"uid": {"ann"}})),
+				message(id, seq(opSearchReference, octets(tagOctetString, "ldap://elsewhere/"))),
+				message(id, result(opSearchDone, ResultSuccess, ""), pagedControl("page-2")),
+			}
+		default:
+			return [][]byte{
+				// Messages for other requests are skipped
+				message(id+100, searchEntry("uid=eve,dc=example,dc=com", nil)),
+				message(id, searchEntry("uid=carol,dc=example,dc=com", map[string][]string{"uid": {"carol"}})),
+				message(id, result(opSearchDone, ResultSuccess, ""), pagedControl("")),
+			}
+		}
+	})
+
+	entries, err := conn.Search(testContext(t), &SearchRequest{
+		BaseDN:     "dc=example,dc=com",
+		Filter:     filter,
+		Attributes: []string{"uid"},
+		PageSize:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Value("uid") != "ann" || entries[1].Value("uid") != "carol" {
+		t.Errorf("entries = %v", entries)
+	}
+	if len(cookies) != 2 || cookies[0] != "" || cookies[1] != "page-2" {
+		t.Errorf("cookies sent = %q", cookies)
+	}
+}
+
+func TestSearchFails(t *testing.T) {
+	conn := fakeServer(t, func(id int64, _, _ *element) [][]byte {
+		return [][]byte{message(id, result(opSearchDone, 32, "no such object"))}
+	})
+	_, err := conn.Search(testContext(t), &SearchRequest{BaseDN: "ou=missing,dc=example,dc=com"})
+	if resultErr, ok := err.(*ResultError); !ok || resultErr.Code != 32 {
+		t.Errorf("search of a missing base = %v", err)
+	}
+
+	// A notice of disconnection ends the search
+	conn = fakeServer(t, func(id int64, _, _ *element) [][]byte {
+		return [][]byte{message(unsolicitedNotifyID, result(opExtendedResponse, 52, "shutting down"))}
+	})
+	if _, err := conn.Search(testContext(t), &SearchRequest{BaseDN: "dc=example,dc=com"}); err == nil {
+		t.Error("a search survived a notice of disconnection")
+	}
+}
@@ -0,0 +1,383 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter operators.
+const (
+	FilterAnd        = "and"
+	FilterOr         = "or"
+	FilterNot        = "not"
+	FilterEqual      = "equal"
+	FilterSubstring  = "substring"
+	FilterGreater    = "ge"
+	FilterLess       = "le"
+	FilterPresent    = "present"
+	FilterApprox     = "approx"
+	FilterExtensible = "extensible"
+)
+
+// Active Directory's bitwise matching rules, as used in e.g.
+// (userAccountControl:1.2.840.113556.1.4.803:=2) for disabled accounts.
+const (
+	matchingRuleBitAnd = "1.2.840.113556.1.4.803"
+	matchingRuleBitOr  = "1.2.840.113556.1.4.804"
+)
+
+// Filter is a parsed search filter (RFC 4515). Which fields are set depends
+// on Op: Children for and, or and not; Initial, Any and Final for
+// substrings; Rule and DNAttributes for extensible matches; Attr and Value
+// for the rest.
+type Filter struct {
+	Op           string
+	Children     []*Filter
+	Attr         string
+	Value        string
+	Initial      string
+	Any          []string
+	Final        string
+	Rule         string
+	DNAttributes bool
+}
+
+// ParseFilter parses a filter in its string form, e.g.
+// "(&(objectClass=person)(mail=*))". The outer parentheses may be left out.
+func ParseFilter(s string) (*Filter, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "(") {
+		s = "(" + s + ")"
+	}
+	f, rest, err := parseFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return f, nil
+}
+
+func parseFilter(s string) (*Filter, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("ldap: expected ( at %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+
+	switch s[0] {
+	case '&', '|', '!':
+		f := &Filter{Op: FilterAnd}
+		switch s[0] {
+		case '|':
+			f.Op = FilterOr
+		case '!':
+			f.Op = FilterNot
+		}
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			f.Children = append(f.Children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("ldap: expected ) at %q", s)
+		}
+		if f.Op == FilterNot && len(f.Children) != 1 {
+			return nil, "", fmt.Errorf("ldap: ! takes exactly one filter")
+		}
+		if len(f.Children) == 0 {
+			return nil, "", fmt.Errorf("ldap: empty filter list")
+		}
+		return f, s[1:], nil
+	}
+
+	// Values cannot contain an unescaped ")", so the item ends at the first
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	f, err := parseItem(s[:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return f, s[end+1:], nil
+}
+
+func parseItem(item string) (*Filter, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attr, raw := item[:eq], item[eq+1:]
+
+	f := &Filter{}
+	switch attr[len(attr)-1] {
+	case '~':
+		f.Op, attr = FilterApprox, attr[:len(attr)-1]
+	case '>':
+		f.Op, attr = FilterGreater, attr[:len(attr)-1]
+	case '<':
+		f.Op, attr = FilterLess, attr[:len(attr)-1]
+	case ':':
+		f.Op = FilterExtensible
+		parts := strings.Split(attr[:len(attr)-1], ":")
+		f.Attr = parts[0]
+		for _, part := range parts[1:] {
+			if strings.EqualFold(part, "dn") {
+				f.DNAttributes = true
+			} else if part != "" {
+				f.Rule = part
+			}
+		}
+		if f.Attr == "" && f.Rule == "" {
+			return nil, fmt.Errorf("ldap: extensible match needs an attribute or rule in %q", item)
+		}
+		value, err := unescapeValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		f.Value = value
+		return f, nil
+	}
+	if !validAttr(attr) {
+		return nil, fmt.Errorf("ldap: invalid attribute %q", attr)
+	}
+	f.Attr = attr
+
+	if f.Op == "" {
+		if raw == "*" {
+			f.Op = FilterPresent
+			return f, nil
+		}
+		if strings.Contains(raw, "*") {
+			f.Op = FilterSubstring
+			parts := strings.Split(raw, "*")
+			var err error
+			if f.Initial, err = unescapeValue(parts[0]); err != nil {
+				return nil, err
+			}
+			if f.Final, err = unescapeValue(parts[len(parts)-1]); err != nil {
+				return nil, err
+			}
+			for _, part := range parts[1 : len(parts)-1] {
+				if part == "" {
+					continue
+				}
+				value, err := unescapeValue(part)
+				if err != nil {
+					return nil, err
+				}
+				f.Any = append(f.Any, value)
+			}
+			return f, nil
+		}
+		f.Op = FilterEqual
+	}
+
+	value, err := unescapeValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	f.Value = value
+	return f, nil
+}
+
+func validAttr(attr string) bool {
+	if attr == "" {
+		return false
+	}
+	for _, r := range attr {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == ';') {
+			return false
+		}
+	}
+	return true
+}
+
+// unescapeValue decodes the \XX escapes of a filter value.
+func unescapeValue(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("ldap: truncated escape in %q", s)
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// EscapeValue escapes s for use as a value in a filter.
+func EscapeValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// encode returns the filter's BER encoding (RFC 4511 section 4.5.1).
+func (f *Filter) encode() []byte {
+	switch f.Op {
+	case FilterAnd, FilterOr:
+		tag := byte(classContext | constructed | 0)
+		if f.Op == FilterOr {
+			tag = classContext | constructed | 1
+		}
+		parts := make([][]byte, len(f.Children))
+		for i, child := range f.Children {
+			parts[i] = child.encode()
+		}
+		return seq(tag, parts...)
+	case FilterNot:
+		return seq(classContext|constructed|2, f.Children[0].encode())
+	case FilterEqual:
+		return seq(classContext|constructed|3, octets(tagOctetString, f.Attr), octets(tagOctetString, f.Value))
+	case FilterSubstring:
+		var parts [][]byte
+		if f.Initial != "" {
+			parts = append(parts, octets(classContext|0, f.Initial))
+		}
+		for _, any := range f.Any {
+			parts = append(parts, octets(classContext|1, any))
+		}
+		if f.Final != "" {
+			parts = append(parts, octets(classContext|2, f.Final))
+		}
+		return seq(classContext|constructed|4, octets(tagOctetString, f.Attr), seq(tagSequence, parts...))
+	case FilterGreater:
+		return seq(classContext|constructed|5, octets(tagOctetString, f.Attr), octets(tagOctetString, f.Value))
+	case FilterLess:
+		return seq(classContext|constructed|6, octets(tagOctetString, f.Attr), octets(tagOctetString, f.Value))
+	case FilterPresent:
+		return octets(classContext|7, f.Attr)
+	case FilterApprox:
+		return seq(classContext|constructed|8, octets(tagOctetString, f.Attr), octets(tagOctetString, f.Value))
+	default:
+		var parts [][]byte
+		if f.Rule != "" {
+			parts = append(parts, octets(classContext|1, f.Rule))
+		}
+		if f.Attr != "" {
+			parts = append(parts, octets(classContext|2, f.Attr))
+		}
+		parts = append(parts, octets(classContext|3, f.Value))
+		if f.DNAttributes {
+			parts = append(parts, boolean(classContext|4, true))
+		}
+		return seq(classContext|constructed|9, parts...)
+	}
+}
+
+// Match evaluates the filter against an entry the way a directory with
+// case-insensitive attributes would. Of the extensible matching rules only
+// Active Directory's bitwise ones are understood; others fall back to
+// equality.
+func (f *Filter) Match(e *Entry) bool {
+	switch f.Op {
+	case FilterAnd:
+		for _, child := range f.Children {
+			if !child.Match(e) {
+				return false
+			}
+		}
+		return true
+	case FilterOr:
+		for _, child := range f.Children {
+			if child.Match(e) {
+				return true
+			}
+		}
+		return false
+	case FilterNot:
+		return !f.Children[0].Match(e)
+	case FilterPresent:
+		return len(e.Values(f.Attr)) > 0
+	}
+
+	for _, value := range e.Values(f.Attr) {
+		if f.matchValue(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchValue(value string) bool {
+	switch f.Op {
+	case FilterEqual, FilterApprox:
+		return strings.EqualFold(value, f.Value)
+	case FilterSubstring:
+		v := strings.ToLower(value)
+		initial, final := strings.ToLower(f.Initial), strings.ToLower(f.Final)
+		if !strings.HasPrefix(v, initial) {
+			return false
+		}
+		v = v[len(initial):]
+		for _, any := range f.Any {
+			i := strings.Index(v, strings.ToLower(any))
+			if i < 0 {
+				return false
+			}
+			v = v[i+len(any):]
+		}
+		return strings.HasSuffix(v, final)
+	case FilterGreater, FilterLess:
+		cmp := strings.Compare(strings.ToLower(value), strings.ToLower(f.Value))
+		if a, err := strconv.ParseInt(value, 10, 64); err == nil {
+			if b, err := strconv.ParseInt(f.Value, 10, 64); err == nil {
+				cmp = 0
+				if a < b {
+					cmp = -1
+				} else if a > b {
+					cmp = 1
+				}
+			}
+		}
+		if f.Op == FilterGreater {
+			return cmp >= 0
+		}
+		return cmp <= 0
+	case FilterExtensible:
+		if f.Rule == matchingRuleBitAnd || f.Rule == matchingRuleBitOr {
+			a, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return false
+			}
+			mask, err := strconv.ParseInt(f.Value, 10, 64)
+			if err != nil {
+				return false
+			}
+			if f.Rule == matchingRuleBitAnd {
+				return a&mask == mask
+			}
+			return a&mask != 0
+		}
+		return strings.EqualFold(value, f.Value)
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package ldap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// decodeFilter reads an encoded filter back, as a server would.
+func decodeFilter(e *element) (*Filter, error) {
+	children, err := e.children()
+	if e.tag&constructed == 0 {
+		children, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pair := func() (string, string, error) {
+		if len(children) != 2 {
+			return "", "", fmt.Errorf("filter 0x%02x has %d parts", e.tag, len(children))
+		}
+		return string(children[0].content), string(children[1].content), nil
+	}
+
+	f := &Filter{}
+	switch e.tag {
+	case classContext | constructed | 0, classContext | constructed | 1, classContext | constructed | 2:
+		f.Op = map[byte]string{0: FilterAnd, 1: FilterOr, 2: FilterNot}[e.tag&0x1f]
+		for _, child := range children {
+			decoded, err := decodeFilter(child)
+			if err != nil {
+				return nil, err
+			}
+			f.Children = append(f.Children, decoded)
+		}
+	case classContext | constructed | 3, classContext | constructed | 5, classContext | constructed | 6, classContext | constructed | 8:
+		f.Op = map[byte]string{3: FilterEqual, 5: FilterGreater, 6: FilterLess, 8: FilterApprox}[e.tag&0x1f]
+		if f.Attr, f.Value, err = pair(); err != nil {
+			return nil, err
+		}
+	case classContext | constructed | 4:
+		f.Op = FilterSubstring
+		if len(children) != 2 {
+			return nil, fmt.Errorf("substring filter has %d parts", len(children))
+		}
+		f.Attr = string(children[0].content)
+		substrings, err := children[1].children()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range substrings {
+			switch s.tag {
+			case classContext | 0:
+				f.Initial = string(s.content)
+			case classContext | 1:
+				f.Any = append(f.Any, string(s.content))
+			case classContext | 2:
+				f.Final = string(s.content)
+			}
+		}
+	case classContext | 7:
+		f.Op, f.Attr = FilterPresent, string(e.content)
+	case classContext | constructed | 9:
+		f.Op = FilterExtensible
+		for _, part := range children {
+			switch part.tag {
+			case classContext | 1:
+				f.Rule = string(part.content)
+			case classContext | 2:
+				f.Attr = string(part.content)
+			case classContext | 3:
+				f.Value = string(part.content)
+			case classContext | 4:
+				f.DNAttributes = len(part.content) == 1 && part.content[0] != 0
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown filter 0x%02x", e.tag)
+	}
+	return f, nil
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   *Filter
+	}{
+		{"(cn=Ann Lee)", &Filter{Op: FilterEqual, Attr: "cn", Value: "Ann Lee"}},
+		{"mail=*", &Filter{Op: FilterPresent, Attr: "mail"}},
+		{"(cn=a\\2ab\\28\\29)", &Filter{Op: FilterEqual, Attr: "cn", Value: "a*b()"}},
+		{"(cn=An*)", &Filter{Op: FilterSubstring, Attr: "cn", Initial: "An"}},
+		{"(cn=*e*L*ee)", &Filter{Op: FilterSubstring, Attr: "cn", Any: []string{"e", "L"}, Final: "ee"}},
+		{"(uidNumber>=1000)", &Filter{Op: FilterGreater, Attr: "uidNumber", Value: "1000"}},
+		{"(uidNumber<=1000)", &Filter{Op: FilterLess, Attr: "uidNumber", Value: "1000"}},
+		{"(sn~=Lee)", &Filter{Op: FilterApprox, Attr: "sn", Value: "Lee"}},
+		{
+			"(userAccountControl:1.2.840.113556.1.4.803:=2)",
+			&Filter{Op: FilterExtensible, Attr: "userAccountControl", Rule: matchingRuleBitAnd, Value: "2"},
+		},
+		{"(ou:dn:=People)", &Filter{Op: FilterExtensible, Attr: "ou", DNAttributes: true, Value: "People"}},
+		{
+			"(&(objectClass=person)(|(uid=ann)(uid=bob))(!(nsAccountLock=TRUE)))",
+			&Filter{Op: FilterAnd, Children: []*Filter{
+				{Op: FilterEqual, Attr: "objectClass", Value: "person"},
+				{Op: FilterOr, Children: []*Filter{
+					{Op: FilterEqual, Attr: "uid", Value: "ann"},
+					{Op: FilterEqual, Attr: "uid", Value: "bob"},
+				}},
+				{Op: FilterNot, Children: []*Filter{{Op: FilterEqual, Attr: "nsAccountLock", Value: "TRUE"}}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseFilter(tt.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%s): %v", tt.filter, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFilter(%s) = %+v, want %+v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterRejectsInvalid(t *testing.T) {
+	for _, filter := range []string{
+		"",
+		"(cn=a",
+		"(cn=a))",
+		"(&)",
+		"(!(a=1)(b=2))",
+		"(=a)",
+		"(c n=a)",
+		"(cn=a\\2)",
+		"(cn=a\\zz)",
+		"(:=a)",
+		"(&(cn=a)",
+	} {
+		if f, err := ParseFilter(filter); err == nil {
+			t.Errorf("ParseFilter(%q) = %+v", filter, f)
+		}
+	}
+}
+
+func TestFilterEncode(t *testing.T) {
+	// (cn=a) is an equalityMatch [3] of two octet strings
+	f, err := ParseFilter("(cn=a)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xa3, 0x07, 0x04, 0x02, 'c', 'n', 0x04, 0x01, 'a'}
+	if got := f.encode(); !bytes.Equal(got, want) {
+		t.Errorf("encode(cn=a) = % x, want % x", got, want)
+	}
+
+	// (mail=*) is a primitive present [7] holding the attribute
+	f, err = ParseFilter("(mail=*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []byte{0x87, 0x04, 'm', 'a', 'i', 'l'}
+	if got := f.encode(); !bytes.Equal(got, want) {
+		t.Errorf("encode(mail=*) = % x, want % x", got, want)
+	}
+}
+
+func TestFilterEncodeRoundTrip(t *testing.T) {
+	for _, filter := range []string{
+		"(cn=Ann Lee)",
+		"(mail=*)",
+		"(cn=An*)",
+		"(cn=*Lee)",
+		"(cn=A*n*L*ee)",
+		"(uidNumber>=1000)",
+		"(uidNumber<=1000)",
+		"(sn~=Lee)",
+		"(userAccountControl:1.2.840.113556.1.4.803:=2)",
+		"(ou:dn:=People)",
+		"(:1.2.3:=x)",
+		"(&(objectClass=person)(|(uid=ann)(uid=bob))(!(nsAccountLock=TRUE)))",
+		"(cn=\\00\\2a)",
+	} {
+		parsed, err := ParseFilter(filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%s): %v", filter, err)
+		}
+		e, err := (&element{content: parsed.encode()}).children()
+		if err != nil || len(e) != 1 {
+			t.Fatalf("%s encodes to more than one element: %v", filter, err)
+		}
+		decoded, err := decodeFilter(e[0])
+		if err != nil {
+			t.Fatalf("decode %s: %v", filter, err)
+		}
+		if !reflect.DeepEqual(decoded, parsed) {
+			t.Errorf("%s decodes to %+v, want %+v", filter, decoded, parsed)
+		}
+	}
+}
+
+func TestEscapeValue(t *testing.T) {
+	for _, value := range []string{"plain", "a*b", "(x)", `back\slash`, "nul\x00", "Ann Lee (Sales)*"} {
+		f, err := ParseFilter("(cn=" + EscapeValue(value) + ")")
+		if err != nil {
+			t.Errorf("escaped %q does not parse: %v", value, err)
+			continue
+		}
+		if f.Op != FilterEqual || f.Value != value {
+			t.Errorf("escaped %q parses to %+v", value, f)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	entry := &Entry{
+		DN: "uid=ann,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass":        {"top", "person"},
+			"uid":                {"ann"},
+			"cn":                 {"Ann Lee"},
+			"mail":               {"ann@example.com", "a.lee@example.org"},
+			"uidNumber":          {"1500"},
+			"userAccountControl": {"514"},
+		},
+	}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"(objectclass=PERSON)", true},
+		{"(uid=bob)", false},
+		{"(mail=a.lee@example.org)", true},
+		{"(mail=*)", true},
+		{"(telephoneNumber=*)", false},
+		{"(cn=ann*)", true},
+		{"(cn=*lee)", true},
+		{"(cn=a*n*e)", true},
+		{"(cn=*x*)", false},
+		{"(cn=Ann Lee*Lee)", false},
+		{"(uidNumber>=1000)", true},
+		{"(uidNumber>=10000)", false},
+		{"(uidNumber<=1500)", true},
+		{"(cn~=ann lee)", true},
+		{"(userAccountControl:1.2.840.113556.1.4.803:=2)", true},
+		{"(userAccountControl:1.2.840.113556.1.4.803:=16)", false},
+		{"(userAccountControl:1.2.840.113556.1.4.804:=18)", true},
+		{"(&(objectClass=person)(!(userAccountControl:1.2.840.113556.1.4.803:=2)))", false},
+		{"(|(uid=bob)(uid=ann))", true},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%s): %v", tt.filter, err)
+		}
+		if got := f.Match(entry); got != tt.want {
+			t.Errorf("%s matched %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	m := NewMemory(
+		&Entry{DN: "uid=ann,ou=people,dc=example,dc=com", Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"ann"}, "mail": {"ann@example.com"}}},
+		&Entry{DN: "uid=bob,ou=people,dc=example,dc=com", Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"bob"}}},
+		&Entry{DN: "cn=staff,ou=groups,dc=example,dc=com", Attributes: map[string][]string{"objectClass": {"groupOfNames"}}},
+	)
+	// Entries with the same DN, written differently, replace each other
+	m.Add(&Entry{DN: "UID=bob, OU=People,dc=example,dc=com", Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"robert"}}})
+
+	ctx := context.Background()
+	entries, err := m.Search(ctx, &SearchRequest{BaseDN: "OU=People, DC=example,DC=com", Filter: "(objectClass=person)", Attributes: []string{"UID"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Value("uid") != "ann" || entries[1].Value("uid") != "robert" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if entries[0].Value("mail") != "" {
+		t.Error("an attribute that was not asked for was returned")
+	}
+
+	// Results are copies
+	entries[0].Attributes["uid"][0] = "changed"
+	if entries, _ := m.Search(ctx, &SearchRequest{Filter: "(uid=ann)"}); len(entries) != 1 {
+		t.Error("changing a result changed the directory")
+	}
+
+	m.Delete("uid=ann,ou=people,dc=example,dc=com")
+	if entries, _ := m.Search(ctx, &SearchRequest{}); len(entries) != 2 {
+		t.Errorf("after a delete, %d entries are left, want 2", len(entries))
+	}
+
+	if _, err := m.Search(ctx, &SearchRequest{Filter: "(uid=ann"}); err == nil {
+		t.Error("a search with an invalid filter succeeded")
+	}
+}
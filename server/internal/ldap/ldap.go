@@ -0,0 +1,95 @@
+// Package ldap is a small LDAPv3 client (RFC 4511): simple binds, StartTLS
+// and paged subtree searches, which is all directory sync needs. Memory is
+// an in-process stand-in for a directory server.
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Entry is a directory entry. Attribute names are matched
+// case-insensitively; values are raw, so binary attributes such as
+// objectGUID keep their bytes.
+type Entry struct {
+	DN         string              `json:"dn"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+// Values returns the values of the attribute name.
+func (e *Entry) Values(name string) []string {
+	if values, ok := e.Attributes[name]; ok {
+		return values
+	}
+	for k, values := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Value returns the first value of the attribute name, or "".
+func (e *Entry) Value(name string) string {
+	if values := e.Values(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// SearchRequest describes a subtree search. PageSize, when positive, reads
+// the results in pages of that size.
+type SearchRequest struct {
+	BaseDN     string
+	Filter     string
+	Attributes []string
+	PageSize   int
+}
+
+// Directory is a source of entries: a connection to a directory server or
+// the in-process Memory stand-in.
+type Directory interface {
+	Search(ctx context.Context, req *SearchRequest) ([]*Entry, error)
+	Close() error
+}
+
+// Result codes (RFC 4511 section 4.1.9) the client checks for.
+const (
+	ResultSuccess            = 0
+	ResultInvalidCredentials = 49
+)
+
+// ResultError is a result code other than success returned by the server.
+type ResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// NormalizeDN lower-cases dn and drops the spaces around its separators, so
+// that DNs written differently compare equal. Escaped characters are not
+// unescaped.
+func NormalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, part := range parts {
+		rdn := strings.SplitN(part, "=", 2)
+		for j := range rdn {
+			rdn[j] = strings.TrimSpace(rdn[j])
+		}
+		parts[i] = strings.Join(rdn, "=")
+	}
+	return strings.ToLower(strings.Join(parts, ","))
+}
+
+// InSubtree reports whether dn is base or below it.
+func InSubtree(dn, base string) bool {
+	dn, base = NormalizeDN(dn), NormalizeDN(base)
+	return base == "" || dn == base || strings.HasSuffix(dn, ","+base)
+}
@@ -0,0 +1,101 @@
+package ldap
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Memory is an in-process directory. It evaluates searches the way a server
+// would, so directory sync can run against it without an LDAP server.
+type Memory struct {
+	mu      sync.RWMutex
+	entries []*Entry
+}
+
+// NewMemory returns a directory holding entries.
+func NewMemory(entries ...*Entry) *Memory {
+	m := &Memory{}
+	for _, entry := range entries {
+		m.Add(entry)
+	}
+	return m
+}
+
+// Add stores entry, replacing any entry with the same DN.
+func (m *Memory) Add(entry *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dn := NormalizeDN(entry.DN)
+	for i, existing := range m.entries {
+		if NormalizeDN(existing.DN) == dn {
+			m.entries[i] = entry
+			return
+		}
+	}
+	m.entries = append(m.entries, entry)
+}
+
+// Delete removes the entry with the given DN.
+func (m *Memory) Delete(dn string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dn = NormalizeDN(dn)
+	for i, existing := range m.entries {
+		if NormalizeDN(existing.DN) == dn {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Search returns copies of the entries under req.BaseDN that match
+// req.Filter, limited to req.Attributes when any are given.
+func (m *Memory) Search(ctx context.Context, req *SearchRequest) ([]*Entry, error) {
+	filter, err := ParseFilter(searchFilter(req))
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []*Entry
+	for _, entry := range m.entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !InSubtree(entry.DN, req.BaseDN) || !filter.Match(entry) {
+			continue
+		}
+		entries = append(entries, selectAttributes(entry, req.Attributes))
+	}
+	return entries, nil
+}
+
+// Close does nothing.
+func (m *Memory) Close() error {
+	return nil
+}
+
+func selectAttributes(entry *Entry, attributes []string) *Entry {
+	out := &Entry{DN: entry.DN, Attributes: make(map[string][]string)}
+	for name, values := range entry.Attributes {
+		if len(attributes) > 0 && !containsFold(attributes, name) {
+			continue
+		}
+		out.Attributes[name] = append([]string(nil), values...)
+	}
+	return out
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if item == "*" || strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,341 @@
+package ldapsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/ldap"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// groupPageSize is how many groups or members are read at a time.
+const groupPageSize = 100
+
+// syncGroups creates, renames and links a group for every group entry and
+// then makes their memberships match the directory's. Groups whose entries
+// are gone are unlinked but kept, as deleting them would lose what was
+// added to them by hand.
+func (r *run) syncGroups(ctx context.Context) error {
+	cfg := r.cfg.Groups
+	attrs := cfg.Attributes
+
+	entries, err := r.dir.Search(ctx, &ldap.SearchRequest{
+		BaseDN:     cfg.BaseDN,
+		Filter:     cfg.Filter,
+		Attributes: attributes(attrs.ID, attrs.Name, attrs.Description, attrs.Member),
+		PageSize:   r.cfg.PageSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to search groups: %w", err)
+	}
+
+	links, err := r.ldapDAO.GetLinks(ctx, ResourceGroup)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 && len(links) > 0 {
+		return errors.New("the directory returned no groups, refusing to unlink every linked group")
+	}
+	linked := make(map[string]*models.LDAPLink, len(links))
+	for _, link := range links {
+		linked[link.EntryID] = link
+		r.linkedGroups[link.ResourceID] = link.DN
+	}
+
+	byID := make(map[uuid.UUID]*models.Group)
+	byName := make(map[string]*models.Group)
+	for offset := 0; ; offset += groupPageSize {
+		page, err := r.groupDAO.GetAll(ctx, groupPageSize, offset)
+		if err != nil {
+			return err
+		}
+		for _, group := range page {
+			byID[group.ID] = group
+			byName[group.Name] = group
+		}
+		if len(page) < groupPageSize {
+			break
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DN < entries[j].DN })
+	seen := make(map[string]bool, len(entries))
+	var synced []*ldap.Entry
+	var changes []*Change
+	for _, entry := range entries {
+		id := entryID(entry, attrs.ID)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		synced = append(synced, entry)
+		changes = append(changes, r.syncGroup(ctx, entry, id, linked[id], byID, byName))
+	}
+
+	// Memberships wait until every group exists, as groups nest
+	for i, entry := range synced {
+		if change := changes[i]; change.Action != ActionSkip && change.Error == "" {
+			r.syncMembers(ctx, entry, change)
+		}
+		r.report.Groups.record(changes[i])
+	}
+
+	for _, link := range links {
+		if seen[link.EntryID] {
+			continue
+		}
+		change := &Change{Action: ActionUnlink, DN: link.DN, ID: &link.ResourceID, Reason: "no longer in the directory"}
+		if group := byID[link.ResourceID]; group != nil {
+			change.Name = group.Name
+		}
+		if !r.dryRun {
+			if err := r.ldapDAO.DeleteLink(ctx, ResourceGroup, link.ResourceID); err != nil {
+				change.Error = err.Error()
+			}
+		}
+		r.report.Groups.record(change)
+	}
+
+	return nil
+}
+
+func (r *run) syncGroup(ctx context.Context, entry *ldap.Entry, entryID string, link *models.LDAPLink,
+	byID map[uuid.UUID]*models.Group, byName map[string]*models.Group) *Change {
+	attrs := r.cfg.Groups.Attributes
+	name := strings.TrimSpace(entry.Value(attrs.Name))
+	change := &Change{DN: entry.DN, Name: name}
+
+	var group *models.Group
+	if link != nil {
+		group = byID[link.ResourceID]
+	}
+	if group == nil {
+		link = nil
+		if group = byName[name]; group != nil {
+			if _, taken := r.linkedGroups[group.ID]; taken {
+				change.Action, change.Reason = ActionSkip, "another entry is linked to the group with this name"
+				return change
+			}
+		}
+	}
+
+	want := &models.Group{Name: name}
+	if group != nil {
+		want.Description = group.Description
+	}
+	if attrs.Description != "" {
+		want.Description = strings.TrimSpace(entry.Value(attrs.Description))
+	}
+	dn := ldap.NormalizeDN(entry.DN)
+
+	if group == nil {
+		change.Action = ActionCreate
+		change.Changes = map[string]models.FieldChange{
+			"name":        {New: want.Name},
+			"description": {New: want.Description},
+		}
+		if err := models.ValidateGroup(want); err != nil {
+			change.Error = err.Error()
+			return change
+		}
+
+		r.groups[dn] = uuid.Nil
+		if r.dryRun {
+			return change
+		}
+		if err := r.groupDAO.Create(ctx, want, r.actor); err != nil {
+			delete(r.groups, dn)
+			change.Error = err.Error()
+			return change
+		}
+		change.ID = &want.ID
+		r.groups[dn] = want.ID
+		r.linkedGroups[want.ID] = entry.DN
+		if err := r.link(ctx, ResourceGroup, want.ID, entryID, entry.DN, false); err != nil {
+			change.Error = err.Error()
+		}
+		return change
+	}
+
+	change.ID = &group.ID
+	r.groups[dn] = group.ID
+	r.linkedGroups[group.ID] = entry.DN
+
+	changes := make(map[string]models.FieldChange)
+	if want.Name != group.Name {
+		changes["name"] = models.FieldChange{Old: group.Name, New: want.Name}
+	}
+	if want.Description != group.Description {
+		changes["description"] = models.FieldChange{Old: group.Description, New: want.Description}
+	}
+	renamed := len(changes) > 0
+	if link != nil && link.DN != entry.DN {
+		changes["dn"] = models.FieldChange{Old: link.DN, New: entry.DN}
+	}
+	if len(changes) > 0 {
+		change.Changes = changes
+	}
+
+	switch {
+	case link == nil:
+		change.Action = ActionLink
+	case len(changes) > 0:
+		change.Action = ActionUpdate
+	default:
+		return change
+	}
+
+	if renamed {
+		if err := models.ValidateGroup(want); err != nil {
+			change.Error = err.Error()
+			return change
+		}
+	}
+	if r.dryRun {
+		return change
+	}
+
+	if renamed {
+		if _, err := r.groupDAO.Update(ctx, group.ID, want.Name, want.Description, r.actor); err != nil {
+			change.Error = err.Error()
+			return change
+		}
+	}
+	if err := r.link(ctx, ResourceGroup, group.ID, entryID, entry.DN, false); err != nil {
+		change.Error = err.Error()
+	}
+	return change
+}
+
+// syncMembers makes the group's direct members and subgroups from the
+// directory those its entry lists. Members that do not come from the
+// directory are left alone; new members join with the member role.
+func (r *run) syncMembers(ctx context.Context, entry *ldap.Entry, change *Change) {
+	groupID := uuid.Nil
+	if change.ID != nil {
+		groupID = *change.ID
+	}
+
+	// Members by DN, and whether each user or group ID is wanted
+	wantUsers := make(map[string]uuid.UUID)
+	wantGroups := make(map[string]uuid.UUID)
+	wanted := make(map[uuid.UUID]bool)
+	for _, dn := range entry.Values(r.cfg.Groups.Attributes.Member) {
+		key := ldap.NormalizeDN(dn)
+		if id, ok := r.users[key]; ok {
+			wantUsers[dn] = id
+			wanted[id] = true
+		} else if id, ok := r.groups[key]; ok && key != ldap.NormalizeDN(entry.DN) {
+			wantGroups[dn] = id
+			wanted[id] = true
+		}
+	}
+
+	haveUsers := make(map[uuid.UUID]models.GroupRole)
+	haveGroups := make(map[uuid.UUID]bool)
+	if groupID != uuid.Nil {
+		for offset := 0; ; offset += groupPageSize {
+			page, err := r.groupDAO.GetMembers(ctx, groupID, false, groupPageSize, offset)
+			if err != nil {
+				change.Error = err.Error()
+				return
+			}
+			for _, member := range page {
+				haveUsers[member.UserID] = member.Role
+			}
+			if len(page) < groupPageSize {
+				break
+			}
+		}
+		subgroups, err := r.groupDAO.GetSubgroups(ctx, groupID)
+		if err != nil {
+			change.Error = err.Error()
+			return
+		}
+		for _, subgroup := range subgroups {
+			haveGroups[subgroup.ID] = true
+		}
+	}
+
+	changes := make(map[string]models.FieldChange)
+	apply := func(key string, fc models.FieldChange, fn func() error) bool {
+		changes[key] = fc
+		if r.dryRun || groupID == uuid.Nil {
+			return true
+		}
+		if err := fn(); err != nil {
+			change.Error = fmt.Sprintf("%s: %v", key, err)
+			return false
+		}
+		return true
+	}
+
+	ok := true
+	for _, dn := range sortedKeys(wantUsers) {
+		id := wantUsers[dn]
+		if _, have := haveUsers[id]; have {
+			continue
+		}
+		ok = ok && apply("member."+dn, models.FieldChange{New: models.GroupRoleMember}, func() error {
+			_, _, err := r.groupDAO.SetMember(ctx, groupID, id, models.GroupRoleMember, r.actor)
+			return err
+		})
+	}
+	for _, dn := range sortedKeys(wantGroups) {
+		id := wantGroups[dn]
+		if haveGroups[id] {
+			continue
+		}
+		ok = ok && apply("subgroup."+dn, models.FieldChange{New: "subgroup"}, func() error {
+			return r.groupDAO.AddSubgroup(ctx, groupID, id, r.actor)
+		})
+	}
+	for id, role := range haveUsers {
+		dn, fromDirectory := r.linkedUsers[id]
+		if !fromDirectory || wanted[id] {
+			continue
+		}
+		id := id
+		ok = ok && apply("member."+dn, models.FieldChange{Old: role}, func() error {
+			return r.groupDAO.RemoveMember(ctx, groupID, id, r.actor)
+		})
+	}
+	for id := range haveGroups {
+		dn, fromDirectory := r.linkedGroups[id]
+		if !fromDirectory || wanted[id] {
+			continue
+		}
+		id := id
+		ok = ok && apply("subgroup."+dn, models.FieldChange{Old: "subgroup"}, func() error {
+			return r.groupDAO.RemoveSubgroup(ctx, groupID, id, r.actor)
+		})
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+	if change.Changes == nil {
+		change.Changes = changes
+	} else {
+		for key, fc := range changes {
+			change.Changes[key] = fc
+		}
+	}
+	if change.Action == "" {
+		change.Action = ActionUpdate
+	}
+}
+
+func sortedKeys(m map[string]uuid.UUID) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
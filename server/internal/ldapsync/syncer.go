@@ -0,0 +1,334 @@
+// Package ldapsync keeps the users and groups of an organization in step
+// with an LDAP directory. It creates, updates and deactivates them through
+// the same DAOs as the API, and can report what it would change without
+// changing anything.
+package ldapsync
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/ldap"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// Resource types of the links between users or groups and their entries.
+const (
+	ResourceUser  = "User"
+	ResourceGroup = "Group"
+)
+
+// Actions recorded in a Report. In a dry run they describe what would have
+// happened.
+const (
+	ActionCreate     = "create"
+	ActionUpdate     = "update"
+	ActionLink       = "link"
+	ActionDeactivate = "deactivate"
+	ActionReactivate = "reactivate"
+	ActionUnlink     = "unlink"
+	ActionSkip       = "skip"
+)
+
+// Report totals also count entries that needed no change and changes that
+// failed.
+const (
+	TotalUnchanged = "unchanged"
+	TotalFailed    = "failed"
+)
+
+// ErrRunning is returned when a sync is started while another is running.
+var ErrRunning = errors.New("ldap sync already running")
+
+// Change is what a sync did, or would do, to one user or group. Changes
+// holds the fields that differ; for groups, "member.<dn>" and
+// "subgroup.<dn>" record memberships gained or lost.
+type Change struct {
+	Action  string                        `json:"action"`
+	DN      string                        `json:"dn"`
+	ID      *uuid.UUID                    `json:"id,omitempty"`
+	Name    string                        `json:"name"`
+	Changes map[string]models.FieldChange `json:"changes,omitempty"`
+	Reason  string                        `json:"reason,omitempty"`
+	Error   string                        `json:"error,omitempty"`
+}
+
+// Section lists the changes to one kind of resource.
+type Section struct {
+	Totals  map[string]int `json:"totals"`
+	Changes []*Change      `json:"changes"`
+}
+
+// record adds change to the section. Changes without an action count as
+// unchanged and are not listed.
+func (s *Section) record(change *Change) {
+	switch {
+	case change.Error != "":
+		s.Totals[TotalFailed]++
+	case change.Action == "":
+		s.Totals[TotalUnchanged]++
+		return
+	default:
+		s.Totals[change.Action]++
+	}
+	s.Changes = append(s.Changes, change)
+}
+
+// Report summarises a sync run.
+type Report struct {
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Users      Section   `json:"users"`
+	Groups     Section   `json:"groups"`
+	// Error is why the sync stopped early, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Connector opens a Directory to sync from.
+type Connector func(ctx context.Context) (ldap.Directory, error)
+
+// Dialer returns a Connector that connects and binds to the directory cfg
+// names.
+func Dialer(cfg config.LDAP) Connector {
+	return func(ctx context.Context) (ldap.Directory, error) {
+		conn, err := ldap.Dial(ctx, cfg.URL, cfg.StartTLS, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.Bind(ctx, cfg.BindDN, cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// Syncer syncs the configured organization from a directory, one run at a
+// time.
+type Syncer struct {
+	cfg      config.LDAP
+	connect  Connector
+	userDAO  *dao.UserDAO
+	groupDAO *dao.GroupDAO
+	ldapDAO  *dao.LDAPDAO
+	orgDAO   *dao.OrganizationDAO
+	cacheDAO *dao.CacheDAO
+	logger   *logger.Logger
+	running  sync.Mutex
+}
+
+func NewSyncer(cfg config.LDAP, connect Connector, userDAO *dao.UserDAO, groupDAO *dao.GroupDAO, ldapDAO *dao.LDAPDAO,
+	orgDAO *dao.OrganizationDAO, cacheDAO *dao.CacheDAO, logger *logger.Logger) *Syncer {
+	return &Syncer{
+		cfg:      cfg,
+		connect:  connect,
+		userDAO:  userDAO,
+		groupDAO: groupDAO,
+		ldapDAO:  ldapDAO,
+		orgDAO:   orgDAO,
+		cacheDAO: cacheDAO,
+		logger:   logger,
+	}
+}
+
+// Enabled reports whether a directory and organization are configured.
+func (s *Syncer) Enabled() bool {
+	return s.cfg.URL != "" && s.cfg.Organization != ""
+}
+
+// OrganizationID returns the ID of the organization that is synced.
+func (s *Syncer) OrganizationID(ctx context.Context) (uuid.UUID, error) {
+	org, err := s.orgDAO.GetBySlug(ctx, s.cfg.Organization)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return org.ID, nil
+}
+
+// Sync brings the organization's users and groups in line with the
+// directory, or with dryRun only reports what that would change. The
+// report, or the error that ended the sync, is kept as the organization's
+// latest. It fails with ErrRunning if a sync is already underway.
+func (s *Syncer) Sync(ctx context.Context, dryRun bool, actor string) (*Report, error) {
+	if !s.running.TryLock() {
+		return nil, ErrRunning
+	}
+	defer s.running.Unlock()
+
+	return s.sync(ctx, dryRun, actor)
+}
+
+// Start runs Sync in the background. It fails with ErrRunning straight
+// away if a sync is already underway.
+func (s *Syncer) Start(ctx context.Context, dryRun bool, actor string) error {
+	if !s.running.TryLock() {
+		return ErrRunning
+	}
+
+	go func() {
+		defer s.running.Unlock()
+		if _, err := s.sync(ctx, dryRun, actor); err != nil {
+			s.logger.Error("LDAP sync failed", "error", err, "dry_run", dryRun)
+		}
+	}()
+	return nil
+}
+
+func (s *Syncer) sync(ctx context.Context, dryRun bool, actor string) (*Report, error) {
+	if s.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.Timeout)
+		defer cancel()
+	}
+
+	orgID, err := s.OrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = tenant.WithID(ctx, orgID)
+
+	r := &run{
+		Syncer: s,
+		dryRun: dryRun,
+		actor:  actor,
+		report: &Report{
+			DryRun:    dryRun,
+			StartedAt: time.Now().UTC(),
+			Users:     Section{Totals: make(map[string]int)},
+			Groups:    Section{Totals: make(map[string]int)},
+		},
+		users:        make(map[string]uuid.UUID),
+		linkedUsers:  make(map[uuid.UUID]string),
+		groups:       make(map[string]uuid.UUID),
+		linkedGroups: make(map[uuid.UUID]string),
+	}
+
+	err = r.syncAll(ctx)
+	r.report.FinishedAt = time.Now().UTC()
+	if err != nil {
+		r.report.Error = err.Error()
+	}
+
+	// Record the outcome even if the sync ran out of time
+	ctx = context.WithoutCancel(ctx)
+	r.invalidateCaches(ctx)
+	if err := s.cacheDAO.SetLDAPSyncReport(ctx, r.report); err != nil {
+		s.logger.Warn("Failed to store ldap sync report", "error", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("LDAP sync finished", "dry_run", dryRun, "organization_id", orgID,
+		"users", r.report.Users.Totals, "groups", r.report.Groups.Totals)
+	return r.report, nil
+}
+
+// run is the state of one sync.
+type run struct {
+	*Syncer
+	dir    ldap.Directory
+	dryRun bool
+	actor  string
+	report *Report
+
+	// users and groups map the normalized DNs of the entries synced to
+	// their users and groups, or to uuid.Nil for those a dry run would
+	// create.
+	users  map[string]uuid.UUID
+	groups map[string]uuid.UUID
+	// linkedUsers and linkedGroups hold the DNs of the users and groups
+	// that belong to the directory, by ID.
+	linkedUsers  map[uuid.UUID]string
+	linkedGroups map[uuid.UUID]string
+	// changedUsers are the IDs of users whose cached copies are stale.
+	changedUsers []string
+}
+
+func (r *run) syncAll(ctx context.Context) error {
+	dir, err := r.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to directory: %w", err)
+	}
+	defer dir.Close()
+	r.dir = dir
+
+	if err := r.syncUsers(ctx); err != nil {
+		return err
+	}
+	if r.cfg.Groups.Filter != "" {
+		return r.syncGroups(ctx)
+	}
+	return nil
+}
+
+func (r *run) link(ctx context.Context, resourceType string, id uuid.UUID, entryID, dn string, deactivated bool) error {
+	return r.ldapDAO.SetLink(ctx, &models.LDAPLink{
+		ResourceType: resourceType,
+		ResourceID:   id,
+		EntryID:      entryID,
+		DN:           dn,
+		Deactivated:  deactivated,
+	})
+}
+
+func (r *run) invalidateCaches(ctx context.Context) {
+	if len(r.changedUsers) == 0 {
+		return
+	}
+	if err := r.cacheDAO.DeleteUser(ctx, r.changedUsers...); err != nil {
+		r.logger.Warn("Failed to invalidate user cache", "error", err)
+	}
+	if err := r.cacheDAO.InvalidateUsersList(ctx); err != nil {
+		r.logger.Warn("Failed to invalidate users list cache", "error", err)
+	}
+}
+
+// entryID returns the stable ID of entry held in attr. Binary IDs such as
+// objectGUID are hex-encoded; entries without one fall back to their DN.
+func entryID(entry *ldap.Entry, attr string) string {
+	id := entry.Value(attr)
+	if id == "" {
+		return "dn:" + ldap.NormalizeDN(entry.DN)
+	}
+	if !utf8.ValidString(id) || strings.ContainsRune(id, 0) {
+		return hex.EncodeToString([]byte(id))
+	}
+	return id
+}
+
+// and returns a filter matching what both filters match.
+func and(a, b string) string {
+	return "(&" + parenthesize(a) + parenthesize(b) + ")"
+}
+
+func parenthesize(filter string) string {
+	filter = strings.TrimSpace(filter)
+	if strings.HasPrefix(filter, "(") {
+		return filter
+	}
+	return "(" + filter + ")"
+}
+
+// attributes returns the attribute names that are set.
+func attributes(names ...string) []string {
+	var out []string
+	for _, name := range names {
+		if name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,421 @@
+//go:build integration
+
+package ldapsync
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/encryption"
+	"github.com/spurge/p4rsec/server/internal/ldap"
+	appLogger "github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+	"go.uber.org/zap"
+)
+
+// These tests sync from an ldap.Memory into the database named by
+// TEST_DATABASE_URL, with Redis at TEST_REDIS_ADDR, as the dao integration
+// tests do.
+
+func testDB(t *testing.T) *database.PostgresDB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("invalid TEST_DATABASE_URL: %v", err)
+	}
+	password, _ := u.User.Password()
+	cfg := config.Database{
+		Host:         u.Hostname(),
+		Port:         u.Port(),
+		User:         u.User.Username(),
+		Password:     password,
+		Name:         strings.TrimPrefix(u.Path, "/"),
+		SSLMode:      u.Query().Get("sslmode"),
+		MaxOpenConns: 4,
+	}
+	if cfg.Port == "" {
+		cfg.Port = "5432"
+	}
+	if cfg.SSLMode == "" {
+		cfg.SSLMode = "disable"
+	}
+
+	db, err := database.NewPostgresConnection(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testRedis(t *testing.T) *database.RedisDB {
+	t.Helper()
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR is not set")
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("invalid TEST_REDIS_ADDR: %v", err)
+	}
+	redis, err := database.NewRedisConnection(config.Redis{Host: host, Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { redis.Close() })
+	return redis
+}
+
+// plainKeys is a KeyProvider that stores data keys as they are.
+type plainKeys struct{}
+
+func (plainKeys) PrimaryKeyID() string { return "test" }
+
+func (plainKeys) WrapKey(_ context.Context, _ string, dataKey []byte) ([]byte, error) {
+	return dataKey, nil
+}
+
+func (plainKeys) UnwrapKey(_ context.Context, _ string, wrapped []byte) ([]byte, error) {
+	return wrapped, nil
+}
+
+// fixture is a syncer for a new organization of its own, reading from dir.
+type fixture struct {
+	syncer *Syncer
+	dir    *ldap.Memory
+	ctx    context.Context
+	users  *dao.UserDAO
+	groups *dao.GroupDAO
+}
+
+func newFixture(t *testing.T, groups bool) *fixture {
+	t.Helper()
+	db, redis := testDB(t), testRedis(t)
+
+	slug := "ldap-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:20]
+	org := &models.Organization{Slug: slug, Name: slug}
+	if err := dao.NewOrganizationDAO(db).Create(context.Background(), org, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	cipher, err := encryption.NewCipher(plainKeys{}, make([]byte, encryption.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.LDAP{
+		Organization: slug,
+		URL:          "ldap://memory",
+		Deactivate:   true,
+		Users: config.LDAPUsers{
+			BaseDN:         "ou=people,dc=example,dc=com",
+			Filter:         "(objectClass=person)",
+			DisabledFilter: "(nsAccountLock=TRUE)",
+			Attributes: config.LDAPUserAttributes{
+				ID: "entryUUID", Username: "uid", Email: "mail", FirstName: "givenName", LastName: "sn",
+			},
+		},
+	}
+	if groups {
+		cfg.Groups = config.LDAPGroups{
+			BaseDN: "ou=groups,dc=example,dc=com",
+			Filter: "(objectClass=groupOfNames)",
+			Attributes: config.LDAPGroupAttributes{
+				ID: "entryUUID", Name: "cn", Description: "description", Member: "member",
+			},
+		}
+	}
+
+	f := &fixture{
+		dir:    ldap.NewMemory(),
+		ctx:    tenant.WithUser(tenant.WithID(context.Background(), org.ID), "test"),
+		users:  dao.NewUserDAO(db, config.Users{}, cipher),
+		groups: dao.NewGroupDAO(db),
+	}
+	connect := func(context.Context) (ldap.Directory, error) { return f.dir, nil }
+	f.syncer = NewSyncer(cfg, connect, f.users, f.groups, dao.NewLDAPDAO(db), dao.NewOrganizationDAO(db),
+		dao.NewCacheDAO(redis), &appLogger.Logger{SugaredLogger: zap.NewNop().Sugar()})
+	return f
+}
+
+// person returns the entry of a user named uid.
+func person(uid, givenName string, attributes map[string][]string) *ldap.Entry {
+	entry := &ldap.Entry{
+		DN: "uid=" + uid + ",ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass": {"top", "person"},
+			"entryUUID":   {"uuid-" + uid},
+			"uid":         {uid},
+			"mail":        {uid + "@example.com"},
+			"givenName":   {givenName},
+			"sn":          {"Test"},
+		},
+	}
+	for name, values := range attributes {
+		entry.Attributes[name] = values
+	}
+	return entry
+}
+
+func (f *fixture) sync(t *testing.T, dryRun bool) *Report {
+	t.Helper()
+	report, err := f.syncer.Sync(context.Background(), dryRun, "ldap-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, section := range []Section{report.Users, report.Groups} {
+		for _, change := range section.Changes {
+			if change.Error != "" {
+				t.Fatalf("%s %s: %s", change.Action, change.DN, change.Error)
+			}
+		}
+	}
+	return report
+}
+
+// user returns the user with the given email, or nil.
+func (f *fixture) user(t *testing.T, email string) *models.User {
+	t.Helper()
+	user, err := f.users.GetByEmail(f.ctx, email)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil
+		}
+		t.Fatal(err)
+	}
+	return user
+}
+
+// wantTotals checks the totals of a report section, leaving out zeros.
+func wantTotals(t *testing.T, step string, got map[string]int, want map[string]int) {
+	t.Helper()
+	for action, n := range got {
+		if n != want[action] {
+			t.Errorf("%s: %d %s, want %d (totals %v)", step, n, action, want[action], got)
+		}
+	}
+	for action, n := range want {
+		if got[action] != n {
+			t.Errorf("%s: %d %s, want %d (totals %v)", step, got[action], action, n, got)
+		}
+	}
+}
+
+func TestSyncUsers(t *testing.T) {
+	f := newFixture(t, false)
+	f.dir.Add(person("annlee", "Ann", nil))
+	f.dir.Add(person("bobsmith", "Bob", nil))
+
+	// A dry run reports the users it would create and creates none
+	report := f.sync(t, true)
+	if !report.DryRun {
+		t.Error("the report of a dry run does not say so")
+	}
+	wantTotals(t, "dry run", report.Users.Totals, map[string]int{ActionCreate: 2})
+	if f.user(t, "annlee@example.com") != nil {
+		t.Fatal("a dry run created a user")
+	}
+
+	report = f.sync(t, false)
+	wantTotals(t, "first sync", report.Users.Totals, map[string]int{ActionCreate: 2})
+	ann := f.user(t, "annlee@example.com")
+	if ann == nil || ann.Username != "annlee" || ann.FirstName != "Ann" || ann.Status != models.UserStatusActive {
+		t.Fatalf("created user = %+v", ann)
+	}
+
+	report = f.sync(t, false)
+	wantTotals(t, "second sync", report.Users.Totals, map[string]int{TotalUnchanged: 2})
+
+	// Entries that change or move update their users
+	f.dir.Delete("uid=annlee,ou=people,dc=example,dc=com")
+	moved := person("annlee", "Anna", nil)
+	moved.DN = "uid=annlee,ou=staff,ou=people,dc=example,dc=com"
+	f.dir.Add(moved)
+	report = f.sync(t, false)
+	wantTotals(t, "update", report.Users.Totals, map[string]int{ActionUpdate: 1, TotalUnchanged: 1})
+	if changes := report.Users.Changes[0].Changes; changes["first_name"].New != "Anna" || changes["dn"].New != moved.DN {
+		t.Errorf("update changes = %v", changes)
+	}
+	if got := f.user(t, "annlee@example.com"); got.ID != ann.ID || got.FirstName != "Anna" {
+		t.Errorf("updated user = %+v", got)
+	}
+
+	// Disabled entries deactivate their users, and enabling them
+	// reactivates them; a dry run only reports it
+	f.dir.Add(person("bobsmith", "Bob", map[string][]string{"nsAccountLock": {"TRUE"}}))
+	report = f.sync(t, true)
+	wantTotals(t, "dry-run deactivation", report.Users.Totals, map[string]int{ActionDeactivate: 1, TotalUnchanged: 1})
+	if bob := f.user(t, "bobsmith@example.com"); bob.Status != models.UserStatusActive {
+		t.Fatalf("a dry run suspended bob: %s", bob.Status)
+	}
+	report = f.sync(t, false)
+	wantTotals(t, "deactivation", report.Users.Totals, map[string]int{ActionDeactivate: 1, TotalUnchanged: 1})
+	if bob := f.user(t, "bobsmith@example.com"); bob.Status != models.UserStatusSuspended {
+		t.Fatalf("disabled bob is %s", bob.Status)
+	}
+
+	f.dir.Add(person("bobsmith", "Bob", nil))
+	report = f.sync(t, false)
+	wantTotals(t, "reactivation", report.Users.Totals, map[string]int{ActionReactivate: 1, TotalUnchanged: 1})
+	if bob := f.user(t, "bobsmith@example.com"); bob.Status != models.UserStatusActive {
+		t.Fatalf("enabled bob is %s", bob.Status)
+	}
+
+	// Entries that leave deactivate their users, which come back with them
+	f.dir.Delete("uid=bobsmith,ou=people,dc=example,dc=com")
+	report = f.sync(t, false)
+	wantTotals(t, "removal", report.Users.Totals, map[string]int{ActionDeactivate: 1, TotalUnchanged: 1})
+	if report.Users.Changes[0].Reason != "no longer in the directory" {
+		t.Errorf("removal reason = %q", report.Users.Changes[0].Reason)
+	}
+	f.dir.Add(person("bobsmith", "Bob", nil))
+	report = f.sync(t, false)
+	wantTotals(t, "return", report.Users.Totals, map[string]int{ActionReactivate: 1, TotalUnchanged: 1})
+
+	// Suspensions made by hand stand
+	if _, err := f.users.ChangeStatus(f.ctx, ann.ID, models.UserStatusSuspended, "By hand", "test"); err != nil {
+		t.Fatal(err)
+	}
+	report = f.sync(t, false)
+	wantTotals(t, "manual suspension", report.Users.Totals, map[string]int{TotalUnchanged: 2})
+	if got := f.user(t, "annlee@example.com"); got.Status != models.UserStatusSuspended {
+		t.Errorf("the sync reactivated a user suspended by hand: %s", got.Status)
+	}
+
+	// An empty result is taken for a misconfiguration, not everyone leaving
+	f.dir.Delete("uid=annlee,ou=staff,ou=people,dc=example,dc=com")
+	f.dir.Delete("uid=bobsmith,ou=people,dc=example,dc=com")
+	if _, err := f.syncer.Sync(context.Background(), false, "ldap-sync"); err == nil {
+		t.Error("a sync that found no users succeeded")
+	}
+	if bob := f.user(t, "bobsmith@example.com"); bob.Status != models.UserStatusActive {
+		t.Errorf("an empty directory suspended bob: %s", bob.Status)
+	}
+}
+
+func TestSyncLinksExistingUsers(t *testing.T) {
+	f := newFixture(t, false)
+	carol := &models.User{
+		Email: "carol@example.com", Username: "carol", FirstName: "Carol", LastName: "Test",
+		Status: models.UserStatusActive, Attributes: map[string]interface{}{},
+	}
+	if err := f.users.Create(f.ctx, carol, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A user with the entry's email is linked rather than created again,
+	// and skipped if a second entry claims the same email
+	f.dir.Add(person("carol", "Caroline", nil))
+	f.dir.Add(person("carol2", "Carol", map[string][]string{"mail": {"carol@example.com"}}))
+	report := f.sync(t, false)
+	wantTotals(t, "link", report.Users.Totals, map[string]int{ActionLink: 1, ActionSkip: 1})
+	if got := f.user(t, "carol@example.com"); got.ID != carol.ID || got.FirstName != "Caroline" {
+		t.Errorf("linked user = %+v", got)
+	}
+
+	// Disabled entries without a user are not created
+	f.dir.Add(person("dave", "Dave", map[string][]string{"nsAccountLock": {"TRUE"}}))
+	report = f.sync(t, false)
+	if report.Users.Totals[ActionCreate] != 0 || f.user(t, "dave@example.com") != nil {
+		t.Error("a disabled entry created a user")
+	}
+}
+
+func TestSyncGroups(t *testing.T) {
+	f := newFixture(t, true)
+	f.dir.Add(person("annlee", "Ann", nil))
+	f.dir.Add(person("bobsmith", "Bob", nil))
+	group := func(cn string, members ...string) *ldap.Entry {
+		return &ldap.Entry{
+			DN: "cn=" + cn + ",ou=groups,dc=example,dc=com",
+			Attributes: map[string][]string{
+				"objectClass": {"groupOfNames"},
+				"entryUUID":   {"uuid-" + cn},
+				"cn":          {cn},
+				"description": {cn + " team"},
+				"member":      members,
+			},
+		}
+	}
+	ann, bob := "uid=annlee,ou=people,dc=example,dc=com", "uid=bobsmith,ou=people,dc=example,dc=com"
+	f.dir.Add(group("engineering", ann, "cn=backend,ou=groups,dc=example,dc=com"))
+	f.dir.Add(group("backend", bob))
+
+	report := f.sync(t, true)
+	wantTotals(t, "dry run", report.Groups.Totals, map[string]int{ActionCreate: 2})
+	if groups, err := f.groups.GetAll(f.ctx, 10, 0); err != nil || len(groups) != 0 {
+		t.Fatalf("a dry run created groups: %v %v", groups, err)
+	}
+
+	report = f.sync(t, false)
+	wantTotals(t, "first sync", report.Groups.Totals, map[string]int{ActionCreate: 2})
+	groups, err := f.groups.GetAll(f.ctx, 10, 0)
+	if err != nil || len(groups) != 2 {
+		t.Fatalf("groups = %v %v", groups, err)
+	}
+	backend, engineering := groups[0], groups[1]
+	if engineering.Description != "engineering team" {
+		t.Errorf("description = %q", engineering.Description)
+	}
+	members := func(g *models.Group, transitive bool) map[uuid.UUID]bool {
+		t.Helper()
+		list, err := f.groups.GetMembers(f.ctx, g.ID, transitive, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make(map[uuid.UUID]bool)
+		for _, member := range list {
+			ids[member.UserID] = true
+		}
+		return ids
+	}
+	annID, bobID := f.user(t, "annlee@example.com").ID, f.user(t, "bobsmith@example.com").ID
+	if got := members(engineering, true); len(got) != 2 || !got[annID] || !got[bobID] {
+		t.Errorf("engineering has %v, want ann and, through backend, bob", got)
+	}
+
+	// Members that leave the entry leave the group, while those added by
+	// hand stay
+	carol := &models.User{
+		Email: "carol@example.com", Username: "carol", FirstName: "Carol", LastName: "Test",
+		Status: models.UserStatusActive, Attributes: map[string]interface{}{},
+	}
+	if err := f.users.Create(f.ctx, carol, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.groups.SetMember(f.ctx, backend.ID, carol.ID, models.GroupRoleMember, "test"); err != nil {
+		t.Fatal(err)
+	}
+	f.dir.Add(group("backend", ann))
+	report = f.sync(t, false)
+	wantTotals(t, "membership", report.Groups.Totals, map[string]int{ActionUpdate: 1, TotalUnchanged: 1})
+	if got := members(backend, false); len(got) != 2 || !got[annID] || !got[carol.ID] {
+		t.Errorf("backend has %v, want ann and carol", got)
+	}
+
+	// Groups that leave the directory are unlinked and kept
+	f.dir.Delete("cn=backend,ou=groups,dc=example,dc=com")
+	f.dir.Add(group("engineering", ann))
+	report = f.sync(t, false)
+	wantTotals(t, "unlink", report.Groups.Totals, map[string]int{ActionUnlink: 1, ActionUpdate: 1})
+	if _, err := f.groups.GetByID(f.ctx, backend.ID); err != nil {
+		t.Errorf("the unlinked group is gone: %v", err)
+	}
+	if subgroups, err := f.groups.GetSubgroups(f.ctx, engineering.ID); err != nil || len(subgroups) != 0 {
+		t.Errorf("engineering still has subgroups %v (%v)", subgroups, err)
+	}
+}
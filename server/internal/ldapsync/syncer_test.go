@@ -0,0 +1,64 @@
+package ldapsync
+
+import (
+	"testing"
+
+	"github.com/spurge/p4rsec/server/internal/ldap"
+)
+
+func TestEntryID(t *testing.T) {
+	entry := &ldap.Entry{
+		DN: "CN=Ann Lee, OU=People,DC=example,DC=com",
+		Attributes: map[string][]string{
+			"entryUUID":  {"5f2b8a3c-1d4e-4f6a-9b7c-8d9e0f1a2b3c"},
+			"objectGUID": {"\x01\x00\xff\x10"},
+		},
+	}
+	tests := map[string]string{
+		"entryUUID":  "5f2b8a3c-1d4e-4f6a-9b7c-8d9e0f1a2b3c",
+		"objectGUID": "0100ff10",
+		"missing":    "dn:cn=ann lee,ou=people,dc=example,dc=com",
+	}
+	for attr, want := range tests {
+		if got := entryID(entry, attr); got != want {
+			t.Errorf("entryID(%s) = %q, want %q", attr, got, want)
+		}
+	}
+}
+
+func TestAnd(t *testing.T) {
+	tests := []struct{ a, b, want string }{
+		{"(objectClass=person)", "(nsAccountLock=TRUE)", "(&(objectClass=person)(nsAccountLock=TRUE))"},
+		{" objectClass=person", "userAccountControl:1.2.840.113556.1.4.803:=2",
+			"(&(objectClass=person)(userAccountControl:1.2.840.113556.1.4.803:=2))"},
+	}
+	for _, tt := range tests {
+		got := and(tt.a, tt.b)
+		if got != tt.want {
+			t.Errorf("and(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+		if _, err := ldap.ParseFilter(got); err != nil {
+			t.Errorf("and(%q, %q) does not parse: %v", tt.a, tt.b, err)
+		}
+	}
+}
+
+func TestSectionRecord(t *testing.T) {
+	s := Section{Totals: make(map[string]int)}
+	s.record(&Change{Action: ActionCreate, DN: "uid=a"})
+	s.record(&Change{DN: "uid=b"})
+	s.record(&Change{Action: ActionUpdate, DN: "uid=c", Error: "failed"})
+
+	want := map[string]int{ActionCreate: 1, TotalUnchanged: 1, TotalFailed: 1}
+	for action, n := range want {
+		if s.Totals[action] != n {
+			t.Errorf("%s = %d, want %d", action, s.Totals[action], n)
+		}
+	}
+	if s.Totals[ActionUpdate] != 0 {
+		t.Error("a failed update counts as an update")
+	}
+	if len(s.Changes) != 2 || s.Changes[0].DN != "uid=a" || s.Changes[1].DN != "uid=c" {
+		t.Errorf("changes = %v, want the create and the failure", s.Changes)
+	}
+}
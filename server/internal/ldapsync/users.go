@@ -0,0 +1,307 @@
+package ldapsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/ldap"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// noAttributes asks a search for entries without any of their attributes
+// (RFC 4511 section 4.5.1.8).
+const noAttributes = "1.1"
+
+// syncUsers creates, updates and links a user for every user entry, and
+// deactivates the users whose entries are disabled or gone.
+func (r *run) syncUsers(ctx context.Context) error {
+	cfg := r.cfg.Users
+	attrs := cfg.Attributes
+
+	entries, err := r.dir.Search(ctx, &ldap.SearchRequest{
+		BaseDN:     cfg.BaseDN,
+		Filter:     cfg.Filter,
+		Attributes: attributes(attrs.ID, attrs.Username, attrs.Email, attrs.FirstName, attrs.LastName),
+		PageSize:   r.cfg.PageSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to search users: %w", err)
+	}
+
+	disabled := make(map[string]bool)
+	if cfg.DisabledFilter != "" {
+		matches, err := r.dir.Search(ctx, &ldap.SearchRequest{
+			BaseDN:     cfg.BaseDN,
+			Filter:     and(cfg.Filter, cfg.DisabledFilter),
+			Attributes: []string{noAttributes},
+			PageSize:   r.cfg.PageSize,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to search disabled users: %w", err)
+		}
+		for _, entry := range matches {
+			disabled[ldap.NormalizeDN(entry.DN)] = true
+		}
+	}
+
+	links, err := r.ldapDAO.GetLinks(ctx, ResourceUser)
+	if err != nil {
+		return err
+	}
+	// A misconfigured base DN or filter finds nothing, which must not read
+	// as everyone having left
+	if len(entries) == 0 && len(links) > 0 {
+		return errors.New("the directory returned no users, refusing to deactivate every linked user")
+	}
+	linked := make(map[string]*models.LDAPLink, len(links))
+	for _, link := range links {
+		linked[link.EntryID] = link
+		r.linkedUsers[link.ResourceID] = link.DN
+	}
+
+	byID := make(map[uuid.UUID]*models.User)
+	byEmail := make(map[string]*models.User)
+	err = r.userDAO.StreamUsers(ctx, models.UserFilter{}, func(user *models.User) error {
+		byID[user.ID] = user
+		byEmail[r.userDAO.NormalizeEmail(user.Email)] = user
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DN < entries[j].DN })
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		id := entryID(entry, attrs.ID)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		change := r.syncUser(ctx, entry, id, linked[id], byID, byEmail, disabled[ldap.NormalizeDN(entry.DN)])
+		r.report.Users.record(change)
+	}
+
+	for _, link := range links {
+		if seen[link.EntryID] {
+			continue
+		}
+		if change := r.removeUser(ctx, link, byID[link.ResourceID]); change != nil {
+			r.report.Users.record(change)
+		}
+	}
+
+	return nil
+}
+
+func (r *run) syncUser(ctx context.Context, entry *ldap.Entry, entryID string, link *models.LDAPLink,
+	byID map[uuid.UUID]*models.User, byEmail map[string]*models.User, disabled bool) *Change {
+	attrs := r.cfg.Users.Attributes
+	want := &models.User{
+		Email:     strings.TrimSpace(entry.Value(attrs.Email)),
+		Username:  strings.TrimSpace(entry.Value(attrs.Username)),
+		FirstName: strings.TrimSpace(entry.Value(attrs.FirstName)),
+		LastName:  strings.TrimSpace(entry.Value(attrs.LastName)),
+	}
+	change := &Change{DN: entry.DN, Name: want.Username}
+
+	var user *models.User
+	if link != nil {
+		if user = byID[link.ResourceID]; user == nil {
+			change.Action, change.Reason = ActionSkip, "the linked user has been deleted"
+			return change
+		}
+	} else if user = byEmail[r.userDAO.NormalizeEmail(want.Email)]; user != nil {
+		if _, taken := r.linkedUsers[user.ID]; taken {
+			change.Action, change.Reason = ActionSkip, "another entry is linked to the user with this email"
+			return change
+		}
+	}
+
+	if user == nil {
+		r.createUser(ctx, change, entry, entryID, want, disabled)
+	} else {
+		r.updateUser(ctx, change, entry, entryID, link, user, want, disabled)
+	}
+	return change
+}
+
+func (r *run) createUser(ctx context.Context, change *Change, entry *ldap.Entry, entryID string, want *models.User, disabled bool) {
+	if disabled {
+		change.Action, change.Reason = ActionSkip, "disabled in the directory"
+		return
+	}
+
+	change.Action = ActionCreate
+	change.Changes = map[string]models.FieldChange{
+		"email":      {New: want.Email},
+		"username":   {New: want.Username},
+		"first_name": {New: want.FirstName},
+		"last_name":  {New: want.LastName},
+	}
+	if err := validateUser(want, true); err != nil {
+		change.Error = err.Error()
+		return
+	}
+
+	dn := ldap.NormalizeDN(entry.DN)
+	r.users[dn] = uuid.Nil
+	if r.dryRun {
+		return
+	}
+
+	if err := r.userDAO.Create(ctx, want, r.actor); err != nil {
+		delete(r.users, dn)
+		change.Error = err.Error()
+		return
+	}
+	change.ID = &want.ID
+	r.users[dn] = want.ID
+	r.linkedUsers[want.ID] = entry.DN
+	r.changedUsers = append(r.changedUsers, want.ID.String())
+
+	if err := r.link(ctx, ResourceUser, want.ID, entryID, entry.DN, false); err != nil {
+		change.Error = err.Error()
+	}
+}
+
+// updateUser brings an existing user in line with its entry. Users are
+// only reactivated if the sync deactivated them, so that suspensions made
+// by hand stand.
+func (r *run) updateUser(ctx context.Context, change *Change, entry *ldap.Entry, entryID string, link *models.LDAPLink,
+	user, want *models.User, disabled bool) {
+	change.ID = &user.ID
+	r.users[ldap.NormalizeDN(entry.DN)] = user.ID
+	r.linkedUsers[user.ID] = entry.DN
+
+	changes := make(map[string]models.FieldChange)
+	updates := make(map[string]interface{})
+	diff := func(field, old, new string) {
+		if old != new {
+			changes[field] = models.FieldChange{Old: old, New: new}
+			updates[field] = new
+		}
+	}
+	diff("email", user.Email, want.Email)
+	diff("username", user.Username, want.Username)
+	diff("first_name", user.FirstName, want.FirstName)
+	diff("last_name", user.LastName, want.LastName)
+
+	deactivated := link != nil && link.Deactivated
+	var status models.UserStatus
+	var reason string
+	switch {
+	case disabled && r.cfg.Deactivate && user.Status == models.UserStatusActive:
+		status, reason, deactivated = models.UserStatusSuspended, "Disabled in directory", true
+	case !disabled && deactivated && user.Status == models.UserStatusSuspended:
+		status, reason, deactivated = models.UserStatusActive, "Enabled in directory", false
+	case !disabled:
+		deactivated = false
+	}
+	if status != "" {
+		changes["status"] = models.FieldChange{Old: user.Status, New: status}
+	}
+
+	switch {
+	case link == nil:
+		change.Action = ActionLink
+	case status == models.UserStatusSuspended:
+		change.Action = ActionDeactivate
+	case status == models.UserStatusActive:
+		change.Action = ActionReactivate
+	case len(updates) > 0:
+		change.Action = ActionUpdate
+	}
+	if link != nil && link.DN != entry.DN {
+		changes["dn"] = models.FieldChange{Old: link.DN, New: entry.DN}
+		if change.Action == "" {
+			change.Action = ActionUpdate
+		}
+	}
+	if len(changes) > 0 {
+		change.Changes = changes
+	}
+
+	if len(updates) > 0 {
+		merged := *user
+		merged.Email, merged.Username = want.Email, want.Username
+		merged.FirstName, merged.LastName = want.FirstName, want.LastName
+		if err := validateUser(&merged, merged.Username != user.Username); err != nil {
+			change.Error = err.Error()
+			return
+		}
+	}
+
+	relink := link == nil || link.DN != entry.DN || link.Deactivated != deactivated
+	if r.dryRun || (change.Action == "" && !relink) {
+		return
+	}
+
+	if len(updates) > 0 {
+		if err := r.userDAO.Update(ctx, user.ID, user.Version, updates, r.actor); err != nil {
+			change.Error = err.Error()
+			return
+		}
+	}
+	if status != "" {
+		if _, err := r.userDAO.ChangeStatus(ctx, user.ID, status, reason, r.actor); err != nil {
+			change.Error = err.Error()
+			return
+		}
+	}
+	if len(updates) > 0 || status != "" {
+		r.changedUsers = append(r.changedUsers, user.ID.String())
+	}
+
+	if err := r.link(ctx, ResourceUser, user.ID, entryID, entry.DN, deactivated); err != nil {
+		change.Error = err.Error()
+	}
+}
+
+// removeUser deactivates the user of an entry that has left the directory.
+// The link stays, so that the user comes back if the entry does.
+func (r *run) removeUser(ctx context.Context, link *models.LDAPLink, user *models.User) *Change {
+	if user == nil || !r.cfg.Deactivate || user.Status != models.UserStatusActive {
+		return nil
+	}
+
+	change := &Change{
+		Action:  ActionDeactivate,
+		DN:      link.DN,
+		ID:      &user.ID,
+		Name:    user.Username,
+		Changes: map[string]models.FieldChange{"status": {Old: user.Status, New: models.UserStatusSuspended}},
+		Reason:  "no longer in the directory",
+	}
+	if r.dryRun {
+		return change
+	}
+
+	if _, err := r.userDAO.ChangeStatus(ctx, user.ID, models.UserStatusSuspended, "Removed from directory", r.actor); err != nil {
+		change.Error = err.Error()
+		return change
+	}
+	r.changedUsers = append(r.changedUsers, user.ID.String())
+
+	if err := r.link(ctx, ResourceUser, user.ID, link.EntryID, link.DN, true); err != nil {
+		change.Error = err.Error()
+	}
+	return change
+}
+
+// validateUser checks a user as the API would. Usernames are only held to
+// the username rules when they are new, as existing ones may predate them.
+func validateUser(user *models.User, newUsername bool) error {
+	if err := models.ValidateUser(user); err != nil {
+		return err
+	}
+	if newUsername {
+		return models.ValidateUsername(user.Username)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/spurge/p4rsec/server/internal/logger"
+)
+
+// Log writes emails to the log instead of sending them. It is meant for
+// development, where invitation links can be copied from the output.
+type Log struct {
+	logger *logger.Logger
+}
+
+func NewLog(logger *logger.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+func (m *Log) Send(ctx context.Context, msg Message) error {
+	m.logger.Info("Email not sent (log mailer)", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/logger"
+)
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails, such as invitations, on behalf of the server.
+type Mailer interface {
+	// Send delivers msg, returning once the mail server has accepted it.
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the Mailer selected by cfg.Driver.
+func New(cfg config.Mailer, logger *logger.Logger) (Mailer, error) {
+	switch cfg.Driver {
+	case "", "log":
+		return NewLog(logger), nil
+	case "smtp":
+		return NewSMTP(cfg.From, cfg.SMTP)
+	default:
+		return nil, fmt.Errorf("unknown mailer driver %q", cfg.Driver)
+	}
+}
+
+// validHeader reports whether value can go in a header without starting
+// another one.
+func validHeader(value string) bool {
+	return !strings.ContainsAny(value, "\r\n")
+}
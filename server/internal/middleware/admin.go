@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/config"
+	appLogger "github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// OperatorLocal is the Fiber local set to true on requests made by an
+// operator.
+const OperatorLocal = "operator"
+
+// Admin lets through only requests made by operators: those with a valid
+// bearer token carrying the admin role. Requests without a valid token
+// return 401 and those whose token lacks the role 403. The token's subject
+// is attached as the request's user.
+func Admin(logger *appLogger.Logger, cfg config.JWT) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		auth := c.Get(fiber.HeaderAuthorization)
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return unauthorized(c, "Authentication required")
+		}
+
+		claims, err := tenant.ParseToken(strings.TrimPrefix(auth, "Bearer "), cfg.Secret)
+		if err != nil {
+			return unauthorized(c, "Invalid bearer token")
+		}
+		user, err := claims.String("sub")
+		if err != nil || user == "" {
+			return unauthorized(c, "Invalid bearer token")
+		}
+
+		if !claims.HasRole(cfg.RoleClaim, cfg.AdminRole) {
+			logger.Warn("Admin request without the admin role", "user", user, "path", c.Path())
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":   true,
+				"message": "Admin role required",
+			})
+		}
+
+		c.Locals(OperatorLocal, true)
+		c.SetUserContext(tenant.WithUser(c.UserContext(), user))
+		return c.Next()
+	}
+}
+
+func unauthorized(c *fiber.Ctx, message string) error {
+	c.Set(fiber.HeaderWWWAuthenticate, `Bearer`)
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error":   true,
+		"message": message,
+	})
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/config"
+	appLogger "github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+	"go.uber.org/zap"
+)
+
+const testSecret = "test-secret"
+
+var testJWT = config.JWT{Secret: testSecret, RoleClaim: "role", AdminRole: "admin"}
+
+func testLogger() *appLogger.Logger {
+	return &appLogger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+}
+
+func signedToken(t *testing.T, claims tenant.Claims) string {
+	t.Helper()
+	token, err := tenant.SignToken(claims, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAdmin(t *testing.T) {
+	app := fiber.New()
+	app.Use(Admin(testLogger(), testJWT))
+	app.Get("/", func(c *fiber.Ctx) error {
+		user, _ := tenant.UserFromContext(c.UserContext())
+		return c.SendString(user)
+	})
+
+	tests := []struct {
+		name   string
+		auth   string
+		status int
+	}{
+		{"no token", "", fiber.StatusUnauthorized},
+		{"not a bearer token", "Basic YWxpY2U6c2VjcmV0", fiber.StatusUnauthorized},
+		{"forged token", "Bearer " + mustForge(t), fiber.StatusUnauthorized},
+		{"token without subject", "Bearer " + signedToken(t, tenant.Claims{"role": "admin"}), fiber.StatusUnauthorized},
+		{"token without role", "Bearer " + signedToken(t, tenant.Claims{"sub": "bob"}), fiber.StatusForbidden},
+		{"token with another role", "Bearer " + signedToken(t, tenant.Claims{"sub": "bob", "role": "user"}), fiber.StatusForbidden},
+		{"admin token", "Bearer " + signedToken(t, tenant.Claims{"sub": "alice", "role": "admin"}), fiber.StatusOK},
+		{"admin among roles", "Bearer " + signedToken(t, tenant.Claims{"sub": "alice", "role": []string{"user", "admin"}}), fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func mustForge(t *testing.T) string {
+	t.Helper()
+	token, err := tenant.SignToken(tenant.Claims{"sub": "mallory", "role": "admin"}, "guessed-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,190 @@
+// Package middleware holds Fiber middleware specific to this API.
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	appLogger "github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// IdempotencyKeyHeader lets clients retry a mutating request safely.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader marks a response replayed from an earlier request.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored and replayed with the body.
+var replayedHeaders = []string{fiber.HeaderContentType, fiber.HeaderETag, fiber.HeaderLocation}
+
+// idempotencyRecord is the stored outcome of a request.
+type idempotencyRecord struct {
+	Fingerprint string            `json:"fingerprint"`
+	Status      int               `json:"status"`
+	Headers     map[string]string `json:"headers"`
+	Body        []byte            `json:"body"`
+}
+
+// IdempotencyStore keeps idempotency records and the locks on keys whose
+// requests are in flight. dao.CacheDAO implements it over Redis.
+type IdempotencyStore interface {
+	SetIdempotencyRecord(ctx context.Context, key string, record interface{}, expiration time.Duration) error
+	GetIdempotencyRecord(ctx context.Context, key string, record interface{}) (bool, error)
+	LockIdempotencyKey(ctx context.Context, key string, expiration time.Duration) (string, bool, error)
+	UnlockIdempotencyKey(ctx context.Context, key, token string) error
+}
+
+// Idempotency makes POST, PUT, PATCH and DELETE requests carrying an
+// Idempotency-Key safe to retry. The first request with a key runs normally
+// and its response is kept for ttl; later requests with the same key and the
+// same method, path and body get that response back without running the
+// handler. Reusing a key for a different request returns 422, and a duplicate
+// that arrives while the first is still running returns 409. Server errors
+// are not stored, so such requests can be retried with the same key. Keys are
+// scoped to the request's organization, so the Tenant middleware must run
+// first. A request that outlives lockTimeout loses its lock, but releasing it
+// never removes a lock taken by another request since.
+func Idempotency(cacheDAO IdempotencyStore, logger *appLogger.Logger, ttl, lockTimeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		default:
+			return c.Next()
+		}
+
+		key := strings.TrimSpace(c.Get(IdempotencyKeyHeader))
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   true,
+				"message": "Idempotency-Key must be at most 255 characters",
+			})
+		}
+
+		// Detached so that the lock is released and the record stored even if
+		// the request is cancelled
+		background := tenant.Detach(c.UserContext())
+		ctx, cancel := context.WithTimeout(background, 5*time.Second)
+		defer cancel()
+
+		fingerprint := requestFingerprint(c)
+
+		record, err := getIdempotencyRecord(ctx, cacheDAO, key)
+		if err != nil {
+			logger.Error("Failed to read idempotency record", "error", err)
+			return idempotencyFailed(c)
+		}
+		if record != nil {
+			return replayIdempotent(c, record, fingerprint)
+		}
+
+		token, locked, err := cacheDAO.LockIdempotencyKey(ctx, key, lockTimeout)
+		if err != nil {
+			logger.Error("Failed to lock idempotency key", "error", err)
+			return idempotencyFailed(c)
+		}
+		if !locked {
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":   true,
+				"message": "A request with this Idempotency-Key is already in progress",
+			})
+		}
+		defer func() {
+			if err := cacheDAO.UnlockIdempotencyKey(background, key, token); err != nil {
+				logger.Warn("Failed to unlock idempotency key", "error", err)
+			}
+		}()
+
+		// The request holding the lock before us may have finished meanwhile
+		record, err = getIdempotencyRecord(ctx, cacheDAO, key)
+		if err != nil {
+			logger.Error("Failed to read idempotency record", "error", err)
+			return idempotencyFailed(c)
+		}
+		if record != nil {
+			return replayIdempotent(c, record, fingerprint)
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusInternalServerError {
+			return nil
+		}
+
+		record = &idempotencyRecord{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Headers:     make(map[string]string),
+			Body:        append([]byte(nil), c.Response().Body()...),
+		}
+		for _, header := range replayedHeaders {
+			if value := c.GetRespHeader(header); value != "" {
+				record.Headers[header] = value
+			}
+		}
+
+		storeCtx, storeCancel := context.WithTimeout(background, 5*time.Second)
+		defer storeCancel()
+		if err := cacheDAO.SetIdempotencyRecord(storeCtx, key, record, ttl); err != nil {
+			logger.Warn("Failed to store idempotency record", "error", err)
+		}
+
+		return nil
+	}
+}
+
+func getIdempotencyRecord(ctx context.Context, cacheDAO IdempotencyStore, key string) (*idempotencyRecord, error) {
+	var record idempotencyRecord
+	found, err := cacheDAO.GetIdempotencyRecord(ctx, key, &record)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// replayIdempotent answers a duplicate request with the stored response.
+func replayIdempotent(c *fiber.Ctx, record *idempotencyRecord, fingerprint string) error {
+	if record.Fingerprint != fingerprint {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   true,
+			"message": "Idempotency-Key has already been used for a different request",
+		})
+	}
+
+	for header, value := range record.Headers {
+		c.Set(header, value)
+	}
+	c.Set(IdempotentReplayedHeader, "true")
+	return c.Status(record.Status).Send(record.Body)
+}
+
+func idempotencyFailed(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   true,
+		"message": "Failed to process Idempotency-Key",
+	})
+}
+
+// requestFingerprint identifies a request by method, path and body.
+func requestFingerprint(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method()))
+	hash.Write([]byte{0})
+	hash.Write([]byte(c.Path()))
+	hash.Write([]byte{0})
+	hash.Write(c.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
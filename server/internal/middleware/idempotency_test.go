@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// memoryIdempotencyStore is an IdempotencyStore whose locks, like the Redis
+// ones, are released only by the token that took them.
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string][]byte
+	locks   map[string]string
+	tokens  int
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: make(map[string][]byte), locks: make(map[string]string)}
+}
+
+func (s *memoryIdempotencyStore) SetIdempotencyRecord(ctx context.Context, key string, record interface{}, expiration time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = data
+	return nil
+}
+
+func (s *memoryIdempotencyStore) GetIdempotencyRecord(ctx context.Context, key string, record interface{}) (bool, error) {
+	s.mu.Lock()
+	data, ok := s.records[key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, record)
+}
+
+func (s *memoryIdempotencyStore) LockIdempotencyKey(ctx context.Context, key string, expiration time.Duration) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, held := s.locks[key]; held {
+		return "", false, nil
+	}
+	s.tokens++
+	token := fmt.Sprintf("token-%d", s.tokens)
+	s.locks[key] = token
+	return token, true, nil
+}
+
+func (s *memoryIdempotencyStore) UnlockIdempotencyKey(ctx context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks[key] == token {
+		delete(s.locks, key)
+	}
+	return nil
+}
+
+// idempotencyApp serves POST /users through the Idempotency middleware,
+// counting how often the handler runs.
+func idempotencyApp(store IdempotencyStore, handler fiber.Handler) (*fiber.App, *int) {
+	calls := 0
+	app := fiber.New()
+	app.Use(Idempotency(store, testLogger(), time.Hour, 30*time.Second))
+	app.Post("/users", func(c *fiber.Ctx) error {
+		calls++
+		return handler(c)
+	})
+	return app, &calls
+}
+
+func created(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderLocation, "/users/1")
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": 1, "body": string(c.Body())})
+}
+
+func postUser(t *testing.T, app *fiber.App, key, body string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodPost, "/users", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	store := newMemoryIdempotencyStore()
+	app, calls := idempotencyApp(store, created)
+
+	first, firstBody := postUser(t, app, "key-1", `{"username":"ann"}`)
+	if first.StatusCode != fiber.StatusCreated || first.Header.Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("first response = %d replayed %q", first.StatusCode, first.Header.Get(IdempotentReplayedHeader))
+	}
+
+	second, secondBody := postUser(t, app, "key-1", `{"username":"ann"}`)
+	if *calls != 1 {
+		t.Errorf("handler ran %d times, want 1", *calls)
+	}
+	if second.StatusCode != fiber.StatusCreated || secondBody != firstBody {
+		t.Errorf("replay = %d %s, want %d %s", second.StatusCode, secondBody, fiber.StatusCreated, firstBody)
+	}
+	if second.Header.Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("replay is missing %s", IdempotentReplayedHeader)
+	}
+	if second.Header.Get(fiber.HeaderLocation) != "/users/1" {
+		t.Errorf("replayed Location = %q", second.Header.Get(fiber.HeaderLocation))
+	}
+	if len(store.locks) != 0 {
+		t.Errorf("locks left behind: %v", store.locks)
+	}
+
+	// Without a key, or with another one, the request runs again
+	postUser(t, app, "", `{"username":"ann"}`)
+	postUser(t, app, "key-2", `{"username":"ann"}`)
+	if *calls != 3 {
+		t.Errorf("handler ran %d times, want 3", *calls)
+	}
+}
+
+func TestIdempotencyBodyMismatch(t *testing.T) {
+	app, calls := idempotencyApp(newMemoryIdempotencyStore(), created)
+
+	postUser(t, app, "key-1", `{"username":"ann"}`)
+	resp, _ := postUser(t, app, "key-1", `{"username":"bob"}`)
+	if resp.StatusCode != fiber.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want 422", resp.StatusCode)
+	}
+	if *calls != 1 {
+		t.Errorf("handler ran %d times, want 1", *calls)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	store := newMemoryIdempotencyStore()
+	app, calls := idempotencyApp(store, created)
+
+	// Another request holds the key
+	store.locks["key-1"] = "other"
+
+	resp, _ := postUser(t, app, "key-1", `{"username":"ann"}`)
+	if resp.StatusCode != fiber.StatusConflict {
+		t.Errorf("status = %d, want 409", resp.StatusCode)
+	}
+	if resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Error("409 is missing Retry-After")
+	}
+	if *calls != 0 {
+		t.Errorf("handler ran %d times, want 0", *calls)
+	}
+	if store.locks["key-1"] != "other" {
+		t.Errorf("lock = %q, want it still held by the other request", store.locks["key-1"])
+	}
+}
+
+func TestIdempotencyKeepsLockTakenAfterExpiry(t *testing.T) {
+	store := newMemoryIdempotencyStore()
+	app, _ := idempotencyApp(store, func(c *fiber.Ctx) error {
+		// The lock expires while the handler runs and a retry claims it
+		store.mu.Lock()
+		store.locks["key-1"] = "retry"
+		store.mu.Unlock()
+		return created(c)
+	})
+
+	postUser(t, app, "key-1", `{"username":"ann"}`)
+	if store.locks["key-1"] != "retry" {
+		t.Errorf("lock = %q, want the retry's lock left in place", store.locks["key-1"])
+	}
+}
+
+func TestIdempotencySkipsServerErrors(t *testing.T) {
+	fail := true
+	app, calls := idempotencyApp(newMemoryIdempotencyStore(), func(c *fiber.Ctx) error {
+		if fail {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": true})
+		}
+		return created(c)
+	})
+
+	resp, _ := postUser(t, app, "key-1", `{"username":"ann"}`)
+	if resp.StatusCode != fiber.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", resp.StatusCode)
+	}
+
+	fail = false
+	resp, _ = postUser(t, app, "key-1", `{"username":"ann"}`)
+	if resp.StatusCode != fiber.StatusCreated || *calls != 2 {
+		t.Errorf("retry = %d after %d calls, want 201 after 2", resp.StatusCode, *calls)
+	}
+}
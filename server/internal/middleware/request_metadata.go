@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/audit"
+)
+
+// ActorHeader lets a client say who it is acting for, such as the person
+// behind a shared service token. Nothing verifies it, so it is recorded in
+// the audit log as the claimed actor and never as the actor.
+const ActorHeader = "X-Actor-ID"
+
+// RequestMetadata attaches the client IP, request ID and claimed actor to the
+// request's user context, from where the DAOs record them in the audit log.
+// It must run after the requestid middleware.
+func RequestMetadata() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		requestID, _ := c.Locals("requestid").(string)
+		c.SetUserContext(audit.WithMetadata(c.UserContext(), audit.Metadata{
+			ClaimedActor: strings.TrimSpace(c.Get(ActorHeader)),
+			IP:           c.IP(),
+			RequestID:    requestID,
+		}))
+		return c.Next()
+	}
+}
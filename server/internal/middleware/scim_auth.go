@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	appLogger "github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/scim"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// SCIMTokenLocal is the Fiber local the authenticated SCIM token is stored
+// under.
+const SCIMTokenLocal = "scim_token"
+
+// SCIMAuth authenticates SCIM requests by their bearer token. The token
+// names the organization the request acts for, so identity providers need
+// no tenant header. Failures are answered with SCIM error responses.
+func SCIMAuth(scimDAO *dao.SCIMDAO, logger *appLogger.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		auth := c.Get(fiber.HeaderAuthorization)
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return scimUnauthorized(c)
+		}
+		secret := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+
+		tenantID, err := dao.ParseSCIMToken(secret)
+		if err != nil {
+			return scimUnauthorized(c)
+		}
+		scoped := tenant.WithID(c.UserContext(), tenantID)
+
+		ctx, cancel := context.WithTimeout(scoped, 5*time.Second)
+		defer cancel()
+
+		token, err := scimDAO.Authenticate(ctx, secret)
+		if err != nil {
+			if err.Error() == "scim token not found" {
+				return scimUnauthorized(c)
+			}
+			logger.Error("Failed to authenticate SCIM token", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(
+				scim.NewError(fiber.StatusInternalServerError, "", "Failed to authenticate").Body(), scim.ContentType)
+		}
+
+		c.Locals(SCIMTokenLocal, token)
+		c.SetUserContext(scoped)
+		return c.Next()
+	}
+}
+
+func scimUnauthorized(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="SCIM"`)
+	return c.Status(fiber.StatusUnauthorized).JSON(
+		scim.NewError(fiber.StatusUnauthorized, "", "Invalid or missing bearer token").Body(), scim.ContentType)
+}
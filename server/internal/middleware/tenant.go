@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	appLogger "github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// TenantLocal is the Fiber local the resolved organization is stored under.
+const TenantLocal = "organization"
+
+// tenantSource is a place a request can name its organization in.
+type tenantSource struct {
+	name  string
+	value string
+}
+
+// OrganizationStore looks organizations up. dao.OrganizationDAO implements
+// it over Postgres.
+type OrganizationStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Organization, error)
+	GetBySlug(ctx context.Context, slug string) (*models.Organization, error)
+}
+
+// Tenant resolves the organization a request acts for and attaches it to the
+// request's user context, where the DAOs require it. The organization is
+// named, by ID or slug, in the bearer token's claim, the subdomain under the
+// base domain, or the tenant header, and falls back to the default
+// organization if none of them is present. The subdomain and header are only
+// trusted when they name the organization of the token's claim, or when the
+// token carries the admin role, so that operators can act for any
+// organization; a request naming one without a token returns 401, and with
+// a token that does not name it 403. A token that does not verify returns
+// 401 and an unknown organization 404. The subject of a valid token is
+// attached as the request's user.
+func Tenant(orgStore OrganizationStore, logger *appLogger.Logger, cfg config.Tenancy, jwt config.JWT) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var claimed []tenantSource
+		var token *tenantSource
+		var user string
+		authenticated, operator := false, false
+
+		if auth := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+			claims, err := tenant.ParseToken(strings.TrimPrefix(auth, "Bearer "), jwt.Secret)
+			var claim string
+			if err == nil {
+				claim, err = claims.String(cfg.TokenClaim)
+			}
+			if err == nil {
+				user, err = claims.String("sub")
+			}
+			if err != nil {
+				return unauthorized(c, "Invalid bearer token")
+			}
+			authenticated = true
+			operator = claims.HasRole(jwt.RoleClaim, jwt.AdminRole)
+			if claim != "" {
+				token = &tenantSource{"token", claim}
+			}
+		}
+
+		if cfg.BaseDomain != "" {
+			suffix := "." + strings.ToLower(cfg.BaseDomain)
+			if host := strings.ToLower(c.Hostname()); strings.HasSuffix(host, suffix) {
+				if sub := strings.TrimSuffix(host, suffix); sub != "" && !strings.Contains(sub, ".") {
+					claimed = append(claimed, tenantSource{"subdomain", sub})
+				}
+			}
+		}
+
+		if cfg.Header != "" {
+			if value := strings.TrimSpace(c.Get(cfg.Header)); value != "" {
+				claimed = append(claimed, tenantSource{"header", value})
+			}
+		}
+
+		// Without a token or with one that names no organization, nothing
+		// vouches for the subdomain or header, unless it is an operator's
+		var sources []tenantSource
+		switch {
+		case len(claimed) == 0:
+			if token != nil {
+				sources = append(sources, *token)
+			}
+		case operator:
+			sources = claimed
+		case !authenticated:
+			return unauthorized(c, "Authentication required to name an organization in the "+claimed[0].name)
+		case token == nil:
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":   true,
+				"message": "The bearer token does not name the organization in the " + claimed[0].name,
+			})
+		default:
+			sources = append([]tenantSource{*token}, claimed...)
+		}
+
+		if len(sources) == 0 {
+			if cfg.DefaultOrganization == "" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error":   true,
+					"message": "Organization is required",
+				})
+			}
+			sources = append(sources, tenantSource{"default", cfg.DefaultOrganization})
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), 5*time.Second)
+		defer cancel()
+
+		var org *models.Organization
+		for _, source := range sources {
+			found, err := getOrganization(ctx, orgStore, source.value)
+			if err != nil {
+				if err.Error() == "organization not found" {
+					return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+						"error":   true,
+						"message": "Organization not found",
+					})
+				}
+				logger.Error("Failed to resolve organization", "error", err, "source", source.name)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error":   true,
+					"message": "Failed to resolve organization",
+				})
+			}
+
+			if org != nil && found.ID != org.ID {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error":   true,
+					"message": "The organization in the " + source.name + " does not match the " + sources[0].name,
+				})
+			}
+			org = found
+		}
+
+		c.Locals(TenantLocal, org)
+		scoped := tenant.WithID(c.UserContext(), org.ID)
+		if user != "" {
+			scoped = tenant.WithUser(scoped, user)
+		}
+		c.SetUserContext(scoped)
+		return c.Next()
+	}
+}
+
+// getOrganization looks an organization up by ID or, failing that, by slug.
+func getOrganization(ctx context.Context, orgStore OrganizationStore, value string) (*models.Organization, error) {
+	if id, err := uuid.Parse(value); err == nil {
+		return orgStore.GetByID(ctx, id)
+	}
+	return orgStore.GetBySlug(ctx, strings.ToLower(value))
+}
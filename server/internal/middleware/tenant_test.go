@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// memoryOrganizations is an OrganizationStore over a fixed set of
+// organizations.
+type memoryOrganizations []*models.Organization
+
+func (m memoryOrganizations) GetByID(_ context.Context, id uuid.UUID) (*models.Organization, error) {
+	for _, org := range m {
+		if org.ID == id {
+			return org, nil
+		}
+	}
+	return nil, errors.New("organization not found")
+}
+
+func (m memoryOrganizations) GetBySlug(_ context.Context, slug string) (*models.Organization, error) {
+	for _, org := range m {
+		if org.Slug == slug {
+			return org, nil
+		}
+	}
+	return nil, errors.New("organization not found")
+}
+
+func TestTenant(t *testing.T) {
+	acme := &models.Organization{ID: uuid.New(), Slug: "acme"}
+	globex := &models.Organization{ID: uuid.New(), Slug: "globex"}
+	fallback := &models.Organization{ID: uuid.New(), Slug: "default"}
+	cfg := config.Tenancy{BaseDomain: "example.com", Header: "X-Tenant-ID", TokenClaim: "org", DefaultOrganization: "default"}
+
+	app := fiber.New()
+	app.Use(Tenant(memoryOrganizations{acme, globex, fallback}, testLogger(), cfg, testJWT))
+	app.Get("/", func(c *fiber.Ctx) error {
+		id, err := tenant.Require(c.UserContext())
+		if err != nil {
+			return err
+		}
+		user, _ := tenant.UserFromContext(c.UserContext())
+		return c.SendString(id.String() + " " + user)
+	})
+
+	member := "Bearer " + signedToken(t, tenant.Claims{"sub": "ann", "org": "acme"})
+	memberByID := "Bearer " + signedToken(t, tenant.Claims{"sub": "ann", "org": acme.ID.String()})
+	noOrg := "Bearer " + signedToken(t, tenant.Claims{"sub": "ann"})
+	operator := "Bearer " + signedToken(t, tenant.Claims{"sub": "root", "role": "admin"})
+	operatorOfAcme := "Bearer " + signedToken(t, tenant.Claims{"sub": "root", "role": "admin", "org": "acme"})
+
+	tests := []struct {
+		name   string
+		host   string
+		header string
+		auth   string
+		status int
+		want   string
+	}{
+		{"default organization", "", "", "", fiber.StatusOK, fallback.ID.String() + " "},
+		{"token claim", "", "", member, fiber.StatusOK, acme.ID.String() + " ann"},
+		{"token without claim", "", "", noOrg, fiber.StatusOK, fallback.ID.String() + " ann"},
+		{"invalid token", "", "", "Bearer " + mustForge(t), fiber.StatusUnauthorized, ""},
+		{"unknown organization", "", "", "Bearer " + signedToken(t, tenant.Claims{"org": "initech"}), fiber.StatusNotFound, ""},
+
+		// The header and subdomain only narrow down what the token vouches for
+		{"header matching token", "", "acme", member, fiber.StatusOK, acme.ID.String() + " ann"},
+		{"header by ID matching token by slug", "", acme.ID.String(), member, fiber.StatusOK, acme.ID.String() + " ann"},
+		{"header by slug matching token by ID", "", "acme", memberByID, fiber.StatusOK, acme.ID.String() + " ann"},
+		{"subdomain matching token", "acme.example.com", "", member, fiber.StatusOK, acme.ID.String() + " ann"},
+		{"header of another organization", "", "globex", member, fiber.StatusForbidden, ""},
+		{"subdomain of another organization", "globex.example.com", "", member, fiber.StatusForbidden, ""},
+		{"header without token", "", "acme", "", fiber.StatusUnauthorized, ""},
+		{"subdomain without token", "acme.example.com", "", "", fiber.StatusUnauthorized, ""},
+		{"header with token naming none", "", "acme", noOrg, fiber.StatusForbidden, ""},
+		{"unknown header without token", "", "initech", "", fiber.StatusUnauthorized, ""},
+
+		// Operators may act for any organization
+		{"operator header", "", "globex", operator, fiber.StatusOK, globex.ID.String() + " root"},
+		{"operator subdomain", "globex.example.com", "", operator, fiber.StatusOK, globex.ID.String() + " root"},
+		{"operator header over claim", "", "globex", operatorOfAcme, fiber.StatusOK, globex.ID.String() + " root"},
+		{"operator subdomain and header differ", "acme.example.com", "globex", operator, fiber.StatusForbidden, ""},
+		{"operator unknown header", "", "initech", operator, fiber.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.want != "" {
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != tt.want {
+					t.Errorf("acted for %q, want %q", body, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTenantWithoutDefault(t *testing.T) {
+	app := fiber.New()
+	app.Use(Tenant(memoryOrganizations{}, testLogger(), config.Tenancy{Header: "X-Tenant-ID", TokenClaim: "org"}, testJWT))
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+}
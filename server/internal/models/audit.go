@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent is one entry of the append-only audit log. Hash covers the
+// event's content and PrevHash, the hash of the event before it. When the
+// event has a ChangesDigest, the hash covers the digest rather than Changes,
+// and Changes is missing once it has been redacted. Events from before
+// organizations existed have no TenantID and belong to the default one.
+// Actor is the authenticated user or the job that made the change;
+// ClaimedActor is who the request said it was made by, unverified.
+type AuditEvent struct {
+	ID            int64           `json:"id"`
+	TenantID      *uuid.UUID      `json:"tenant_id,omitempty"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Action        string          `json:"action"`
+	Actor         *string         `json:"actor,omitempty"`
+	ClaimedActor  *string         `json:"claimed_actor,omitempty"`
+	TargetType    string          `json:"target_type"`
+	TargetID      string          `json:"target_id"`
+	IP            *string         `json:"ip,omitempty"`
+	RequestID     *string         `json:"request_id,omitempty"`
+	Changes       json.RawMessage `json:"changes,omitempty"`
+	ChangesDigest *string         `json:"changes_digest,omitempty"`
+	PrevHash      string          `json:"prev_hash"`
+	Hash          string          `json:"hash"`
+}
+
+// AuditFilter narrows audit log queries. Empty fields match everything.
+type AuditFilter struct {
+	Action     string     `json:"action,omitempty"`
+	Actor      string     `json:"actor,omitempty"`
+	TargetType string     `json:"target_type,omitempty"`
+	TargetID   string     `json:"target_id,omitempty"`
+	RequestID  string     `json:"request_id,omitempty"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpDelete = "delete"
+)
+
+// BatchOperation is one entry of a batch request. User holds a
+// CreateUserRequest for creates and an UpdateUserRequest for updates; updates
+// and deletes must name the version they expect to replace.
+type BatchOperation struct {
+	Op      string          `json:"op"`
+	ID      *uuid.UUID      `json:"id,omitempty"`
+	Version *int64          `json:"version,omitempty"`
+	User    json.RawMessage `json:"user,omitempty"`
+}
+
+type BatchUsersRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperationResult reports one operation using HTTP status codes.
+type BatchOperationResult struct {
+	Index  int        `json:"index"`
+	Op     string     `json:"op"`
+	Status int        `json:"status"`
+	ID     *uuid.UUID `json:"id,omitempty"`
+	User   *User      `json:"user,omitempty"`
+	Error  string     `json:"error,omitempty"`
+	// Field names the email or username that conflicts with another user.
+	Field string `json:"field,omitempty"`
+}
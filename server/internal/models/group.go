@@ -0,0 +1,75 @@
+package models
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// GroupRole is a user's role in a group they are a direct member of.
+type GroupRole string
+
+const (
+	GroupRoleOwner  GroupRole = "owner"
+	GroupRoleMember GroupRole = "member"
+)
+
+// Valid reports whether r is a known group role.
+func (r GroupRole) Valid() bool {
+	return r == GroupRoleOwner || r == GroupRoleMember
+}
+
+// Group collects users of one organization. Teams are groups; a group's
+// subgroups nest inside it, and their members are members of the group too.
+type Group struct {
+	ID          uuid.UUID `json:"id"`
+	TenantID    uuid.UUID `json:"tenant_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GroupMember is a user's direct membership of a group.
+type GroupMember struct {
+	GroupID   uuid.UUID `json:"group_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Role      GroupRole `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GroupMembership is a group a user belongs to. Role is set for direct
+// memberships; Via is the subgroup the user belongs through otherwise.
+type GroupMembership struct {
+	Group *Group     `json:"group"`
+	Role  *GroupRole `json:"role,omitempty"`
+	Via   *uuid.UUID `json:"via,omitempty"`
+}
+
+type CreateGroupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type UpdateGroupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type SetGroupMemberRequest struct {
+	Role GroupRole `json:"role"`
+}
+
+// ValidateGroup checks a group's name and description.
+func ValidateGroup(group *Group) error {
+	if err := validateLength("name", group.Name, 1, 255); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(group.Description) > 2000 {
+		return fmt.Errorf("description must be at most 2000 characters")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Operations recorded in a user's change history.
+const (
+	UserChangeCreate  = "create"
+	UserChangeUpdate  = "update"
+	UserChangeDelete  = "delete"
+	UserChangeStatus  = "status"
+	UserChangeRestore = "restore"
+	UserChangeAvatar  = "avatar"
+	UserChangeImport  = "import"
+	// UserChangeErase replaces everything recorded before a user's personal
+	// data was erased.
+	UserChangeErase = "erase"
+	// UserChangeBaseline marks the state a user was in when history
+	// recording began.
+	UserChangeBaseline = "baseline"
+)
+
+// UserChange is one recorded write to a user: who made it, when, and the old
+// and new value of every field it changed.
+type UserChange struct {
+	ID        int64                  `json:"id"`
+	UserID    uuid.UUID              `json:"user_id"`
+	Version   int64                  `json:"version"`
+	Operation string                 `json:"operation"`
+	Actor     *string                `json:"actor,omitempty"`
+	ChangedAt time.Time              `json:"changed_at"`
+	Changes   map[string]FieldChange `json:"changes"`
+}
+
+// FieldChange holds a field's value before and after a write, as it appears
+// in the user's JSON representation. A missing value is null. Redacted
+// marks a change of personal data recorded without its values, as in the
+// audit log.
+type FieldChange struct {
+	Old      interface{} `json:"old"`
+	New      interface{} `json:"new"`
+	Redacted bool        `json:"redacted,omitempty"`
+}
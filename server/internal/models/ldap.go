@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LDAPLink ties a user or group to the directory entry LDAP sync manages it
+// from. Deactivated is set while the user is suspended because of its entry.
+type LDAPLink struct {
+	ResourceType string    `json:"resource_type"`
+	ResourceID   uuid.UUID `json:"resource_id"`
+	EntryID      string    `json:"entry_id"`
+	DN           string    `json:"dn"`
+	Deactivated  bool      `json:"deactivated"`
+	SyncedAt     time.Time `json:"synced_at"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeEmail returns the form of email used to decide whether two
+// addresses belong to the same account: Unicode NFKC, case-folded and
+// trimmed. With foldPlus, a "+tag" suffix on the local part is dropped so
+// that "bob+news@example.com" matches "bob@example.com".
+func NormalizeEmail(email string, foldPlus bool) string {
+	normalized := normalizeIdentifier(email)
+	if !foldPlus {
+		return normalized
+	}
+
+	at := strings.LastIndexByte(normalized, '@')
+	if at < 0 {
+		return normalized
+	}
+	local, domain := normalized[:at], normalized[at:]
+	if plus := strings.IndexByte(local, '+'); plus > 0 {
+		local = local[:plus]
+	}
+	return local + domain
+}
+
+// NormalizeUsername returns the form of username used for uniqueness:
+// Unicode NFKC, case-folded and trimmed.
+func NormalizeUsername(username string) string {
+	return normalizeIdentifier(username)
+}
+
+func normalizeIdentifier(s string) string {
+	// A Caser is stateful, so each call gets its own. Folding can produce
+	// characters that compose differently, so normalize again afterwards.
+	folded := cases.Fold().String(norm.NFKC.String(strings.TrimSpace(s)))
+	return norm.NFKC.String(folded)
+}
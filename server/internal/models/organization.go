@@ -0,0 +1,44 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultOrganizationID is the organization that everything stored before
+// organizations existed was assigned to.
+var DefaultOrganizationID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// organizationSlugPattern allows a DNS label, so that every slug can be a
+// subdomain: lowercase letters, digits and inner dashes.
+var organizationSlugPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Organization is a tenant of the deployment. Its slug names it in
+// subdomains and headers and cannot change.
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CreateOrganizationRequest struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+type UpdateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+// ValidateOrganization checks an organization's slug and name.
+func ValidateOrganization(org *Organization) error {
+	if !organizationSlugPattern.MatchString(org.Slug) {
+		return fmt.Errorf("slug must be 1 to 63 lowercase letters, digits or inner dashes")
+	}
+	return validateLength("name", org.Name, 1, 255)
+}
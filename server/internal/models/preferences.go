@@ -0,0 +1,124 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// UserPreferences are a user's effective settings: the server defaults with
+// the user's own choices applied on top.
+type UserPreferences struct {
+	Theme         string                  `json:"theme"`
+	Locale        string                  `json:"locale"`
+	Timezone      string                  `json:"timezone"`
+	Notifications NotificationPreferences `json:"notifications"`
+}
+
+type NotificationPreferences struct {
+	Email     bool   `json:"email"`
+	Push      bool   `json:"push"`
+	Marketing bool   `json:"marketing"`
+	Digest    string `json:"digest"`
+}
+
+// DefaultUserPreferences apply to every setting a user has not chosen.
+var DefaultUserPreferences = UserPreferences{
+	Theme:    "system",
+	Locale:   "en-US",
+	Timezone: "UTC",
+	Notifications: NotificationPreferences{
+		Email:     true,
+		Push:      true,
+		Marketing: false,
+		Digest:    "weekly",
+	},
+}
+
+var (
+	preferenceThemes  = []string{"light", "dark", "system"}
+	preferenceDigests = []string{"none", "daily", "weekly"}
+)
+
+// PreferenceOverrides holds only the settings a user has chosen. Unset
+// members fall back to DefaultUserPreferences, so changing a default reaches
+// every user who has not overridden it.
+type PreferenceOverrides struct {
+	Theme         *string                `json:"theme,omitempty"`
+	Locale        *string                `json:"locale,omitempty"`
+	Timezone      *string                `json:"timezone,omitempty"`
+	Notifications *NotificationOverrides `json:"notifications,omitempty"`
+}
+
+type NotificationOverrides struct {
+	Email     *bool   `json:"email,omitempty"`
+	Push      *bool   `json:"push,omitempty"`
+	Marketing *bool   `json:"marketing,omitempty"`
+	Digest    *string `json:"digest,omitempty"`
+}
+
+// Apply returns the effective preferences for these overrides.
+func (o *PreferenceOverrides) Apply() UserPreferences {
+	prefs := DefaultUserPreferences
+	if o == nil {
+		return prefs
+	}
+
+	setString(&prefs.Theme, o.Theme)
+	setString(&prefs.Locale, o.Locale)
+	setString(&prefs.Timezone, o.Timezone)
+	if n := o.Notifications; n != nil {
+		setBool(&prefs.Notifications.Email, n.Email)
+		setBool(&prefs.Notifications.Push, n.Push)
+		setBool(&prefs.Notifications.Marketing, n.Marketing)
+		setString(&prefs.Notifications.Digest, n.Digest)
+	}
+	return prefs
+}
+
+// Validate checks every chosen setting. Locales must be BCP 47 tags and
+// timezones IANA names.
+func (o *PreferenceOverrides) Validate() error {
+	if o.Theme != nil && !oneOf(*o.Theme, preferenceThemes) {
+		return fmt.Errorf("theme must be one of %v", preferenceThemes)
+	}
+	if o.Locale != nil {
+		if _, err := language.Parse(*o.Locale); err != nil {
+			return fmt.Errorf("locale must be a BCP 47 language tag")
+		}
+	}
+	if o.Timezone != nil {
+		if *o.Timezone == "" || *o.Timezone == "Local" {
+			return fmt.Errorf("timezone must be an IANA time zone name")
+		}
+		if _, err := time.LoadLocation(*o.Timezone); err != nil {
+			return fmt.Errorf("timezone must be an IANA time zone name")
+		}
+	}
+	if o.Notifications != nil && o.Notifications.Digest != nil && !oneOf(*o.Notifications.Digest, preferenceDigests) {
+		return fmt.Errorf("notifications.digest must be one of %v", preferenceDigests)
+	}
+	return nil
+}
+
+func setString(dst *string, value *string) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+func setBool(dst *bool, value *bool) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+func oneOf(value string, allowed []string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
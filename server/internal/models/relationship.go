@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Follow is one user following another.
+type Follow struct {
+	FollowerID uuid.UUID `json:"follower_id"`
+	FolloweeID uuid.UUID `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Block is one user blocking another. While it stands, neither can follow
+// the other.
+type Block struct {
+	BlockerID uuid.UUID `json:"blocker_id"`
+	BlockedID uuid.UUID `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Relationship is how a user relates to a target user. Mutual is set when
+// each follows the other.
+type Relationship struct {
+	UserID     uuid.UUID `json:"user_id"`
+	TargetID   uuid.UUID `json:"target_id"`
+	Following  bool      `json:"following"`
+	FollowedBy bool      `json:"followed_by"`
+	Mutual     bool      `json:"mutual"`
+	Blocking   bool      `json:"blocking"`
+	BlockedBy  bool      `json:"blocked_by"`
+}
+
+// FollowCounts are how many users follow a user and how many it follows.
+type FollowCounts struct {
+	Followers int64 `json:"followers"`
+	Following int64 `json:"following"`
+}
+
+// FollowPage is one page of a followers or following list, newest first.
+// NextCursor fetches the page after it and is empty on the last page.
+type FollowPage struct {
+	Follows    []*Follow `json:"follows"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SCIMToken lets an identity provider provision the users and groups of an
+// organization over SCIM. The token itself is only shown once, when created.
+type SCIMToken struct {
+	ID         uuid.UUID  `json:"id"`
+	TenantID   uuid.UUID  `json:"tenant_id"`
+	Name       string     `json:"name"`
+	CreatedBy  *string    `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateSCIMTokenRequest struct {
+	Name string `json:"name"`
+}
+
+// ValidateSCIMToken checks a token's name.
+func ValidateSCIMToken(token *SCIMToken) error {
+	return validateLength("name", token.Name, 1, 255)
+}
@@ -1,20 +1,101 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// UserStatus is the lifecycle state of an account.
+type UserStatus string
+
+const (
+	UserStatusPending   UserStatus = "pending"
+	UserStatusActive    UserStatus = "active"
+	UserStatusSuspended UserStatus = "suspended"
+	UserStatusDeleted   UserStatus = "deleted"
+)
+
+// userStatusTransitions lists, for each status, the statuses it may move to.
+var userStatusTransitions = map[UserStatus][]UserStatus{
+	UserStatusPending:   {UserStatusActive, UserStatusDeleted},
+	UserStatusActive:    {UserStatusSuspended, UserStatusDeleted},
+	UserStatusSuspended: {UserStatusActive, UserStatusDeleted},
+	UserStatusDeleted:   {UserStatusActive},
+}
+
+// CanTransitionTo reports whether an account in status s may move to next.
+func (s UserStatus) CanTransitionTo(next UserStatus) bool {
+	for _, allowed := range userStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// UserStatusSources returns every status that may move to next.
+func UserStatusSources(next UserStatus) []UserStatus {
+	var sources []UserStatus
+	for from := range userStatusTransitions {
+		if from.CanTransitionTo(next) {
+			sources = append(sources, from)
+		}
+	}
+	return sources
+}
+
 type User struct {
-	ID        uuid.UUID `json:"id" db:"id"`
-	Email     string    `json:"email" db:"email"`
-	Username  string    `json:"username" db:"username"`
-	FirstName string    `json:"first_name" db:"first_name"`
-	LastName  string    `json:"last_name" db:"last_name"`
-	IsActive  bool      `json:"is_active" db:"is_active"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID              uuid.UUID  `json:"id" db:"id"`
+	TenantID        uuid.UUID  `json:"tenant_id" db:"tenant_id"`
+	Email           string     `json:"email" db:"email"`
+	Username        string     `json:"username" db:"username"`
+	FirstName       string     `json:"first_name" db:"first_name"`
+	LastName        string     `json:"last_name" db:"last_name"`
+	Status          UserStatus `json:"status" db:"status"`
+	StatusReason    *string    `json:"status_reason,omitempty" db:"status_reason"`
+	StatusChangedAt time.Time  `json:"status_changed_at" db:"status_changed_at"`
+	StatusChangedBy *string    `json:"status_changed_by,omitempty" db:"status_changed_by"`
+	Version         int64      `json:"version" db:"version"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// Attributes holds deployment-specific profile fields, validated against
+	// the current AttributeSchema.
+	Attributes map[string]interface{} `json:"attributes" db:"attributes"`
+	// AvatarURL links to the user's avatar at the default size. AvatarKey is
+	// the storage prefix of all of its sizes.
+	AvatarURL *string `json:"avatar_url,omitempty" db:"avatar_url"`
+	AvatarKey *string `json:"-" db:"avatar_key"`
+	// ErasedAt is set once the user's personal data has been anonymized.
+	ErasedAt *time.Time `json:"erased_at,omitempty" db:"erased_at"`
+}
+
+// AttributeSchema is a JSON Schema that user attributes must satisfy.
+// Version increases with every change.
+type AttributeSchema struct {
+	Version   int64           `json:"version"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+	CreatedBy *string         `json:"created_by,omitempty"`
+}
+
+// UserFilter narrows user listings and exports. The zero value matches every
+// user that has not been deleted.
+type UserFilter struct {
+	Status        UserStatus `json:"status,omitempty"`
+	Query         string     `json:"q,omitempty"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	// Attributes matches users whose attributes have these values, compared
+	// as text.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// IsZero reports whether the filter matches the default listing.
+func (f UserFilter) IsZero() bool {
+	return f.Status == "" && f.Query == "" && f.CreatedAfter == nil && f.CreatedBefore == nil && len(f.Attributes) == 0
 }
 
 type CreateUserRequest struct {
@@ -22,6 +103,8 @@ type CreateUserRequest struct {
 	Username  string `json:"username" validate:"required,min=3,max=50"`
 	FirstName string `json:"first_name" validate:"required,min=1,max=100"`
 	LastName  string `json:"last_name" validate:"required,min=1,max=100"`
+
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
 }
 
 type UpdateUserRequest struct {
@@ -29,16 +112,29 @@ type UpdateUserRequest struct {
 	Username  *string `json:"username,omitempty" validate:"omitempty,min=3,max=50"`
 	FirstName *string `json:"first_name,omitempty" validate:"omitempty,min=1,max=100"`
 	LastName  *string `json:"last_name,omitempty" validate:"omitempty,min=1,max=100"`
-	IsActive  *bool   `json:"is_active,omitempty"`
+	Version   *int64  `json:"version,omitempty"`
+	// Attributes, when present, replaces all of the user's attributes.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type ChangeUserStatusRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
 }
 
 type UserResponse struct {
-	ID        uuid.UUID `json:"id"`
-	Email     string    `json:"email"`
-	Username  string    `json:"username"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	IsActive  bool      `json:"is_active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              uuid.UUID  `json:"id"`
+	Email           string     `json:"email"`
+	Username        string     `json:"username"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	Status          UserStatus `json:"status"`
+	StatusReason    *string    `json:"status_reason,omitempty"`
+	StatusChangedAt time.Time  `json:"status_changed_at"`
+	StatusChangedBy *string    `json:"status_changed_by,omitempty"`
+	Version         int64      `json:"version"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	AvatarURL       *string    `json:"avatar_url,omitempty"`
+	ErasedAt        *time.Time `json:"erased_at,omitempty"`
 }
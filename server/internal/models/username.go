@@ -0,0 +1,84 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// usernamePattern allows ASCII letters and digits separated by single dots,
+// dashes or underscores, e.g. "jane.doe" or "j_doe-2".
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9]+(?:[._-][A-Za-z0-9]+)*$`)
+
+// reservedUsernames cannot be taken by anyone because they name the service,
+// its routes or its staff.
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "api": true, "app": true, "auth": true,
+	"billing": true, "contact": true, "help": true, "info": true, "login": true,
+	"logout": true, "me": true, "mod": true, "moderator": true, "null": true,
+	"p4rsec": true, "postmaster": true, "register": true, "root": true,
+	"security": true, "settings": true, "signup": true, "staff": true,
+	"support": true, "system": true, "undefined": true, "user": true,
+	"users": true, "webmaster": true, "www": true,
+}
+
+// blockedUsernameWords may not be a word of a username, ignoring case and
+// plurals. Only whole words are matched, so that names like "dickson" or
+// "scunthorpe" that merely contain one are allowed.
+var blockedUsernameWords = map[string]bool{
+	"asshole": true, "bastard": true, "bitch": true, "cunt": true, "dick": true,
+	"fuck": true, "nigger": true, "shit": true, "slut": true, "whore": true,
+}
+
+// usernameWordPattern finds the words of a username: runs of letters, split
+// where a capital starts a new word, so "big.dick", "big_dick2" and
+// "BigDick" are all "big" and "dick".
+var usernameWordPattern = regexp.MustCompile(`[A-Z]?[a-z]+|[A-Z]+`)
+
+// UsernameChange records a username a user gave up and how long it stays
+// reserved for them.
+type UsernameChange struct {
+	UserID        uuid.UUID `json:"user_id"`
+	Username      string    `json:"username"`
+	ChangedAt     time.Time `json:"changed_at"`
+	ReservedUntil time.Time `json:"reserved_until"`
+}
+
+// ValidateUsername checks username against the naming policy: the allowed
+// character set, reserved names and blocked words. Length is checked by
+// ValidateUser.
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username may only contain letters, digits and single '.', '-' or '_' between them")
+	}
+
+	normalized := NormalizeUsername(username)
+	if reservedUsernames[normalized] {
+		return fmt.Errorf("username %q is reserved", username)
+	}
+
+	for _, word := range usernameWordPattern.FindAllString(username, -1) {
+		if blockedUsernameWord(strings.ToLower(word)) {
+			return fmt.Errorf("username contains a word that is not allowed")
+		}
+	}
+
+	return nil
+}
+
+// blockedUsernameWord reports whether word, in lower case, is a blocked word
+// or its plural.
+func blockedUsernameWord(word string) bool {
+	if blockedUsernameWords[word] {
+		return true
+	}
+	for _, suffix := range []string{"s", "es"} {
+		if stem, ok := strings.CutSuffix(word, suffix); ok && blockedUsernameWords[stem] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package models
+
+import "testing"
+
+func TestValidateUsernameBlockedWords(t *testing.T) {
+	allowed := []string{
+		"dickson", "Dickens", "scunthorpe", "matsushita", "bassett",
+		"cocktail", "shitake", "jane.doe", "j_doe-2",
+	}
+	for _, username := range allowed {
+		if err := ValidateUsername(username); err != nil {
+			t.Errorf("ValidateUsername(%q) = %v, want it allowed", username, err)
+		}
+	}
+
+	blocked := []string{
+		"dick", "DICK", "big.dick", "big_dick2", "dick-42", "BigDick",
+		"dicks", "bitches", "fuck.you", "ShitHead", "the_cunt",
+	}
+	for _, username := range blocked {
+		if err := ValidateUsername(username); err == nil {
+			t.Errorf("ValidateUsername(%q) = nil, want it blocked", username)
+		}
+	}
+}
+
+func TestValidateUsernamePolicy(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"ann", true},
+		{"ann.lee-2", true},
+		{"ann..lee", false},
+		{"-ann", false},
+		{"ann_", false},
+		{"ann lee", false},
+		{"änn", false},
+		{"admin", false},
+		{"Admin", false},
+		{"admins", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateUsername(tt.username); (err == nil) != tt.valid {
+			t.Errorf("ValidateUsername(%q) = %v, want valid %v", tt.username, err, tt.valid)
+		}
+	}
+}
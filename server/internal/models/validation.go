@@ -0,0 +1,36 @@
+package models
+
+import (
+	"fmt"
+	"net/mail"
+	"unicode/utf8"
+)
+
+// ValidateUser checks the editable fields of a user against the constraints
+// declared on CreateUserRequest.
+func ValidateUser(user *User) error {
+	if user.Email == "" {
+		return fmt.Errorf("email is required")
+	}
+	if addr, err := mail.ParseAddress(user.Email); err != nil || addr.Address != user.Email {
+		return fmt.Errorf("email is not a valid address")
+	}
+	if err := validateLength("username", user.Username, 3, 50); err != nil {
+		return err
+	}
+	if err := validateLength("first_name", user.FirstName, 1, 100); err != nil {
+		return err
+	}
+	return validateLength("last_name", user.LastName, 1, 100)
+}
+
+func validateLength(field, value string, min, max int) error {
+	n := utf8.RuneCountInString(value)
+	if n == 0 {
+		return fmt.Errorf("%s is required", field)
+	}
+	if n < min || n > max {
+		return fmt.Errorf("%s must be between %d and %d characters", field, min, max)
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2).
+type Filter interface {
+	// Match reports whether the resource satisfies the filter.
+	Match(r Resource) bool
+}
+
+// Comparison is an attribute expression such as `userName eq "bjensen"`.
+// Value is nil for the pr operator.
+type Comparison struct {
+	Path  string
+	Op    string
+	Value interface{}
+}
+
+// Logical combines two filters with "and" or "or".
+type Logical struct {
+	Op          string
+	Left, Right Filter
+}
+
+// Not negates a filter.
+type Not struct {
+	Filter Filter
+}
+
+// ValuePath matches resources with at least one element of a multi-valued
+// attribute that satisfies Filter, e.g. `emails[type eq "work"]`.
+type ValuePath struct {
+	Attr   string
+	Filter Filter
+}
+
+var comparisonOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// ParseFilter parses a filter. Errors are *Error values of type
+// invalidFilter.
+func ParseFilter(s string) (Filter, error) {
+	p, err := newParser(s)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, invalidFilter("unexpected %q", p.peek().text)
+	}
+	return filter, nil
+}
+
+func invalidFilter(format string, args ...interface{}) *Error {
+	return NewError(400, ErrorInvalidFilter, "Invalid filter: "+fmt.Sprintf(format, args...))
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// value is the decoded value of string tokens
+	value string
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func newParser(s string) (*parser, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")"})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{kind: tokenOpenBracket, text: "["})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{kind: tokenCloseBracket, text: "]"})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, invalidFilter("unterminated string")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(s[i:end+1]), &value); err != nil {
+				return nil, invalidFilter("bad string %s", s[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s[i : end+1], value: value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t()[]\"", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: s[i:end]})
+			i = end
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, invalidFilter("empty filter")
+	}
+	return &parser{tokens: tokens}, nil
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: tokenWord}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() (token, error) {
+	if p.done() {
+		return token{}, invalidFilter("unexpected end of filter")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.kind != kind {
+		return invalidFilter("expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+// parseOr parses filters joined by "or", which binds looser than "and".
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+	if p.keyword("not") {
+		if err := p.expect(tokenOpen, "("); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenClose, ")"); err != nil {
+			return nil, err
+		}
+		return &Not{Filter: inner}, nil
+	}
+
+	if p.peek().kind == tokenOpen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenClose, ")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenWord || !validAttrPath(t.text) {
+		return nil, invalidFilter("expected an attribute, got %q", t.text)
+	}
+	path := t.text
+
+	if p.peek().kind == tokenOpenBracket {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenCloseBracket, "]"); err != nil {
+			return nil, err
+		}
+		return &ValuePath{Attr: path, Filter: inner}, nil
+	}
+
+	return p.parseComparison(path)
+}
+
+func (p *parser) parseComparison(path string) (Filter, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op := strings.ToLower(t.text)
+	if t.kind != tokenWord || !comparisonOps[op] {
+		return nil, invalidFilter("unknown operator %q", t.text)
+	}
+	if op == "pr" {
+		return &Comparison{Path: path, Op: op}, nil
+	}
+
+	v, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch {
+	case v.kind == tokenString:
+		value = v.value
+	case v.kind == tokenWord && v.text == "true":
+		value = true
+	case v.kind == tokenWord && v.text == "false":
+		value = false
+	case v.kind == tokenWord && v.text == "null":
+		value = nil
+	case v.kind == tokenWord:
+		var number float64
+		if err := json.Unmarshal([]byte(v.text), &number); err != nil {
+			return nil, invalidFilter("bad value %q", v.text)
+		}
+		value = number
+	default:
+		return nil, invalidFilter("bad value %q", v.text)
+	}
+	return &Comparison{Path: path, Op: op, Value: value}, nil
+}
+
+// validAttrPath reports whether s looks like an attribute path: an optional
+// schema URN followed by names made of letters, digits, "_", "-" and "$",
+// separated by dots.
+func validAttrPath(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-$.:", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Logical) Match(r Resource) bool {
+	if f.Op == "and" {
+		return f.Left.Match(r) && f.Right.Match(r)
+	}
+	return f.Left.Match(r) || f.Right.Match(r)
+}
+
+func (f *Not) Match(r Resource) bool {
+	return !f.Filter.Match(r)
+}
+
+func (f *ValuePath) Match(r Resource) bool {
+	value, ok := lookup(r, f.Attr)
+	if !ok {
+		return false
+	}
+	for _, element := range asList(value) {
+		if m, ok := element.(map[string]interface{}); ok && f.Filter.Match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Comparison) Match(r Resource) bool {
+	values := attributeValues(r, f.Path)
+	if f.Op == "pr" {
+		for _, v := range values {
+			if present(v) {
+				return true
+			}
+		}
+		return false
+	}
+	if f.Op == "ne" {
+		for _, v := range values {
+			if compare(v, "eq", f.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		if compare(v, f.Op, f.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// attributeValues returns the values at path, flattening multi-valued
+// attributes. A multi-valued complex attribute named without a
+// sub-attribute stands for its elements' "value".
+func attributeValues(r Resource, path string) []interface{} {
+	path = stripSchema(path)
+	current := []interface{}{map[string]interface{}(r)}
+	for _, name := range strings.Split(path, ".") {
+		var next []interface{}
+		for _, c := range current {
+			m, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if v, ok := get(m, name); ok {
+				next = append(next, asList(v)...)
+			}
+		}
+		current = next
+	}
+
+	values := make([]interface{}, 0, len(current))
+	for _, v := range current {
+		if m, ok := v.(map[string]interface{}); ok {
+			if value, ok := get(m, "value"); ok {
+				values = append(values, value)
+			}
+			continue
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func present(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// compare applies op to a stored value and a filter value. Strings compare
+// case-insensitively; strings that are both timestamps compare as times.
+func compare(stored interface{}, op string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return op == "eq" && stored == nil
+	case bool:
+		b, ok := asBool(stored)
+		return ok && op == "eq" && b == v
+	case float64:
+		n, ok := stored.(float64)
+		if !ok {
+			return false
+		}
+		return compareOrdered(op, n < v, n == v)
+	case string:
+		s, ok := stored.(string)
+		if !ok {
+			return false
+		}
+		a, b := strings.ToLower(s), strings.ToLower(v)
+		switch op {
+		case "eq":
+			return a == b
+		case "co":
+			return strings.Contains(a, b)
+		case "sw":
+			return strings.HasPrefix(a, b)
+		case "ew":
+			return strings.HasSuffix(a, b)
+		}
+		if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			if tv, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return compareOrdered(op, ts.Before(tv), ts.Equal(tv))
+			}
+		}
+		return compareOrdered(op, a < b, a == b)
+	}
+	return false
+}
+
+func compareOrdered(op string, less, equal bool) bool {
+	switch op {
+	case "eq":
+		return equal
+	case "gt":
+		return !less && !equal
+	case "ge":
+		return !less
+	case "lt":
+		return less
+	case "le":
+		return less || equal
+	}
+	return false
+}
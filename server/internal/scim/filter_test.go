@@ -0,0 +1,193 @@
+package scim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		want Filter
+	}{
+		{`userName eq "bjensen"`, &Comparison{Path: "userName", Op: "eq", Value: "bjensen"}},
+		{`userName EQ "bjensen"`, &Comparison{Path: "userName", Op: "eq", Value: "bjensen"}},
+		{`title pr`, &Comparison{Path: "title", Op: "pr"}},
+		{`active eq true`, &Comparison{Path: "active", Op: "eq", Value: true}},
+		{`meta.version eq null`, &Comparison{Path: "meta.version", Op: "eq", Value: nil}},
+		{`age gt 21.5`, &Comparison{Path: "age", Op: "gt", Value: 21.5}},
+		{`name.familyName co "O\"Malley"`, &Comparison{Path: "name.familyName", Op: "co", Value: `O"Malley`}},
+		{
+			`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "J"`,
+			&Comparison{Path: "urn:ietf:params:scim:schemas:core:2.0:User:userName", Op: "sw", Value: "J"},
+		},
+		{
+			// "and" binds tighter than "or"
+			`a eq "1" or b eq "2" and c eq "3"`,
+			&Logical{Op: "or",
+				Left: &Comparison{Path: "a", Op: "eq", Value: "1"},
+				Right: &Logical{Op: "and",
+					Left:  &Comparison{Path: "b", Op: "eq", Value: "2"},
+					Right: &Comparison{Path: "c", Op: "eq", Value: "3"},
+				},
+			},
+		},
+		{
+			`(a eq "1" or b eq "2") and not (c pr)`,
+			&Logical{Op: "and",
+				Left: &Logical{Op: "or",
+					Left:  &Comparison{Path: "a", Op: "eq", Value: "1"},
+					Right: &Comparison{Path: "b", Op: "eq", Value: "2"},
+				},
+				Right: &Not{Filter: &Comparison{Path: "c", Op: "pr"}},
+			},
+		},
+		{
+			`emails[type eq "work" and value co "@example.com"]`,
+			&ValuePath{Attr: "emails", Filter: &Logical{Op: "and",
+				Left:  &Comparison{Path: "type", Op: "eq", Value: "work"},
+				Right: &Comparison{Path: "value", Op: "co", Value: "@example.com"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%s): %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFilter(%s) = %#v, want %#v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`userName`,
+		`userName xx "a"`,
+		`userName eq`,
+		`userName eq "unterminated`,
+		`userName eq bare`,
+		`(userName eq "a"`,
+		`userName eq "a")`,
+		`not userName eq "a"`,
+		`emails[type eq "work"`,
+		`userName eq "a" and`,
+		`"userName" eq "a"`,
+		`user/name eq "a"`,
+	} {
+		_, err := ParseFilter(expr)
+		var scimErr *Error
+		if !errors.As(err, &scimErr) {
+			t.Errorf("ParseFilter(%s) = %v, want an invalid filter error", expr, err)
+			continue
+		}
+		if scimErr.Type != ErrorInvalidFilter {
+			t.Errorf("ParseFilter(%s) error type = %q, want %q", expr, scimErr.Type, ErrorInvalidFilter)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	user := &models.User{
+		ID:        uuid.MustParse("3f6c2c4e-9a1d-4b8e-8f3a-1c2d3e4f5a6b"),
+		Username:  "BJensen",
+		Email:     "bjensen@example.com",
+		FirstName: "Barbara",
+		LastName:  "Jensen",
+		Status:    models.UserStatusActive,
+		Version:   3,
+		CreatedAt: time.Date(2024, 5, 14, 9, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	r := UserResource(user, "ext-1", nil, "https://example.com/scim/v2/Users/"+user.ID.String())
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`userName eq "bjensen"`, true},
+		{`userName eq "jensen"`, false},
+		{`USERNAME eq "BJENSEN"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, true},
+		{`userName ne "bjensen"`, false},
+		{`userName sw "bj"`, true},
+		{`userName ew "sen"`, true},
+		{`userName co "jen"`, true},
+		{`userName co "xyz"`, false},
+		{`externalId eq "ext-1"`, true},
+		{`externalId pr`, true},
+		{`nickName pr`, false},
+		{`nickName ne "x"`, true},
+		{`name.familyName eq "Jensen"`, true},
+		{`emails eq "bjensen@example.com"`, true},
+		{`emails.value ew "@example.com"`, true},
+		{`emails[type eq "work" and primary eq true]`, true},
+		{`emails[type eq "home"]`, false},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`meta.created gt "2024-05-01T00:00:00Z"`, true},
+		{`meta.created lt "2024-05-01T00:00:00Z"`, false},
+		{`meta.lastModified ge "2024-06-01T12:00:00Z"`, true},
+		{`userName eq "x" or active eq true`, true},
+		{`userName eq "bjensen" and active eq false`, false},
+		{`not (userName eq "bjensen")`, false},
+		{`not (nickName pr)`, true},
+		{`groups pr`, false},
+	}
+	for _, tt := range tests {
+		filter, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseFilter(%s): %v", tt.expr, err)
+		}
+		if got := filter.Match(r); got != tt.want {
+			t.Errorf("%s matched %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestAttributeName(t *testing.T) {
+	tests := map[string]string{
+		"userName": "username",
+		"urn:ietf:params:scim:schemas:core:2.0:User:userName":     "username",
+		"urn:ietf:params:scim:schemas:core:2.0:Group:displayName": "displayname",
+		"name.givenName":             "name.givenname",
+		"urn:example:ext:department": "urn:example:ext:department",
+	}
+	for path, want := range tests {
+		if got := AttributeName(path); got != want {
+			t.Errorf("AttributeName(%s) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		startIndex, count string
+		want              Page
+	}{
+		{"", "", Page{StartIndex: 1, Count: 100}},
+		{"0", "-5", Page{StartIndex: 1, Count: 0}},
+		{"11", "10", Page{StartIndex: 11, Count: 10}},
+		{"x", "100000", Page{StartIndex: 1, Count: MaxResults}},
+	}
+	for _, tt := range tests {
+		if got := ParsePage(tt.startIndex, tt.count); got != tt.want {
+			t.Errorf("ParsePage(%q, %q) = %+v, want %+v", tt.startIndex, tt.count, got, tt.want)
+		}
+	}
+
+	page := Page{StartIndex: 11, Count: 10}
+	for index, want := range map[int]bool{9: false, 10: true, 19: true, 20: false} {
+		if got := page.Contains(index); got != want {
+			t.Errorf("page %+v contains %d: %v, want %v", page, index, got, want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Operation is one operation of a PATCH request (RFC 7644 section 3.5.2).
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// ParsePatch reads a PatchOp request body.
+func ParsePatch(body []byte) ([]Operation, error) {
+	var req struct {
+		Schemas    []string    `json:"schemas"`
+		Operations []Operation `json:"Operations"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, NewError(400, ErrorInvalidSyntax, "Invalid PATCH request body")
+	}
+	if !hasSchema(req.Schemas, PatchOpSchema) {
+		return nil, NewError(400, ErrorInvalidSyntax, "PATCH requests must use the "+PatchOpSchema+" schema")
+	}
+	if len(req.Operations) == 0 {
+		return nil, NewError(400, ErrorInvalidSyntax, "PATCH request has no operations")
+	}
+	for i := range req.Operations {
+		req.Operations[i].Op = strings.ToLower(req.Operations[i].Op)
+		switch req.Operations[i].Op {
+		case "add", "replace", "remove":
+		default:
+			return nil, NewError(400, ErrorInvalidSyntax, fmt.Sprintf("Unknown PATCH operation %q", req.Operations[i].Op))
+		}
+	}
+	return req.Operations, nil
+}
+
+func hasSchema(schemas []string, schema string) bool {
+	for _, s := range schemas {
+		if strings.EqualFold(s, schema) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply applies operations to r in order. Operations on attributes r does
+// not know are applied all the same; the caller decides which attributes it
+// reads back.
+func Apply(r Resource, operations []Operation) error {
+	for _, op := range operations {
+		if err := apply(r, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchPath is a parsed PATCH path: an attribute, optionally filtered to
+// some of its values and narrowed to one of their sub-attributes.
+type patchPath struct {
+	attr   string
+	filter Filter
+	sub    string
+}
+
+func parsePatchPath(path string) (*patchPath, error) {
+	path = stripSchema(strings.TrimSpace(path))
+	open := strings.IndexByte(path, '[')
+	if open < 0 {
+		if !validAttrPath(path) {
+			return nil, NewError(400, ErrorInvalidPath, fmt.Sprintf("Invalid path %q", path))
+		}
+		return &patchPath{attr: path}, nil
+	}
+
+	close := strings.LastIndexByte(path, ']')
+	if close < open {
+		return nil, NewError(400, ErrorInvalidPath, fmt.Sprintf("Invalid path %q", path))
+	}
+	filter, err := ParseFilter(path[open+1 : close])
+	if err != nil {
+		return nil, NewError(400, ErrorInvalidPath, fmt.Sprintf("Invalid path %q", path))
+	}
+
+	p := &patchPath{attr: path[:open], filter: filter}
+	if rest := path[close+1:]; rest != "" {
+		if !strings.HasPrefix(rest, ".") || !validAttrPath(rest[1:]) {
+			return nil, NewError(400, ErrorInvalidPath, fmt.Sprintf("Invalid path %q", path))
+		}
+		p.sub = rest[1:]
+	}
+	return p, nil
+}
+
+func apply(r Resource, op Operation) error {
+	if op.Path == "" {
+		if op.Op == "remove" {
+			return NewError(400, ErrorNoTarget, "remove operations need a path")
+		}
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return NewError(400, ErrorInvalidValue, op.Op+" operations without a path need an object value")
+		}
+		for name, value := range values {
+			if err := apply(r, Operation{Op: op.Op, Path: name, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parsePatchPath(op.Path)
+	if err != nil {
+		return err
+	}
+	if path.filter != nil {
+		return applyFiltered(r, op, path)
+	}
+
+	names := strings.Split(path.attr, ".")
+	parent := map[string]interface{}(r)
+	for _, name := range names[:len(names)-1] {
+		next, ok := get(parent, name)
+		child, isMap := next.(map[string]interface{})
+		if !ok || !isMap {
+			if op.Op == "remove" {
+				return nil
+			}
+			child = map[string]interface{}{}
+			parent[key(parent, name)] = child
+		}
+		parent = child
+	}
+	name := key(parent, names[len(names)-1])
+	existing, exists := parent[name]
+
+	switch op.Op {
+	case "add":
+		list, isList := existing.([]interface{})
+		if exists && isList {
+			parent[name] = appendValues(list, asList(op.Value))
+			return nil
+		}
+		if current, ok := existing.(map[string]interface{}); ok {
+			if additions, ok := op.Value.(map[string]interface{}); ok {
+				for k, v := range additions {
+					current[key(current, k)] = v
+				}
+				return nil
+			}
+		}
+		parent[name] = op.Value
+	case "replace":
+		parent[name] = op.Value
+	case "remove":
+		list, isList := existing.([]interface{})
+		if exists && isList && op.Value != nil {
+			parent[name] = removeValues(list, asList(op.Value))
+			return nil
+		}
+		delete(parent, name)
+	}
+	return nil
+}
+
+// applyFiltered applies an operation to the values of a multi-valued
+// attribute that match the path's filter.
+func applyFiltered(r Resource, op Operation, path *patchPath) error {
+	existing, _ := get(r, path.attr)
+	list, _ := existing.([]interface{})
+
+	var matched []int
+	for i, element := range list {
+		if m, ok := element.(map[string]interface{}); ok && path.filter.Match(m) {
+			matched = append(matched, i)
+		}
+	}
+
+	if len(matched) == 0 {
+		if op.Op == "remove" {
+			return nil
+		}
+		// Adding to a value that does not exist yet, e.g.
+		// emails[type eq "work"].value, creates it
+		element, ok := newElement(path.filter)
+		if !ok {
+			return NewError(400, ErrorNoTarget, fmt.Sprintf("No values match %q", op.Path))
+		}
+		list = append(list, element)
+		matched = []int{len(list) - 1}
+	}
+
+	var kept []interface{}
+	removed := make(map[int]bool)
+	for _, i := range matched {
+		element := list[i].(map[string]interface{})
+		switch {
+		case path.sub != "" && op.Op == "remove":
+			delete(element, key(element, path.sub))
+		case path.sub != "":
+			element[key(element, path.sub)] = op.Value
+		case op.Op == "remove":
+			removed[i] = true
+		case op.Op == "replace":
+			replacement, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return NewError(400, ErrorInvalidValue, "replacing a filtered value needs an object value")
+			}
+			list[i] = replacement
+		default:
+			additions, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return NewError(400, ErrorInvalidValue, "adding to a filtered value needs an object value")
+			}
+			for k, v := range additions {
+				element[key(element, k)] = v
+			}
+		}
+	}
+	for i, element := range list {
+		if !removed[i] {
+			kept = append(kept, element)
+		}
+	}
+
+	r[key(r, path.attr)] = kept
+	return nil
+}
+
+// newElement builds the value a filter of eq comparisons describes, or
+// reports false if the filter describes no single value.
+func newElement(filter Filter) (map[string]interface{}, bool) {
+	switch f := filter.(type) {
+	case *Comparison:
+		if f.Op != "eq" || strings.Contains(f.Path, ".") {
+			return nil, false
+		}
+		return map[string]interface{}{f.Path: f.Value}, true
+	case *Logical:
+		if f.Op != "and" {
+			return nil, false
+		}
+		left, ok := newElement(f.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := newElement(f.Right)
+		if !ok {
+			return nil, false
+		}
+		for k, v := range right {
+			left[k] = v
+		}
+		return left, true
+	}
+	return nil, false
+}
+
+// appendValues adds the values to a multi-valued attribute, skipping those
+// it already has.
+func appendValues(list, values []interface{}) []interface{} {
+	for _, value := range values {
+		if indexOf(list, value) < 0 {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// removeValues drops the values from a multi-valued attribute. Complex
+// values are identified by their "value" sub-attribute.
+func removeValues(list, values []interface{}) []interface{} {
+	var kept []interface{}
+	for _, element := range list {
+		if indexOf(values, element) < 0 {
+			kept = append(kept, element)
+		}
+	}
+	return kept
+}
+
+func indexOf(list []interface{}, value interface{}) int {
+	for i, element := range list {
+		if sameValue(element, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameValue(a, b interface{}) bool {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok && bok {
+		av, aHas := get(am, "value")
+		bv, bHas := get(bm, "value")
+		if aHas && bHas {
+			as, _ := av.(string)
+			bs, _ := bv.(string)
+			return as != "" && strings.EqualFold(as, bs)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,210 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// resource decodes a JSON resource, so that its values have the types a
+// request body's would.
+func resource(t *testing.T, s string) Resource {
+	t.Helper()
+	var r Resource
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestParsePatch(t *testing.T) {
+	operations, err := ParsePatch([]byte(`{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Replace", "path": "active", "value": false},
+			{"op": "remove", "path": "nickName"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Operation{
+		{Op: "replace", Path: "active", Value: false},
+		{Op: "remove", Path: "nickName"},
+	}
+	if !reflect.DeepEqual(operations, want) {
+		t.Errorf("operations = %+v, want %+v", operations, want)
+	}
+}
+
+func TestParsePatchRejectsInvalid(t *testing.T) {
+	for name, body := range map[string]string{
+		"not JSON":          `{`,
+		"no schema":         `{"Operations": [{"op": "remove", "path": "title"}]}`,
+		"another schema":    `{"schemas": ["` + UserSchema + `"], "Operations": [{"op": "remove", "path": "title"}]}`,
+		"no operations":     `{"schemas": ["` + PatchOpSchema + `"], "Operations": []}`,
+		"unknown operation": `{"schemas": ["` + PatchOpSchema + `"], "Operations": [{"op": "move", "path": "title"}]}`,
+	} {
+		_, err := ParsePatch([]byte(body))
+		var scimErr *Error
+		if !errors.As(err, &scimErr) || scimErr.Type != ErrorInvalidSyntax {
+			t.Errorf("%s: error = %v, want an invalid syntax error", name, err)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	const user = `{
+		"userName": "bjensen",
+		"name": {"givenName": "Barbara", "familyName": "Jensen"},
+		"emails": [
+			{"value": "bjensen@example.com", "type": "work", "primary": true},
+			{"value": "babs@example.org", "type": "home"}
+		],
+		"active": true
+	}`
+	tests := []struct {
+		name       string
+		operations []Operation
+		want       string
+	}{
+		{
+			"replace an attribute",
+			[]Operation{{Op: "replace", Path: "userName", Value: "barbara"}},
+			`{"userName": "barbara", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true},
+			             {"value": "babs@example.org", "type": "home"}], "active": true}`,
+		},
+		{
+			"paths ignore case and the schema prefix",
+			[]Operation{{Op: "replace", Path: UserSchema + ":NAME.givenname", Value: "Babs"}},
+			`{"userName": "bjensen", "name": {"givenName": "Babs", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true},
+			             {"value": "babs@example.org", "type": "home"}], "active": true}`,
+		},
+		{
+			"without a path, the value's attributes",
+			[]Operation{{Op: "replace", Value: map[string]interface{}{"active": false, "title": "CEO"}}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true},
+			             {"value": "babs@example.org", "type": "home"}], "active": false, "title": "CEO"}`,
+		},
+		{
+			"add merges into a complex attribute",
+			[]Operation{{Op: "add", Path: "name", Value: map[string]interface{}{"middleName": "Jane"}}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen", "middleName": "Jane"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true},
+			             {"value": "babs@example.org", "type": "home"}], "active": true}`,
+		},
+		{
+			"add appends new values only",
+			[]Operation{{Op: "add", Path: "emails", Value: []interface{}{
+				map[string]interface{}{"value": "BJENSEN@example.com"},
+				map[string]interface{}{"value": "b@example.net", "type": "other"},
+			}}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true},
+			             {"value": "babs@example.org", "type": "home"},
+			             {"value": "b@example.net", "type": "other"}], "active": true}`,
+		},
+		{
+			"add creates parents",
+			[]Operation{{Op: "add", Path: "urn:example:ext.department", Value: "Sales"}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true},
+			             {"value": "babs@example.org", "type": "home"}], "active": true,
+			  "urn:example:ext": {"department": "Sales"}}`,
+		},
+		{
+			"remove an attribute",
+			[]Operation{{Op: "remove", Path: "name.familyName"}, {Op: "remove", Path: "nickName.missing"}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true},
+			             {"value": "babs@example.org", "type": "home"}], "active": true}`,
+		},
+		{
+			"remove values of a multi-valued attribute",
+			[]Operation{{Op: "remove", Path: "emails", Value: []interface{}{
+				map[string]interface{}{"value": "babs@example.org"},
+			}}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}], "active": true}`,
+		},
+		{
+			"replace a sub-attribute of filtered values",
+			[]Operation{{Op: "replace", Path: `emails[type eq "work"].value`, Value: "barbara@example.com"}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "barbara@example.com", "type": "work", "primary": true},
+			             {"value": "babs@example.org", "type": "home"}], "active": true}`,
+		},
+		{
+			"replace filtered values",
+			[]Operation{{Op: "replace", Path: `emails[type eq "home"]`, Value: map[string]interface{}{"value": "h@example.org", "type": "home"}}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true},
+			             {"value": "h@example.org", "type": "home"}], "active": true}`,
+		},
+		{
+			"remove filtered values",
+			[]Operation{{Op: "remove", Path: `emails[type eq "home"]`}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}], "active": true}`,
+		},
+		{
+			"remove a sub-attribute of filtered values",
+			[]Operation{{Op: "remove", Path: `emails[primary eq true].primary`}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work"},
+			             {"value": "babs@example.org", "type": "home"}], "active": true}`,
+		},
+		{
+			"add to a value that does not exist creates it",
+			[]Operation{{Op: "add", Path: `emails[type eq "other"].value`, Value: "o@example.net"}},
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "bjensen@example.com", "type": "work", "primary": true},
+			             {"value": "babs@example.org", "type": "home"},
+			             {"type": "other", "value": "o@example.net"}], "active": true}`,
+		},
+		{
+			"removing values that do not exist does nothing",
+			[]Operation{{Op: "remove", Path: `emails[type eq "other"]`}},
+			user,
+		},
+	}
+	for _, tt := range tests {
+		r := resource(t, user)
+		if err := Apply(r, tt.operations); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if want := resource(t, tt.want); !reflect.DeepEqual(r, want) {
+			got, _ := json.Marshal(r)
+			t.Errorf("%s: resource = %s", tt.name, got)
+		}
+	}
+}
+
+func TestApplyRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation Operation
+		want      string
+	}{
+		{"remove without a path", Operation{Op: "remove"}, ErrorNoTarget},
+		{"no path and no object", Operation{Op: "add", Value: "x"}, ErrorInvalidValue},
+		{"bad path", Operation{Op: "replace", Path: "emails[type eq]", Value: "x"}, ErrorInvalidPath},
+		{"unclosed filter", Operation{Op: "replace", Path: "emails[type eq \"work\"", Value: "x"}, ErrorInvalidPath},
+		{"bad sub-attribute", Operation{Op: "replace", Path: `emails[type eq "work"]value`, Value: "x"}, ErrorInvalidPath},
+		{"no value the filter describes", Operation{Op: "add", Path: `emails[type ne "work"].value`, Value: "x"}, ErrorNoTarget},
+		{"replace a filtered value with a string", Operation{Op: "replace", Path: `emails[type eq "work"]`, Value: "x"}, ErrorInvalidValue},
+	}
+	for _, tt := range tests {
+		r := resource(t, `{"emails": [{"value": "bjensen@example.com", "type": "work"}]}`)
+		err := Apply(r, []Operation{tt.operation})
+		var scimErr *Error
+		if !errors.As(err, &scimErr) || scimErr.Type != tt.want {
+			t.Errorf("%s: error = %v, want one of type %s", tt.name, err, tt.want)
+		}
+	}
+}
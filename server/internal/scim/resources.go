@@ -0,0 +1,183 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// Member types of a Group resource.
+const (
+	MemberTypeUser  = "User"
+	MemberTypeGroup = "Group"
+)
+
+// UserResource returns the SCIM form of user. groups are the user's
+// memberships and location is the URL of the resource.
+func UserResource(user *models.User, externalID string, groups []*models.GroupMembership, location string) Resource {
+	r := Resource{
+		"schemas":  []interface{}{UserSchema},
+		"id":       user.ID.String(),
+		"userName": user.Username,
+		"name": map[string]interface{}{
+			"givenName":  user.FirstName,
+			"familyName": user.LastName,
+			"formatted":  strings.TrimSpace(user.FirstName + " " + user.LastName),
+		},
+		"displayName": strings.TrimSpace(user.FirstName + " " + user.LastName),
+		"emails": []interface{}{
+			map[string]interface{}{"value": user.Email, "type": "work", "primary": true},
+		},
+		"active": user.Status == models.UserStatusActive,
+		"meta": map[string]interface{}{
+			"resourceType": "User",
+			"created":      user.CreatedAt.UTC().Format(time.RFC3339),
+			"lastModified": user.UpdatedAt.UTC().Format(time.RFC3339),
+			"location":     location,
+			"version":      fmt.Sprintf(`W/"%d"`, user.Version),
+		},
+	}
+	if externalID != "" {
+		r["externalId"] = externalID
+	}
+
+	memberships := make([]interface{}, 0, len(groups))
+	for _, membership := range groups {
+		kind := "direct"
+		if membership.Role == nil {
+			kind = "indirect"
+		}
+		memberships = append(memberships, map[string]interface{}{
+			"value":   membership.Group.ID.String(),
+			"display": membership.Group.Name,
+			"type":    kind,
+		})
+	}
+	r["groups"] = memberships
+	return r
+}
+
+// GroupMember is a member of a Group resource: a user or a subgroup.
+type GroupMember struct {
+	ID      uuid.UUID
+	Type    string
+	Display string
+}
+
+// GroupResource returns the SCIM form of group with its direct members.
+func GroupResource(group *models.Group, externalID string, members []GroupMember, location string) Resource {
+	r := Resource{
+		"schemas":     []interface{}{GroupSchema},
+		"id":          group.ID.String(),
+		"displayName": group.Name,
+		"meta": map[string]interface{}{
+			"resourceType": "Group",
+			"created":      group.CreatedAt.UTC().Format(time.RFC3339),
+			"lastModified": group.UpdatedAt.UTC().Format(time.RFC3339),
+			"location":     location,
+		},
+	}
+	if externalID != "" {
+		r["externalId"] = externalID
+	}
+
+	values := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		value := map[string]interface{}{
+			"value": member.ID.String(),
+			"type":  member.Type,
+		}
+		if member.Display != "" {
+			value["display"] = member.Display
+		}
+		values = append(values, value)
+	}
+	r["members"] = values
+	return r
+}
+
+// UserFields are the parts of a User resource that map onto a user.
+type UserFields struct {
+	UserName   string
+	Email      string
+	FirstName  string
+	LastName   string
+	ExternalID string
+	// Active is nil when the resource does not say.
+	Active *bool
+}
+
+// ReadUser reads the fields of a User resource. The email is the primary
+// one, or the userName when that is an address and there are no emails.
+func ReadUser(r Resource) (*UserFields, error) {
+	fields := &UserFields{
+		UserName:   strings.TrimSpace(String(r, "userName")),
+		Email:      strings.TrimSpace(PrimaryValue(r, "emails")),
+		FirstName:  strings.TrimSpace(String(r, "name.givenName")),
+		LastName:   strings.TrimSpace(String(r, "name.familyName")),
+		ExternalID: strings.TrimSpace(String(r, "externalId")),
+	}
+	if active, ok := Bool(r, "active"); ok {
+		fields.Active = &active
+	}
+
+	if fields.UserName == "" {
+		return nil, NewError(400, ErrorInvalidValue, "userName is required")
+	}
+	if fields.Email == "" && strings.Contains(fields.UserName, "@") {
+		fields.Email = fields.UserName
+	}
+	if fields.Email == "" {
+		return nil, NewError(400, ErrorInvalidValue, "An email is required")
+	}
+	if fields.FirstName == "" || fields.LastName == "" {
+		return nil, NewError(400, ErrorInvalidValue, "name.givenName and name.familyName are required")
+	}
+	return fields, nil
+}
+
+// GroupFields are the parts of a Group resource that map onto a group.
+type GroupFields struct {
+	DisplayName string
+	ExternalID  string
+	// Members have Type "" when the resource does not say what they are.
+	Members []GroupMember
+}
+
+// ReadGroup reads the fields of a Group resource.
+func ReadGroup(r Resource) (*GroupFields, error) {
+	fields := &GroupFields{
+		DisplayName: strings.TrimSpace(String(r, "displayName")),
+		ExternalID:  strings.TrimSpace(String(r, "externalId")),
+	}
+	if fields.DisplayName == "" {
+		return nil, NewError(400, ErrorInvalidValue, "displayName is required")
+	}
+
+	for _, element := range Values(r, "members") {
+		value, _ := get(element, "value")
+		s, _ := value.(string)
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return nil, NewError(400, ErrorInvalidValue, fmt.Sprintf("Invalid member %q", s))
+		}
+
+		member := GroupMember{ID: id}
+		if kind, ok := get(element, "type"); ok {
+			kind, _ := kind.(string)
+			switch {
+			case strings.EqualFold(kind, MemberTypeUser):
+				member.Type = MemberTypeUser
+			case strings.EqualFold(kind, MemberTypeGroup):
+				member.Type = MemberTypeGroup
+			case kind != "":
+				return nil, NewError(400, ErrorInvalidValue, fmt.Sprintf("Invalid member type %q", kind))
+			}
+		}
+		fields.Members = append(fields.Members, member)
+	}
+	return fields, nil
+}
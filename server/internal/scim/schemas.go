@@ -0,0 +1,131 @@
+package scim
+
+// ServiceProviderConfig describes what this server supports (RFC 7643
+// section 5). baseURL is the URL of the SCIM root, e.g.
+// "https://api.example.com/scim/v2".
+func ServiceProviderConfig(baseURL string) Resource {
+	supported := func(ok bool) map[string]interface{} {
+		return map[string]interface{}{"supported": ok}
+	}
+	return Resource{
+		"schemas":          []interface{}{ServiceProviderConfigSchema},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            supported(true),
+		"bulk": map[string]interface{}{
+			"supported":      false,
+			"maxOperations":  0,
+			"maxPayloadSize": 0,
+		},
+		"filter": map[string]interface{}{
+			"supported":  true,
+			"maxResults": MaxResults,
+		},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []interface{}{
+			map[string]interface{}{
+				"type":        "oauthbearertoken",
+				"name":        "Bearer token",
+				"description": "A SCIM token issued to the organization, sent as Authorization: Bearer <token>",
+				"primary":     true,
+			},
+		},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     baseURL + "/ServiceProviderConfig",
+		},
+	}
+}
+
+// ResourceTypes lists the resource types served under baseURL.
+func ResourceTypes(baseURL string) []Resource {
+	resourceType := func(name, endpoint, schema string) Resource {
+		return Resource{
+			"schemas":     []interface{}{ResourceTypeSchema},
+			"id":          name,
+			"name":        name,
+			"endpoint":    endpoint,
+			"description": name + " resources",
+			"schema":      schema,
+			"meta": map[string]interface{}{
+				"resourceType": "ResourceType",
+				"location":     baseURL + "/ResourceTypes/" + name,
+			},
+		}
+	}
+	return []Resource{
+		resourceType("User", "/Users", UserSchema),
+		resourceType("Group", "/Groups", GroupSchema),
+	}
+}
+
+// attribute describes one attribute in a schema document.
+func attribute(name, kind string, multiValued, required bool, mutability, uniqueness string, sub ...Resource) Resource {
+	a := Resource{
+		"name":        name,
+		"type":        kind,
+		"multiValued": multiValued,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    "default",
+		"uniqueness":  uniqueness,
+	}
+	if len(sub) > 0 {
+		a["subAttributes"] = sub
+	}
+	return a
+}
+
+// Schemas returns the schema documents of the resources served under
+// baseURL, limited to the attributes this server stores.
+func Schemas(baseURL string) []Resource {
+	schema := func(id, name string, attributes ...Resource) Resource {
+		return Resource{
+			"schemas":     []interface{}{SchemaSchema},
+			"id":          id,
+			"name":        name,
+			"description": name + " account",
+			"attributes":  attributes,
+			"meta": map[string]interface{}{
+				"resourceType": "Schema",
+				"location":     baseURL + "/Schemas/" + id,
+			},
+		}
+	}
+
+	user := schema(UserSchema, "User",
+		attribute("userName", "string", false, true, "readWrite", "server"),
+		attribute("externalId", "string", false, false, "readWrite", "none"),
+		attribute("name", "complex", false, true, "readWrite", "none",
+			attribute("givenName", "string", false, true, "readWrite", "none"),
+			attribute("familyName", "string", false, true, "readWrite", "none"),
+			attribute("formatted", "string", false, false, "readOnly", "none"),
+		),
+		attribute("displayName", "string", false, false, "readOnly", "none"),
+		attribute("emails", "complex", true, true, "readWrite", "none",
+			attribute("value", "string", false, true, "readWrite", "server"),
+			attribute("type", "string", false, false, "readWrite", "none"),
+			attribute("primary", "boolean", false, false, "readWrite", "none"),
+		),
+		attribute("active", "boolean", false, false, "readWrite", "none"),
+		attribute("groups", "complex", true, false, "readOnly", "none",
+			attribute("value", "string", false, false, "readOnly", "none"),
+			attribute("display", "string", false, false, "readOnly", "none"),
+			attribute("type", "string", false, false, "readOnly", "none"),
+		),
+	)
+
+	group := schema(GroupSchema, "Group",
+		attribute("displayName", "string", false, true, "readWrite", "server"),
+		attribute("externalId", "string", false, false, "readWrite", "none"),
+		attribute("members", "complex", true, false, "readWrite", "none",
+			attribute("value", "string", false, false, "immutable", "none"),
+			attribute("display", "string", false, false, "readOnly", "none"),
+			attribute("type", "string", false, false, "immutable", "none"),
+		),
+	)
+
+	return []Resource{user, group}
+}
@@ -0,0 +1,297 @@
+package scim
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Schema URNs of the resources and messages this server speaks.
+const (
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// MaxResults is the most resources a list response returns.
+const MaxResults = 200
+
+// Error types (RFC 7644 section 3.12).
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorNoTarget      = "noTarget"
+	ErrorUniqueness    = "uniqueness"
+	ErrorMutability    = "mutability"
+)
+
+// Resource is the JSON form of a SCIM resource. Attribute names are matched
+// case-insensitively.
+type Resource = map[string]interface{}
+
+// Error is a SCIM error response.
+type Error struct {
+	Status int
+	Type   string
+	Detail string
+}
+
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{Status: status, Type: scimType, Detail: detail}
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// Body returns the error's response body.
+func (e *Error) Body() Resource {
+	body := Resource{
+		"schemas": []string{ErrorSchema},
+		"status":  strconv.Itoa(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.Type != "" {
+		body["scimType"] = e.Type
+	}
+	return body
+}
+
+// ListResponse wraps one page of resources. startIndex is 1-based.
+func ListResponse(resources []Resource, total, startIndex int) Resource {
+	if resources == nil {
+		resources = []Resource{}
+	}
+	return Resource{
+		"schemas":      []string{ListResponseSchema},
+		"totalResults": total,
+		"startIndex":   startIndex,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}
+}
+
+// Page is the window of a list request: StartIndex is 1-based and Count is
+// at most MaxResults.
+type Page struct {
+	StartIndex int
+	Count      int
+}
+
+// ParsePage reads the startIndex and count query parameters. Values out of
+// range are clamped as RFC 7644 section 3.4.2.4 requires.
+func ParsePage(startIndex, count string) Page {
+	page := Page{StartIndex: 1, Count: 100}
+	if n, err := strconv.Atoi(startIndex); err == nil && n > 1 {
+		page.StartIndex = n
+	}
+	if n, err := strconv.Atoi(count); err == nil {
+		page.Count = n
+	}
+	if page.Count < 0 {
+		page.Count = 0
+	}
+	if page.Count > MaxResults {
+		page.Count = MaxResults
+	}
+	return page
+}
+
+// Contains reports whether the 0-based index of a match falls in the page.
+func (p Page) Contains(index int) bool {
+	return index >= p.StartIndex-1 && index < p.StartIndex-1+p.Count
+}
+
+// stripSchema removes a core schema URN prefix from an attribute path, so
+// that "urn:ietf:params:scim:schemas:core:2.0:User:userName" becomes
+// "userName".
+func stripSchema(path string) string {
+	for _, schema := range []string{UserSchema, GroupSchema} {
+		if len(path) > len(schema) && strings.EqualFold(path[:len(schema)+1], schema+":") {
+			return path[len(schema)+1:]
+		}
+	}
+	return path
+}
+
+// AttributeName returns path without a core schema URN prefix and in lower
+// case, the form in which attribute names compare equal.
+func AttributeName(path string) string {
+	return strings.ToLower(stripSchema(path))
+}
+
+// get returns the attribute of m named name, ignoring case.
+func get(m map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// key returns the key m stores the attribute named name under, or name if
+// m has none.
+func key(m map[string]interface{}, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return name
+}
+
+// lookup returns the value at a dotted path without flattening lists.
+func lookup(r Resource, path string) (interface{}, bool) {
+	var current interface{} = map[string]interface{}(r)
+	for _, name := range strings.Split(stripSchema(path), ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = get(m, name); !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func asList(v interface{}) []interface{} {
+	if list, ok := v.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{v}
+}
+
+// asBool reads a boolean, also accepting the strings "true" and "false"
+// that some identity providers send.
+func asBool(v interface{}) (bool, bool) {
+	switch v := v.(type) {
+	case bool:
+		return v, true
+	case string:
+		b, err := strconv.ParseBool(v)
+		return b, err == nil
+	}
+	return false, false
+}
+
+// String returns the string at a dotted path, or "" if there is none.
+func String(r Resource, path string) string {
+	v, _ := lookup(r, path)
+	s, _ := v.(string)
+	return s
+}
+
+// Bool returns the boolean at a dotted path and whether there is one.
+func Bool(r Resource, path string) (bool, bool) {
+	v, ok := lookup(r, path)
+	if !ok {
+		return false, false
+	}
+	return asBool(v)
+}
+
+// Values returns the elements of the multi-valued attribute name that are
+// complex values.
+func Values(r Resource, name string) []map[string]interface{} {
+	v, ok := lookup(r, name)
+	if !ok {
+		return nil
+	}
+	var elements []map[string]interface{}
+	for _, element := range asList(v) {
+		if m, ok := element.(map[string]interface{}); ok {
+			elements = append(elements, m)
+		}
+	}
+	return elements
+}
+
+// PrimaryValue returns the "value" of the element of a multi-valued
+// attribute marked primary, or of its first element.
+func PrimaryValue(r Resource, name string) string {
+	elements := Values(r, name)
+	for _, element := range elements {
+		if primary, _ := get(element, "primary"); primary != nil {
+			if b, ok := asBool(primary); ok && b {
+				s, _ := get(element, "value")
+				value, _ := s.(string)
+				return value
+			}
+		}
+	}
+	if len(elements) > 0 {
+		s, _ := get(elements[0], "value")
+		value, _ := s.(string)
+		return value
+	}
+	return ""
+}
+
+// Requested reports whether the attributes and excludedAttributes query
+// parameters ask for the top-level attribute name.
+func Requested(name, attributes, excluded string) bool {
+	contains := func(list string) bool {
+		for _, attr := range strings.Split(list, ",") {
+			attr = strings.SplitN(stripSchema(strings.TrimSpace(attr)), ".", 2)[0]
+			if strings.EqualFold(attr, name) {
+				return true
+			}
+		}
+		return false
+	}
+	if attributes != "" {
+		return contains(attributes)
+	}
+	return !contains(excluded)
+}
+
+// Project applies the attributes and excludedAttributes query parameters to
+// a resource's top-level attributes. id and schemas are always returned.
+func Project(r Resource, attributes, excluded string) Resource {
+	if attributes == "" && excluded == "" {
+		return r
+	}
+
+	names := func(list string) map[string]bool {
+		set := make(map[string]bool)
+		for _, name := range strings.Split(list, ",") {
+			name = strings.ToLower(strings.SplitN(stripSchema(strings.TrimSpace(name)), ".", 2)[0])
+			if name != "" {
+				set[name] = true
+			}
+		}
+		return set
+	}
+
+	projected := Resource{}
+	include, exclude := names(attributes), names(excluded)
+	for k, v := range r {
+		lower := strings.ToLower(k)
+		always := lower == "id" || lower == "schemas"
+		if !always && attributes != "" && !include[lower] {
+			continue
+		}
+		if !always && exclude[lower] {
+			continue
+		}
+		projected[k] = v
+	}
+	return projected
+}
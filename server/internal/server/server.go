@@ -11,26 +11,45 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/spurge/p4rsec/server/internal/config"
 	"github.com/spurge/p4rsec/server/internal/dao"
 	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/encryption"
+	"github.com/spurge/p4rsec/server/internal/gdpr"
 	"github.com/spurge/p4rsec/server/internal/handlers"
+	"github.com/spurge/p4rsec/server/internal/invitation"
+	"github.com/spurge/p4rsec/server/internal/jobs"
+	"github.com/spurge/p4rsec/server/internal/ldapsync"
 	appLogger "github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/mailer"
+	"github.com/spurge/p4rsec/server/internal/middleware"
+	"github.com/spurge/p4rsec/server/internal/storage"
+	"github.com/spurge/p4rsec/server/internal/userexport"
 )
 
 type Server struct {
-	app    *fiber.App
-	config *config.Config
-	logger *appLogger.Logger
-	db     *database.PostgresDB
-	redis  *database.RedisDB
+	app      *fiber.App
+	config   *config.Config
+	logger   *appLogger.Logger
+	db       *database.PostgresDB
+	redis    *database.RedisDB
+	storage  storage.Storage
+	cipher   *encryption.Cipher
+	mailer   mailer.Mailer
+	stopJobs context.CancelFunc
+	// ldapSyncer is shared by the API and the scheduled job, so that only
+	// one sync runs at a time
+	ldapSyncer *ldapsync.Syncer
 }
 
-func New(cfg *config.Config, logger *appLogger.Logger, db *database.PostgresDB, redis *database.RedisDB) *Server {
+func New(cfg *config.Config, logger *appLogger.Logger, db *database.PostgresDB, redis *database.RedisDB, store storage.Storage, cipher *encryption.Cipher, mail mailer.Mailer) *Server {
 	app := fiber.New(fiber.Config{
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
+		// Bulk imports are read as they arrive instead of being buffered
+		StreamRequestBody: true,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -45,15 +64,23 @@ func New(cfg *config.Config, logger *appLogger.Logger, db *database.PostgresDB,
 	})
 
 	server := &Server{
-		app:    app,
-		config: cfg,
-		logger: logger,
-		db:     db,
-		redis:  redis,
+		app:     app,
+		config:  cfg,
+		logger:  logger,
+		db:      db,
+		redis:   redis,
+		storage: store,
+		cipher:  cipher,
+		mailer:  mail,
 	}
 
+	server.ldapSyncer = ldapsync.NewSyncer(cfg.LDAP, ldapsync.Dialer(cfg.LDAP),
+		dao.NewUserDAO(db, cfg.Users, cipher), dao.NewGroupDAO(db), dao.NewLDAPDAO(db),
+		dao.NewOrganizationDAO(db), dao.NewCacheDAO(redis), logger)
+
 	server.setupMiddlewares()
 	server.setupRoutes()
+	server.setupJobs()
 
 	return server
 }
@@ -65,11 +92,16 @@ func (s *Server) setupMiddlewares() {
 	// Security headers
 	s.app.Use(helmet.New())
 
+	// Request IDs, and the request metadata recorded in the audit log
+	s.app.Use(requestid.New())
+	s.app.Use(middleware.RequestMetadata())
+
 	// CORS
 	s.app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
+		AllowOrigins:  "*",
+		AllowMethods:  "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization, If-Match, If-None-Match, Idempotency-Key, X-Actor-ID, X-Request-ID, X-Tenant-ID",
+		ExposeHeaders: "ETag, Idempotent-Replayed, X-Request-ID",
 	}))
 
 	// Rate limiting
@@ -97,12 +129,34 @@ func (s *Server) setupMiddlewares() {
 
 func (s *Server) setupRoutes() {
 	// Initialize DAOs
-	userDAO := dao.NewUserDAO(s.db)
+	userDAO := dao.NewUserDAO(s.db, s.config.Users, s.cipher)
 	cacheDAO := dao.NewCacheDAO(s.redis)
+	auditDAO := dao.NewAuditDAO(s.db)
+	orgDAO := dao.NewOrganizationDAO(s.db)
+	groupDAO := dao.NewGroupDAO(s.db)
+	invitationDAO := dao.NewInvitationDAO(userDAO)
+	scimDAO := dao.NewSCIMDAO(s.db)
+	relationshipDAO := dao.NewRelationshipDAO(s.db)
+
+	exporter := userexport.NewExporter(userDAO, cacheDAO, s.logger, s.config.Export.Dir, s.config.Export.Retention)
+	archiver := gdpr.NewArchiver(userDAO, auditDAO, cacheDAO, s.storage)
+	eraser := gdpr.NewEraser(userDAO, cacheDAO, s.storage, s.logger)
+	inviter := invitation.NewInviter(invitationDAO, orgDAO, s.mailer, s.config.Invitations)
 
 	// Initialize handlers
 	healthHandler := handlers.NewHealthHandler(s.db, s.redis)
 	userHandler := handlers.NewUserHandler(userDAO, cacheDAO, s.logger)
+	exportHandler := handlers.NewExportHandler(userDAO, exporter, s.logger)
+	auditHandler := handlers.NewAuditHandler(auditDAO, s.logger)
+	gdprHandler := handlers.NewGDPRHandler(archiver, eraser, s.logger)
+	orgHandler := handlers.NewOrganizationHandler(orgDAO, s.logger)
+	groupHandler := handlers.NewGroupHandler(groupDAO, s.logger)
+	relationshipHandler := handlers.NewRelationshipHandler(relationshipDAO, cacheDAO, s.logger)
+	invitationHandler := handlers.NewInvitationHandler(invitationDAO, orgDAO, cacheDAO, inviter, s.logger)
+	scimHandler := handlers.NewSCIMHandler(userDAO, groupDAO, scimDAO, cacheDAO, s.logger)
+	ldapSyncHandler := handlers.NewLDAPSyncHandler(s.ldapSyncer, cacheDAO, s.logger)
+	avatarHandler := handlers.NewAvatarHandler(userDAO, cacheDAO, s.storage, s.logger,
+		s.config.Avatars, s.config.Storage.PublicURL)
 
 	// API routes
 	api := s.app.Group("/api/v1")
@@ -110,13 +164,127 @@ func (s *Server) setupRoutes() {
 	// Health check
 	api.Get("/health", healthHandler.Health)
 
+	// Everything below that reads or writes users acts for one organization
+	tenantScoped := middleware.Tenant(orgDAO, s.logger, s.config.Tenancy, s.config.JWT)
+
 	// User routes
-	users := api.Group("/users")
+	users := api.Group("/users", tenantScoped, middleware.Idempotency(cacheDAO, s.logger,
+		s.config.Idempotency.TTL, s.config.Idempotency.LockTimeout))
 	users.Get("/", userHandler.GetUsers)
 	users.Post("/", userHandler.CreateUser)
+	users.Post("/batch", userHandler.BatchUsers)
+	users.Get("/by-username/:username", userHandler.GetUserByUsername)
 	users.Get("/:id", userHandler.GetUser)
 	users.Put("/:id", userHandler.UpdateUser)
+	users.Patch("/:id", userHandler.PatchUser)
 	users.Delete("/:id", userHandler.DeleteUser)
+	users.Post("/:id/suspend", userHandler.SuspendUser)
+	users.Post("/:id/reactivate", userHandler.ReactivateUser)
+	users.Get("/:id/username-history", userHandler.GetUsernameHistory)
+	users.Get("/:id/history", userHandler.GetUserHistory)
+	users.Get("/:id/preferences", userHandler.GetPreferences)
+	users.Patch("/:id/preferences", userHandler.UpdatePreferences)
+	users.Delete("/:id/preferences", userHandler.ResetPreferences)
+	users.Put("/:id/avatar", avatarHandler.UploadAvatar)
+	users.Delete("/:id/avatar", avatarHandler.DeleteAvatar)
+	users.Get("/:id/groups", groupHandler.GetUserGroups)
+	users.Get("/:id/followers", relationshipHandler.GetFollowers)
+	users.Get("/:id/following", relationshipHandler.GetFollowing)
+	users.Put("/:id/following/:targetId", relationshipHandler.Follow)
+	users.Delete("/:id/following/:targetId", relationshipHandler.Unfollow)
+	users.Get("/:id/follow-counts", relationshipHandler.GetFollowCounts)
+	users.Put("/:id/blocks/:targetId", relationshipHandler.Block)
+	users.Delete("/:id/blocks/:targetId", relationshipHandler.Unblock)
+	users.Get("/:id/relationships/:targetId", relationshipHandler.GetRelationship)
+
+	// Group routes
+	groups := api.Group("/groups", tenantScoped, middleware.Idempotency(cacheDAO, s.logger,
+		s.config.Idempotency.TTL, s.config.Idempotency.LockTimeout))
+	groups.Get("/", groupHandler.GetGroups)
+	groups.Post("/", groupHandler.CreateGroup)
+	groups.Get("/:id", groupHandler.GetGroup)
+	groups.Put("/:id", groupHandler.UpdateGroup)
+	groups.Delete("/:id", groupHandler.DeleteGroup)
+	groups.Get("/:id/members", groupHandler.GetMembers)
+	groups.Get("/:id/members/:userId", groupHandler.CheckMember)
+	groups.Put("/:id/members/:userId", groupHandler.SetMember)
+	groups.Delete("/:id/members/:userId", groupHandler.RemoveMember)
+	groups.Get("/:id/subgroups", groupHandler.GetSubgroups)
+	groups.Put("/:id/subgroups/:childId", groupHandler.AddSubgroup)
+	groups.Delete("/:id/subgroups/:childId", groupHandler.RemoveSubgroup)
+
+	// Invitees are not members yet; their token names the organization
+	invitations := api.Group("/invitations")
+	invitations.Post("/lookup", invitationHandler.LookupInvitation)
+	invitations.Post("/accept", invitationHandler.AcceptInvitation)
+	invitations.Post("/decline", invitationHandler.DeclineInvitation)
+
+	// Avatars, when storage does not serve them itself
+	api.Get("/avatars/*", avatarHandler.ServeAvatar)
+
+	// Admin routes, for operators only
+	admin := api.Group("/admin", middleware.Admin(s.logger, s.config.JWT))
+	adminUsers := admin.Group("/users", tenantScoped)
+	adminUsers.Get("/deleted", userHandler.GetDeletedUsers)
+	adminUsers.Get("/attribute-schema", userHandler.GetAttributeSchema)
+	adminUsers.Put("/attribute-schema", userHandler.SetAttributeSchema)
+	adminUsers.Post("/:id/restore", userHandler.RestoreUser)
+	adminUsers.Delete("/:id/purge", userHandler.PurgeUser)
+	adminUsers.Get("/:id/data-export", gdprHandler.ExportUserData)
+	adminUsers.Post("/:id/erasure", gdprHandler.EraseUser)
+	adminUsers.Post("/import", userHandler.ImportUsers)
+	adminUsers.Get("/import/:id/report", userHandler.GetImportReport)
+	adminUsers.Get("/export", exportHandler.ExportUsers)
+	adminUsers.Post("/exports", exportHandler.StartExport)
+	adminUsers.Get("/exports/:id", exportHandler.GetExport)
+	adminUsers.Get("/exports/:id/download", exportHandler.DownloadExport)
+	admin.Get("/erasures/:id", tenantScoped, gdprHandler.GetErasure)
+	admin.Get("/audit-events", tenantScoped, auditHandler.GetAuditEvents)
+
+	adminInvitations := admin.Group("/invitations", tenantScoped, middleware.Idempotency(cacheDAO, s.logger,
+		s.config.Idempotency.TTL, s.config.Idempotency.LockTimeout))
+	adminInvitations.Get("/", invitationHandler.GetInvitations)
+	adminInvitations.Post("/", invitationHandler.CreateInvitation)
+	adminInvitations.Get("/:id", invitationHandler.GetInvitation)
+	adminInvitations.Post("/:id/resend", invitationHandler.ResendInvitation)
+	adminInvitations.Post("/:id/revoke", invitationHandler.RevokeInvitation)
+
+	adminSCIMTokens := admin.Group("/scim-tokens", tenantScoped)
+	adminSCIMTokens.Get("/", scimHandler.GetTokens)
+	adminSCIMTokens.Post("/", scimHandler.CreateToken)
+	adminSCIMTokens.Delete("/:id", scimHandler.RevokeToken)
+
+	adminLDAPSync := admin.Group("/ldap-sync", tenantScoped)
+	adminLDAPSync.Get("/", ldapSyncHandler.GetSyncReport)
+	adminLDAPSync.Post("/", ldapSyncHandler.StartSync)
+
+	// Organizations are managed across tenants, so these routes are not
+	// tenant-scoped
+	adminOrgs := admin.Group("/organizations")
+	adminOrgs.Get("/", orgHandler.GetOrganizations)
+	adminOrgs.Post("/", orgHandler.CreateOrganization)
+	adminOrgs.Get("/:id", orgHandler.GetOrganization)
+	adminOrgs.Put("/:id", orgHandler.RenameOrganization)
+
+	// SCIM provisioning; the bearer token names the organization
+	scimRoutes := s.app.Group("/scim/v2", middleware.SCIMAuth(scimDAO, s.logger))
+	scimRoutes.Get("/ServiceProviderConfig", scimHandler.GetServiceProviderConfig)
+	scimRoutes.Get("/ResourceTypes", scimHandler.GetResourceTypes)
+	scimRoutes.Get("/ResourceTypes/:id", scimHandler.GetResourceType)
+	scimRoutes.Get("/Schemas", scimHandler.GetSchemas)
+	scimRoutes.Get("/Schemas/:id", scimHandler.GetSchema)
+	scimRoutes.Get("/Users", scimHandler.GetUsers)
+	scimRoutes.Post("/Users", scimHandler.CreateUser)
+	scimRoutes.Get("/Users/:id", scimHandler.GetUser)
+	scimRoutes.Put("/Users/:id", scimHandler.ReplaceUser)
+	scimRoutes.Patch("/Users/:id", scimHandler.PatchUser)
+	scimRoutes.Delete("/Users/:id", scimHandler.DeleteUser)
+	scimRoutes.Get("/Groups", scimHandler.GetGroups)
+	scimRoutes.Post("/Groups", scimHandler.CreateGroup)
+	scimRoutes.Get("/Groups/:id", scimHandler.GetGroup)
+	scimRoutes.Put("/Groups/:id", scimHandler.ReplaceGroup)
+	scimRoutes.Patch("/Groups/:id", scimHandler.PatchGroup)
+	scimRoutes.Delete("/Groups/:id", scimHandler.DeleteGroup)
 
 	// Root route
 	s.app.Get("/", func(c *fiber.Ctx) error {
@@ -136,11 +304,28 @@ func (s *Server) setupRoutes() {
 	})
 }
 
+func (s *Server) setupJobs() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopJobs = cancel
+
+	userDAO := dao.NewUserDAO(s.db, s.config.Users, s.cipher)
+	cacheDAO := dao.NewCacheDAO(s.redis)
+	orgDAO := dao.NewOrganizationDAO(s.db)
+
+	purgeDeletedUsers := jobs.NewPurgeDeletedUsers(userDAO, cacheDAO, orgDAO, s.logger,
+		s.config.Retention.DeletedUsers, s.config.Retention.PurgeInterval)
+	go purgeDeletedUsers.Run(ctx)
+
+	ldapSync := jobs.NewLDAPSync(s.ldapSyncer, s.logger, s.config.LDAP.Interval, s.config.LDAP.DryRun)
+	go ldapSync.Run(ctx)
+}
+
 func (s *Server) Start() error {
 	addr := fmt.Sprintf("%s:%s", s.config.Server.Host, s.config.Server.Port)
 	return s.app.Listen(addr)
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopJobs()
 	return s.app.ShutdownWithContext(ctx)
 }
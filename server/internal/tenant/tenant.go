@@ -0,0 +1,65 @@
+// Package tenant carries the organization a request acts for, and the user
+// making it when the request is authenticated. Handlers attach them to the
+// request context; the DAOs scope every query and cache key to the
+// organization and refuse to run without one, and the database connection
+// is scoped to both for row-level security.
+package tenant
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// ErrMissing is returned by data access that needs a tenant when the
+// context has none.
+var ErrMissing = errors.New("no tenant in context")
+
+type idKey struct{}
+
+type userKey struct{}
+
+// WithID returns a copy of ctx acting for the organization id.
+func WithID(ctx context.Context, id uuid.UUID) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// FromContext returns the organization ctx acts for, if any.
+func FromContext(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(idKey{}).(uuid.UUID)
+	return id, ok && id != uuid.Nil
+}
+
+// Require returns the organization ctx acts for, or ErrMissing.
+func Require(ctx context.Context) (uuid.UUID, error) {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return uuid.Nil, ErrMissing
+	}
+	return id, nil
+}
+
+// WithUser returns a copy of ctx made by the authenticated user.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the authenticated user ctx is made by, if any.
+func UserFromContext(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userKey{}).(string)
+	return user, ok && user != ""
+}
+
+// Detach returns a context that acts for the same organization and user as
+// ctx but is not cancelled with it, for work that outlives a request.
+func Detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	if id, ok := FromContext(ctx); ok {
+		detached = WithID(detached, id)
+	}
+	if user, ok := UserFromContext(ctx); ok {
+		detached = WithUser(detached, user)
+	}
+	return detached
+}
@@ -0,0 +1,123 @@
+package tenant
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for bearer tokens that are malformed, not
+// signed with the server's secret, or expired.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the claims of a verified token.
+type Claims map[string]interface{}
+
+// ParseToken checks an HS256-signed JWT's signature against secret and its
+// expiry, and returns its claims.
+func ParseToken(token, secret string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() >= int64(exp) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// String returns the string claim named claim, or "" if there is none.
+func (c Claims) String(claim string) (string, error) {
+	value, ok := c[claim]
+	if !ok {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: claim %q is not a string", ErrInvalidToken, claim)
+	}
+	return s, nil
+}
+
+// HasRole reports whether the claim named claim is role or a list holding
+// it.
+func (c Claims) HasRole(claim, role string) bool {
+	if role == "" {
+		return false
+	}
+	switch value := c[claim].(type) {
+	case string:
+		return value == role
+	case []interface{}:
+		for _, item := range value {
+			if item == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TokenClaim returns the string claim named claim from an HS256-signed JWT,
+// after checking its signature against secret and its expiry. It returns ""
+// if the token is valid but has no such claim.
+func TokenClaim(token, secret, claim string) (string, error) {
+	claims, err := ParseToken(token, secret)
+	if err != nil {
+		return "", err
+	}
+	return claims.String(claim)
+}
+
+// SignToken returns an HS256-signed JWT holding claims.
+func SignToken(claims Claims, secret string) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode claims: %w", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
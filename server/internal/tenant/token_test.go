@@ -0,0 +1,94 @@
+package tenant
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+func TestParseToken(t *testing.T) {
+	token, err := SignToken(Claims{"sub": "alice", "org": "acme", "exp": time.Now().Add(time.Hour).Unix()}, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseToken(token, testSecret)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if sub, _ := claims.String("sub"); sub != "alice" {
+		t.Errorf("sub = %q, want alice", sub)
+	}
+	if org, err := TokenClaim(token, testSecret, "org"); err != nil || org != "acme" {
+		t.Errorf("TokenClaim(org) = %q, %v", org, err)
+	}
+	if missing, err := TokenClaim(token, testSecret, "missing"); err != nil || missing != "" {
+		t.Errorf("TokenClaim(missing) = %q, %v", missing, err)
+	}
+}
+
+func TestParseTokenRejects(t *testing.T) {
+	valid, _ := SignToken(Claims{"sub": "alice"}, testSecret)
+	expired, _ := SignToken(Claims{"sub": "alice", "exp": time.Now().Add(-time.Minute).Unix()}, testSecret)
+	parts := strings.Split(valid, ".")
+	forged, _ := SignToken(Claims{"sub": "mallory"}, testSecret)
+	forgedParts := strings.Split(forged, ".")
+
+	tests := map[string]string{
+		"wrong secret":       mustSign(t, Claims{"sub": "alice"}, "other-secret"),
+		"expired":            expired,
+		"swapped payload":    parts[0] + "." + forgedParts[1] + "." + parts[2],
+		"alg none":           "eyJhbGciOiJub25lIn0." + parts[1] + ".",
+		"not a jwt":          "not-a-token",
+		"bad signature":      parts[0] + "." + parts[1] + ".!!!",
+		"too many segments":  valid + ".x",
+		"undecodable claims": parts[0] + ".!!!." + parts[2],
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseToken(token, testSecret); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestClaimsHasRole(t *testing.T) {
+	tests := []struct {
+		claims Claims
+		want   bool
+	}{
+		{Claims{"role": "admin"}, true},
+		{Claims{"role": []interface{}{"user", "admin"}}, true},
+		{Claims{"role": "user"}, false},
+		{Claims{"role": []interface{}{"user"}}, false},
+		{Claims{"role": 1}, false},
+		{Claims{}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.claims.HasRole("role", "admin"); got != tt.want {
+			t.Errorf("%v.HasRole = %v, want %v", tt.claims, got, tt.want)
+		}
+	}
+	if (Claims{"role": ""}).HasRole("role", "") {
+		t.Error("an empty role must never match")
+	}
+}
+
+func TestClaimsStringRejectsNonStrings(t *testing.T) {
+	if _, err := (Claims{"org": 42.0}).String("org"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func mustSign(t *testing.T, claims Claims, secret string) string {
+	t.Helper()
+	token, err := SignToken(claims, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,353 @@
+// Package userexport streams users out of Postgres as CSV, NDJSON or
+// columnar JSON, either directly to a response or as a background job that
+// writes to local storage.
+package userexport
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// Format is an output encoding.
+type Format string
+
+const (
+	FormatCSV      Format = "csv"
+	FormatNDJSON   Format = "ndjson"
+	FormatColumnar Format = "columnar"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(strings.TrimSpace(s))) {
+	case "", FormatCSV:
+		return FormatCSV, nil
+	case FormatNDJSON, "jsonl":
+		return FormatNDJSON, nil
+	case FormatColumnar:
+		return FormatColumnar, nil
+	default:
+		return "", fmt.Errorf("unsupported export format %q", s)
+	}
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatColumnar:
+		return "application/json"
+	default:
+		return "text/csv"
+	}
+}
+
+func (f Format) Extension() string {
+	switch f {
+	case FormatNDJSON:
+		return "ndjson"
+	case FormatColumnar:
+		return "json"
+	default:
+		return "csv"
+	}
+}
+
+// column is one exported user attribute.
+type column struct {
+	name  string
+	value func(*models.User) interface{}
+}
+
+var columns = []column{
+	{"id", func(u *models.User) interface{} { return u.ID }},
+	{"email", func(u *models.User) interface{} { return u.Email }},
+	{"username", func(u *models.User) interface{} { return u.Username }},
+	{"first_name", func(u *models.User) interface{} { return u.FirstName }},
+	{"last_name", func(u *models.User) interface{} { return u.LastName }},
+	{"status", func(u *models.User) interface{} { return u.Status }},
+	{"status_reason", func(u *models.User) interface{} { return u.StatusReason }},
+	{"status_changed_at", func(u *models.User) interface{} { return u.StatusChangedAt }},
+	{"status_changed_by", func(u *models.User) interface{} { return u.StatusChangedBy }},
+	{"version", func(u *models.User) interface{} { return u.Version }},
+	{"created_at", func(u *models.User) interface{} { return u.CreatedAt }},
+	{"updated_at", func(u *models.User) interface{} { return u.UpdatedAt }},
+	{"deleted_at", func(u *models.User) interface{} { return u.DeletedAt }},
+	{"attributes", func(u *models.User) interface{} { return u.Attributes }},
+	{"avatar_url", func(u *models.User) interface{} { return u.AvatarURL }},
+}
+
+// encoder writes users one at a time and finishes the document on close.
+// close ends the document with a marker recording whether the export
+// completed, so that readers can tell a finished export from one cut short:
+// the status is sent before the first row, so it cannot say.
+type encoder interface {
+	write(user *models.User) error
+	close(rows int, failed error) error
+}
+
+// Export writes every user matching filter to w and returns how many were
+// written. Users are streamed from a database cursor, so memory use does not
+// grow with the number of users. The output ends with a completion marker,
+// or an error marker if the export fails part way:
+//
+//   - CSV: a last record whose first field is "#complete" or "#error", then
+//     the number of rows and, for errors, a message; the other fields are
+//     empty
+//   - NDJSON: a last line {"export": {"complete": true, "rows": n}}, with
+//     complete false and an error message on failure
+//   - columnar: "complete" and, on failure, "error" members after "data"
+func Export(ctx context.Context, userDAO *dao.UserDAO, filter models.UserFilter, format Format, w io.Writer) (int, error) {
+	var enc encoder
+	switch format {
+	case FormatCSV:
+		enc = newCSVEncoder(w)
+	case FormatNDJSON:
+		enc = newNDJSONEncoder(w)
+	case FormatColumnar:
+		enc = newColumnarEncoder(w)
+	default:
+		return 0, fmt.Errorf("unsupported export format %q", format)
+	}
+
+	count := 0
+	err := userDAO.StreamUsers(ctx, filter, func(user *models.User) error {
+		if err := enc.write(user); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		if closeErr := enc.close(count, err); closeErr != nil {
+			return count, fmt.Errorf("%w (and failed to close output: %v)", err, closeErr)
+		}
+		return count, err
+	}
+
+	return count, enc.close(count, nil)
+}
+
+// failureMessage is what the error marker of a failed export says. The
+// cause is logged rather than written, as it may describe the database.
+const failureMessage = "export failed before all users were written"
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+	record []string
+}
+
+func newCSVEncoder(w io.Writer) *csvEncoder {
+	return &csvEncoder{w: csv.NewWriter(w), record: make([]string, len(columns))}
+}
+
+func (e *csvEncoder) writeHeader() error {
+	for i, col := range columns {
+		e.record[i] = col.name
+	}
+	e.header = true
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) write(user *models.User) error {
+	if !e.header {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+	}
+	for i, col := range columns {
+		e.record[i] = csvValue(col.value(user))
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) close(rows int, failed error) error {
+	if !e.header {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	// The marker has as many fields as the header, for strict readers
+	for i := range e.record {
+		e.record[i] = ""
+	}
+	e.record[0], e.record[1] = "#complete", strconv.Itoa(rows)
+	if failed != nil {
+		e.record[0], e.record[2] = "#error", failureMessage
+	}
+	if err := e.w.Write(e.record); err != nil {
+		return err
+	}
+
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case *string:
+		if v == nil {
+			return ""
+		}
+		return *v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case map[string]interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func newNDJSONEncoder(w io.Writer) *ndjsonEncoder {
+	return &ndjsonEncoder{enc: json.NewEncoder(w)}
+}
+
+func (e *ndjsonEncoder) write(user *models.User) error {
+	return e.enc.Encode(user)
+}
+
+// exportMarker is the last line of an NDJSON export.
+type exportMarker struct {
+	Export struct {
+		Complete bool   `json:"complete"`
+		Rows     int    `json:"rows"`
+		Error    string `json:"error,omitempty"`
+	} `json:"export"`
+}
+
+func (e *ndjsonEncoder) close(rows int, failed error) error {
+	var marker exportMarker
+	marker.Export.Complete = failed == nil
+	marker.Export.Rows = rows
+	if failed != nil {
+		marker.Export.Error = failureMessage
+	}
+	return e.enc.Encode(marker)
+}
+
+// columnarEncoder writes {"columns": [...], "rows": n, "data": {"col": [...]}}.
+// Each column is spooled to its own temporary file while rows stream in and
+// the files are stitched together on close, keeping memory use flat.
+type columnarEncoder struct {
+	w     io.Writer
+	rows  int
+	files []*os.File
+	bufs  []*bufio.Writer
+	err   error
+}
+
+func newColumnarEncoder(w io.Writer) *columnarEncoder {
+	e := &columnarEncoder{w: w}
+	for range columns {
+		f, err := os.CreateTemp("", "user-export-column-*")
+		if err != nil {
+			e.err = fmt.Errorf("failed to create column spool: %w", err)
+			break
+		}
+		e.files = append(e.files, f)
+		e.bufs = append(e.bufs, bufio.NewWriter(f))
+	}
+	return e
+}
+
+func (e *columnarEncoder) write(user *models.User) error {
+	if e.err != nil {
+		return e.err
+	}
+	for i, col := range columns {
+		data, err := json.Marshal(col.value(user))
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", col.name, err)
+		}
+		if e.rows > 0 {
+			e.bufs[i].WriteByte(',')
+		}
+		if _, err := e.bufs[i].Write(data); err != nil {
+			return fmt.Errorf("failed to spool %s: %w", col.name, err)
+		}
+	}
+	e.rows++
+	return nil
+}
+
+func (e *columnarEncoder) close(rows int, failed error) error {
+	defer func() {
+		for _, f := range e.files {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if e.err != nil {
+		_, err := fmt.Fprintf(e.w, `{"complete":false,"error":%q}`+"\n", failureMessage)
+		if err != nil {
+			return err
+		}
+		return e.err
+	}
+
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.name
+	}
+	header, _ := json.Marshal(names)
+	if _, err := fmt.Fprintf(e.w, `{"columns":%s,"rows":%d,"data":{`, header, e.rows); err != nil {
+		return err
+	}
+
+	for i, col := range columns {
+		if err := e.bufs[i].Flush(); err != nil {
+			return fmt.Errorf("failed to spool %s: %w", col.name, err)
+		}
+		if _, err := e.files[i].Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind %s: %w", col.name, err)
+		}
+
+		sep := ","
+		if i == 0 {
+			sep = ""
+		}
+		if _, err := fmt.Fprintf(e.w, `%s%q:[`, sep, col.name); err != nil {
+			return err
+		}
+		if _, err := io.Copy(e.w, e.files[i]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(e.w, "]"); err != nil {
+			return err
+		}
+	}
+
+	if failed != nil {
+		_, err := fmt.Fprintf(e.w, `},"complete":false,"error":%q}`+"\n", failureMessage)
+		return err
+	}
+	_, err := io.WriteString(e.w, "},\"complete\":true}\n")
+	return err
+}
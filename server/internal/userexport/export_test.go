@@ -0,0 +1,128 @@
+package userexport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+func testUsers() []*models.User {
+	return []*models.User{
+		{ID: uuid.New(), Email: "ada@example.com", Username: "ada", Status: models.UserStatusActive},
+		{ID: uuid.New(), Email: "bob@example.com", Username: "bob", Status: models.UserStatusActive},
+	}
+}
+
+// encode writes the test users with the format's encoder and closes it,
+// failing the export with failed if it is set.
+func encode(t *testing.T, format Format, failed error) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var enc encoder
+	switch format {
+	case FormatCSV:
+		enc = newCSVEncoder(&buf)
+	case FormatNDJSON:
+		enc = newNDJSONEncoder(&buf)
+	case FormatColumnar:
+		enc = newColumnarEncoder(&buf)
+	}
+	users := testUsers()
+	for _, user := range users {
+		if err := enc.write(user); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := enc.close(len(users), failed); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.String()
+}
+
+func TestCSVMarker(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		failed error
+		want   []string
+	}{
+		{"complete", nil, []string{"#complete", "2", ""}},
+		{"failed", errors.New("connection reset"), []string{"#error", "2", failureMessage}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// A strict reader rejects records of a different width
+			records, err := csv.NewReader(strings.NewReader(encode(t, FormatCSV, tt.failed))).ReadAll()
+			if err != nil {
+				t.Fatalf("invalid CSV: %v", err)
+			}
+			if len(records) != 4 {
+				t.Fatalf("got %d records, want header, 2 users and the marker", len(records))
+			}
+			marker := records[3]
+			for i, want := range tt.want {
+				if marker[i] != want {
+					t.Errorf("marker field %d = %q, want %q", i, marker[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestNDJSONMarker(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		failed error
+		want   string
+	}{
+		{"complete", nil, `{"export":{"complete":true,"rows":2}}`},
+		{"failed", errors.New("connection reset"), `{"export":{"complete":false,"rows":2,"error":"` + failureMessage + `"}}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := strings.Split(strings.TrimSuffix(encode(t, FormatNDJSON, tt.failed), "\n"), "\n")
+			if len(lines) != 3 {
+				t.Fatalf("got %d lines, want 2 users and the marker", len(lines))
+			}
+			if lines[2] != tt.want {
+				t.Errorf("marker = %s, want %s", lines[2], tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnarMarker(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		failed   error
+		complete bool
+		err      string
+	}{
+		{"complete", nil, true, ""},
+		{"failed", errors.New("connection reset"), false, failureMessage},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc struct {
+				Rows     int                      `json:"rows"`
+				Data     map[string][]interface{} `json:"data"`
+				Complete *bool                    `json:"complete"`
+				Error    string                   `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(encode(t, FormatColumnar, tt.failed)), &doc); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if doc.Rows != 2 || len(doc.Data["email"]) != 2 {
+				t.Errorf("rows = %d with %d emails, want 2", doc.Rows, len(doc.Data["email"]))
+			}
+			if doc.Complete == nil || *doc.Complete != tt.complete {
+				t.Errorf("complete = %v, want %v", doc.Complete, tt.complete)
+			}
+			if doc.Error != tt.err {
+				t.Errorf("error = %q, want %q", doc.Error, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package userexport
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/logger"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is an asynchronous export. Its state lives in Redis and its output in
+// the exporter's directory.
+type Job struct {
+	ID         uuid.UUID         `json:"id"`
+	Status     JobStatus         `json:"status"`
+	Format     Format            `json:"format"`
+	Filter     models.UserFilter `json:"filter"`
+	Rows       int               `json:"rows"`
+	Error      string            `json:"error,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+}
+
+// FileName is the name the job's output is stored and downloaded under.
+func (j *Job) FileName() string {
+	return fmt.Sprintf("users-%s.%s", j.ID, j.Format.Extension())
+}
+
+// Exporter runs export jobs in the background. Finished files are kept for
+// the retention period and swept when later jobs start.
+type Exporter struct {
+	userDAO   *dao.UserDAO
+	cacheDAO  *dao.CacheDAO
+	logger    *logger.Logger
+	dir       string
+	retention time.Duration
+}
+
+func NewExporter(userDAO *dao.UserDAO, cacheDAO *dao.CacheDAO, logger *logger.Logger, dir string, retention time.Duration) *Exporter {
+	return &Exporter{
+		userDAO:   userDAO,
+		cacheDAO:  cacheDAO,
+		logger:    logger,
+		dir:       dir,
+		retention: retention,
+	}
+}
+
+// Start records a new job and runs it in the background.
+func (e *Exporter) Start(ctx context.Context, format Format, filter models.UserFilter) (*Job, error) {
+	if err := os.MkdirAll(e.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	job := &Job{
+		ID:        uuid.New(),
+		Status:    JobPending,
+		Format:    format,
+		Filter:    filter,
+		CreatedAt: time.Now(),
+	}
+	if err := e.save(ctx, job); err != nil {
+		return nil, err
+	}
+
+	go e.run(tenant.Detach(ctx), job)
+
+	return job, nil
+}
+
+func (e *Exporter) Get(ctx context.Context, id uuid.UUID) (*Job, error) {
+	var job Job
+	if err := e.cacheDAO.GetExportJob(ctx, id.String(), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Path returns where a completed job's output is stored.
+func (e *Exporter) Path(job *Job) string {
+	return filepath.Join(e.dir, job.FileName())
+}
+
+func (e *Exporter) run(ctx context.Context, job *Job) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Hour)
+	defer cancel()
+
+	e.sweep()
+
+	job.Status = JobRunning
+	if err := e.save(ctx, job); err != nil {
+		e.logger.Warn("Failed to update export job", "error", err, "job_id", job.ID)
+	}
+
+	rows, err := e.write(ctx, job)
+	now := time.Now()
+	job.Rows = rows
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+		e.logger.Error("Export job failed", "error", err, "job_id", job.ID)
+	} else {
+		job.Status = JobCompleted
+		e.logger.Info("Export job completed", "job_id", job.ID, "rows", rows)
+	}
+
+	if err := e.save(ctx, job); err != nil {
+		e.logger.Error("Failed to update export job", "error", err, "job_id", job.ID)
+	}
+}
+
+// write exports into a temporary file and renames it into place, so a
+// download never sees a partial file.
+func (e *Exporter) write(ctx context.Context, job *Job) (int, error) {
+	tmp, err := os.CreateTemp(e.dir, job.FileName()+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	rows, err := Export(ctx, e.userDAO, job.Filter, job.Format, tmp)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write export file: %w", closeErr)
+	}
+	if err != nil {
+		return rows, err
+	}
+
+	if err := os.Rename(tmp.Name(), e.Path(job)); err != nil {
+		return rows, fmt.Errorf("failed to store export file: %w", err)
+	}
+	return rows, nil
+}
+
+func (e *Exporter) save(ctx context.Context, job *Job) error {
+	return e.cacheDAO.SetExportJob(ctx, job.ID.String(), job, e.retention)
+}
+
+// sweep removes export files older than the retention period.
+func (e *Exporter) sweep() {
+	entries, err := os.ReadDir(e.dir)
+	if err != nil {
+		e.logger.Warn("Failed to list export directory", "error", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-e.retention)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(e.dir, entry.Name())); err != nil {
+			e.logger.Warn("Failed to remove expired export", "error", err, "file", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Package userimport creates and updates users in bulk from CSV or NDJSON
+// input, producing a per-row report.
+package userimport
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/models"
+)
+
+// DefaultBatchSize is the number of rows written per transaction.
+const DefaultBatchSize = 500
+
+// Row outcomes recorded in a Report. In a dry run, created and updated
+// describe what would have happened.
+const (
+	StatusCreated   = "created"
+	StatusUpdated   = "updated"
+	StatusUnchanged = "unchanged"
+	StatusInvalid   = "invalid"
+	StatusSkipped   = "skipped"
+	StatusFailed    = "failed"
+)
+
+// Result is the outcome of importing one row.
+type Result struct {
+	Line   int        `json:"line"`
+	Email  string     `json:"email"`
+	Status string     `json:"status"`
+	UserID *uuid.UUID `json:"user_id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// Report summarises an import run.
+type Report struct {
+	DryRun     bool           `json:"dry_run"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Totals     map[string]int `json:"totals"`
+	Results    []Result       `json:"results"`
+}
+
+// ChangedUserIDs returns the IDs of users the import created or updated.
+func (r *Report) ChangedUserIDs() []uuid.UUID {
+	var ids []uuid.UUID
+	for _, result := range r.Results {
+		if result.UserID != nil && (result.Status == StatusCreated || result.Status == StatusUpdated) {
+			ids = append(ids, *result.UserID)
+		}
+	}
+	return ids
+}
+
+// WriteCSV writes one line per input row.
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"line", "email", "status", "user_id", "error"}); err != nil {
+		return err
+	}
+	for _, result := range r.Results {
+		userID := ""
+		if result.UserID != nil {
+			userID = result.UserID.String()
+		}
+		record := []string{strconv.Itoa(result.Line), result.Email, result.Status, userID, result.Error}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type Importer struct {
+	userDAO   *dao.UserDAO
+	BatchSize int
+}
+
+func New(userDAO *dao.UserDAO) *Importer {
+	return &Importer{
+		userDAO:   userDAO,
+		BatchSize: DefaultBatchSize,
+	}
+}
+
+// Run reads users from r and upserts them by email in batches. Every row is
+// validated and reported individually; a failed batch is retried row by row
+// so one bad row does not take its neighbours down with it. The returned
+// error is non-nil only when the input itself cannot be read.
+func (i *Importer) Run(ctx context.Context, format Format, r io.Reader, dryRun bool) (*Report, error) {
+	reader, err := newRowReader(format, r)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+		Totals:    make(map[string]int),
+	}
+
+	batchSize := i.BatchSize
+	if batchSize < 1 {
+		batchSize = DefaultBatchSize
+	}
+
+	seenEmails := make(map[string]int)
+	seenUsernames := make(map[string]int)
+	batch := make([]*Row, 0, batchSize)
+
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var rowErr *RowError
+			if errors.As(err, &rowErr) {
+				report.add(Result{Line: rowErr.Line, Status: StatusInvalid, Error: rowErr.Err.Error()})
+				continue
+			}
+			return nil, err
+		}
+
+		if err := models.ValidateUser(row.user()); err != nil {
+			report.add(Result{Line: row.Line, Email: row.Email, Status: StatusInvalid, Error: err.Error()})
+			continue
+		}
+		email, username := i.userDAO.NormalizeEmail(row.Email), models.NormalizeUsername(row.Username)
+		if line, ok := seenEmails[email]; ok {
+			report.add(Result{Line: row.Line, Email: row.Email, Status: StatusInvalid,
+				Error: fmt.Sprintf("duplicate email, first seen on line %d", line)})
+			continue
+		}
+		if line, ok := seenUsernames[username]; ok {
+			report.add(Result{Line: row.Line, Email: row.Email, Status: StatusInvalid,
+				Error: fmt.Sprintf("duplicate username, first seen on line %d", line)})
+			continue
+		}
+		seenEmails[email] = row.Line
+		seenUsernames[username] = row.Line
+
+		batch = append(batch, row)
+		if len(batch) == batchSize {
+			if err := i.flush(ctx, report, batch); err != nil {
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := i.flush(ctx, report, batch); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(report.Results, func(a, b int) bool {
+		return report.Results[a].Line < report.Results[b].Line
+	})
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+func (i *Importer) flush(ctx context.Context, report *Report, batch []*Row) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if report.DryRun {
+		return i.plan(ctx, report, batch)
+	}
+
+	users := make([]*models.User, len(batch))
+	for n, row := range batch {
+		users[n] = row.user()
+	}
+
+	upserted, err := i.userDAO.UpsertBatch(ctx, users)
+	if err != nil {
+		if len(batch) == 1 {
+			report.add(Result{Line: batch[0].Line, Email: batch[0].Email, Status: failureStatus(err), Error: err.Error()})
+			return nil
+		}
+		for _, row := range batch {
+			if err := i.flush(ctx, report, []*Row{row}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var untouched []*Row
+	for _, row := range batch {
+		result, ok := upserted[i.userDAO.NormalizeEmail(row.Email)]
+		if !ok {
+			untouched = append(untouched, row)
+			continue
+		}
+		status := StatusUpdated
+		if result.Created {
+			status = StatusCreated
+		}
+		id := result.ID
+		report.add(Result{Line: row.Line, Email: row.Email, Status: status, UserID: &id})
+	}
+
+	return i.explainUntouched(ctx, report, untouched)
+}
+
+// plan reports what a real run would do with batch without writing anything.
+// Rows whose usernames the real run would refuse are reported the same way,
+// with the same error.
+func (i *Importer) plan(ctx context.Context, report *Report, batch []*Row) error {
+	emails := make([]string, len(batch))
+	users := make([]*models.User, len(batch))
+	for n, row := range batch {
+		emails[n] = row.Email
+		users[n] = row.user()
+	}
+
+	statuses, err := i.userDAO.GetStatusesByEmail(ctx, emails)
+	if err != nil {
+		return err
+	}
+
+	failures, err := i.userDAO.CheckImportUsernames(ctx, users)
+	if err != nil {
+		return err
+	}
+
+	for n, row := range batch {
+		status, exists := statuses[i.userDAO.NormalizeEmail(row.Email)]
+		switch {
+		case failures[n] != nil:
+			report.add(Result{Line: row.Line, Email: row.Email, Status: failureStatus(failures[n]), Error: failures[n].Error()})
+		case !exists:
+			report.add(Result{Line: row.Line, Email: row.Email, Status: StatusCreated})
+		case status == models.UserStatusDeleted:
+			report.add(Result{Line: row.Line, Email: row.Email, Status: StatusSkipped,
+				Error: "email belongs to a deleted user"})
+		default:
+			report.add(Result{Line: row.Line, Email: row.Email, Status: StatusUpdated})
+		}
+	}
+
+	return nil
+}
+
+// explainUntouched reports rows the upsert matched but did not change.
+func (i *Importer) explainUntouched(ctx context.Context, report *Report, rows []*Row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	emails := make([]string, len(rows))
+	for n, row := range rows {
+		emails[n] = row.Email
+	}
+
+	statuses, err := i.userDAO.GetStatusesByEmail(ctx, emails)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if statuses[i.userDAO.NormalizeEmail(row.Email)] == models.UserStatusDeleted {
+			report.add(Result{Line: row.Line, Email: row.Email, Status: StatusSkipped,
+				Error: "email belongs to a deleted user"})
+			continue
+		}
+		report.add(Result{Line: row.Line, Email: row.Email, Status: StatusUnchanged})
+	}
+
+	return nil
+}
+
+func (r *Report) add(result Result) {
+	r.Results = append(r.Results, result)
+	r.Totals[result.Status]++
+}
+
+func (row *Row) user() *models.User {
+	return &models.User{
+		Email:     row.Email,
+		Username:  row.Username,
+		FirstName: row.FirstName,
+		LastName:  row.LastName,
+	}
+}
+
+// String summarises the totals, e.g. "created=3 invalid=1".
+func (r *Report) String() string {
+	parts := make([]string, 0, len(r.Totals))
+	for _, status := range []string{StatusCreated, StatusUpdated, StatusUnchanged, StatusInvalid, StatusSkipped, StatusFailed} {
+		if n := r.Totals[status]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", status, n))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// failureStatus is the status of a row the import refused with err. Rows
+// that give a new username breaking the naming policy are invalid; whether
+// a username is new depends on the user the row's email already belongs
+// to, so it is only known once the row is written.
+func failureStatus(err error) string {
+	var invalid *dao.InvalidUsernameError
+	if errors.As(err, &invalid) {
+		return StatusInvalid
+	}
+	return StatusFailed
+}
@@ -0,0 +1,143 @@
+//go:build integration
+
+package userimport
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/spurge/p4rsec/server/internal/config"
+	"github.com/spurge/p4rsec/server/internal/dao"
+	"github.com/spurge/p4rsec/server/internal/database"
+	"github.com/spurge/p4rsec/server/internal/encryption"
+	"github.com/spurge/p4rsec/server/internal/models"
+	"github.com/spurge/p4rsec/server/internal/tenant"
+)
+
+// These tests import into the database named by TEST_DATABASE_URL, as the
+// dao integration tests do.
+
+func testDB(t *testing.T) *database.PostgresDB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("invalid TEST_DATABASE_URL: %v", err)
+	}
+	password, _ := u.User.Password()
+	cfg := config.Database{
+		Host:         u.Hostname(),
+		Port:         u.Port(),
+		User:         u.User.Username(),
+		Password:     password,
+		Name:         strings.TrimPrefix(u.Path, "/"),
+		SSLMode:      u.Query().Get("sslmode"),
+		MaxOpenConns: 4,
+	}
+	if cfg.Port == "" {
+		cfg.Port = "5432"
+	}
+	if cfg.SSLMode == "" {
+		cfg.SSLMode = "disable"
+	}
+
+	db, err := database.NewPostgresConnection(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// plainKeys is a KeyProvider that stores data keys as they are.
+type plainKeys struct{}
+
+func (plainKeys) PrimaryKeyID() string { return "test" }
+
+func (plainKeys) WrapKey(_ context.Context, _ string, dataKey []byte) ([]byte, error) {
+	return dataKey, nil
+}
+
+func (plainKeys) UnwrapKey(_ context.Context, _ string, wrapped []byte) ([]byte, error) {
+	return wrapped, nil
+}
+
+// testUserDAO returns a UserDAO and a context acting for a new organization
+// of its own.
+func testUserDAO(t *testing.T) (*dao.UserDAO, context.Context) {
+	t.Helper()
+	db := testDB(t)
+
+	slug := "import-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:20]
+	org := &models.Organization{Slug: slug, Name: slug}
+	if err := dao.NewOrganizationDAO(db).Create(context.Background(), org, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	cipher, err := encryption.NewCipher(plainKeys{}, make([]byte, encryption.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dao.NewUserDAO(db, config.Users{}, cipher), tenant.WithUser(tenant.WithID(context.Background(), org.ID), "test")
+}
+
+func TestImportKeepsLegacyUsernames(t *testing.T) {
+	users, ctx := testUserDAO(t)
+
+	// Users created before the naming policy, which their names break
+	legacy := &models.User{Email: "old@example.com", Username: "Old Timer", FirstName: "Old", LastName: "Timer"}
+	renamed := &models.User{Email: "renamed@example.com", Username: "Was Here", FirstName: "Was", LastName: "Here"}
+	for _, user := range []*models.User{legacy, renamed} {
+		if err := users.Create(ctx, user, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input := "email,username,first_name,last_name\n" +
+		"old@example.com,Old Timer,Olivia,Timer\n" +
+		"renamed@example.com,Still Here,Was,Here\n" +
+		"new@example.com,new user,New,User\n" +
+		"fresh@example.com,fresh,Fresh,User\n"
+	want := map[string]string{
+		"old@example.com":     StatusUpdated,
+		"renamed@example.com": StatusInvalid,
+		"new@example.com":     StatusInvalid,
+		"fresh@example.com":   StatusCreated,
+	}
+
+	importer := New(users)
+	for _, dryRun := range []bool{true, false} {
+		report, err := importer.Run(ctx, FormatCSV, strings.NewReader(input), dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, result := range report.Results {
+			if result.Status != want[result.Email] {
+				t.Errorf("dry run %v: %s is %s (%s), want %s", dryRun, result.Email, result.Status, result.Error, want[result.Email])
+			}
+		}
+	}
+
+	got, err := users.GetByID(ctx, legacy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FirstName != "Olivia" || got.Username != "Old Timer" {
+		t.Errorf("legacy user = %q %q, want the new first name and the old username", got.Username, got.FirstName)
+	}
+	got, err = users.GetByID(ctx, renamed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "Was Here" {
+		t.Errorf("renamed user took the invalid username %q", got.Username)
+	}
+}
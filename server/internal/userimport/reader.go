@@ -0,0 +1,163 @@
+package userimport
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format is an input encoding the importer understands.
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+// ParseFormat accepts a format name or a Content-Type.
+func ParseFormat(s string) (Format, error) {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(s, ";", 2)[0]))
+	switch mediaType {
+	case "csv", "text/csv":
+		return FormatCSV, nil
+	case "ndjson", "jsonl", "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return FormatNDJSON, nil
+	default:
+		return "", fmt.Errorf("unsupported import format %q", s)
+	}
+}
+
+// Row is one user record read from the input.
+type Row struct {
+	Line      int    `json:"-"`
+	Email     string `json:"email"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// RowError is a problem confined to a single input row. Reading can continue
+// past it.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// rowReader yields rows until io.EOF. A *RowError rejects one row; any other
+// error aborts the import.
+type rowReader interface {
+	Next() (*Row, error)
+}
+
+func newRowReader(format Format, r io.Reader) (rowReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatNDJSON:
+		return newNDJSONReader(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+var csvColumns = []string{"email", "username", "first_name", "last_name"}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("CSV input is empty")
+		}
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header is missing column %q", name)
+		}
+	}
+
+	return &csvReader{r: reader, columns: columns}, nil
+}
+
+func (c *csvReader) Next() (*Row, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount) {
+			return nil, &RowError{Line: parseErr.StartLine, Err: fmt.Errorf("wrong number of fields")}
+		}
+		return nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+
+	field := func(name string) string {
+		return strings.TrimSpace(record[c.columns[name]])
+	}
+
+	return &Row{
+		Line:      line,
+		Email:     field("email"),
+		Username:  field("username"),
+		FirstName: field("first_name"),
+		LastName:  field("last_name"),
+	}, nil
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &ndjsonReader{scanner: scanner}
+}
+
+func (n *ndjsonReader) Next() (*Row, error) {
+	for n.scanner.Scan() {
+		n.line++
+		data := strings.TrimSpace(n.scanner.Text())
+		if data == "" {
+			continue
+		}
+
+		var row Row
+		if err := json.Unmarshal([]byte(data), &row); err != nil {
+			return nil, &RowError{Line: n.line, Err: fmt.Errorf("invalid JSON: %w", err)}
+		}
+		row.Line = n.line
+		row.Email = strings.TrimSpace(row.Email)
+		row.Username = strings.TrimSpace(row.Username)
+		row.FirstName = strings.TrimSpace(row.FirstName)
+		row.LastName = strings.TrimSpace(row.LastName)
+		return &row, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read NDJSON input: %w", err)
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN is_active BOOLEAN DEFAULT true;
+UPDATE users SET is_active = (status IN ('pending', 'active'));
+CREATE INDEX idx_users_is_active ON users(is_active);
+
+DROP INDEX IF EXISTS idx_users_status;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS status_changed_by,
+    DROP COLUMN IF EXISTS status_changed_at,
+    DROP COLUMN IF EXISTS status_reason,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,15 @@
+ALTER TABLE users
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('pending', 'active', 'suspended', 'deleted')),
+    ADD COLUMN status_reason TEXT,
+    ADD COLUMN status_changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ADD COLUMN status_changed_by TEXT;
+
+-- is_active used to mean both "suspended" and "deleted"
+UPDATE users SET status = 'deleted', status_changed_at = deleted_at WHERE deleted_at IS NOT NULL;
+UPDATE users SET status = 'suspended', status_changed_at = updated_at WHERE deleted_at IS NULL AND is_active = false;
+
+DROP INDEX IF EXISTS idx_users_is_active;
+ALTER TABLE users DROP COLUMN is_active;
+
+CREATE INDEX idx_users_status ON users(status);
@@ -0,0 +1,9 @@
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
+
+DROP INDEX IF EXISTS idx_users_username_normalized;
+DROP INDEX IF EXISTS idx_users_email_normalized;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS username_normalized,
+    DROP COLUMN IF EXISTS email_normalized;
@@ -0,0 +1,39 @@
+-- Uniqueness is decided on normalized forms (NFKC, case-folded) so that
+-- "Bob@example.com" and "bob@example.com" cannot be two accounts. The server
+-- computes these columns on every write; the backfill below uses the closest
+-- SQL equivalent and can be refined with `admin normalize-users`.
+ALTER TABLE users
+    ADD COLUMN email_normalized VARCHAR(255),
+    ADD COLUMN username_normalized VARCHAR(100);
+
+UPDATE users SET
+    email_normalized = lower(normalize(btrim(email), NFKC)),
+    username_normalized = lower(normalize(btrim(username), NFKC));
+
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(DISTINCT email_normalized, ', ') INTO duplicates
+    FROM (SELECT email_normalized FROM users GROUP BY email_normalized HAVING count(*) > 1) d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'users share a normalized email, resolve before migrating: %', duplicates;
+    END IF;
+
+    SELECT string_agg(DISTINCT username_normalized, ', ') INTO duplicates
+    FROM (SELECT username_normalized FROM users GROUP BY username_normalized HAVING count(*) > 1) d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'users share a normalized username, resolve before migrating: %', duplicates;
+    END IF;
+END $$;
+
+ALTER TABLE users
+    ALTER COLUMN email_normalized SET NOT NULL,
+    ALTER COLUMN username_normalized SET NOT NULL;
+
+CREATE UNIQUE INDEX idx_users_email_normalized ON users(email_normalized);
+CREATE UNIQUE INDEX idx_users_username_normalized ON users(username_normalized);
+
+-- The normalized indexes supersede the exact-match constraints
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+ALTER TABLE users DROP CONSTRAINT users_username_key;
@@ -0,0 +1,13 @@
+-- Usernames users have given up. A name stays reserved for its previous
+-- owner until reserved_until, and requests for it can be redirected.
+CREATE TABLE username_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    username VARCHAR(100) NOT NULL,
+    username_normalized VARCHAR(100) NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    reserved_until TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_username_history_user_id ON username_history(user_id, changed_at DESC);
+CREATE INDEX idx_username_history_username ON username_history(username_normalized, reserved_until);
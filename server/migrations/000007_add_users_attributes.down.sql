@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_attribute_schemas;
+ALTER TABLE users DROP COLUMN IF EXISTS attributes;
@@ -0,0 +1,11 @@
+-- Deployment-specific profile fields, validated by the server against the
+-- latest schema in user_attribute_schemas.
+ALTER TABLE users ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}'
+    CHECK (jsonb_typeof(attributes) = 'object');
+
+CREATE TABLE user_attribute_schemas (
+    version BIGSERIAL PRIMARY KEY,
+    schema JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_by TEXT
+);
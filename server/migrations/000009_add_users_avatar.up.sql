@@ -0,0 +1,4 @@
+-- avatar_key is the storage prefix of the user's avatar thumbnails and
+-- avatar_url links to the default size.
+ALTER TABLE users ADD COLUMN avatar_key TEXT;
+ALTER TABLE users ADD COLUMN avatar_url TEXT;
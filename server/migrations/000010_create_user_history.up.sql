@@ -0,0 +1,37 @@
+-- One row per write to a user. changes maps each changed field to its old
+-- and new value; snapshot is the whole user after the write, so any past
+-- state can be read back without replaying diffs.
+CREATE TABLE user_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    operation TEXT NOT NULL,
+    actor TEXT,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    changes JSONB NOT NULL DEFAULT '{}',
+    snapshot JSONB NOT NULL
+);
+
+CREATE INDEX idx_user_history_user_changed_at ON user_history(user_id, changed_at DESC, id DESC);
+
+-- Existing users start from their current state, which has held since their
+-- last update
+INSERT INTO user_history (user_id, version, operation, changed_at, snapshot)
+SELECT id, version, 'baseline', COALESCE(updated_at, created_at, NOW()), jsonb_build_object(
+    'id', id,
+    'email', email,
+    'username', username,
+    'first_name', first_name,
+    'last_name', last_name,
+    'status', status,
+    'status_reason', status_reason,
+    'status_changed_at', status_changed_at,
+    'status_changed_by', status_changed_by,
+    'version', version,
+    'created_at', created_at,
+    'updated_at', updated_at,
+    'deleted_at', deleted_at,
+    'attributes', attributes,
+    'avatar_url', avatar_url
+)
+FROM users;
@@ -0,0 +1,37 @@
+-- Append-only, hash-chained audit log. IDs are assigned without gaps and
+-- every row stores the hash of the row before it, so removing or editing a
+-- row is detectable. changes is JSON rather than JSONB so that it is kept
+-- byte for byte as it was hashed. No foreign keys: events outlive purged
+-- users.
+CREATE TABLE audit_events (
+    id BIGINT PRIMARY KEY,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    ip TEXT,
+    request_id TEXT,
+    changes JSON,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE
+);
+
+CREATE INDEX idx_audit_events_occurred_at ON audit_events(occurred_at);
+CREATE INDEX idx_audit_events_target ON audit_events(target_type, target_id);
+CREATE INDEX idx_audit_events_actor ON audit_events(actor);
+CREATE INDEX idx_audit_events_action ON audit_events(action);
+
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_update_delete
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,28 @@
+-- erased_at is set once a user's personal data has been anonymized. The row
+-- itself is kept so that everything referring to the user stays valid.
+ALTER TABLE users ADD COLUMN erased_at TIMESTAMP WITH TIME ZONE;
+
+-- changes_digest is the SHA-256 of changes. Events that have one are hashed
+-- over the digest instead of the changes themselves, so the changes can be
+-- redacted when their subject is erased without breaking the chain. Events
+-- written before this migration have no digest and cannot be redacted.
+ALTER TABLE audit_events ADD COLUMN changes_digest CHAR(64);
+
+-- The only update allowed is clearing the changes of an event that has a
+-- digest; every other column must stay as it is.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND OLD.changes_digest IS NOT NULL
+        AND NEW.changes IS NULL
+        AND (NEW.id, NEW.occurred_at, NEW.action, NEW.actor, NEW.target_type, NEW.target_id,
+             NEW.ip, NEW.request_id, NEW.changes_digest, NEW.prev_hash, NEW.hash)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.occurred_at, OLD.action, OLD.actor, OLD.target_type, OLD.target_id,
+             OLD.ip, OLD.request_id, OLD.changes_digest, OLD.prev_hash, OLD.hash)
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,23 @@
+-- Ciphertext cannot be decrypted here, so only go back while nothing is
+-- encrypted.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users WHERE data_key_id IS NOT NULL) THEN
+        RAISE EXCEPTION 'users have been encrypted, which cannot be rolled back';
+    END IF;
+END $$;
+
+CREATE INDEX idx_users_email ON users(email);
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+DROP INDEX IF EXISTS idx_users_data_key_id;
+DROP INDEX IF EXISTS idx_users_email_index;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS data_key_id,
+    DROP COLUMN IF EXISTS data_key,
+    DROP COLUMN IF EXISTS email_index,
+    ALTER COLUMN email_normalized SET NOT NULL,
+    ALTER COLUMN last_name TYPE VARCHAR(100),
+    ALTER COLUMN first_name TYPE VARCHAR(100),
+    ALTER COLUMN email TYPE VARCHAR(255);
@@ -0,0 +1,21 @@
+-- Emails and names are encrypted by the server. Each row has its own data
+-- key, stored wrapped by the keyring key named in data_key_id; rows without
+-- one are still in plaintext until `admin rotate-keys` encrypts them.
+-- email_index is a keyed hash of the normalized email that takes over
+-- uniqueness and lookups from email_normalized, which encrypted rows leave
+-- empty.
+ALTER TABLE users
+    ALTER COLUMN email TYPE TEXT,
+    ALTER COLUMN first_name TYPE TEXT,
+    ALTER COLUMN last_name TYPE TEXT,
+    ALTER COLUMN email_normalized DROP NOT NULL,
+    ADD COLUMN email_index BYTEA,
+    ADD COLUMN data_key BYTEA,
+    ADD COLUMN data_key_id TEXT;
+
+CREATE UNIQUE INDEX idx_users_email_index ON users(email_index);
+CREATE INDEX idx_users_data_key_id ON users(data_key_id);
+
+-- Neither is of any use on ciphertext
+DROP INDEX IF EXISTS idx_users_email;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
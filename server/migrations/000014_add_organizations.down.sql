@@ -0,0 +1,24 @@
+-- Only a single organization's users can share the global uniqueness indexes
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM organizations WHERE id <> '00000000-0000-0000-0000-000000000001') THEN
+        RAISE EXCEPTION 'organizations other than the default exist, which cannot be rolled back';
+    END IF;
+END $$;
+
+DROP INDEX IF EXISTS idx_audit_events_tenant;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS idx_user_attribute_schemas_tenant;
+ALTER TABLE user_attribute_schemas DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS idx_users_tenant_created_at;
+DROP INDEX IF EXISTS idx_users_username_normalized;
+DROP INDEX IF EXISTS idx_users_email_normalized;
+DROP INDEX IF EXISTS idx_users_email_index;
+CREATE UNIQUE INDEX idx_users_email_index ON users(email_index);
+CREATE UNIQUE INDEX idx_users_email_normalized ON users(email_normalized);
+CREATE UNIQUE INDEX idx_users_username_normalized ON users(username_normalized);
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS organizations;
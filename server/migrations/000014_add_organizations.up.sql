@@ -0,0 +1,37 @@
+-- Organizations are the tenants sharing this deployment. Every user belongs
+-- to exactly one, and emails and usernames are only unique within it.
+-- Slugs name organizations in subdomains and headers and never change.
+CREATE TABLE organizations (
+    id UUID PRIMARY KEY,
+    slug VARCHAR(63) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Everything that exists already belongs to the default organization
+INSERT INTO organizations (id, slug, name)
+VALUES ('00000000-0000-0000-0000-000000000001', 'default', 'Default');
+
+ALTER TABLE users ADD COLUMN tenant_id UUID REFERENCES organizations(id);
+UPDATE users SET tenant_id = '00000000-0000-0000-0000-000000000001';
+ALTER TABLE users ALTER COLUMN tenant_id SET NOT NULL;
+
+DROP INDEX idx_users_email_index;
+DROP INDEX idx_users_email_normalized;
+DROP INDEX idx_users_username_normalized;
+CREATE UNIQUE INDEX idx_users_email_index ON users(tenant_id, email_index);
+CREATE UNIQUE INDEX idx_users_email_normalized ON users(tenant_id, email_normalized);
+CREATE UNIQUE INDEX idx_users_username_normalized ON users(tenant_id, username_normalized);
+CREATE INDEX idx_users_tenant_created_at ON users(tenant_id, created_at);
+
+ALTER TABLE user_attribute_schemas ADD COLUMN tenant_id UUID REFERENCES organizations(id);
+UPDATE user_attribute_schemas SET tenant_id = '00000000-0000-0000-0000-000000000001';
+ALTER TABLE user_attribute_schemas ALTER COLUMN tenant_id SET NOT NULL;
+CREATE INDEX idx_user_attribute_schemas_tenant ON user_attribute_schemas(tenant_id, version DESC);
+
+-- The audit log is append-only, so events from before organizations existed
+-- keep a NULL tenant_id and are read as the default organization's. No
+-- foreign key, for the same reason events have none on users.
+ALTER TABLE audit_events ADD COLUMN tenant_id UUID;
+CREATE INDEX idx_audit_events_tenant ON audit_events(tenant_id, id);
@@ -0,0 +1,19 @@
+DROP POLICY tenant_isolation ON user_history;
+ALTER TABLE user_history NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE user_history DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY tenant_isolation ON user_preferences;
+ALTER TABLE user_preferences NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE user_preferences DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY tenant_isolation ON username_history;
+ALTER TABLE username_history NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE username_history DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY tenant_isolation ON user_attribute_schemas;
+ALTER TABLE user_attribute_schemas NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE user_attribute_schemas DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY tenant_isolation ON users;
+ALTER TABLE users NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE users DISABLE ROW LEVEL SECURITY;
@@ -0,0 +1,41 @@
+-- Row-level security keeps each organization's rows out of reach of the
+-- others even if a query forgets to filter on tenant_id. Every pooled
+-- connection sets app.tenant_id to the organization of the request it
+-- serves, or to '' outside of one, which matches no rows. FORCE applies the
+-- policies to the tables' owner too; only superusers and roles with
+-- BYPASSRLS are exempt, so the server must not connect as one.
+--
+-- audit_events is left out: its hash chain spans every organization, and
+-- appending needs the head of the whole chain.
+
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON users
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE user_attribute_schemas ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_attribute_schemas FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_attribute_schemas
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+-- Tables keyed by user follow their user, whose own policy applies inside
+-- the subquery
+ALTER TABLE username_history ENABLE ROW LEVEL SECURITY;
+ALTER TABLE username_history FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON username_history
+    USING (EXISTS (SELECT 1 FROM users u WHERE u.id = user_id))
+    WITH CHECK (EXISTS (SELECT 1 FROM users u WHERE u.id = user_id));
+
+ALTER TABLE user_preferences ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_preferences FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_preferences
+    USING (EXISTS (SELECT 1 FROM users u WHERE u.id = user_id))
+    WITH CHECK (EXISTS (SELECT 1 FROM users u WHERE u.id = user_id));
+
+ALTER TABLE user_history ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_history FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_history
+    USING (EXISTS (SELECT 1 FROM users u WHERE u.id = user_id))
+    WITH CHECK (EXISTS (SELECT 1 FROM users u WHERE u.id = user_id));
@@ -0,0 +1,58 @@
+-- Groups collect users within an organization. A team is simply a group;
+-- groups nest by listing other groups as subgroups, whose members count as
+-- members of every group above them. Names are unique per organization.
+CREATE TABLE groups (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES organizations(id),
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_groups_tenant_name ON groups(tenant_id, name);
+
+-- Direct user members and their role in the group
+CREATE TABLE group_members (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'member')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX idx_group_members_user_id ON group_members(user_id);
+
+-- Nesting. The server refuses edges that would close a cycle.
+CREATE TABLE group_subgroups (
+    parent_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    child_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (parent_id, child_id),
+    CHECK (parent_id <> child_id)
+);
+
+CREATE INDEX idx_group_subgroups_child_id ON group_subgroups(child_id);
+
+-- Same row-level security as the user tables; memberships and nesting
+-- follow their group
+ALTER TABLE groups ENABLE ROW LEVEL SECURITY;
+ALTER TABLE groups FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON groups
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE group_members ENABLE ROW LEVEL SECURITY;
+ALTER TABLE group_members FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON group_members
+    USING (EXISTS (SELECT 1 FROM groups g WHERE g.id = group_id))
+    WITH CHECK (EXISTS (SELECT 1 FROM groups g WHERE g.id = group_id)
+        AND EXISTS (SELECT 1 FROM users u WHERE u.id = user_id));
+
+ALTER TABLE group_subgroups ENABLE ROW LEVEL SECURITY;
+ALTER TABLE group_subgroups FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON group_subgroups
+    USING (EXISTS (SELECT 1 FROM groups g WHERE g.id = parent_id))
+    WITH CHECK (EXISTS (SELECT 1 FROM groups g WHERE g.id = parent_id)
+        AND EXISTS (SELECT 1 FROM groups g WHERE g.id = child_id));
@@ -0,0 +1,38 @@
+-- Invitations to join an organization, sent by email. The email is
+-- encrypted like users' and found by its blind index; the token is only
+-- stored hashed. groups lists the memberships granted on acceptance as
+-- [{"group_id": ..., "role": ...}].
+CREATE TABLE invitations (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES organizations(id),
+    email TEXT NOT NULL,
+    email_index BYTEA NOT NULL,
+    data_key BYTEA NOT NULL,
+    data_key_id TEXT NOT NULL,
+    token_hash BYTEA NOT NULL,
+    groups JSONB NOT NULL DEFAULT '[]',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'accepted', 'declined', 'revoked', 'expired')),
+    invited_by VARCHAR(255),
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE,
+    send_count INTEGER NOT NULL DEFAULT 0,
+    responded_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_invitations_token_hash ON invitations(token_hash);
+CREATE INDEX idx_invitations_tenant_created_at ON invitations(tenant_id, created_at);
+CREATE INDEX idx_invitations_user_id ON invitations(user_id);
+
+-- At most one open invitation per email in an organization
+CREATE UNIQUE INDEX idx_invitations_pending_email ON invitations(tenant_id, email_index)
+    WHERE status = 'pending';
+
+ALTER TABLE invitations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE invitations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON invitations
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS scim_external_ids;
+DROP TABLE IF EXISTS scim_tokens;
@@ -0,0 +1,40 @@
+-- Bearer tokens identity providers provision users with over SCIM. Like
+-- invitation tokens they carry their organization and are only stored
+-- hashed.
+CREATE TABLE scim_tokens (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES organizations(id),
+    name VARCHAR(255) NOT NULL,
+    token_hash BYTEA NOT NULL,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX idx_scim_tokens_token_hash ON scim_tokens(token_hash);
+CREATE INDEX idx_scim_tokens_tenant_created_at ON scim_tokens(tenant_id, created_at);
+
+-- The identity provider's own IDs for the users and groups it provisioned.
+-- resource_type is 'User' or 'Group'.
+CREATE TABLE scim_external_ids (
+    tenant_id UUID NOT NULL REFERENCES organizations(id),
+    resource_type VARCHAR(10) NOT NULL CHECK (resource_type IN ('User', 'Group')),
+    resource_id UUID NOT NULL,
+    external_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (resource_type, resource_id)
+);
+
+CREATE INDEX idx_scim_external_ids_external_id ON scim_external_ids(tenant_id, resource_type, external_id);
+
+ALTER TABLE scim_tokens ENABLE ROW LEVEL SECURITY;
+ALTER TABLE scim_tokens FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON scim_tokens
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE scim_external_ids ENABLE ROW LEVEL SECURITY;
+ALTER TABLE scim_external_ids FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON scim_external_ids
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
@@ -0,0 +1,22 @@
+-- Ties users and groups to the directory entries LDAP sync manages them
+-- from. entry_id is the entry's stable ID, such as its entryUUID or
+-- objectGUID, and dn where it was last seen. deactivated records that the
+-- sync suspended the user, so that it only reactivates those.
+CREATE TABLE ldap_links (
+    tenant_id UUID NOT NULL REFERENCES organizations(id),
+    resource_type VARCHAR(10) NOT NULL CHECK (resource_type IN ('User', 'Group')),
+    resource_id UUID NOT NULL,
+    entry_id VARCHAR(255) NOT NULL,
+    dn TEXT NOT NULL,
+    deactivated BOOLEAN NOT NULL DEFAULT FALSE,
+    synced_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (resource_type, resource_id)
+);
+
+CREATE UNIQUE INDEX idx_ldap_links_entry_id ON ldap_links(tenant_id, resource_type, entry_id);
+
+ALTER TABLE ldap_links ENABLE ROW LEVEL SECURITY;
+ALTER TABLE ldap_links FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON ldap_links
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
@@ -0,0 +1,38 @@
+-- Follows and blocks between users of one organization. A block ends any
+-- follow between the two users and keeps either from following the other
+-- until it is lifted.
+CREATE TABLE user_follows (
+    tenant_id UUID NOT NULL REFERENCES organizations(id),
+    follower_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    followee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+-- Followers and following are listed newest first
+CREATE INDEX idx_user_follows_followee ON user_follows(followee_id, created_at DESC, follower_id DESC);
+CREATE INDEX idx_user_follows_follower ON user_follows(follower_id, created_at DESC, followee_id DESC);
+
+CREATE TABLE user_blocks (
+    tenant_id UUID NOT NULL REFERENCES organizations(id),
+    blocker_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX idx_user_blocks_blocked_id ON user_blocks(blocked_id);
+
+ALTER TABLE user_follows ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_follows FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_follows
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE user_blocks ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_blocks FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_blocks
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
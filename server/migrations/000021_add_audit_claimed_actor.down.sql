@@ -0,0 +1,18 @@
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND OLD.changes_digest IS NOT NULL
+        AND NEW.changes IS NULL
+        AND (NEW.id, NEW.occurred_at, NEW.action, NEW.actor, NEW.target_type, NEW.target_id,
+             NEW.ip, NEW.request_id, NEW.changes_digest, NEW.prev_hash, NEW.hash)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.occurred_at, OLD.action, OLD.actor, OLD.target_type, OLD.target_id,
+             OLD.ip, OLD.request_id, OLD.changes_digest, OLD.prev_hash, OLD.hash)
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE audit_events DROP COLUMN IF EXISTS claimed_actor;
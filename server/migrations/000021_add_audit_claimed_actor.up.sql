@@ -0,0 +1,24 @@
+-- claimed_actor is the X-Actor-ID a client sent with the request, recorded
+-- for reference only: nothing verifies it. actor is the authenticated user.
+ALTER TABLE audit_events ADD COLUMN claimed_actor TEXT;
+
+-- Redaction may still only clear the changes; the new column, and the
+-- tenant, must stay as they are too.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND OLD.changes_digest IS NOT NULL
+        AND NEW.changes IS NULL
+        AND (NEW.id, NEW.tenant_id, NEW.occurred_at, NEW.action, NEW.actor, NEW.claimed_actor,
+             NEW.target_type, NEW.target_id, NEW.ip, NEW.request_id, NEW.changes_digest,
+             NEW.prev_hash, NEW.hash)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.tenant_id, OLD.occurred_at, OLD.action, OLD.actor, OLD.claimed_actor,
+             OLD.target_type, OLD.target_id, OLD.ip, OLD.request_id, OLD.changes_digest,
+             OLD.prev_hash, OLD.hash)
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_user_history_data_key_id;
+
+ALTER TABLE user_history
+    DROP COLUMN IF EXISTS data_key_id,
+    DROP COLUMN IF EXISTS data_key;
@@ -0,0 +1,9 @@
+-- The email and names in a history entry's snapshot and changes are sealed
+-- with the data key stored here, wrapped by the keyring key named in
+-- data_key_id. Entries without one still hold them in the clear until
+-- `admin rotate-keys` encrypts them.
+ALTER TABLE user_history
+    ADD COLUMN data_key BYTEA,
+    ADD COLUMN data_key_id TEXT;
+
+CREATE INDEX idx_user_history_data_key_id ON user_history(data_key_id);